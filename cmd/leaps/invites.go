@@ -0,0 +1,310 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Jeffail/leaps/lib/acl"
+	"github.com/Jeffail/leaps/lib/api/events"
+	"github.com/Jeffail/leaps/lib/util/service/log"
+)
+
+//------------------------------------------------------------------------------
+
+// tokenCookie - The name of the cookie that holds an authentication token.
+const tokenCookie = "leaps_token"
+
+// guestPrefix - Prefixes the usernames of guests that were let in by an invite
+// while user login is enabled, so that they cannot pose as real users.
+const guestPrefix = "guest:"
+
+// inviteLinks - Mints invite tokens that grant access to a document or folder,
+// and redeems invite links by storing the token as a cookie. Invites never grant
+// more access than their creator has to the files within their scope.
+type inviteLinks struct {
+	invites    *acl.Invites
+	auth       acl.Authenticator
+	files      *acl.FileExists
+	cookiePath string
+	redeemPath string
+	home       string
+	requester  func(r *http.Request) events.Client
+	logger     log.Modular
+}
+
+func newInviteLinks(
+	invitesPath, cookiePath, redeemPath, home string,
+	auth acl.Authenticator, files *acl.FileExists, logger log.Modular,
+) (*inviteLinks, error) {
+	invitesConf := acl.NewInvitesConfig()
+	invitesConf.Path = invitesPath
+	invites, err := acl.NewInvites(invitesConf, logger)
+	if err != nil {
+		return nil, err
+	}
+	return &inviteLinks{
+		invites:    invites,
+		auth:       auth,
+		files:      files,
+		cookiePath: cookiePath,
+		redeemPath: redeemPath,
+		home:       home,
+		requester: func(r *http.Request) events.Client {
+			return events.Client{Username: r.RemoteAddr}
+		},
+		logger: logger.NewModule(":invites"),
+	}, nil
+}
+
+// invite - Returns the invite of the token of a request, if it is valid.
+func (i *inviteLinks) invite(r *http.Request) (acl.Invite, bool) {
+	token := requestToken(r)
+	if len(token) == 0 {
+		return acl.Invite{}, false
+	}
+	return i.invites.Lookup(token)
+}
+
+// invited - Returns whether a request carries a valid invite token.
+func (i *inviteLinks) invited(r *http.Request) bool {
+	_, ok := i.invite(r)
+	return ok
+}
+
+// useLogin - Lets guests with an invite in without logging in, and only lets
+// logged in users create invites.
+func (i *inviteLinks) useLogin(login *userLogin) {
+	login.exempt = i.invited
+	i.requester = func(r *http.Request) events.Client {
+		username, _ := login.sessionUser(r)
+		return events.Client{Username: username}
+	}
+}
+
+// useProxy - Only lets users identified by a trusted proxy create invites.
+func (i *inviteLinks) useProxy(proxy *acl.TrustedProxy) {
+	i.requester = func(r *http.Request) events.Client {
+		identity, _ := proxy.Identify(r)
+		return events.Client{Username: identity.Username, Groups: identity.Groups}
+	}
+}
+
+// grantable - Returns the highest access that a user may grant with an invite
+// to a scope, which is the lowest access they have to the files within it.
+func (i *inviteLinks) grantable(requester events.Client, token, scope string) acl.AccessLevel {
+	invite := acl.Invite{Scope: scope}
+	level, covered := acl.EditAccess, false
+	for _, p := range i.files.GetPaths() {
+		if !invite.Covers(p) {
+			continue
+		}
+		covered = true
+		if pLevel := i.auth.Authenticate(requester, token, p); pLevel < level {
+			level = pLevel
+		}
+	}
+	if !covered {
+		return acl.NoAccess
+	}
+	return level
+}
+
+// handleMint - Creates an invite from the form values of a POST request and
+// responds with its token and link.
+func (i *inviteLinks) handleMint(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	access := acl.EditAccess
+	if level := r.PostFormValue("access"); len(level) > 0 {
+		switch strings.ToUpper(level) {
+		case "READ":
+			access = acl.ReadAccess
+		case "EDIT":
+			access = acl.EditAccess
+		default:
+			http.Error(w, acl.ErrInviteAccess.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	ttl := time.Hour
+	if ttlStr := r.PostFormValue("ttl_s"); len(ttlStr) > 0 {
+		ttlS, err := strconv.ParseInt(ttlStr, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid ttl_s: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		ttl = time.Duration(ttlS) * time.Second
+	}
+	singleUse, _ := strconv.ParseBool(r.PostFormValue("single_use"))
+
+	requester := i.requester(r)
+	if len(requester.Username) == 0 {
+		http.Error(w, "Login required", http.StatusUnauthorized)
+		return
+	}
+	createdBy := requester.Username
+
+	path := r.PostFormValue("path")
+	if scope := acl.CleanScope(path); len(scope) > 0 && access > i.grantable(requester, requestToken(r), scope) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		i.logger.Warnf("Rejected invite to %v with %v access from %v\n", scope, access, createdBy)
+		return
+	}
+
+	token, invite, err := i.invites.Mint(path, access, ttl, singleUse, createdBy)
+	if err != nil {
+		if err == acl.ErrInviteAccess || err == acl.ErrInviteScope || err == acl.ErrInviteTTL {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			http.Error(w, "Failed to create invite", http.StatusInternalServerError)
+			i.logger.Errorf("Failed to create invite: %v\n", err)
+		}
+		return
+	}
+	i.logger.Infof("Invite to %v with %v access minted by %v\n", invite.Scope, invite.Access, createdBy)
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	data, err := json.Marshal(struct {
+		acl.Invite
+		Token string `json:"token"`
+		Link  string `json:"link"`
+	}{
+		Invite: invite,
+		Token:  token,
+		Link:   scheme + "://" + r.Host + i.redeemPath + "?token=" + url.QueryEscape(token),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// handleRedeem - Redeems the token of a valid invite link, stores the token of
+// its holder as a cookie and redirects to the home page. The holder token of a
+// single use invite is only known to the cookie of the redeeming browser.
+func (i *inviteLinks) handleRedeem(w http.ResponseWriter, r *http.Request) {
+	token, invite, err := i.invites.Redeem(r.URL.Query().Get("token"))
+	if err == acl.ErrInviteInvalid {
+		http.Error(w, "Invite is invalid, has expired or has already been redeemed", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to redeem invite", http.StatusInternalServerError)
+		i.logger.Errorf("Failed to redeem invite: %v\n", err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     tokenCookie,
+		Value:    token,
+		Path:     i.cookiePath,
+		Expires:  time.Unix(invite.Expires, 0),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, i.home, http.StatusSeeOther)
+}
+
+// filterPaths - Returns the paths that lie within the scope of an invite.
+func filterPaths(paths []string, invite acl.Invite) []string {
+	filtered := []string{}
+	for _, p := range paths {
+		if invite.Covers(p) {
+			filtered = append(filtered, p)
+		}
+	}
+	return filtered
+}
+
+// localOnly - Wraps a handler so that it responds with 403 to requests that do
+// not originate from the host machine.
+func localOnly(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if ip := net.ParseIP(host); err != nil || ip == nil || !ip.IsLoopback() {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// inviteAuth - Combines the access granted by invites with that of the
+// document authenticator. Guests are only granted access by their invite, which
+// never reaches hidden, ignored or reserved files.
+type inviteAuth struct {
+	base    acl.Authenticator
+	invites *acl.Invites
+	files   *acl.FileExists
+}
+
+func (a inviteAuth) Explain(userMetadata interface{}, token, documentID string) (acl.AccessLevel, string) {
+	level := a.invites.Authenticate(userMetadata, token, documentID)
+	if fileLevel := a.files.Authenticate(userMetadata, token, documentID); fileLevel < level {
+		level = fileLevel
+	}
+	if user, ok := userMetadata.(acl.UserIdentity); ok && strings.HasPrefix(user.UserID(), guestPrefix) {
+		return level, "invites"
+	}
+	if baseLevel, by := acl.Explain(a.base, userMetadata, token, documentID); baseLevel > level {
+		return baseLevel, by
+	}
+	return level, "invites"
+}
+
+func (a inviteAuth) Authenticate(userMetadata interface{}, token, documentID string) acl.AccessLevel {
+	level, _ := a.Explain(userMetadata, token, documentID)
+	return level
+}
+
+// ExplainCreate - Invites never grant create access, and so only users with an
+// account are checked for it by the document authenticator.
+func (a inviteAuth) ExplainCreate(userMetadata interface{}, token, documentID string) (acl.AccessLevel, string) {
+	if user, ok := userMetadata.(acl.UserIdentity); ok && strings.HasPrefix(user.UserID(), guestPrefix) {
+		return acl.NoAccess, "invites"
+	}
+	return acl.ExplainCreate(a.base, userMetadata, token, documentID)
+}
+
+func (a inviteAuth) AuthenticateCreate(userMetadata interface{}, token, documentID string) acl.AccessLevel {
+	level, _ := a.ExplainCreate(userMetadata, token, documentID)
+	return level
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,287 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Jeffail/leaps/lib/acl"
+	"github.com/Jeffail/leaps/lib/api/events"
+	"github.com/Jeffail/leaps/lib/util/service/log"
+	"golang.org/x/crypto/bcrypt"
+)
+
+//------------------------------------------------------------------------------
+
+func testLogger() log.Modular {
+	return log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+}
+
+// testInvites - Creates invite links for a tree where alice may edit docs and
+// read everything else.
+func testInvites(t *testing.T) (*inviteLinks, *acl.FileExists, func()) {
+	dir, err := ioutil.TempDir("", "leaps_invites")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		".git/config":    "",
+		"docs/readme.md": "",
+		"notes/todo.md":  "",
+		"rules.yaml": `rules:
+  - paths: [ "docs/**" ]
+    users: [ "alice" ]
+    access: EDIT
+  - paths: [ "**" ]
+    users: [ "alice" ]
+    access: READ
+`,
+	}
+	for p, content := range files {
+		full := filepath.Join(dir, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	filesConf := acl.NewFileExistsConfig()
+	filesConf.Path = dir
+	filesConf.Watch = false
+	filesConf.ReservedIgnores = append(filesConf.ReservedIgnores, "/invites.json")
+	fileAuth := acl.NewFileExists(filesConf, testLogger())
+
+	rulesConf := acl.NewRulesConfig()
+	rulesConf.Path = filepath.Join(dir, "rules.yaml")
+	rules, err := acl.NewRules(rulesConf, nil, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invites, err := newInviteLinks(
+		filepath.Join(dir, "invites.json"), "/", "/leaps/invite", "/",
+		acl.NewFileGate(rules, fileAuth), fileAuth, testLogger(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return invites, fileAuth, func() {
+		fileAuth.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func mintRequest(path, access string) *http.Request {
+	form := url.Values{}
+	form.Set("path", path)
+	form.Set("access", access)
+	r := httptest.NewRequest("POST", "/leaps/invites", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+//------------------------------------------------------------------------------
+
+func TestInviteMintLimits(t *testing.T) {
+	invites, _, done := testInvites(t)
+	defer done()
+
+	username := "alice"
+	invites.requester = func(r *http.Request) events.Client {
+		return events.Client{Username: username}
+	}
+
+	for _, test := range []struct {
+		path   string
+		access string
+		status int
+	}{
+		{"docs", "EDIT", http.StatusOK},
+		{"docs/readme.md", "EDIT", http.StatusOK},
+		{"notes", "EDIT", http.StatusForbidden},
+		{"notes/todo.md", "EDIT", http.StatusForbidden},
+		{"notes", "READ", http.StatusOK},
+		{".git", "READ", http.StatusForbidden},
+		{".git/config", "READ", http.StatusForbidden},
+		{"invites.json", "READ", http.StatusForbidden},
+		{"missing", "READ", http.StatusForbidden},
+	} {
+		w := httptest.NewRecorder()
+		invites.handleMint(w, mintRequest(test.path, test.access))
+		if w.Code != test.status {
+			t.Errorf("%v %v: wrong status: %v != %v: %v", test.access, test.path, w.Code, test.status, w.Body.String())
+		}
+	}
+
+	username = "bob"
+	w := httptest.NewRecorder()
+	invites.handleMint(w, mintRequest("docs", "READ"))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Wrong status for user without access: %v", w.Code)
+	}
+
+	username = ""
+	w = httptest.NewRecorder()
+	invites.handleMint(w, mintRequest("docs", "READ"))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Wrong status for anonymous requester: %v", w.Code)
+	}
+}
+
+func TestInviteAuthFileCap(t *testing.T) {
+	invites, fileAuth, done := testInvites(t)
+	defer done()
+
+	auth := inviteAuth{base: invites.auth, invites: invites.invites, files: fileAuth}
+	guest := events.Client{Username: guestPrefix + "carol"}
+
+	gitToken, _, err := invites.invites.Mint(".git", acl.EditAccess, time.Hour, false, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if level := auth.Authenticate(guest, gitToken, ".git/config"); level != acl.NoAccess {
+		t.Errorf("Wrong access level of reserved file: %v", level)
+	}
+
+	rootToken, _, err := invites.invites.Mint("notes", acl.EditAccess, time.Hour, false, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if level := auth.Authenticate(guest, rootToken, "notes/todo.md"); level != acl.EditAccess {
+		t.Errorf("Wrong access level of visible file: %v", level)
+	}
+	if level := auth.Authenticate(guest, rootToken, "notes/missing.md"); level != acl.NoAccess {
+		t.Errorf("Wrong access level of missing file: %v", level)
+	}
+}
+
+func TestInviteRedeemSingleUse(t *testing.T) {
+	invites, _, done := testInvites(t)
+	defer done()
+
+	token, _, err := invites.invites.Mint("docs", acl.ReadAccess, time.Hour, true, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	invites.handleRedeem(w, httptest.NewRequest("GET", "/leaps/invite?token="+url.QueryEscape(token), nil))
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("Wrong status: %v", w.Code)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != tokenCookie {
+		t.Fatalf("Wrong cookies: %v", cookies)
+	}
+	if cookies[0].Value == token {
+		t.Error("Cookie holds the token of the link")
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(cookies[0])
+	if !invites.invited(r) {
+		t.Error("Redeemed cookie is not invited")
+	}
+
+	w = httptest.NewRecorder()
+	invites.handleRedeem(w, httptest.NewRequest("GET", "/leaps/invite?token="+url.QueryEscape(token), nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Wrong status of second redeem: %v", w.Code)
+	}
+}
+
+func TestInviteLoginWiring(t *testing.T) {
+	invites, _, done := testInvites(t)
+	defer done()
+
+	dir, err := ioutil.TempDir("", "leaps_login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	usersPath := filepath.Join(dir, "users")
+	if err = ioutil.WriteFile(usersPath, []byte("alice:"+string(hash)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	login, err := newUserLogin(usersPath, "", 3600, "/", "/leaps/login", "/", testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	invites.useLogin(login)
+	mint := login.requireSession(invites.handleMint)
+
+	token, _, err := invites.invites.Mint("docs", acl.EditAccess, time.Hour, false, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Guests are let in by their invite, but cannot create invites.
+	r := mintRequest("docs", "EDIT")
+	r.AddCookie(&http.Cookie{Name: tokenCookie, Value: token})
+	w := httptest.NewRecorder()
+	mint(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Wrong status for guest: %v", w.Code)
+	}
+
+	r = mintRequest("docs", "EDIT")
+	w = httptest.NewRecorder()
+	mint(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Wrong status without session: %v", w.Code)
+	}
+
+	session, _ := login.sessions.Issue("alice")
+	r = mintRequest("docs", "EDIT")
+	r.AddCookie(&http.Cookie{Name: sessionCookie, Value: session})
+	w = httptest.NewRecorder()
+	mint(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("Wrong status for logged in user: %v: %v", w.Code, w.Body.String())
+	}
+
+	r = mintRequest("notes", "EDIT")
+	r.AddCookie(&http.Cookie{Name: sessionCookie, Value: session})
+	w = httptest.NewRecorder()
+	mint(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Wrong status for escalating user: %v", w.Code)
+	}
+}
+
+//------------------------------------------------------------------------------
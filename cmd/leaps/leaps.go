@@ -38,6 +38,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/Jeffail/leaps/lib/accesslog"
 	"github.com/Jeffail/leaps/lib/acl"
 	"github.com/Jeffail/leaps/lib/api"
 	apiio "github.com/Jeffail/leaps/lib/api/io"
@@ -48,6 +49,7 @@ import (
 	"github.com/Jeffail/leaps/lib/util/service/log"
 	"github.com/Jeffail/leaps/lib/util/service/metrics"
 	"github.com/gorilla/websocket"
+	"gopkg.in/yaml.v2"
 )
 
 //------------------------------------------------------------------------------
@@ -75,6 +77,24 @@ var (
 	logLevel    string
 	subdirPath  string
 	showVersion bool
+	maxBinders  int
+	maxBuffered int64
+	gitBranch   string
+	watchPeriod int64
+	symlinks    string
+	maxRevs     int
+	storePath   string
+	rulesPath   string
+	aclPath     string
+	usersPath   string
+	sessionKey  string
+	sessionTTL  int64
+	proxyCIDRs  string
+	proxyUser   string
+	proxyGroups string
+	invitesPath string
+	limitsPath  string
+	accessPath  string
 	cmds        cmdList
 )
 
@@ -95,6 +115,24 @@ func init() {
 	flag.StringVar(&debugWWWDir, "use_www", "", "Serve alternative web files from this dir")
 	flag.StringVar(&logLevel, "log_level", "INFO", "Log level (NONE, ERROR, WARM, INFO, DEBUG, TRACE)")
 	flag.StringVar(&subdirPath, "path", "/", "Subdirectory (when running leaps in a webserver subdirectory as example.com/myleaps)")
+	flag.IntVar(&maxBinders, "max_open_docs", 0, "The maximum number of documents that can be open at once (0 for unlimited)")
+	flag.Int64Var(&maxBuffered, "max_buffered_bytes", 0, "The maximum total size in bytes of open documents (0 for unlimited)")
+	flag.StringVar(&gitBranch, "git_branch", "", "Periodically commit changes to this branch of the git repository being edited (not compatible with --safe)")
+	flag.Int64Var(&watchPeriod, "watch_period_ms", 1000, "How often in milliseconds the files of open documents are polled for changes made on disk, which are merged into the document, only file and git stores within the target path are watched (0 to disable)")
+	flag.StringVar(&symlinks, "symlinks", store.SymlinksConfine, "How symlinks within the target directory are treated (follow, confine to the directory, or reject)")
+	flag.IntVar(&maxRevs, "max_revisions", 0, "Retain this many revisions of each document in a hidden .leaps directory (0 to disable)")
+	flag.StringVar(&storePath, "store", "", "Path to a YAML or JSON document store config, which may select another store type or enable caching and encryption (replaces --git_branch and --max_revisions)")
+	flag.StringVar(&rulesPath, "rules", "", "Path to a YAML or JSON file of rules that restrict access to documents per user")
+	flag.StringVar(&aclPath, "acl", "", "Path to a YAML or JSON authenticator config, which may chain authenticators (replaces --rules)")
+	flag.StringVar(&usersPath, "users", "", "Path to an htpasswd file of bcrypt hashed passwords (htpasswd -B), when set users must log in to edit documents")
+	flag.StringVar(&sessionKey, "session_secret", "", "Path to a file containing a secret of at least 32 bytes for signing login sessions (random when omitted, meaning sessions end on restart)")
+	flag.Int64Var(&sessionTTL, "session_ttl_s", 86400, "How long login sessions last in seconds")
+	flag.StringVar(&proxyCIDRs, "trusted_proxies", "", "Comma separated CIDRs or IPs of authenticating reverse proxies, when set usernames and groups are taken from request headers sent by them (not compatible with --users)")
+	flag.StringVar(&proxyUser, "proxy_user_header", "X-Forwarded-User", "The header containing the username of requests from a trusted proxy")
+	flag.StringVar(&proxyGroups, "proxy_groups_header", "X-Forwarded-Groups", "The header containing comma separated groups of the user of requests from a trusted proxy")
+	flag.StringVar(&invitesPath, "invites", "", "Path to a file that stores invite links, when set invite links granting access to a document or folder can be created at /leaps/invites")
+	flag.StringVar(&limitsPath, "limits", "", "Path to a YAML or JSON config of rate limits and quotas applied to each session and user")
+	flag.StringVar(&accessPath, "access_log", "", "Path to a file that records every attempt to open, create, delete, rename or list a document as JSON lines, rotated at 100MB")
 	flag.Var(&cmds, "cmd", "Set commands that can be executed from the web UI, e.g. (-cmd 'make build' -cmd 'make test')")
 }
 
@@ -133,6 +171,149 @@ func readAudit(path string, auditor *audit.ToJSON, docStore store.Type) error {
 	return auditor.Reapply(docStore)
 }
 
+// requestToken - Extracts an authentication token from a request, which can
+// either be provided as a bearer token within the Authorization header or as
+// the leaps_token cookie.
+func requestToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	if cookie, err := r.Cookie(tokenCookie); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// readACLConfig - Reads an authenticator config from a JSON or YAML file.
+func readACLConfig(path string) (acl.Config, error) {
+	conf := acl.NewConfig()
+	confBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return conf, err
+	}
+	switch filepath.Ext(path) {
+	case ".js", ".json":
+		err = json.Unmarshal(confBytes, &conf)
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(confBytes, &conf)
+	default:
+		err = fmt.Errorf("config file extension not recognised: %v", path)
+	}
+	return conf, err
+}
+
+// reservedPatterns - Returns ignore patterns that hide a file used by leaps,
+// along with its siblings with the given suffix, when it lies within the target
+// directory.
+func reservedPatterns(targetPath, path, suffix string) []string {
+	absTarget, tErr := filepath.Abs(targetPath)
+	absPath, pErr := filepath.Abs(path)
+	if tErr != nil || pErr != nil {
+		return nil
+	}
+	rel, err := filepath.Rel(absTarget, absPath)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return nil
+	}
+	rel = "/" + filepath.ToSlash(rel)
+	return []string{rel, rel + suffix}
+}
+
+// readLimitsConfig - Reads a rate limits and quotas config from a JSON or YAML
+// file.
+func readLimitsConfig(path string) (api.LimitsConfig, error) {
+	conf := api.NewLimitsConfig()
+	confBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return conf, err
+	}
+	switch filepath.Ext(path) {
+	case ".js", ".json":
+		err = json.Unmarshal(confBytes, &conf)
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(confBytes, &conf)
+	default:
+		err = fmt.Errorf("config file extension not recognised: %v", path)
+	}
+	return conf, err
+}
+
+// watchDocuments - Checks the files of all open documents for changes made on
+// disk, such as by a git checkout or a formatter, and reloads changed documents
+// so that the changes are merged and seen by all clients. Files are checked as
+// soon as the file_exists authenticator reports a write to them, and are also
+// polled by comparing their size and modification time, as writes are only
+// reported on platforms where directories can be watched. Files left as they
+// were by the most recent write of the store are not reloaded.
+func watchDocuments(
+	root string, files *acl.FileExists, writer store.FileWriter, cur curator.Type,
+	period time.Duration, logger log.Modular, closeChan <-chan bool,
+) {
+	type fileState struct {
+		modTime time.Time
+		size    int64
+	}
+	states := map[string]fileState{}
+
+	stat := func(id string) (fileState, bool) {
+		info, err := os.Stat(filepath.Join(root, filepath.FromSlash(id)))
+		if err != nil {
+			return fileState{}, false
+		}
+		return fileState{modTime: info.ModTime(), size: info.Size()}, true
+	}
+	reload := func(id string, state fileState) {
+		if modTime, size, ok := writer.LastWritten(id); ok && (fileState{modTime: modTime, size: size}) == state {
+			return
+		}
+		logger.Debugf("Detected change to %v on disk\n", id)
+		if err := cur.ReloadDocument(id, time.Second*10); err != nil && err != curator.ErrBinderNotFound {
+			logger.Errorf("Failed to merge changes to %v from disk: %v\n", id, err)
+		}
+	}
+
+	events, cancel := files.Subscribe()
+	defer cancel()
+
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event := <-events:
+			if event.Type != acl.PathModified {
+				continue
+			}
+			if state, ok := stat(event.Path); ok && states[event.Path] != state {
+				states[event.Path] = state
+				reload(event.Path, state)
+			}
+		case <-ticker.C:
+			newStates := map[string]fileState{}
+			for _, id := range cur.OpenDocumentIDs() {
+				state, ok := stat(id)
+				if !ok {
+					continue
+				}
+				newStates[id] = state
+				if prev, seen := states[id]; seen && prev != state {
+					reload(id, state)
+				}
+			}
+			states = newStates
+		case <-closeChan:
+			return
+		}
+	}
+}
+
+// sameDir - Returns whether two paths refer to the same directory.
+func sameDir(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
+
 //------------------------------------------------------------------------------
 
 type shellRunner struct{}
@@ -171,10 +352,22 @@ func main() {
 		closeChan = make(chan bool)
 	)
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "rotate_keys" {
+		os.Exit(runRotateKeys(os.Args[2:]))
+	}
+
 	flag.Usage = func() {
 		fmt.Println(`Usage: leaps [flags...] [path/to/share]
+       leaps migrate [flags...]
+       leaps rotate_keys [flags...]
 
-If a path is not specified the current directory is shared instead.`)
+If a path is not specified the current directory is shared instead. The migrate
+command copies documents between stores, run leaps migrate -help for details.
+The rotate_keys command re-encrypts the documents of an encrypted store with its
+current key, run leaps rotate_keys -help for details.`)
 		flag.PrintDefaults()
 	}
 
@@ -211,20 +404,182 @@ If a path is not specified the current directory is shared instead.`)
 	defer stats.Close()
 
 	// Document storage engine
-	docStore, err := store.NewFile(targetPath, !safeMode || applyLcot)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, fmt.Sprintf("Document store error: %v\n", err))
+	if len(gitBranch) > 0 && (safeMode || applyLcot || discardLcot) {
+		fmt.Fprintln(os.Stderr, "The --git_branch flag cannot be used in safe mode")
+		os.Exit(1)
+	}
+	if len(storePath) > 0 && (len(gitBranch) > 0 || maxRevs > 0 || safeMode || applyLcot || discardLcot) {
+		fmt.Fprintln(os.Stderr, "The --store flag cannot be used with --git_branch, --max_revisions or in safe mode")
 		os.Exit(1)
 	}
+	var docStore store.Type
+
+	// Only documents kept as files within the target path can be watched for
+	// changes made on disk.
+	watchable := true
+	if len(storePath) > 0 {
+		// File based stores default to the target directory.
+		storeConf := store.NewConfig()
+		storeConf.Type = "file"
+		storeConf.File.Path = targetPath
+		storeConf.File.SymlinkPolicy = symlinks
+		storeConf.Git.Path = targetPath
+		storeConf.Git.SymlinkPolicy = symlinks
+
+		storeConf, err = readStoreConfig(storePath, storeConf)
+		if err == nil {
+			docStore, err = store.New(storeConf, logger)
+		}
+		watchable = (storeConf.Type == "file" && sameDir(storeConf.File.Path, targetPath)) ||
+			(storeConf.Type == "git" && sameDir(storeConf.Git.Path, targetPath))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, fmt.Sprintf("Document store error: %v\n", err))
+			os.Exit(1)
+		}
+		defer closeStore(docStore, logger)
+	} else if len(gitBranch) > 0 {
+		gitConf := store.NewGitConfig()
+		gitConf.Path = targetPath
+		gitConf.Branch = gitBranch
+		gitConf.SymlinkPolicy = symlinks
+
+		gitStore, gitErr := store.NewGit(gitConf, logger)
+		if gitErr != nil {
+			fmt.Fprintln(os.Stderr, fmt.Sprintf("Document store error: %v\n", gitErr))
+			os.Exit(1)
+		}
+		defer func() {
+			if gitErr := gitStore.Close(); gitErr != nil {
+				logger.Errorf("Failed to commit final changes: %v\n", gitErr)
+			}
+		}()
+		docStore = gitStore
+	} else {
+		fileConf := store.NewFileConfig()
+		fileConf.Path = targetPath
+		fileConf.AllowWrites = !safeMode || applyLcot
+		fileConf.SymlinkPolicy = symlinks
+		if maxRevs > 0 {
+			fileConf.RevisionsDir = filepath.Join(".leaps", "revisions")
+			fileConf.RevisionPolicy.MaxRevisions = maxRevs
+		}
+		if docStore, err = store.NewFileFromConfig(fileConf); err != nil {
+			fmt.Fprintln(os.Stderr, fmt.Sprintf("Document store error: %v\n", err))
+			os.Exit(1)
+		}
+	}
 
 	// Authenticator
 	storeConf := acl.NewFileExistsConfig()
 	storeConf.Path = targetPath
 	storeConf.ShowHidden = showHidden
-	storeConf.ReservedIgnores = append(storeConf.ReservedIgnores, leapsCOTPath)
+	storeConf.ReservedIgnores = append(storeConf.ReservedIgnores, "/"+filepath.Base(leapsCOTPath))
+	// Invites must never be editable, as their tokens could be forged, and the
+	// access log must never be editable either.
+	if len(invitesPath) > 0 {
+		storeConf.ReservedIgnores = append(storeConf.ReservedIgnores, reservedPatterns(targetPath, invitesPath, ".tmp")...)
+	}
+	if len(accessPath) > 0 {
+		storeConf.ReservedIgnores = append(storeConf.ReservedIgnores, reservedPatterns(targetPath, accessPath, ".*")...)
+	}
 
 	authenticator := acl.NewFileExists(storeConf, logger)
 
+	var docAuth acl.Authenticator = authenticator
+	if len(aclPath) > 0 {
+		aclConf, err := readACLConfig(aclPath)
+		if err == nil {
+			docAuth, err = acl.New(aclConf, logger, stats)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, fmt.Sprintf("Authenticator error: %v\n", err))
+			os.Exit(1)
+		}
+	} else if len(rulesPath) > 0 {
+		rulesConf := acl.NewRulesConfig()
+		rulesConf.Path = rulesPath
+		rules, err := acl.NewRules(rulesConf, nil, logger)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, fmt.Sprintf("Rules error: %v\n", err))
+			os.Exit(1)
+		}
+		// Rules never grant access to hidden, ignored or reserved files.
+		docAuth = acl.NewFileGate(rules, authenticator)
+	}
+
+	// Identifies users from their tokens, when supported by the authenticator.
+	identifier := acl.IdentifierOf(docAuth)
+
+	homePath := gopath.Join("/", subdirPath)
+	if homePath != "/" {
+		homePath = homePath + "/"
+	}
+
+	// Optional user login, which is required for editing when enabled.
+	var login *userLogin
+	if len(usersPath) > 0 {
+		if login, err = newUserLogin(
+			usersPath, sessionKey, sessionTTL, gopath.Join("/", subdirPath),
+			gopath.Join("/", subdirPath, "/leaps/login"), homePath, logger,
+		); err != nil {
+			fmt.Fprintln(os.Stderr, fmt.Sprintf("Login error: %v\n", err))
+			os.Exit(1)
+		}
+	}
+
+	// Optional authenticating reverse proxy, which replaces user login.
+	var proxy *acl.TrustedProxy
+	if len(proxyCIDRs) > 0 {
+		if login != nil {
+			fmt.Fprintln(os.Stderr, "Trusted proxies cannot be used along with --users")
+			os.Exit(1)
+		}
+		proxyConf := acl.NewTrustedProxyConfig()
+		proxyConf.SourceCIDRs = strings.Split(proxyCIDRs, ",")
+		proxyConf.UserHeader = proxyUser
+		proxyConf.GroupsHeader = proxyGroups
+		if proxy, err = acl.NewTrustedProxy(proxyConf); err != nil {
+			fmt.Fprintln(os.Stderr, fmt.Sprintf("Trusted proxy error: %v\n", err))
+			os.Exit(1)
+		}
+	}
+
+	// Optional invite links, which grant access to a document or folder.
+	var invites *inviteLinks
+	if len(invitesPath) > 0 {
+		if invites, err = newInviteLinks(
+			invitesPath, gopath.Join("/", subdirPath), gopath.Join("/", subdirPath, "/leaps/invite"),
+			homePath, docAuth, authenticator, logger,
+		); err != nil {
+			fmt.Fprintln(os.Stderr, fmt.Sprintf("Invites error: %v\n", err))
+			os.Exit(1)
+		}
+		docAuth = inviteAuth{base: docAuth, invites: invites.invites, files: authenticator}
+		if login != nil {
+			invites.useLogin(login)
+		}
+		if proxy != nil {
+			invites.useProxy(proxy)
+		}
+	}
+
+	requireLogin := func(handler http.HandlerFunc) http.HandlerFunc {
+		if proxy != nil {
+			return func(w http.ResponseWriter, r *http.Request) {
+				if _, err := proxy.Identify(r); err != nil {
+					http.Error(w, "Unauthorized", http.StatusUnauthorized)
+					logger.Warnf("Rejected request from %v: %v\n", r.RemoteAddr, err)
+					return
+				}
+				handler(w, r)
+			}
+		}
+		if login == nil {
+			return handler
+		}
+		return login.requireSession(handler)
+	}
+
 	// Auditors
 	auditors := audit.NewToJSON()
 
@@ -288,13 +643,41 @@ If a path is not specified the current directory is shared instead.`)
 
 	// Curator of documents
 	curatorConf := curator.NewConfig()
-	curator, err := curator.New(curatorConf, logger, stats, authenticator, docStore, auditors)
+	curatorConf.MaxOpenBinders = maxBinders
+	curatorConf.MaxBufferedBytes = maxBuffered
+	curator, err := curator.New(curatorConf, logger, stats, docAuth, docStore, auditors)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, fmt.Sprintf("Curator error: %v\n", err))
 		os.Exit(1)
 	}
 	defer curator.Close()
 
+	if len(accessPath) > 0 {
+		accessConf := accesslog.NewJSONFileConfig()
+		accessConf.Path = accessPath
+		accessLog, err := accesslog.NewJSONFile(accessConf, logger)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, fmt.Sprintf("Access log error: %v\n", err))
+			os.Exit(1)
+		}
+		defer accessLog.Close()
+		curator.SetAccessLog(accessLog)
+	}
+
+	if watchPeriod > 0 {
+		// Stores wrapped with a cache or encryption are not file writers.
+		if writer, ok := docStore.(store.FileWriter); ok && watchable {
+			watchCloseChan := make(chan bool)
+			defer close(watchCloseChan)
+			go watchDocuments(
+				targetPath, authenticator, writer, curator,
+				time.Duration(watchPeriod)*time.Millisecond, logger, watchCloseChan,
+			)
+		} else {
+			logger.Infoln("Documents are not watched for changes on disk as they are not stored as files within the target path")
+		}
+	}
+
 	handle("/endpoints", "Lists all available endpoints (including this one).",
 		func(w http.ResponseWriter, r *http.Request) {
 			data, reqErr := json.Marshal(endpoints)
@@ -308,11 +691,19 @@ If a path is not specified the current directory is shared instead.`)
 		})
 
 	handle("/files", "Returns a list of available files and a map of users per document.",
-		func(w http.ResponseWriter, r *http.Request) {
+		requireLogin(func(w http.ResponseWriter, r *http.Request) {
+			paths := authenticator.GetPaths()
+			if invites != nil && login != nil {
+				// Guests only see the paths of their invite.
+				if _, err := login.sessionUser(r); err != nil {
+					invite, _ := invites.invite(r)
+					paths = filterPaths(paths, invite)
+				}
+			}
 			data, err := json.Marshal(struct {
 				Paths []string `json:"paths"`
 			}{
-				Paths: authenticator.GetPaths(),
+				Paths: paths,
 			})
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -321,7 +712,24 @@ If a path is not specified the current directory is shared instead.`)
 			}
 			w.Header().Add("Content-Type", "application/json")
 			w.Write(data)
-		})
+		}))
+
+	if login != nil {
+		handle("/leaps/login", "Serves a login form and starts a session for users with valid credentials.",
+			login.handleLogin)
+		handle("/leaps/logout", "Ends the session of a logged in user.", login.handleLogout)
+	}
+
+	if invites != nil {
+		// Without login or a proxy only the host machine may create invites.
+		mintGuard := requireLogin
+		if login == nil && proxy == nil {
+			mintGuard = localOnly
+		}
+		handle("/leaps/invites", "Creates an invite link granting access to a document or folder until it expires.",
+			mintGuard(invites.handleMint))
+		handle("/leaps/invite", "Redeems an invite link and redirects to the home page.", invites.handleRedeem)
+	}
 
 	if hStats, ok := stats.(*metrics.HTTP); ok {
 		handle("/stats", "Lists all aggregated metrics as a json blob.", hStats.JSONHandler())
@@ -333,21 +741,94 @@ If a path is not specified the current directory is shared instead.`)
 		wwwPath = wwwPath + "/"
 		stripPath = wwwPath
 	}
+	var wwwHandler http.Handler
 	if len(debugWWWDir) > 0 {
 		logger.Warnf("Serving web files from alternative www dir: %v\n", debugWWWDir)
-		http.Handle(wwwPath, http.StripPrefix(stripPath, http.FileServer(http.Dir(debugWWWDir))))
+		wwwHandler = http.StripPrefix(stripPath, http.FileServer(http.Dir(debugWWWDir)))
 	} else {
-		http.Handle(wwwPath, http.StripPrefix(stripPath, http.FileServer(assetFS())))
+		wwwHandler = http.StripPrefix(stripPath, http.FileServer(assetFS()))
 	}
+	if login != nil {
+		wwwHandler = login.redirectToLogin(wwwHandler)
+	}
+	http.Handle(wwwPath, wwwHandler)
 
 	// Leaps API
 	globalBroker := api.NewGlobalMetadataBroker(time.Second*300, logger, stats)
 	cmdBroker := api.NewCMDBroker(cmds, shellRunner{}, time.Second*300, logger, stats)
 
+	var limiter *api.Limiter
+	if len(limitsPath) > 0 {
+		limitsConf, err := readLimitsConfig(limitsPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, fmt.Sprintf("Limits config error: %v\n", err))
+			os.Exit(1)
+		}
+		limiter = api.NewLimiter(limitsConf, logger, stats)
+	}
+
 	http.HandleFunc(gopath.Join("/", subdirPath, "/leaps/ws"), func(w http.ResponseWriter, r *http.Request) {
 		username := r.URL.Query().Get("username")
 		uuid := util.GenerateUUID()
 
+		// When behind a trusted proxy the username and groups asserted by the
+		// proxy replace the username provided by the client.
+		var groups []string
+		authenticated := false
+		if proxy != nil {
+			identity, err := proxy.Identify(r)
+			if err != nil {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				logger.Warnf("Failed to create websocket for %v: %v\n", r.RemoteAddr, err)
+				return
+			}
+			username, groups = identity.Username, identity.Groups
+			authenticated = true
+		}
+
+		// When login is enabled the username of the session replaces the
+		// username provided by the client.
+		token := requestToken(r)
+		invited := false
+		if invites != nil {
+			_, invited = invites.invites.Lookup(token)
+		}
+
+		if login != nil {
+			sessionUser, err := login.sessionUser(r)
+			if err == nil {
+				username = sessionUser
+				authenticated = true
+			} else if invited && len(username) > 0 {
+				// Guests keep their chosen name, marked so that they cannot
+				// pose as users with an account.
+				username = guestPrefix + username
+			} else if !invited {
+				http.Error(w, "Login required", http.StatusUnauthorized)
+				logger.Warnf("Failed to create websocket: %v\n", err)
+				return
+			}
+		}
+
+		// If the authenticator is able to identify users by their token then
+		// the resolved identity replaces the username provided by the client.
+		// Invite tokens do not identify anyone.
+		if len(token) > 0 && identifier != nil && !invited {
+			tokenUser, err := identifier.Identify(token)
+			if err != nil {
+				http.Error(w, "Failed to authenticate token", http.StatusUnauthorized)
+				logger.Warnf("Failed to create websocket: %v\n", err)
+				return
+			}
+			if (login != nil || proxy != nil) && tokenUser != username {
+				http.Error(w, "Token does not belong to the logged in user", http.StatusUnauthorized)
+				logger.Warnf("Failed to create websocket: token of %v used by %v\n", tokenUser, username)
+				return
+			}
+			username = tokenUser
+			authenticated = true
+		}
+
 		if len(username) == 0 {
 			http.Error(w, "Expected username header value in request", http.StatusBadRequest)
 			logger.Errorln("Failed to create websocket: no username provided")
@@ -366,9 +847,19 @@ If a path is not specified the current directory is shared instead.`)
 		}
 
 		jsonEmitter := apiio.NewJSONEmitter(&apiio.ConcurrentJSON{C: conn})
-		globalBroker.NewEmitter(username, uuid, jsonEmitter)
-		cmdBroker.NewEmitter(username, uuid, jsonEmitter)
-		api.NewCuratorSession(username, uuid, jsonEmitter, curator, time.Second*300, logger, stats)
+		var emitter api.Emitter = jsonEmitter
+		if limiter != nil {
+			emitter = limiter.Wrap(username, uuid, authenticated, jsonEmitter)
+		}
+		globalBroker.NewEmitter(username, uuid, emitter)
+		cmdBroker.NewEmitter(username, uuid, emitter)
+		session := api.NewCuratorSession(
+			username, uuid, token, identifier, emitter, curator, time.Second*300, logger, stats,
+		)
+		session.SetGroups(groups)
+		if login != nil || proxy != nil {
+			session.VerifyIdentity()
+		}
 
 		jsonEmitter.ListenAndEmit()
 	})
@@ -0,0 +1,107 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Jeffail/leaps/lib/acl"
+	"github.com/Jeffail/leaps/lib/curator"
+	"github.com/Jeffail/leaps/lib/store"
+)
+
+//------------------------------------------------------------------------------
+
+// reloadCurator - A curator with one open document that records reloads.
+type reloadCurator struct {
+	curator.Type
+	id      string
+	reloads chan string
+}
+
+func (c reloadCurator) OpenDocumentIDs() []string {
+	return []string{c.id}
+}
+
+func (c reloadCurator) ReloadDocument(documentID string, timeout time.Duration) error {
+	c.reloads <- documentID
+	return nil
+}
+
+func TestWatchDocuments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	docStore, err := store.NewFile(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = docStore.Create(store.Document{ID: "a.txt", Content: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+
+	filesConf := acl.NewFileExistsConfig()
+	filesConf.Path = dir
+	files := acl.NewFileExists(filesConf, testLogger())
+	defer files.Close()
+
+	cur := reloadCurator{id: "a.txt", reloads: make(chan string, 10)}
+	closeChan := make(chan bool)
+	defer close(closeChan)
+	go watchDocuments(
+		dir, files, docStore.(store.FileWriter), cur, time.Millisecond*10, testLogger(), closeChan,
+	)
+
+	// Allow the initial state of the file to be polled.
+	<-time.After(time.Millisecond * 50)
+
+	if err = docStore.Update(store.Document{ID: "a.txt", Content: "hello world"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case id := <-cur.reloads:
+		t.Errorf("Reloaded %v after a write of the store", id)
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello from disk"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case id := <-cur.reloads:
+		if id != "a.txt" {
+			t.Errorf("Wrong reloaded document: %v", id)
+		}
+	case <-time.After(time.Second):
+		t.Error("Timed out waiting for reload")
+	}
+}
+
+//------------------------------------------------------------------------------
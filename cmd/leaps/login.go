@@ -0,0 +1,204 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Jeffail/leaps/lib/acl"
+	"github.com/Jeffail/leaps/lib/util/service/log"
+)
+
+//------------------------------------------------------------------------------
+
+// sessionCookie - The name of the cookie that holds a login session.
+const sessionCookie = "leaps_session"
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Leaps Login</title></head>
+<body>
+<form method="POST">
+{{if .Failed}}<p>Incorrect username or password.</p>{{end}}
+<p><label>Username <input name="username" autofocus></label></p>
+<p><label>Password <input name="password" type="password"></label></p>
+<input type="hidden" name="redirect" value="{{.Redirect}}">
+<p><button type="submit">Log in</button></p>
+</form>
+</body>
+</html>
+`))
+
+// userLogin - Authenticates users against an htpasswd style users file and
+// issues signed session cookies to those that log in.
+type userLogin struct {
+	users      *acl.Htpasswd
+	sessions   *acl.Sessions
+	cookiePath string
+	loginPath  string
+	home       string
+	logger     log.Modular
+
+	// exempt, when set, lets requests in without a session.
+	exempt func(r *http.Request) bool
+}
+
+func newUserLogin(
+	usersPath, secretPath string, ttl int64, cookiePath, loginPath, home string, logger log.Modular,
+) (*userLogin, error) {
+	usersConf := acl.NewHtpasswdConfig()
+	usersConf.Path = usersPath
+	users, err := acl.NewHtpasswd(usersConf, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionsConf := acl.NewSessionsConfig()
+	sessionsConf.SecretFile = secretPath
+	sessionsConf.TTLS = ttl
+	sessions, err := acl.NewSessions(sessionsConf)
+	if err != nil {
+		return nil, err
+	}
+
+	return &userLogin{
+		users:      users,
+		sessions:   sessions,
+		cookiePath: cookiePath,
+		loginPath:  loginPath,
+		home:       home,
+		logger:     logger.NewModule(":login"),
+	}, nil
+}
+
+// localRedirect - Returns the redirect target of a login if it is a path on
+// this server, otherwise the home page.
+func (l *userLogin) localRedirect(target string) string {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") ||
+		strings.HasPrefix(target, "/\\") {
+		return l.home
+	}
+	return target
+}
+
+// handleLogin - Serves a login form, and verifies submitted credentials. A
+// successful login sets a session cookie and redirects the user.
+func (l *userLogin) handleLogin(w http.ResponseWriter, r *http.Request) {
+	page := struct {
+		Failed   bool
+		Redirect string
+	}{
+		Redirect: l.localRedirect(r.URL.Query().Get("redirect")),
+	}
+
+	switch r.Method {
+	case "GET":
+	case "POST":
+		username, password := r.PostFormValue("username"), r.PostFormValue("password")
+		page.Redirect = l.localRedirect(r.PostFormValue("redirect"))
+		if len(username) > 0 && l.users.Verify(username, password) {
+			token, expires := l.sessions.Issue(username)
+			http.SetCookie(w, &http.Cookie{
+				Name:     sessionCookie,
+				Value:    token,
+				Path:     l.cookiePath,
+				Expires:  expires,
+				HttpOnly: true,
+				Secure:   r.TLS != nil,
+				SameSite: http.SameSiteLaxMode,
+			})
+			l.logger.Infof("User %v logged in\n", username)
+			http.Redirect(w, r, page.Redirect, http.StatusSeeOther)
+			return
+		}
+		l.logger.Warnf("Failed login attempt for user %v from %v\n", username, r.RemoteAddr)
+		page.Failed = true
+		w.WriteHeader(http.StatusUnauthorized)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := loginPage.Execute(w, page); err != nil {
+		l.logger.Errorf("Failed to render login page: %v\n", err)
+	}
+}
+
+// handleLogout - Clears the session cookie of a user.
+func (l *userLogin) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    "",
+		Path:     l.cookiePath,
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+	http.Redirect(w, r, l.home, http.StatusSeeOther)
+}
+
+// sessionUser - Returns the username of the session cookie of a request.
+func (l *userLogin) sessionUser(r *http.Request) (string, error) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return "", acl.ErrInvalidSession
+	}
+	return l.sessions.Identify(cookie.Value)
+}
+
+// requireSession - Wraps a handler so that it responds with 401 to requests
+// without a valid session.
+func (l *userLogin) requireSession(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, err := l.sessionUser(r); err != nil && (l.exempt == nil || !l.exempt(r)) {
+			http.Error(w, "Login required", http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// redirectToLogin - Wraps a handler so that requests without a valid session
+// are redirected to the login page.
+func (l *userLogin) redirectToLogin(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := l.sessionUser(r); err != nil && (l.exempt == nil || !l.exempt(r)) {
+			http.Redirect(
+				w, r, l.loginPath+"?redirect="+url.QueryEscape(r.URL.Path), http.StatusSeeOther,
+			)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,170 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/Jeffail/leaps/lib/store"
+	"github.com/Jeffail/leaps/lib/util/service/log"
+	"gopkg.in/yaml.v2"
+)
+
+//------------------------------------------------------------------------------
+
+// readStoreConfig - Reads a document store configuration from a JSON or YAML
+// file, fields that are not specified keep the values of the provided config.
+func readStoreConfig(path string, conf store.Config) (store.Config, error) {
+	confBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return conf, err
+	}
+	switch filepath.Ext(path) {
+	case ".js", ".json":
+		err = json.Unmarshal(confBytes, &conf)
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(confBytes, &conf)
+	default:
+		err = fmt.Errorf("config file extension not recognised: %v", path)
+	}
+	return conf, err
+}
+
+// openStore - Creates a document store from a configuration file.
+func openStore(path string, logger log.Modular) (store.Type, error) {
+	conf, err := readStoreConfig(path, store.NewConfig())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read store config %v: %v", path, err)
+	}
+	docStore, err := store.New(conf, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %v store from %v: %v", conf.Type, path, err)
+	}
+	return docStore, nil
+}
+
+// closeStore - Closes a document store if it holds resources.
+func closeStore(docStore store.Type, logger log.Modular) {
+	if closer, ok := docStore.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			logger.Errorf("Failed to close document store: %v\n", err)
+		}
+	}
+}
+
+// runMigrate - Runs the migrate subcommand, which copies every document from
+// one configured store to another, and returns the exit code.
+func runMigrate(args []string) int {
+	var (
+		fromPath, toPath, reportPath string
+		printConfig, listTypes       bool
+	)
+	migrateConf := store.NewMigrateConfig()
+
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	flags.StringVar(&fromPath, "from", "", "Path to a JSON or YAML config file of the store to copy documents from")
+	flags.StringVar(&toPath, "to", "", "Path to a JSON or YAML config file of the store to copy documents to")
+	flags.StringVar(&migrateConf.Prefix, "prefix", "", "Only copy documents with IDs beginning with this prefix")
+	flags.BoolVar(&migrateConf.DryRun, "dry_run", false, "Report the documents that would be copied without writing anything")
+	flags.StringVar(&migrateConf.ResumePath, "resume", "", "Record completed documents in this file, and skip documents recorded by a previous run")
+	flags.BoolVar(&migrateConf.Verify, "verify", true, "Read back each copied document and compare its checksum")
+	flags.BoolVar(&migrateConf.Overwrite, "overwrite", false, "Replace documents that already exist in the target store with different content")
+	flags.StringVar(&logLevel, "log_level", "INFO", "Log level (NONE, ERROR, WARM, INFO, DEBUG, TRACE)")
+	flags.StringVar(&reportPath, "report", "", "Write a JSON report of the migration to this file")
+	flags.BoolVar(&printConfig, "print_config", false, "Print an example store config file as YAML and exit")
+	flags.BoolVar(&listTypes, "list_types", false, "Print the available store types and exit")
+
+	flags.Usage = func() {
+		fmt.Println(`Usage: leaps migrate -from <store config> -to <store config> [flags...]
+
+Copies every document from one document store to another. The source store must
+be able to list its documents.`)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if printConfig {
+		confBytes, err := yaml.Marshal(store.NewConfig())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to marshal config: %v\n", err)
+			return 1
+		}
+		fmt.Print(string(confBytes))
+		return 0
+	}
+	if listTypes {
+		fmt.Print(store.Descriptions())
+		return 0
+	}
+	if len(fromPath) == 0 || len(toPath) == 0 {
+		flags.Usage()
+		return 1
+	}
+
+	logConf := log.NewLoggerConfig()
+	logConf.Prefix = "leaps"
+	logConf.LogLevel = logLevel
+	logger := log.NewLogger(os.Stderr, logConf)
+
+	source, err := openStore(fromPath, logger)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer closeStore(source, logger)
+
+	target, err := openStore(toPath, logger)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer closeStore(target, logger)
+
+	report, err := store.Migrate(source, target, migrateConf)
+	fmt.Print(report.String())
+	if len(reportPath) > 0 {
+		reportBytes, mErr := json.MarshalIndent(report, "", "\t")
+		if mErr == nil {
+			mErr = ioutil.WriteFile(reportPath, reportBytes, 0644)
+		}
+		if mErr != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write report: %v\n", mErr)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Migration failed: %v\n", err)
+		return 1
+	}
+	if len(report.Failed) > 0 {
+		return 1
+	}
+	return 0
+}
+
+//------------------------------------------------------------------------------
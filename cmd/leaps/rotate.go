@@ -0,0 +1,101 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/Jeffail/leaps/lib/store"
+	"github.com/Jeffail/leaps/lib/util/service/log"
+)
+
+//------------------------------------------------------------------------------
+
+// runRotateKeys - Runs the rotate_keys subcommand, which re-encrypts the
+// documents of an encrypted store with its current key, and returns the exit
+// code.
+func runRotateKeys(args []string) int {
+	var storePath, prefix string
+
+	flags := flag.NewFlagSet("rotate_keys", flag.ExitOnError)
+	flags.StringVar(&storePath, "store", "", "Path to a JSON or YAML config file of an encrypted document store")
+	flags.StringVar(&prefix, "prefix", "", "Only re-encrypt documents with IDs beginning with this prefix")
+	flags.StringVar(&logLevel, "log_level", "INFO", "Log level (NONE, ERROR, WARM, INFO, DEBUG, TRACE)")
+
+	flags.Usage = func() {
+		fmt.Println(`Usage: leaps rotate_keys -store <store config> [flags...]
+
+Re-encrypts every document of a store that is not encrypted with the current
+key of its encryption config, after which any other keys can be removed from the
+config. The store must be able to list its documents, and leaps should not be
+running against it at the same time.`)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if len(storePath) == 0 {
+		flags.Usage()
+		return 1
+	}
+
+	logConf := log.NewLoggerConfig()
+	logConf.Prefix = "leaps"
+	logConf.LogLevel = logLevel
+	logger := log.NewLogger(os.Stderr, logConf)
+
+	conf, err := readStoreConfig(storePath, store.NewConfig())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read store config %v: %v\n", storePath, err)
+		return 1
+	}
+	if !conf.Encryption.Enabled {
+		fmt.Fprintf(os.Stderr, "Store config %v does not enable encryption\n", storePath)
+		return 1
+	}
+
+	// Documents are re-encrypted directly rather than through a cache.
+	conf.Cache.Enabled = false
+	docStore, err := store.New(conf, logger)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create %v store from %v: %v\n", conf.Type, storePath, err)
+		return 1
+	}
+	defer closeStore(docStore, logger)
+
+	encrypted, ok := docStore.(*store.Encrypted)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Store config %v does not enable encryption\n", storePath)
+		return 1
+	}
+	rotated, err := encrypted.RotateKeys(prefix)
+	fmt.Printf("Re-encrypted: %v\n", rotated)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Key rotation failed: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,105 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Jeffail/leaps/lib/store"
+)
+
+//------------------------------------------------------------------------------
+
+func TestRotateKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldKey := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("a", 32)))
+	newKey := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("b", 32)))
+
+	// writeConf - Writes a config of an encrypted file store with keys and
+	// returns its path.
+	writeConf := func(name string, keys map[string]string, current string) string {
+		conf := store.NewConfig()
+		conf.Type = "file"
+		conf.File.Path = filepath.Join(dir, "docs")
+		conf.Encryption.Enabled = true
+		conf.Encryption.Keys = keys
+		conf.Encryption.CurrentKeyID = current
+		conf.Cache.Enabled = true
+		confBytes, err := json.Marshal(conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		confPath := filepath.Join(dir, name)
+		if err = ioutil.WriteFile(confPath, confBytes, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return confPath
+	}
+	oldConf := writeConf("old.json", map[string]string{"old": oldKey}, "old")
+	bothConf := writeConf("both.json", map[string]string{"old": oldKey, "new": newKey}, "new")
+	newConf := writeConf("new.json", map[string]string{"new": newKey}, "new")
+
+	docStore, err := openStore(oldConf, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a.txt", "sub/b.txt"} {
+		if err = docStore.Create(store.Document{ID: id, Content: "hello " + id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	closeStore(docStore, testLogger())
+
+	if code := runRotateKeys([]string{"-store", bothConf, "-log_level", "NONE"}); code != 0 {
+		t.Fatalf("Wrong exit code: %v", code)
+	}
+	if code := runRotateKeys([]string{"-store", filepath.Join(dir, "missing.json")}); code != 1 {
+		t.Errorf("Wrong exit code for missing config: %v", code)
+	}
+
+	if docStore, err = openStore(newConf, testLogger()); err != nil {
+		t.Fatal(err)
+	}
+	defer closeStore(docStore, testLogger())
+	for _, id := range []string{"a.txt", "sub/b.txt"} {
+		doc, err := docStore.Read(id)
+		if err != nil {
+			t.Errorf("Failed to read %v with the new key: %v", id, err)
+		} else if exp, act := "hello "+id, doc.Content; exp != act {
+			t.Errorf("Wrong content: %v != %v", act, exp)
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
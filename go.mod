@@ -10,7 +10,7 @@ require (
 	github.com/amir/raidman v0.0.0-20170415203553-1ccc43bfb9c9
 	github.com/azure/azure-sdk-for-go v26.2.0+incompatible
 	github.com/cenkalti/backoff v2.1.1+incompatible
-	github.com/dgrijalva/jwt-go v3.2.0+incompatible // indirect
+	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/dnaeon/go-vcr v1.0.1 // indirect
 	github.com/elazarl/go-bindata-assetfs v1.0.0
 	github.com/garyburd/redigo v1.6.0
@@ -21,6 +21,8 @@ require (
 	github.com/lib/pq v1.0.0
 	github.com/marstr/guid v1.1.0 // indirect
 	github.com/satori/go.uuid v1.2.0 // indirect
+	golang.org/x/crypto v0.21.0
+	golang.org/x/sync v0.2.0 // indirect
 	gopkg.in/alexcesaro/statsd.v2 v2.0.0
 	gopkg.in/yaml.v2 v2.2.3
 )
@@ -0,0 +1,89 @@
+/*
+Copyright (c) 2017 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package accesslog
+
+import (
+	"time"
+
+	"github.com/Jeffail/leaps/lib/acl"
+)
+
+//------------------------------------------------------------------------------
+
+// Entry - A record of an access decision.
+type Entry struct {
+	Time          time.Time `json:"time"`
+	Operation     string    `json:"operation"`
+	User          string    `json:"user"`
+	Session       string    `json:"session,omitempty"`
+	Document      string    `json:"document"`
+	Requested     string    `json:"requested"`
+	Granted       string    `json:"granted"`
+	Allowed       bool      `json:"allowed"`
+	Authenticator string    `json:"authenticator"`
+}
+
+// Sink - A type that records access decisions. Record is called synchronously
+// by curators for each decision, and therefore implementations must be thread
+// safe and avoid blocking for long.
+type Sink interface {
+	// Record - Record an access decision.
+	Record(entry Entry)
+}
+
+// SessionIdentity - May be implemented by the user metadata given to curators
+// in order to expose the session of the user.
+type SessionIdentity interface {
+	// UserSession - Returns the unique ID of the session of the user.
+	UserSession() string
+}
+
+//------------------------------------------------------------------------------
+
+// NewEntry - Creates an entry for an access decision, taking the user and
+// session from the user metadata of the client. The entry is marked as allowed
+// when the granted level meets the requested level.
+func NewEntry(
+	operation string,
+	userMetadata interface{},
+	documentID string,
+	requested, granted acl.AccessLevel,
+	authenticator string,
+) Entry {
+	entry := Entry{
+		Time:          time.Now().UTC(),
+		Operation:     operation,
+		User:          acl.UserIDOf(userMetadata),
+		Document:      documentID,
+		Requested:     requested.String(),
+		Granted:       granted.String(),
+		Allowed:       granted >= requested,
+		Authenticator: authenticator,
+	}
+	if session, ok := userMetadata.(SessionIdentity); ok {
+		entry.Session = session.UserSession()
+	}
+	return entry
+}
+
+//------------------------------------------------------------------------------
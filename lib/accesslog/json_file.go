@@ -0,0 +1,180 @@
+/*
+Copyright (c) 2017 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package accesslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/Jeffail/leaps/lib/util/service/log"
+)
+
+//------------------------------------------------------------------------------
+
+// JSONFileConfig - Holds configuration options for a JSONFile.
+type JSONFileConfig struct {
+	Path         string `json:"path" yaml:"path"`
+	MaxSizeBytes int64  `json:"max_size_bytes" yaml:"max_size_bytes"`
+	MaxBackups   int    `json:"max_backups" yaml:"max_backups"`
+}
+
+// NewJSONFileConfig - Returns a fully defined JSONFile configuration with the
+// default values for each field.
+func NewJSONFileConfig() JSONFileConfig {
+	return JSONFileConfig{
+		Path:         "",
+		MaxSizeBytes: 100 * 1024 * 1024,
+		MaxBackups:   5,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// JSONFile - A Sink that appends each entry as a line of JSON to a file. When
+// the file would exceed MaxSizeBytes it is rotated, the current file is renamed
+// with a numbered suffix (path.1 being the most recent) and at most MaxBackups
+// rotated files are kept. A MaxSizeBytes of zero disables rotation.
+type JSONFile struct {
+	config JSONFileConfig
+	logger log.Modular
+
+	file   *os.File
+	size   int64
+	closed bool
+	mut    sync.Mutex
+}
+
+// NewJSONFile - Opens, or creates, the file of a JSONFile sink for appending.
+func NewJSONFile(config JSONFileConfig, logger log.Modular) (*JSONFile, error) {
+	j := &JSONFile{
+		config: config,
+		logger: logger.NewModule(":access_log"),
+	}
+	if err := j.open(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+//------------------------------------------------------------------------------
+
+// open - Opens the log file for appending, must be called whilst holding the
+// mutex.
+func (j *JSONFile) open() error {
+	file, err := os.OpenFile(j.config.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	j.file, j.size = file, info.Size()
+	return nil
+}
+
+// backupPath - Returns the path of a rotated file.
+func (j *JSONFile) backupPath(n int) string {
+	return fmt.Sprintf("%v.%v", j.config.Path, n)
+}
+
+// rotate - Moves the current log file to the first backup, shifting existing
+// backups along and removing the oldest, then opens a new file. Must be called
+// whilst holding the mutex.
+func (j *JSONFile) rotate() error {
+	if err := j.file.Close(); err != nil {
+		j.logger.Errorf("Failed to close access log: %v\n", err)
+	}
+	j.file = nil
+
+	if j.config.MaxBackups > 0 {
+		os.Remove(j.backupPath(j.config.MaxBackups))
+		for n := j.config.MaxBackups - 1; n > 0; n-- {
+			if err := os.Rename(j.backupPath(n), j.backupPath(n+1)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := os.Rename(j.config.Path, j.backupPath(1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(j.config.Path); err != nil {
+		return err
+	}
+	return j.open()
+}
+
+// Record - Writes an entry to the log file, rotating the file first if needed.
+func (j *JSONFile) Record(entry Entry) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		j.logger.Errorf("Failed to marshal access log entry: %v\n", err)
+		return
+	}
+	line = append(line, '\n')
+
+	j.mut.Lock()
+	defer j.mut.Unlock()
+
+	if j.closed {
+		return
+	}
+	if j.file == nil {
+		// A previous rotation failed, try again.
+		if err = j.open(); err != nil {
+			j.logger.Errorf("Failed to open access log: %v\n", err)
+			return
+		}
+	}
+	if j.config.MaxSizeBytes > 0 && j.size > 0 && j.size+int64(len(line)) > j.config.MaxSizeBytes {
+		if err = j.rotate(); err != nil {
+			j.logger.Errorf("Failed to rotate access log: %v\n", err)
+			if j.file == nil {
+				return
+			}
+		}
+	}
+	n, err := j.file.Write(line)
+	j.size += int64(n)
+	if err != nil {
+		j.logger.Errorf("Failed to write access log entry: %v\n", err)
+	}
+}
+
+// Close - Closes the log file.
+func (j *JSONFile) Close() error {
+	j.mut.Lock()
+	defer j.mut.Unlock()
+
+	j.closed = true
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,134 @@
+/*
+Copyright (c) 2017 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package accesslog
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Jeffail/leaps/lib/acl"
+	"github.com/Jeffail/leaps/lib/util/service/log"
+)
+
+//------------------------------------------------------------------------------
+
+type testClient struct {
+	user, session string
+}
+
+func (t testClient) UserID() string      { return t.user }
+func (t testClient) UserSession() string { return t.session }
+
+func readEntries(t *testing.T, path string) []Entry {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	entries := []Entry{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry Entry
+		if err = json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestJSONFileRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_access_log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logConf := log.NewLoggerConfig()
+	logConf.LogLevel = "OFF"
+	logger := log.NewLogger(os.Stdout, logConf)
+
+	entry := NewEntry(
+		"open", testClient{"alice", "s1"}, "docs/a", acl.EditAccess, acl.ReadAccess, "rules",
+	)
+	if entry.User != "alice" || entry.Session != "s1" || entry.Allowed || entry.Granted != "READ" {
+		t.Errorf("Wrong entry: %+v", entry)
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := NewJSONFileConfig()
+	conf.Path = filepath.Join(dir, "access.jsonl")
+	conf.MaxSizeBytes = int64(len(line)+1) * 2
+	conf.MaxBackups = 2
+
+	sink, err := NewJSONFile(conf, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 7; i++ {
+		sink.Record(entry)
+	}
+	if err = sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	sink.Record(entry)
+
+	for path, count := range map[string]int{
+		conf.Path:        1,
+		conf.Path + ".1": 2,
+		conf.Path + ".2": 2,
+	} {
+		entries := readEntries(t, path)
+		if len(entries) != count {
+			t.Errorf("Wrong count of entries in %v: %v != %v", path, len(entries), count)
+		}
+		for _, e := range entries {
+			if e.User != "alice" || e.Document != "docs/a" || e.Authenticator != "rules" {
+				t.Errorf("Wrong entry in %v: %+v", path, e)
+			}
+		}
+	}
+	if _, err = os.Stat(conf.Path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected oldest backup to be removed: %v", err)
+	}
+
+	// Reopening appends to the existing file.
+	if sink, err = NewJSONFile(conf, logger); err != nil {
+		t.Fatal(err)
+	}
+	sink.Record(entry)
+	sink.Close()
+	if entries := readEntries(t, conf.Path); len(entries) != 2 {
+		t.Errorf("Wrong count of entries after reopening: %v", len(entries))
+	}
+}
+
+//------------------------------------------------------------------------------
@@ -22,6 +22,37 @@ THE SOFTWARE.
 
 package acl
 
+import (
+	"github.com/Jeffail/leaps/lib/util/service/log"
+	"github.com/Jeffail/leaps/lib/util/service/metrics"
+)
+
+//--------------------------------------------------------------------------------------------------
+
+func init() {
+	constructors["anarchy"] = typeSpec{
+		constructor: func(conf Config, logger log.Modular, stats metrics.Type) (Authenticator, error) {
+			return NewAnarchy(conf.Anarchy.AllowCreate), nil
+		},
+		description: `
+Everyone has edit access to every document, and optionally may create documents.`,
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// AnarchyConfig - A config object for the Anarchy acl object.
+type AnarchyConfig struct {
+	AllowCreate bool `json:"allow_create" yaml:"allow_create"`
+}
+
+// NewAnarchyConfig - Returns a default config object for an Anarchy object.
+func NewAnarchyConfig() AnarchyConfig {
+	return AnarchyConfig{
+		AllowCreate: false,
+	}
+}
+
 //--------------------------------------------------------------------------------------------------
 
 // Anarchy - Most basic implementation of an ACL, everyone has access to everything.
@@ -0,0 +1,508 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, sub to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package acl
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Jeffail/leaps/lib/util/service/log"
+	"github.com/Jeffail/leaps/lib/util/service/metrics"
+)
+
+//--------------------------------------------------------------------------------------------------
+
+func init() {
+	chainSpec := func(
+		newChain func(name string, links []Link, logger log.Modular, stats metrics.Type) Authenticator,
+		description string,
+	) typeSpec {
+		return typeSpec{
+			constructor: func(conf Config, logger log.Modular, stats metrics.Type) (Authenticator, error) {
+				links, err := newLinks(conf, logger, stats)
+				if err != nil {
+					return nil, err
+				}
+				return newChain(conf.name(), links, logger, stats), nil
+			},
+			description: description,
+		}
+	}
+	constructors["first_match"] = chainSpec(
+		func(name string, links []Link, logger log.Modular, stats metrics.Type) Authenticator {
+			return NewFirstMatch(name, links, logger, stats)
+		}, `
+Consults the authenticators listed in the links field in order, returning the
+first access level granted above NONE.`,
+	)
+	constructors["min_of"] = chainSpec(
+		func(name string, links []Link, logger log.Modular, stats metrics.Type) Authenticator {
+			return NewMinOf(name, links, logger, stats)
+		}, `
+Returns the lowest access level granted by the authenticators listed in the
+links field, meaning all of them must agree to grant access.`,
+	)
+	constructors["max_of"] = chainSpec(
+		func(name string, links []Link, logger log.Modular, stats metrics.Type) Authenticator {
+			return NewMaxOf(name, links, logger, stats)
+		}, `
+Returns the highest access level granted by the authenticators listed in the
+links field.`,
+	)
+	constructors["fallback"] = chainSpec(
+		func(name string, links []Link, logger log.Modular, stats metrics.Type) Authenticator {
+			return NewFallback(name, links, logger, stats)
+		}, `
+Returns the decision of the first authenticator listed in the links field that
+is able to make one, moving on when an authenticator that depends on an external
+service (such as http_callout) fails.`,
+	)
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// Link - An authenticator within a chain, along with a name that identifies it in metrics and logs.
+type Link struct {
+	Name          string
+	Authenticator Authenticator
+}
+
+/*
+chain - Common behaviour of the combinator authenticators. Each consultation of a link is recorded
+within metrics, along with the link that made the final decision:
+
+acl.<link name>.access.<level>   - Count of each access level returned by a link.
+acl.<link name>.latency          - Time taken by a link to make a decision in nanoseconds.
+acl.<link name>.error            - Count of links that failed to make a decision.
+acl.<chain name>.decided_by.<link name> - Count of decisions made by each link of a chain.
+*/
+type chain struct {
+	name   string
+	links  []Link
+	logger log.Modular
+	stats  metrics.Type
+}
+
+func newChain(name string, links []Link, logger log.Modular, stats metrics.Type) chain {
+	return chain{
+		name:   name,
+		links:  links,
+		logger: logger.NewModule(":" + name),
+		stats:  stats,
+	}
+}
+
+// Links - Returns the links of the chain.
+func (c chain) Links() []Link {
+	return c.links
+}
+
+// consult - Asks a link of the chain for an access level and records the outcome, along with the
+// name of the authenticator that decided it. When create is true the documentID is the ID of a new
+// document being checked for CreateAccess. When the link is a Decider its errors are returned along
+// with its fallback level (see FallbackDecider), otherwise the error is always nil.
+func (c chain) consult(
+	link Link, userMetadata interface{}, token, documentID string, create bool,
+) (level AccessLevel, by string, err error) {
+	start := time.Now()
+	by = link.Name
+	switch t := link.Authenticator.(type) {
+	case *Fallback:
+		var inner string
+		if level, inner, err = t.explainDecision(userMetadata, token, documentID, create); err == nil {
+			by = link.Name + "/" + inner
+		}
+	case Decider:
+		if create {
+			level, err = t.Decide(userMetadata, token, "")
+		} else {
+			level, err = t.Decide(userMetadata, token, documentID)
+		}
+	case Explainer:
+		var inner string
+		if create {
+			level, inner = ExplainCreate(link.Authenticator, userMetadata, token, documentID)
+		} else {
+			level, inner = t.Explain(userMetadata, token, documentID)
+		}
+		by = link.Name + "/" + inner
+	default:
+		if create {
+			level = AuthenticateCreate(link.Authenticator, userMetadata, token, documentID)
+		} else {
+			level = link.Authenticator.Authenticate(userMetadata, token, documentID)
+		}
+	}
+	c.stats.Timing("acl."+link.Name+".latency", int64(time.Since(start)))
+	if err != nil {
+		c.stats.Incr("acl."+link.Name+".error", 1)
+		return fallbackLevel(link.Authenticator), by, err
+	}
+	c.stats.Incr("acl."+link.Name+".access."+strings.ToLower(level.String()), 1)
+	return level, by, nil
+}
+
+// fallbackLevel - Returns the fallback level of an authenticator that failed to make a decision.
+func fallbackLevel(a Authenticator) AccessLevel {
+	if f, ok := a.(FallbackDecider); ok {
+		return f.FallbackLevel()
+	}
+	return NoAccess
+}
+
+// decided - Records which link decided the access level of a chain.
+func (c chain) decided(link Link, level AccessLevel, documentID string) AccessLevel {
+	c.stats.Incr("acl."+c.name+".decided_by."+link.Name, 1)
+	c.logger.Debugf("Link `%v` decided %v for `%v`\n", link.Name, level, documentID)
+	return level
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// FirstMatch - An authenticator chain that returns the first access level above NoAccess granted by
+// its links, in order.
+type FirstMatch struct {
+	chain
+}
+
+// NewFirstMatch - Creates a FirstMatch chain of links.
+func NewFirstMatch(name string, links []Link, logger log.Modular, stats metrics.Type) *FirstMatch {
+	return &FirstMatch{chain: newChain(name, links, logger, stats)}
+}
+
+func (f *FirstMatch) explain(
+	userMetadata interface{}, token, documentID string, create bool,
+) (AccessLevel, string) {
+	for _, link := range f.links {
+		level, by, err := f.consult(link, userMetadata, token, documentID, create)
+		if err != nil {
+			f.logger.Warnf("Link `%v` failed with fallback %v: %v\n", link.Name, level, err)
+		}
+		if level != NoAccess {
+			return f.decided(link, level, documentID), by
+		}
+	}
+	return NoAccess, f.name
+}
+
+// Explain - Returns the first access level above NoAccess granted by a link, and the link that
+// granted it.
+func (f *FirstMatch) Explain(userMetadata interface{}, token, documentID string) (AccessLevel, string) {
+	return f.explain(userMetadata, token, documentID, false)
+}
+
+// ExplainCreate - Returns the first access level above NoAccess granted by a link for creating a
+// document ID, and the link that granted it.
+func (f *FirstMatch) ExplainCreate(
+	userMetadata interface{}, token, documentID string,
+) (AccessLevel, string) {
+	return f.explain(userMetadata, token, documentID, true)
+}
+
+// Authenticate - Returns the first access level above NoAccess granted by a link.
+func (f *FirstMatch) Authenticate(userMetadata interface{}, token, documentID string) AccessLevel {
+	level, _ := f.explain(userMetadata, token, documentID, false)
+	return level
+}
+
+// AuthenticateCreate - Returns the first access level above NoAccess granted by a link for creating
+// a document ID.
+func (f *FirstMatch) AuthenticateCreate(userMetadata interface{}, token, documentID string) AccessLevel {
+	level, _ := f.explain(userMetadata, token, documentID, true)
+	return level
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// MinOf - An authenticator chain that returns the lowest access level granted by any of its links,
+// meaning each link must agree for a user to be granted access.
+type MinOf struct {
+	chain
+}
+
+// NewMinOf - Creates a MinOf chain of links.
+func NewMinOf(name string, links []Link, logger log.Modular, stats metrics.Type) *MinOf {
+	return &MinOf{chain: newChain(name, links, logger, stats)}
+}
+
+func (m *MinOf) explain(
+	userMetadata interface{}, token, documentID string, create bool,
+) (AccessLevel, string) {
+	if len(m.links) == 0 {
+		return NoAccess, m.name
+	}
+	var decider Link
+	var decidedBy string
+	level := CreateAccess
+	for _, link := range m.links {
+		linkLevel, by, err := m.consult(link, userMetadata, token, documentID, create)
+		if err != nil {
+			m.logger.Warnf("Link `%v` failed with fallback %v: %v\n", link.Name, linkLevel, err)
+		}
+		if linkLevel < level || len(decider.Name) == 0 {
+			decider, decidedBy, level = link, by, linkLevel
+		}
+		if level == NoAccess {
+			break
+		}
+	}
+	return m.decided(decider, level, documentID), decidedBy
+}
+
+// Explain - Returns the lowest access level granted by a link, stopping at the first link that grants
+// NoAccess, and the link that granted it.
+func (m *MinOf) Explain(userMetadata interface{}, token, documentID string) (AccessLevel, string) {
+	return m.explain(userMetadata, token, documentID, false)
+}
+
+// ExplainCreate - Returns the lowest access level granted by a link for creating a document ID, and
+// the link that granted it.
+func (m *MinOf) ExplainCreate(userMetadata interface{}, token, documentID string) (AccessLevel, string) {
+	return m.explain(userMetadata, token, documentID, true)
+}
+
+// Authenticate - Returns the lowest access level granted by a link, stopping at the first link that
+// grants NoAccess.
+func (m *MinOf) Authenticate(userMetadata interface{}, token, documentID string) AccessLevel {
+	level, _ := m.explain(userMetadata, token, documentID, false)
+	return level
+}
+
+// AuthenticateCreate - Returns the lowest access level granted by a link for creating a document ID.
+func (m *MinOf) AuthenticateCreate(userMetadata interface{}, token, documentID string) AccessLevel {
+	level, _ := m.explain(userMetadata, token, documentID, true)
+	return level
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// MaxOf - An authenticator chain that returns the highest access level granted by any of its links.
+type MaxOf struct {
+	chain
+}
+
+// NewMaxOf - Creates a MaxOf chain of links.
+func NewMaxOf(name string, links []Link, logger log.Modular, stats metrics.Type) *MaxOf {
+	return &MaxOf{chain: newChain(name, links, logger, stats)}
+}
+
+func (m *MaxOf) explain(
+	userMetadata interface{}, token, documentID string, create bool,
+) (AccessLevel, string) {
+	var decider Link
+	var decidedBy string
+	level := NoAccess
+	for _, link := range m.links {
+		linkLevel, by, err := m.consult(link, userMetadata, token, documentID, create)
+		if err != nil {
+			m.logger.Warnf("Link `%v` failed with fallback %v: %v\n", link.Name, linkLevel, err)
+		}
+		if linkLevel > level {
+			decider, decidedBy, level = link, by, linkLevel
+		}
+		if level == CreateAccess {
+			break
+		}
+	}
+	if level == NoAccess {
+		return NoAccess, m.name
+	}
+	return m.decided(decider, level, documentID), decidedBy
+}
+
+// Explain - Returns the highest access level granted by a link, stopping at the first link that
+// grants CreateAccess, and the link that granted it.
+func (m *MaxOf) Explain(userMetadata interface{}, token, documentID string) (AccessLevel, string) {
+	return m.explain(userMetadata, token, documentID, false)
+}
+
+// ExplainCreate - Returns the highest access level granted by a link for creating a document ID, and
+// the link that granted it.
+func (m *MaxOf) ExplainCreate(userMetadata interface{}, token, documentID string) (AccessLevel, string) {
+	return m.explain(userMetadata, token, documentID, true)
+}
+
+// Authenticate - Returns the highest access level granted by a link, stopping at the first link that
+// grants CreateAccess.
+func (m *MaxOf) Authenticate(userMetadata interface{}, token, documentID string) AccessLevel {
+	level, _ := m.explain(userMetadata, token, documentID, false)
+	return level
+}
+
+// AuthenticateCreate - Returns the highest access level granted by a link for creating a document
+// ID.
+func (m *MaxOf) AuthenticateCreate(userMetadata interface{}, token, documentID string) AccessLevel {
+	level, _ := m.explain(userMetadata, token, documentID, true)
+	return level
+}
+
+//--------------------------------------------------------------------------------------------------
+
+/*
+Fallback - An authenticator chain that returns the decision of its first link able to make one. A
+link is only skipped when it implements Decider and returns an error, such as when an external
+service is unavailable. Links that do not implement Decider always make a decision. When every link
+fails the fallback level of the final link is returned, see FallbackDecider.
+*/
+type Fallback struct {
+	chain
+}
+
+// NewFallback - Creates a Fallback chain of links.
+func NewFallback(name string, links []Link, logger log.Modular, stats metrics.Type) *Fallback {
+	return &Fallback{chain: newChain(name, links, logger, stats)}
+}
+
+// explainDecision - Returns the decision of the first link able to make one along with the link that
+// made it, or the fallback level and error of the final link.
+func (f *Fallback) explainDecision(
+	userMetadata interface{}, token, documentID string, create bool,
+) (AccessLevel, string, error) {
+	var err error
+	for _, link := range f.links {
+		var level AccessLevel
+		var by string
+		if level, by, err = f.consult(link, userMetadata, token, documentID, create); err == nil {
+			return f.decided(link, level, documentID), by, nil
+		}
+		f.logger.Warnf("Link `%v` failed, falling back: %v\n", link.Name, err)
+	}
+	return f.FallbackLevel(), f.name, err
+}
+
+// FallbackLevel - Returns the fallback level of the final link, which is used when every link fails
+// to make a decision.
+func (f *Fallback) FallbackLevel() AccessLevel {
+	if len(f.links) == 0 {
+		return NoAccess
+	}
+	return fallbackLevel(f.links[len(f.links)-1].Authenticator)
+}
+
+// Decide - Returns the decision of the first link able to make one, or the fallback level and error
+// of the final link.
+func (f *Fallback) Decide(userMetadata interface{}, token, documentID string) (AccessLevel, error) {
+	level, _, err := f.explainDecision(userMetadata, token, documentID, false)
+	return level, err
+}
+
+// Explain - Returns the decision of the first link able to make one, and the link that made it.
+func (f *Fallback) Explain(userMetadata interface{}, token, documentID string) (AccessLevel, string) {
+	level, by, _ := f.explainDecision(userMetadata, token, documentID, false)
+	return level, by
+}
+
+// ExplainCreate - Returns the decision of the first link able to make one for creating a document ID,
+// and the link that made it.
+func (f *Fallback) ExplainCreate(
+	userMetadata interface{}, token, documentID string,
+) (AccessLevel, string) {
+	level, by, _ := f.explainDecision(userMetadata, token, documentID, true)
+	return level, by
+}
+
+// Authenticate - Returns the decision of the first link able to make one.
+func (f *Fallback) Authenticate(userMetadata interface{}, token, documentID string) AccessLevel {
+	level, _ := f.Decide(userMetadata, token, documentID)
+	return level
+}
+
+// AuthenticateCreate - Returns the decision of the first link able to make one for creating a
+// document ID.
+func (f *Fallback) AuthenticateCreate(userMetadata interface{}, token, documentID string) AccessLevel {
+	level, _, _ := f.explainDecision(userMetadata, token, documentID, true)
+	return level
+}
+
+//--------------------------------------------------------------------------------------------------
+
+/*
+Explain - Returns the access level granted by an authenticator along with the name of the
+authenticator that decided it. Chains name the link that decided, separated by slashes when chains
+are nested, and other authenticators are named after their type.
+*/
+func Explain(a Authenticator, userMetadata interface{}, token, documentID string) (AccessLevel, string) {
+	if e, ok := a.(Explainer); ok {
+		return e.Explain(userMetadata, token, documentID)
+	}
+	return a.Authenticate(userMetadata, token, documentID), strings.TrimPrefix(fmt.Sprintf("%T", a), "*")
+}
+
+/*
+ExplainCreate - Returns the access level granted by an authenticator for creating a document ID along
+with the name of the authenticator that decided it, in the same way as Explain.
+*/
+func ExplainCreate(
+	a Authenticator, userMetadata interface{}, token, documentID string,
+) (AccessLevel, string) {
+	switch t := a.(type) {
+	case interface {
+		ExplainCreate(userMetadata interface{}, token, documentID string) (AccessLevel, string)
+	}:
+		return t.ExplainCreate(userMetadata, token, documentID)
+	case CreateAuthenticator:
+		level := t.AuthenticateCreate(userMetadata, token, documentID)
+		return level, strings.TrimPrefix(fmt.Sprintf("%T", a), "*")
+	}
+	return Explain(a, userMetadata, token, "")
+}
+
+/*
+AuthenticateCreate - Returns the access level granted by an authenticator for creating a document ID.
+Authenticators that do not implement CreateAuthenticator are asked for CreateAccess with a blank
+document ID, and therefore grant it regardless of the ID.
+*/
+func AuthenticateCreate(a Authenticator, userMetadata interface{}, token, documentID string) AccessLevel {
+	if c, ok := a.(CreateAuthenticator); ok {
+		return c.AuthenticateCreate(userMetadata, token, documentID)
+	}
+	return a.Authenticate(userMetadata, token, "")
+}
+
+/*
+IdentifierOf - Searches an authenticator for a way to identify the owners of tokens. Returns the
+authenticator itself if it implements Identifier, otherwise the first Identifier found within the
+links of a chain or the identity of a Rules object. Returns nil if there is none.
+*/
+func IdentifierOf(a Authenticator) Identifier {
+	switch t := a.(type) {
+	case Identifier:
+		return t
+	case interface {
+		Links() []Link
+	}:
+		for _, link := range t.Links() {
+			if i := IdentifierOf(link.Authenticator); i != nil {
+				return i
+			}
+		}
+	case *Rules:
+		if t.identity != nil {
+			return IdentifierOf(t.identity)
+		}
+	}
+	return nil
+}
+
+//--------------------------------------------------------------------------------------------------
@@ -0,0 +1,345 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, sub to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package acl
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Jeffail/leaps/lib/util/service/metrics"
+	"gopkg.in/yaml.v2"
+)
+
+//--------------------------------------------------------------------------------------------------
+
+type fixedAuth AccessLevel
+
+func (f fixedAuth) Authenticate(_ interface{}, _, _ string) AccessLevel {
+	return AccessLevel(f)
+}
+
+type failingAuth struct{}
+
+func (f failingAuth) Authenticate(_ interface{}, _, _ string) AccessLevel {
+	return NoAccess
+}
+
+func (f failingAuth) Decide(_ interface{}, _, _ string) (AccessLevel, error) {
+	return NoAccess, errors.New("unavailable")
+}
+
+// fallingBack fails every decision but has a fallback level, like an HTTPCallout.
+type fallingBack AccessLevel
+
+func (f fallingBack) Authenticate(_ interface{}, _, _ string) AccessLevel {
+	return AccessLevel(f)
+}
+
+func (f fallingBack) Decide(_ interface{}, _, _ string) (AccessLevel, error) {
+	return NoAccess, errors.New("unavailable")
+}
+
+func (f fallingBack) FallbackLevel() AccessLevel {
+	return AccessLevel(f)
+}
+
+// prefixCreator grants CreateAccess only for document IDs with a prefix.
+type prefixCreator string
+
+func (p prefixCreator) Authenticate(_ interface{}, _, documentID string) AccessLevel {
+	if len(documentID) == 0 {
+		return CreateAccess
+	}
+	return ReadAccess
+}
+
+func (p prefixCreator) AuthenticateCreate(_ interface{}, _, documentID string) AccessLevel {
+	if strings.HasPrefix(documentID, string(p)) {
+		return CreateAccess
+	}
+	return NoAccess
+}
+
+type recordedStats struct {
+	sync.Mutex
+	counts map[string]int64
+}
+
+func newRecordedStats() *recordedStats {
+	return &recordedStats{counts: map[string]int64{}}
+}
+
+func (r *recordedStats) Incr(path string, count int64) error {
+	r.Lock()
+	r.counts[path] += count
+	r.Unlock()
+	return nil
+}
+
+func (r *recordedStats) Decr(path string, count int64) error {
+	return r.Incr(path, -count)
+}
+
+func (r *recordedStats) Timing(path string, delta int64) error { return nil }
+func (r *recordedStats) Gauge(path string, value int64) error  { return nil }
+func (r *recordedStats) Close() error                          { return nil }
+
+var _ metrics.Type = &recordedStats{}
+
+func TestChains(t *testing.T) {
+	links := []Link{
+		{Name: "none", Authenticator: fixedAuth(NoAccess)},
+		{Name: "down", Authenticator: failingAuth{}},
+		{Name: "read", Authenticator: fixedAuth(ReadAccess)},
+		{Name: "edit", Authenticator: fixedAuth(EditAccess)},
+	}
+
+	testCases := []struct {
+		name     string
+		chain    func(links []Link, stats metrics.Type) Authenticator
+		links    []int
+		expected AccessLevel
+		decider  string
+	}{
+		{"first_match", func(l []Link, s metrics.Type) Authenticator {
+			return NewFirstMatch("chain", l, logger(), s)
+		}, []int{0, 1, 2, 3}, ReadAccess, "read"},
+		{"first_match", func(l []Link, s metrics.Type) Authenticator {
+			return NewFirstMatch("chain", l, logger(), s)
+		}, []int{0, 1}, NoAccess, ""},
+		{"min_of", func(l []Link, s metrics.Type) Authenticator {
+			return NewMinOf("chain", l, logger(), s)
+		}, []int{3, 2}, ReadAccess, "read"},
+		{"min_of", func(l []Link, s metrics.Type) Authenticator {
+			return NewMinOf("chain", l, logger(), s)
+		}, []int{3, 1, 2}, NoAccess, "down"},
+		{"max_of", func(l []Link, s metrics.Type) Authenticator {
+			return NewMaxOf("chain", l, logger(), s)
+		}, []int{2, 1, 3, 0}, EditAccess, "edit"},
+		{"fallback", func(l []Link, s metrics.Type) Authenticator {
+			return NewFallback("chain", l, logger(), s)
+		}, []int{1, 0, 3}, NoAccess, "none"},
+		{"fallback", func(l []Link, s metrics.Type) Authenticator {
+			return NewFallback("chain", l, logger(), s)
+		}, []int{1, 3, 0}, EditAccess, "edit"},
+	}
+
+	for _, test := range testCases {
+		chainLinks := []Link{}
+		for _, i := range test.links {
+			chainLinks = append(chainLinks, links[i])
+		}
+		stats := newRecordedStats()
+		if actual := test.chain(chainLinks, stats).Authenticate("alice", "", "doc"); actual != test.expected {
+			t.Errorf("%v %v: wrong access level: %v != %v", test.name, test.links, actual, test.expected)
+		}
+		if len(test.decider) > 0 {
+			if count := stats.counts["acl.chain.decided_by."+test.decider]; count != 1 {
+				t.Errorf("%v %v: link %v not recorded as decider: %v", test.name, test.links, test.decider, stats.counts)
+			}
+		}
+		for path := range stats.counts {
+			if len(test.decider) == 0 && strings.HasPrefix(path, "acl.chain.decided_by.") {
+				t.Errorf("%v %v: unexpected decision recorded: %v", test.name, test.links, path)
+			}
+		}
+	}
+}
+
+func TestChainFallbackLevels(t *testing.T) {
+	down := Link{Name: "down", Authenticator: fallingBack(ReadAccess)}
+	none := Link{Name: "none", Authenticator: fixedAuth(NoAccess)}
+	edit := Link{Name: "edit", Authenticator: fixedAuth(EditAccess)}
+
+	testCases := []struct {
+		name     string
+		chain    Authenticator
+		expected AccessLevel
+	}{
+		{"first_match", NewFirstMatch("chain", []Link{none, down, edit}, logger(), metrics.DudType{}), ReadAccess},
+		{"min_of", NewMinOf("chain", []Link{edit, down}, logger(), metrics.DudType{}), ReadAccess},
+		{"max_of", NewMaxOf("chain", []Link{none, down}, logger(), metrics.DudType{}), ReadAccess},
+		{"fallback", NewFallback("chain", []Link{down, edit}, logger(), metrics.DudType{}), EditAccess},
+		{"fallback", NewFallback("chain", []Link{down}, logger(), metrics.DudType{}), ReadAccess},
+		{"fallback", NewFallback("chain", []Link{down, {Name: "failing", Authenticator: failingAuth{}}}, logger(), metrics.DudType{}), NoAccess},
+	}
+
+	for _, test := range testCases {
+		if actual := test.chain.Authenticate("alice", "", "doc"); actual != test.expected {
+			t.Errorf("%v: wrong access level: %v != %v", test.name, actual, test.expected)
+		}
+	}
+
+	nested := NewMinOf("outer", []Link{
+		edit, {Name: "inner", Authenticator: NewFallback("inner", []Link{down}, logger(), metrics.DudType{})},
+	}, logger(), metrics.DudType{})
+	if level, by := Explain(nested, "alice", "", "doc"); level != ReadAccess || by != "inner" {
+		t.Errorf("Wrong explanation: %v, %v", level, by)
+	}
+}
+
+func TestNewFromConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_chain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rulesPath := filepath.Join(dir, "rules.yaml")
+	writeTestFile(t, rulesPath, []byte(`
+rules:
+  - paths: [ "docs/**" ]
+    users: [ alice ]
+    access: EDIT
+  - paths: [ "**" ]
+    users: [ "*" ]
+    access: READ
+`))
+
+	confStr := `
+type: min_of
+name: docs
+links:
+  - type: rules
+    rules:
+      path: ` + rulesPath + `
+      refresh_period_s: 0
+    identity:
+      type: http_callout
+      http_callout:
+        url: http://localhost:1
+  - type: anarchy
+    name: everyone
+`
+	var conf Config
+	if err = yaml.Unmarshal([]byte(confStr), &conf); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Links[1].Anarchy.AllowCreate || conf.Links[0].HTTPCallout.TimeoutMS != 2000 {
+		t.Errorf("Link defaults not populated: %+v", conf.Links)
+	}
+
+	stats := newRecordedStats()
+	auth, err := New(conf, logger(), stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if IdentifierOf(auth) != nil {
+		t.Error("Unexpected identifier found within chain")
+	}
+	if actual := auth.Authenticate("alice", "", "docs/foo"); actual != EditAccess {
+		t.Errorf("Wrong access level: %v != %v", actual, EditAccess)
+	}
+	if actual := auth.Authenticate("bob", "", "docs/foo"); actual != ReadAccess {
+		t.Errorf("Wrong access level: %v != %v", actual, ReadAccess)
+	}
+	if count := stats.counts["acl.docs.decided_by.rules"]; count != 2 {
+		t.Errorf("Wrong count of decisions by rules: %v", stats.counts)
+	}
+	if count := stats.counts["acl.everyone.access.edit"]; count != 2 {
+		t.Errorf("Wrong count of link access levels: %v", stats.counts)
+	}
+
+	jsonConf := NewConfig()
+	if err = json.Unmarshal([]byte(`{"type":"first_match","links":[{"type":"nope"}]}`), &jsonConf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = New(jsonConf, logger(), stats); err == nil {
+		t.Error("Expected error from invalid link type")
+	}
+}
+
+func TestExplain(t *testing.T) {
+	chain := NewFirstMatch("outer", []Link{
+		{Name: "a", Authenticator: fixedAuth(NoAccess)},
+		{Name: "b", Authenticator: NewFallback("inner", []Link{
+			{Name: "down", Authenticator: failingAuth{}},
+			{Name: "c", Authenticator: fixedAuth(EditAccess)},
+		}, logger(), metrics.DudType{})},
+	}, logger(), metrics.DudType{})
+
+	if level, by := Explain(chain, "alice", "", "doc"); level != EditAccess || by != "b/c" {
+		t.Errorf("Wrong explanation: %v, %v", level, by)
+	}
+	if level, by := Explain(fixedAuth(ReadAccess), "alice", "", "doc"); level != ReadAccess || by != "acl.fixedAuth" {
+		t.Errorf("Wrong explanation: %v, %v", level, by)
+	}
+	empty := NewMaxOf("empty", nil, logger(), metrics.DudType{})
+	if level, by := Explain(empty, "alice", "", "doc"); level != NoAccess || by != "empty" {
+		t.Errorf("Wrong explanation: %v, %v", level, by)
+	}
+}
+
+func TestCreateTargets(t *testing.T) {
+	chain := NewMinOf("outer", []Link{
+		{Name: "a", Authenticator: fixedAuth(CreateAccess)},
+		{Name: "b", Authenticator: NewFallback("inner", []Link{
+			{Name: "down", Authenticator: failingAuth{}},
+			{Name: "c", Authenticator: NewMaxOf("nested", []Link{
+				{Name: "docs", Authenticator: prefixCreator("docs/")},
+			}, logger(), metrics.DudType{})},
+		}, logger(), metrics.DudType{})},
+	}, logger(), metrics.DudType{})
+
+	if level := AuthenticateCreate(chain, "alice", "", "docs/a.md"); level != CreateAccess {
+		t.Errorf("Wrong create access within prefix: %v", level)
+	}
+	if level := AuthenticateCreate(chain, "alice", "", "src/a.go"); level != NoAccess {
+		t.Errorf("Wrong create access outside prefix: %v", level)
+	}
+	if level := chain.Authenticate("alice", "", ""); level != CreateAccess {
+		t.Errorf("Wrong create access without target: %v", level)
+	}
+	if level, by := ExplainCreate(chain, "alice", "", "src/a.go"); level != NoAccess || by != "b/c/nested" {
+		t.Errorf("Wrong explanation: %v, %v", level, by)
+	}
+	if level := AuthenticateCreate(fixedAuth(CreateAccess), "alice", "", "src/a.go"); level != CreateAccess {
+		t.Errorf("Wrong create access of plain authenticator: %v", level)
+	}
+}
+
+func TestIdentifierOf(t *testing.T) {
+	ident := tokenIdentifier{"t1": "alice"}
+	chain := NewFirstMatch("outer", []Link{
+		{Name: "a", Authenticator: fixedAuth(ReadAccess)},
+		{Name: "b", Authenticator: NewMaxOf("inner", []Link{
+			{Name: "c", Authenticator: ident},
+		}, logger(), metrics.DudType{})},
+	}, logger(), metrics.DudType{})
+
+	identifier := IdentifierOf(chain)
+	if identifier == nil {
+		t.Fatal("Expected identifier within chain")
+	}
+	if user, err := identifier.Identify("t1"); err != nil || user != "alice" {
+		t.Errorf("Wrong identity: %v, %v", user, err)
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
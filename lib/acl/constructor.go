@@ -0,0 +1,170 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, sub to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package acl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Jeffail/leaps/lib/util/service/log"
+	"github.com/Jeffail/leaps/lib/util/service/metrics"
+)
+
+//--------------------------------------------------------------------------------------------------
+
+// typeSpec - Constructor and a usage description for each authenticator type.
+type typeSpec struct {
+	constructor func(conf Config, logger log.Modular, stats metrics.Type) (Authenticator, error)
+	description string
+}
+
+var constructors = map[string]typeSpec{}
+
+//--------------------------------------------------------------------------------------------------
+
+// Config - The all encompassing configuration struct for all authenticator types. Chains of
+// authenticators are declared with the links field, and the identity field declares the
+// authenticator used by a rules type to identify tokens.
+type Config struct {
+	Type        string            `json:"type" yaml:"type"`
+	Name        string            `json:"name" yaml:"name"`
+	Anarchy     AnarchyConfig     `json:"anarchy" yaml:"anarchy"`
+	FileExists  FileExistsConfig  `json:"file_exists" yaml:"file_exists"`
+	Redis       RedisConfig       `json:"redis" yaml:"redis"`
+	JWT         JWTConfig         `json:"jwt" yaml:"jwt"`
+	Rules       RulesConfig       `json:"rules" yaml:"rules"`
+	HTTPCallout HTTPCalloutConfig `json:"http_callout" yaml:"http_callout"`
+	Invites     InvitesConfig     `json:"invites" yaml:"invites"`
+	Identity    *Config           `json:"identity" yaml:"identity"`
+	Links       []Config          `json:"links" yaml:"links"`
+}
+
+// NewConfig - Returns a configuration struct fully populated with default values.
+func NewConfig() Config {
+	return Config{
+		Type:        "anarchy",
+		Name:        "",
+		Anarchy:     NewAnarchyConfig(),
+		FileExists:  NewFileExistsConfig(),
+		Redis:       NewRedisConfig(),
+		JWT:         NewJWTConfig(),
+		Rules:       NewRulesConfig(),
+		HTTPCallout: NewHTTPCalloutConfig(),
+		Invites:     NewInvitesConfig(),
+		Identity:    nil,
+		Links:       []Config{},
+	}
+}
+
+/*
+UnmarshalJSON - Ensures that when parsing configs any missing fields, including those of identities
+and links, are populated with default values.
+*/
+func (c *Config) UnmarshalJSON(data []byte) error {
+	type confAlias Config
+	aliased := confAlias(NewConfig())
+	if err := json.Unmarshal(data, &aliased); err != nil {
+		return err
+	}
+	*c = Config(aliased)
+	return nil
+}
+
+/*
+UnmarshalYAML - Ensures that when parsing configs any missing fields, including those of identities
+and links, are populated with default values.
+*/
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type confAlias Config
+	aliased := confAlias(NewConfig())
+	if err := unmarshal(&aliased); err != nil {
+		return err
+	}
+	*c = Config(aliased)
+	return nil
+}
+
+// name - Returns the name used for an authenticator in metrics and logs.
+func (c Config) name() string {
+	if len(c.Name) > 0 {
+		return c.Name
+	}
+	return c.Type
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// Descriptions - Returns a formatted string of collated descriptions of each type.
+func Descriptions() string {
+	// Order our authenticator types alphabetically
+	names := []string{}
+	for name := range constructors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buf := bytes.Buffer{}
+	buf.WriteString("AUTHENTICATORS\n")
+	buf.WriteString(strings.Repeat("=", 80))
+	buf.WriteString("\n\n")
+
+	// Append each description
+	for i, name := range names {
+		buf.WriteString(name)
+		buf.WriteString("\n")
+		buf.WriteString(strings.Repeat("-", 80))
+		buf.WriteString("\n")
+		buf.WriteString(constructors[name].description)
+		buf.WriteString("\n")
+		if i != (len(names) - 1) {
+			buf.WriteString("\n")
+		}
+	}
+	return buf.String()
+}
+
+// New - Create an authenticator type based on a configuration.
+func New(conf Config, logger log.Modular, stats metrics.Type) (Authenticator, error) {
+	if c, ok := constructors[conf.Type]; ok {
+		return c.constructor(conf, logger, stats)
+	}
+	return nil, ErrInvalidAuthType
+}
+
+// newLinks - Creates the links of a chain from their configurations.
+func newLinks(conf Config, logger log.Modular, stats metrics.Type) ([]Link, error) {
+	links := make([]Link, 0, len(conf.Links))
+	for _, linkConf := range conf.Links {
+		a, err := New(linkConf, logger, stats)
+		if err != nil {
+			return nil, fmt.Errorf("link %v: %v", linkConf.name(), err)
+		}
+		links = append(links, Link{Name: linkConf.name(), Authenticator: a})
+	}
+	return links, nil
+}
+
+//--------------------------------------------------------------------------------------------------
@@ -23,25 +23,43 @@ THE SOFTWARE.
 package acl
 
 import (
-	"bufio"
+	"errors"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/Jeffail/leaps/lib/util/service/log"
+	"github.com/Jeffail/leaps/lib/util/service/metrics"
 )
 
 //--------------------------------------------------------------------------------------------------
 
+func init() {
+	constructors["file_exists"] = typeSpec{
+		constructor: func(conf Config, logger log.Modular, stats metrics.Type) (Authenticator, error) {
+			return NewFileExists(conf.FileExists, logger), nil
+		},
+		description: `
+Grants edit access to files that exist within a directory, excluding hidden files
+and those matched by .gitignore or .leapsignore files. Changes to the directory
+are watched with inotify where supported, and polled otherwise.`,
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
+
 // FileExistsConfig - A config object for the FileExists acl object.
 type FileExistsConfig struct {
 	Path            string   `json:"path" yaml:"path"`
 	ShowHidden      bool     `json:"show_hidden" yaml:"show_hidden"`
+	Watch           bool     `json:"watch" yaml:"watch"`
 	RefreshPeriod   int64    `json:"refresh_period_s" yaml:"refresh_period_s"`
 	ReservedIgnores []string `json:"ignore_files" yaml:"ignore_files"`
+	IgnoreFileNames []string `json:"ignore_file_names" yaml:"ignore_file_names"`
 }
 
 // NewFileExistsConfig - Returns a default config object for a FileExists object.
@@ -49,175 +67,504 @@ func NewFileExistsConfig() FileExistsConfig {
 	return FileExistsConfig{
 		Path:            "",
 		ShowHidden:      false,
+		Watch:           true,
 		RefreshPeriod:   10,
-		ReservedIgnores: []string{".leapsignore"},
+		ReservedIgnores: []string{".leapsignore", ".git/"},
+		IgnoreFileNames: []string{".gitignore", ".leapsignore"},
 	}
 }
 
+//--------------------------------------------------------------------------------------------------
+
+var errWatchUnsupported = errors.New("watching directories is not supported on this platform")
+
+// dirEvent - A change to an entry of a watched directory, identified by its slash separated path
+// relative to the root of the tree. An overflow event means that events were lost.
+type dirEvent struct {
+	Path     string
+	IsDir    bool
+	Removed  bool
+	Overflow bool
+}
+
+// dirWatcher - Watches directories for changes to their entries.
+type dirWatcher interface {
+	// Add - Starts watching a directory, identified in events by its relative path.
+	Add(relPath, dir string) error
+
+	// Remove - Stops watching a directory and all watched directories within it.
+	Remove(relPath string)
+
+	// Events - Returns a channel of events, which is closed when the watcher is closed.
+	Events() <-chan dirEvent
+
+	// Close - Stops watching all directories.
+	Close() error
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// PathEventType - The type of change to the set of paths of a FileExists.
+type PathEventType int
+
+// Types of PathEvent.
+const (
+	PathCreated PathEventType = iota
+	PathRemoved
+	PathModified
+)
+
+// PathEvent - A path that was added to or removed from the paths of a FileExists, or an existing
+// path that was written to. Writes are only reported while directories are watched.
+type PathEvent struct {
+	Type PathEventType
+	Path string
+}
+
+// pathEventBuffer - The number of events buffered for each subscriber, events are dropped when a
+// subscriber falls this far behind.
+const pathEventBuffer = 1000
+
+//--------------------------------------------------------------------------------------------------
+
 /*
 FileExists - An acl authenticator type that validates document edit sessions by checking that the
 document ID (the file path) exists. Can be configured to show hidden files.
+
+Paths matched by the patterns of .gitignore and .leapsignore files (or any other configured ignore
+file names) are excluded, these files may be nested anywhere within the tree and follow the syntax
+of .gitignore files, including negation and ** patterns.
+
+On Linux the tree is watched with inotify and the set of paths is updated incrementally, on other
+platforms, or when watching fails (for example when the limit of inotify watches is reached), the
+tree is walked every refresh period instead. Changes to the set of paths can be subscribed to.
 */
 type FileExists struct {
 	logger log.Modular
 	config FileExistsConfig
-	paths  []string
+
+	// Only accessed by the loop goroutine after construction, except for the matcher which is
+	// modified whilst holding the mutex so that it may also be read by Creatable.
+	matcher *ignoreMatcher
+	watcher dirWatcher
+	watched map[string]struct{}
+
+	// The tree maps each directory containing paths to its entries, files or directories, so that
+	// the paths within a directory are found without visiting the whole set.
+	paths  map[string]struct{}
+	tree   map[string]map[string]struct{}
+	sorted []string
+	subs   map[chan PathEvent]struct{}
 	mutex  *sync.RWMutex
+
+	closeChan  chan struct{}
+	closedChan chan struct{}
 }
 
 // NewFileExists - Creates an File using the provided configuration.
 func NewFileExists(config FileExistsConfig, logger log.Modular) *FileExists {
 	fa := FileExists{
-		logger: logger.NewModule(":fs_auth"),
-		config: config,
-		paths:  []string{},
-		mutex:  &sync.RWMutex{},
+		logger:     logger.NewModule(":fs_auth"),
+		config:     config,
+		matcher:    newIgnoreMatcher(config.ReservedIgnores),
+		watched:    map[string]struct{}{},
+		paths:      map[string]struct{}{},
+		tree:       map[string]map[string]struct{}{},
+		subs:       map[chan PathEvent]struct{}{},
+		mutex:      &sync.RWMutex{},
+		closeChan:  make(chan struct{}),
+		closedChan: make(chan struct{}),
+	}
+
+	if info, err := os.Stat(config.Path); config.Watch && err == nil && info.IsDir() {
+		if fa.watcher, err = newDirWatcher(); err != nil {
+			fa.logger.Warnf("Failed to watch %v, falling back to polling: %v\n", config.Path, err)
+		}
 	}
+	fa.refresh("")
+
 	go fa.loop()
 	return &fa
 }
 
 //--------------------------------------------------------------------------------------------------
 
-// extractIgnores - Parses a .leapsignore file for ignore patterns (one per line).
-func (f *FileExists) extractIgnores(ignoreFilePath string) []string {
-	ignorePatterns := []string{}
-	for _, p := range f.config.ReservedIgnores {
-		ignorePatterns = append(ignorePatterns, p)
-	}
-
-	file, err := os.Open(ignoreFilePath)
-	if err != nil {
-		f.logger.Errorf("Failed to read .leapsignore file: %v\n", err)
-		return ignorePatterns
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		ignorePatterns = append(ignorePatterns, filepath.Clean(scanner.Text()))
-	}
-	if err := scanner.Err(); err != nil {
-		f.logger.Errorf("Failed to read .leapsignore file: %v\n", err)
-	}
-	return ignorePatterns
-}
-
 /*
-checkPatterns - Checks an array of ignore patterns against a path.
-
-Pattern rules:
-
-Pattern match rules either:
-	- Pattern is an exact match of the full relative path (foo/bar/*.jpg matches foo/bar/test.jpg)
-or:
-	- Pattern is an exact match of the base of the path (*.jpg matches foo/bar/test.jpg)
-
-./foo.jpg will only match foo.jpg
-foo.jpg will match foo.jpg and bar/foo.jpg
+checkPatterns - Checks an array of ignore patterns, relative to the root of the tree, against a
+path. The last pattern to match decides whether the path is ignored.
 */
 func (f *FileExists) checkPatterns(patterns []string, path string) bool {
+	ignored := false
+	rel := cleanRelPath(filepath.ToSlash(path))
 	for _, pattern := range patterns {
-		// Cleaned pattern and full relative path
-		matched, err := filepath.Match(filepath.Clean(pattern), path)
-		if err != nil {
-			f.logger.Errorf("Pattern match error: %s: %v\n", pattern, err)
-			return false
+		if p, ok := parseIgnorePattern(pattern); ok && p.matches(rel, false) {
+			ignored = !p.negate
 		}
-		// Or non-cleaned pattern versus base of relative path
-		if !matched {
-			matched, err = filepath.Match(pattern, filepath.Base(path))
-			if err != nil {
-				f.logger.Errorf("Pattern match error: %s: %v\n", pattern, err)
-				return false
+	}
+	return ignored
+}
+
+// readIgnores - Reads the patterns of all ignore files within a directory.
+func (f *FileExists) readIgnores(relDir string) []ignorePattern {
+	patterns := []ignorePattern{}
+	for _, name := range f.config.IgnoreFileNames {
+		file, err := os.Open(filepath.Join(f.config.Path, filepath.FromSlash(relDir), name))
+		if err != nil {
+			if !os.IsNotExist(err) {
+				f.logger.Errorf("Failed to read ignore file: %v\n", err)
 			}
+			continue
 		}
-		if matched {
+		filePatterns, err := parseIgnoreFile(file)
+		file.Close()
+		if err != nil {
+			f.logger.Errorf("Failed to read ignore file: %v\n", err)
+		}
+		patterns = append(patterns, filePatterns...)
+	}
+	return patterns
+}
+
+// isIgnoreFile - Returns whether a path is an ignore file.
+func (f *FileExists) isIgnoreFile(relPath string) bool {
+	base := path.Base(relPath)
+	for _, name := range f.config.IgnoreFileNames {
+		if base == name {
 			return true
 		}
 	}
 	return false
 }
 
-func (f *FileExists) getPaths() ([]string, error) {
-	paths := []string{}
-	if info, err := os.Stat(f.config.Path); err == nil {
+// visible - Returns whether a path within the tree is neither hidden nor ignored.
+func (f *FileExists) visible(relPath string, isDir bool) bool {
+	name := path.Base(relPath)
+	if !f.config.ShowHidden && len(name) > 1 && strings.HasPrefix(name, ".") {
+		return false
+	}
+	return !f.matcher.ignored(relPath, isDir)
+}
+
+/*
+scan - Walks a directory of the tree, returning the visible files and directories within it. The
+ignore patterns of the directory are reloaded as it is walked. A directory that does not exist is
+treated as empty.
+
+Each directory is watched before its entries are read, so that a file created within a new directory
+while it is being scanned is either found by the scan or reported by the watcher.
+*/
+func (f *FileExists) scan(relDir string) (files []string, dirs []string, err error) {
+	if info, err := os.Stat(f.config.Path); err != nil {
+		return nil, nil, err
+	} else if info.Mode().IsRegular() {
 		// If the path is a file then it is the only valid target.
-		if info.Mode().IsRegular() {
-			return []string{path.Clean(f.config.Path)}, nil
-		}
-	} else {
-		return paths, err
+		return []string{path.Clean(f.config.Path)}, nil, nil
 	}
-	// map[directoryTree][]ignorePaths
-	ignorePatterns := map[string][]string{}
-	if err := filepath.Walk(f.config.Path, func(p string, info os.FileInfo, err error) error {
-		// Get path relative to root search directory.
-		relPath, err := filepath.Rel(f.config.Path, p)
+
+	f.mutex.Lock()
+	f.matcher.clear(relDir)
+	f.mutex.Unlock()
+	root := filepath.Join(f.config.Path, filepath.FromSlash(relDir))
+	err = filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if p == root && os.IsNotExist(err) {
+				return nil
+			}
+			f.logger.Errorf("Failed to walk path %v: %v\n", p, err)
+			return nil
+		}
+		rel, err := filepath.Rel(f.config.Path, p)
 		if err != nil {
-			f.logger.Errorf("Relative path conversion error: %v\n", err)
 			// Stop walking files
 			return err
 		}
-		// If we've found a .leapsignore file then parse it for ignore patterns.
-		if info.Mode().IsRegular() && info.Name() == ".leapsignore" {
-			dirTree := filepath.Dir(relPath)
-			if dirTree == "." {
-				dirTree = ""
+		if rel = cleanRelPath(filepath.ToSlash(rel)); len(rel) > 0 && !f.visible(rel, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
 			}
-			ignorePatterns[dirTree] = append(ignorePatterns[dirTree], f.extractIgnores(relPath)...)
 			return nil
 		}
-		for dirTree, patterns := range ignorePatterns {
-			if strings.Contains(relPath, dirTree) {
-				relToPatternDir, err := filepath.Rel(dirTree, relPath)
-				if err != nil {
-					f.logger.Errorf("Relative path conversion error: %v\n", err)
-					// Stop walking files
-					return err
-				}
-				if f.checkPatterns(patterns, relToPatternDir) {
-					// Otherwise, check all ignore patterns for a match.
-					if info.Mode().IsRegular() {
-						return nil
-					}
-					return filepath.SkipDir
-				}
-			}
+		if info.IsDir() {
+			f.watch(rel)
+			patterns := f.readIgnores(rel)
+			f.mutex.Lock()
+			f.matcher.set(rel, patterns)
+			f.mutex.Unlock()
+			dirs = append(dirs, rel)
+		} else if info.Mode().IsRegular() {
+			files = append(files, rel)
 		}
-		// If not showing hidden files then skip when prefix is "."
-		if !f.config.ShowHidden && len(info.Name()) > 1 && strings.HasPrefix(info.Name(), ".") {
-			if info.Mode().IsRegular() {
-				return nil
+		return nil
+	})
+	return files, dirs, err
+}
+
+/*
+refresh - Rescans a directory of the tree and applies the differences to the set of paths and the
+watched directories, emitting an event for each path created or removed.
+*/
+func (f *FileExists) refresh(relDir string) {
+	files, dirs, err := f.scan(relDir)
+	if err != nil {
+		f.logger.Errorf("Failed to walk paths for authenticator: %v\n", err)
+	}
+
+	if f.watcher != nil {
+		keep := make(map[string]struct{}, len(dirs))
+		for _, d := range dirs {
+			keep[d] = struct{}{}
+		}
+		for d := range f.watched {
+			if _, exists := keep[d]; !exists && withinDir(relDir, d) {
+				f.watcher.Remove(d)
+				delete(f.watched, d)
 			}
-			// Skip hidden directories
-			return filepath.SkipDir
 		}
-		if info.Mode().IsRegular() {
-			paths = append(paths, relPath)
+	}
+
+	found := make(map[string]struct{}, len(files))
+	for _, p := range files {
+		found[p] = struct{}{}
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for _, p := range f.pathsWithin(relDir) {
+		if _, exists := found[p]; !exists {
+			f.removePath(p)
 		}
-		return nil
-	}); err != nil {
-		return []string{}, err
 	}
-	return paths, nil
+	for p := range found {
+		f.addPath(p)
+	}
+}
+
+// watch - Adds a watch for a directory of the tree unless it is already watched, falling back to
+// polling when the watch cannot be added.
+func (f *FileExists) watch(relDir string) {
+	if f.watcher == nil {
+		return
+	}
+	if _, exists := f.watched[relDir]; exists {
+		return
+	}
+	if err := f.watcher.Add(relDir, filepath.Join(f.config.Path, filepath.FromSlash(relDir))); err != nil {
+		f.logger.Warnf("Failed to watch %v, falling back to polling: %v\n", relDir, err)
+		f.stopWatching()
+		return
+	}
+	f.watched[relDir] = struct{}{}
+}
+
+// stopWatching - Closes the watcher, after which the tree is polled.
+func (f *FileExists) stopWatching() {
+	if f.watcher == nil {
+		return
+	}
+	f.watcher.Close()
+	for range f.watcher.Events() {
+	}
+	f.watcher = nil
+	f.watched = map[string]struct{}{}
+}
+
+// handleEvent - Applies a change within a watched directory to the set of paths.
+func (f *FileExists) handleEvent(event dirEvent) {
+	switch {
+	case event.Overflow:
+		f.logger.Warnln("Directory events were lost, rescanning paths")
+		f.refresh("")
+	case f.isIgnoreFile(event.Path):
+		// Ignore patterns have changed, and so the whole directory must be rescanned.
+		dir := path.Dir(event.Path)
+		if dir == "." {
+			dir = ""
+		}
+		f.refresh(dir)
+	case event.IsDir:
+		// Rescanning also removes the paths and watches of a directory that no longer exists.
+		f.refresh(event.Path)
+	default:
+		f.mutex.Lock()
+		if event.Removed {
+			f.removePath(event.Path)
+		} else if _, exists := f.paths[event.Path]; exists {
+			f.emit(PathEvent{Type: PathModified, Path: event.Path})
+		} else if f.visible(event.Path, false) {
+			f.addPath(event.Path)
+		}
+		f.mutex.Unlock()
+	}
 }
 
 func (f *FileExists) loop() {
+	defer close(f.closedChan)
+
+	for f.watcher != nil {
+		select {
+		case event, open := <-f.watcher.Events():
+			if !open {
+				f.logger.Warnln("Directory watcher closed, falling back to polling")
+				f.watcher = nil
+				continue
+			}
+			f.handleEvent(event)
+		case <-f.closeChan:
+			f.stopWatching()
+			return
+		}
+	}
+
 	for {
-		p, err := f.getPaths()
-		if err != nil {
-			f.logger.Errorf("Failed to walk paths for authenticator: %v\n", err)
+		select {
+		case <-time.After(time.Duration(f.config.RefreshPeriod) * time.Second):
+			f.refresh("")
+		case <-f.closeChan:
+			return
 		}
+	}
+}
 
-		f.mutex.Lock()
-		f.paths = p
-		f.mutex.Unlock()
+//--------------------------------------------------------------------------------------------------
+
+// parentDir - Returns the directory of a slash separated relative path, where the root is
+// represented by "".
+func parentDir(p string) string {
+	if dir := path.Dir(p); dir != "." {
+		return dir
+	}
+	return ""
+}
+
+// addPath - Adds a path to the set and emits an event, the mutex must be held.
+func (f *FileExists) addPath(p string) {
+	if _, exists := f.paths[p]; exists {
+		return
+	}
+	f.paths[p] = struct{}{}
+	f.sorted = nil
+
+	// Link the path and any new directories above it into the tree.
+	for child, dir := p, parentDir(p); ; child, dir = dir, parentDir(dir) {
+		entries, exists := f.tree[dir]
+		if !exists {
+			entries = map[string]struct{}{}
+			f.tree[dir] = entries
+		}
+		if _, linked := entries[child]; linked {
+			break
+		}
+		entries[child] = struct{}{}
+		if len(dir) == 0 {
+			break
+		}
+	}
+	f.emit(PathEvent{Type: PathCreated, Path: p})
+}
+
+// unlink - Removes a path from the tree along with any directories above it that become empty, the
+// mutex must be held. A path that is also a directory with entries remains linked.
+func (f *FileExists) unlink(p string) {
+	if len(f.tree[p]) > 0 {
+		return
+	}
+	for child, dir := p, parentDir(p); ; child, dir = dir, parentDir(dir) {
+		entries := f.tree[dir]
+		delete(entries, child)
+		if len(entries) > 0 {
+			return
+		}
+		delete(f.tree, dir)
+		if _, exists := f.paths[dir]; exists || len(dir) == 0 {
+			return
+		}
+	}
+}
+
+// removePath - Removes a path, and any paths within it, from the set and emits an event for each,
+// the mutex must be held.
+func (f *FileExists) removePath(p string) {
+	if _, exists := f.paths[p]; exists {
+		delete(f.paths, p)
+		f.unlink(p)
+		f.sorted = nil
+		f.emit(PathEvent{Type: PathRemoved, Path: p})
+	}
+	entries := f.tree[p]
+	if len(entries) == 0 {
+		return
+	}
+	children := make([]string, 0, len(entries))
+	for child := range entries {
+		children = append(children, child)
+	}
+	for _, child := range children {
+		f.removePath(child)
+	}
+}
+
+// pathsWithin - Returns the paths within a directory of the tree, the mutex must be held.
+func (f *FileExists) pathsWithin(dir string) []string {
+	var paths []string
+	if _, exists := f.paths[dir]; exists {
+		paths = append(paths, dir)
+	}
+	pending := []string{dir}
+	for len(pending) > 0 {
+		d := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		for child := range f.tree[d] {
+			if _, exists := f.paths[child]; exists {
+				paths = append(paths, child)
+			} else {
+				pending = append(pending, child)
+			}
+		}
+	}
+	return paths
+}
+
+// emit - Sends an event to all subscribers, the mutex must be held.
+func (f *FileExists) emit(event PathEvent) {
+	for sub := range f.subs {
+		select {
+		case sub <- event:
+		default:
+			f.logger.Warnf("Subscriber fell behind, dropping path event for %v\n", event.Path)
+		}
+	}
+}
+
+/*
+Subscribe - Returns a channel that receives an event whenever a path is created, removed or written,
+along with a function that cancels the subscription and closes the channel. Events are dropped for
+subscribers that fall too far behind.
+*/
+func (f *FileExists) Subscribe() (<-chan PathEvent, func()) {
+	sub := make(chan PathEvent, pathEventBuffer)
+
+	f.mutex.Lock()
+	f.subs[sub] = struct{}{}
+	f.mutex.Unlock()
 
-		time.Sleep(time.Duration(f.config.RefreshPeriod) * time.Second)
+	var once sync.Once
+	return sub, func() {
+		once.Do(func() {
+			f.mutex.Lock()
+			delete(f.subs, sub)
+			close(sub)
+			f.mutex.Unlock()
+		})
 	}
 }
 
+// Close - Stops watching for changes to the set of paths.
+func (f *FileExists) Close() {
+	close(f.closeChan)
+	<-f.closedChan
+}
+
 //--------------------------------------------------------------------------------------------------
 
 // Authenticate - Checks whether the documentID (file path) exists, returns EditAccess if it does.
@@ -225,18 +572,56 @@ func (f *FileExists) Authenticate(_ interface{}, _, documentID string) AccessLev
 	f.mutex.RLock()
 	defer f.mutex.RUnlock()
 
-	cleanPath := path.Clean(documentID)
-	for _, p := range f.paths {
-		if cleanPath == p {
-			return EditAccess
-		}
+	if _, exists := f.paths[path.Clean(documentID)]; exists {
+		return EditAccess
 	}
 	return NoAccess
 }
 
-// GetPaths - Returns the cached list of file paths available.
+/*
+Creatable - Returns whether a new document may be created at an ID. The ID must be a clean path
+within the tree that does not already exist, and neither the path nor any of its parent directories
+may be hidden or ignored.
+*/
+func (f *FileExists) Creatable(documentID string) bool {
+	if len(documentID) == 0 || cleanRelPath(documentID) != documentID {
+		return false
+	}
+
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	if _, exists := f.paths[documentID]; exists {
+		return false
+	}
+	segments := strings.Split(documentID, "/")
+	for i := 1; i <= len(segments); i++ {
+		if !f.visible(strings.Join(segments[:i], "/"), i < len(segments)) {
+			return false
+		}
+	}
+	return true
+}
+
+// GetPaths - Returns the cached list of file paths available, sorted alphabetically.
 func (f *FileExists) GetPaths() []string {
-	return f.paths
+	f.mutex.RLock()
+	sorted := f.sorted
+	f.mutex.RUnlock()
+	if sorted != nil {
+		return sorted
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.sorted == nil {
+		f.sorted = make([]string, 0, len(f.paths))
+		for p := range f.paths {
+			f.sorted = append(f.sorted, p)
+		}
+		sort.Strings(f.sorted)
+	}
+	return f.sorted
 }
 
 //--------------------------------------------------------------------------------------------------
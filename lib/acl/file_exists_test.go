@@ -23,8 +23,14 @@ THE SOFTWARE.
 package acl
 
 import (
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
 	"testing"
+	"time"
 
 	"github.com/Jeffail/leaps/lib/util/service/log"
 )
@@ -97,4 +103,255 @@ func TestIgnorePatterns(t *testing.T) {
 	}
 }
 
+func TestIgnoreMatcher(t *testing.T) {
+	m := newIgnoreMatcher([]string{".git/"})
+	for dir, lines := range map[string][]string{
+		"": {
+			"# comment",
+			"*.log",
+			"!keep.log",
+			"build/",
+			"/root_only.txt",
+			"docs/**/*.tmp",
+			"vendor/**",
+		},
+		"src": {
+			"!debug.log",
+			"gen/",
+			"\\#notes",
+		},
+		"src/deep": {
+			"*",
+			"!*.go",
+		},
+	} {
+		patterns := []ignorePattern{}
+		for _, line := range lines {
+			if p, ok := parseIgnorePattern(line); ok {
+				patterns = append(patterns, p)
+			}
+		}
+		m.set(dir, patterns)
+	}
+
+	testCases := []struct {
+		path     string
+		isDir    bool
+		expected bool
+	}{
+		{"app.log", false, true},
+		{"a/b/app.log", false, true},
+		{"keep.log", false, false},
+		{"a/keep.log", false, false},
+		{"build", true, true},
+		{"a/build", true, true},
+		{"build", false, false},
+		{"root_only.txt", false, true},
+		{"a/root_only.txt", false, false},
+		{"docs/x.tmp", false, true},
+		{"docs/a/b/x.tmp", false, true},
+		{"x.tmp", false, false},
+		{"vendor", true, false},
+		{"vendor/a/b.go", false, true},
+		{"src/debug.log", false, false},
+		{"src/other.log", false, true},
+		{"debug.log", false, true},
+		{"src/gen", true, true},
+		{"gen", true, false},
+		{"src/#notes", false, true},
+		{"src/deep/main.go", false, false},
+		{"src/deep/main.c", false, true},
+		{".git", true, true},
+		{"sub/.git", true, true},
+	}
+	for _, test := range testCases {
+		if actual := m.ignored(test.path, test.isDir); actual != test.expected {
+			t.Errorf("Wrong result for %v: %v != %v", test.path, actual, test.expected)
+		}
+	}
+}
+
+func writeTree(t *testing.T, root string, files map[string]string) {
+	for p, content := range files {
+		full := filepath.Join(root, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFileExistsNestedIgnores(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_file_exists")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeTree(t, dir, map[string]string{
+		".gitignore":           "bin/\n*.o\n",
+		".leapsignore":         "secret.txt\n",
+		"main.go":              "",
+		"main.o":               "",
+		"secret.txt":           "",
+		"bin/app":              "",
+		".hidden/file":         "",
+		"lib/.gitignore":       "!keep.o\n",
+		"lib/keep.o":           "",
+		"lib/other.o":          "",
+		"lib/sub/.leapsignore": "**/*.md\n",
+		"lib/sub/a.md":         "",
+		"lib/sub/x/b.md":       "",
+		"lib/sub/c.txt":        "",
+	})
+
+	conf := NewFileExistsConfig()
+	conf.Path = dir
+	conf.Watch = false
+
+	f := NewFileExists(conf, logger())
+	defer f.Close()
+
+	exp := []string{"lib/keep.o", "lib/sub/c.txt", "main.go"}
+	if act := f.GetPaths(); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong paths: %v != %v", act, exp)
+	}
+	if level := f.Authenticate(nil, "", "lib/keep.o"); level != EditAccess {
+		t.Errorf("Wrong access level: %v != %v", level, EditAccess)
+	}
+	if level := f.Authenticate(nil, "", "bin/app"); level != NoAccess {
+		t.Errorf("Wrong access level: %v != %v", level, NoAccess)
+	}
+}
+
+func TestFileExistsWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_file_exists")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeTree(t, dir, map[string]string{
+		"a.txt":     "",
+		"sub/b.txt": "",
+	})
+
+	conf := NewFileExistsConfig()
+	conf.Path = dir
+	conf.RefreshPeriod = 1
+
+	f := NewFileExists(conf, logger())
+	defer f.Close()
+
+	events, cancel := f.Subscribe()
+	defer cancel()
+
+	expecting := func(expected []PathEvent, event PathEvent) bool {
+		for _, e := range expected {
+			if e == event {
+				return true
+			}
+		}
+		return false
+	}
+	expectEvents := func(expected ...PathEvent) {
+		received := map[PathEvent]struct{}{}
+		timeout := time.After(time.Second * 5)
+		for len(received) < len(expected) {
+			select {
+			case event := <-events:
+				// Writes to paths are only checked when expected.
+				if event.Type == PathModified && !expecting(expected, event) {
+					continue
+				}
+				received[event] = struct{}{}
+			case <-timeout:
+				t.Fatalf("Timed out waiting for events, received: %v, expected: %v", received, expected)
+			}
+		}
+		for _, e := range expected {
+			if _, exists := received[e]; !exists {
+				t.Errorf("Missing event: %v, received: %v", e, received)
+			}
+		}
+	}
+
+	writeTree(t, dir, map[string]string{"c.txt": "", "new/d/e.txt": ""})
+	expectEvents(
+		PathEvent{Type: PathCreated, Path: "c.txt"},
+		PathEvent{Type: PathCreated, Path: "new/d/e.txt"},
+	)
+
+	if runtime.GOOS == "linux" {
+		writeTree(t, dir, map[string]string{"a.txt": "changed"})
+		expectEvents(PathEvent{Type: PathModified, Path: "a.txt"})
+	}
+
+	if err = os.RemoveAll(filepath.Join(dir, "sub")); err != nil {
+		t.Fatal(err)
+	}
+	expectEvents(PathEvent{Type: PathRemoved, Path: "sub/b.txt"})
+
+	writeTree(t, dir, map[string]string{".gitignore": "new/\n"})
+	expectEvents(PathEvent{Type: PathRemoved, Path: "new/d/e.txt"})
+
+	exp := []string{"a.txt", "c.txt"}
+	if act := f.GetPaths(); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong paths: %v != %v", act, exp)
+	}
+}
+
+func TestFileExistsRemoveSubtree(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_file_exists")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := NewFileExistsConfig()
+	conf.Path = dir
+	conf.Watch = false
+
+	f := NewFileExists(conf, logger())
+	defer f.Close()
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for _, p := range []string{"a.txt", "a/b.txt", "a/c/d.txt", "a/c/e.txt", "ab/f.txt", "g/h.txt"} {
+		f.addPath(p)
+	}
+
+	exp := []string{"a/b.txt", "a/c/d.txt", "a/c/e.txt"}
+	act := f.pathsWithin("a")
+	sort.Strings(act)
+	if !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong paths within a: %v != %v", act, exp)
+	}
+
+	f.removePath("a/c")
+	f.removePath("a.txt")
+	if _, exists := f.tree["a/c"]; exists {
+		t.Error("Expected removed directory to be unlinked")
+	}
+	exp = []string{"a/b.txt", "ab/f.txt", "g/h.txt"}
+	act = f.pathsWithin("")
+	sort.Strings(act)
+	if !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong paths after removal: %v != %v", act, exp)
+	}
+
+	f.removePath("a")
+	f.removePath("ab/f.txt")
+	f.removePath("g")
+	if len(f.paths) != 0 {
+		t.Errorf("Expected no paths: %v", f.paths)
+	}
+	if len(f.tree) != 0 {
+		t.Errorf("Expected an empty tree: %v", f.tree)
+	}
+}
+
 //--------------------------------------------------------------------------------------------------
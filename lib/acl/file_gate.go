@@ -0,0 +1,84 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, sub to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package acl
+
+//--------------------------------------------------------------------------------------------------
+
+/*
+FileGate - Caps the access levels granted by an authenticator to those of a FileExists, so that
+hidden, ignored and reserved paths remain inaccessible regardless of the rules of the authenticator.
+New documents may only be created where the FileExists considers them creatable, and only when the
+authenticator grants CreateAccess for them.
+*/
+type FileGate struct {
+	base  Authenticator
+	files *FileExists
+}
+
+// NewFileGate - Creates a FileGate that caps the access levels of an authenticator.
+func NewFileGate(base Authenticator, files *FileExists) *FileGate {
+	return &FileGate{base: base, files: files}
+}
+
+// Links - Returns the gated authenticator as the only link, so that its identifier can be found.
+func (f *FileGate) Links() []Link {
+	return []Link{{Name: "gated", Authenticator: f.base}}
+}
+
+// Explain - Returns the access level of the authenticator capped by that of the FileExists, along
+// with the name of the authenticator that decided it.
+func (f *FileGate) Explain(userMetadata interface{}, token, documentID string) (AccessLevel, string) {
+	if len(documentID) == 0 {
+		return f.ExplainCreate(userMetadata, token, documentID)
+	}
+	level, by := Explain(f.base, userMetadata, token, documentID)
+	if filesLevel := f.files.Authenticate(userMetadata, token, documentID); filesLevel < level {
+		return filesLevel, "file_exists"
+	}
+	return level, by
+}
+
+// Authenticate - Returns the access level of the authenticator capped by that of the FileExists.
+func (f *FileGate) Authenticate(userMetadata interface{}, token, documentID string) AccessLevel {
+	level, _ := f.Explain(userMetadata, token, documentID)
+	return level
+}
+
+// ExplainCreate - Returns the access level of the authenticator for creating a document, which is
+// NoAccess when the document is not creatable, along with the name of the authenticator that
+// decided it. A blank document ID is only checked by the authenticator.
+func (f *FileGate) ExplainCreate(userMetadata interface{}, token, documentID string) (AccessLevel, string) {
+	if len(documentID) > 0 && !f.files.Creatable(documentID) {
+		return NoAccess, "file_exists"
+	}
+	return ExplainCreate(f.base, userMetadata, token, documentID)
+}
+
+// AuthenticateCreate - Returns the access level of the authenticator for creating a document, which
+// is NoAccess when the document is not creatable.
+func (f *FileGate) AuthenticateCreate(userMetadata interface{}, token, documentID string) AccessLevel {
+	level, _ := f.ExplainCreate(userMetadata, token, documentID)
+	return level
+}
+
+//--------------------------------------------------------------------------------------------------
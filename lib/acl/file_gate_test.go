@@ -0,0 +1,110 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, sub to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package acl
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Jeffail/leaps/lib/util/service/metrics"
+)
+
+//--------------------------------------------------------------------------------------------------
+
+func TestFileGateCatchAllRule(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_file_gate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeTree(t, dir, map[string]string{
+		".gitignore":       "bin/\n",
+		".git/config":      "",
+		".leaps_cot.json":  "",
+		"invites.json":     "",
+		"main.go":          "",
+		"bin/app":          "",
+		"docs/readme.md":   "",
+		"rules/rules.yaml": "rules:\n  - paths: [ \"**\" ]\n    users: [ \"*\" ]\n    access: CREATE\n",
+	})
+
+	filesConf := NewFileExistsConfig()
+	filesConf.Path = dir
+	filesConf.Watch = false
+	filesConf.ReservedIgnores = append(filesConf.ReservedIgnores, "/.leaps_cot.json", "/invites.json")
+	files := NewFileExists(filesConf, logger())
+	defer files.Close()
+
+	rulesConf := NewRulesConfig()
+	rulesConf.Path = filepath.Join(dir, "rules", "rules.yaml")
+	rules, err := NewRules(rulesConf, nil, logger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	gate := NewFileGate(rules, files)
+
+	for _, id := range []string{".git/config", ".leaps_cot.json", "invites.json", "bin/app", "missing.go"} {
+		if level := rules.Authenticate("alice", "", id); level != CreateAccess {
+			t.Errorf("%v: wrong ungated access level: %v", id, level)
+		}
+		if level := gate.Authenticate("alice", "", id); level != NoAccess {
+			t.Errorf("%v: wrong gated access level: %v", id, level)
+		}
+	}
+	if level := gate.Authenticate("alice", "", "main.go"); level != EditAccess {
+		t.Errorf("Wrong gated access level of visible file: %v", level)
+	}
+
+	createCases := map[string]AccessLevel{
+		"new.go":            CreateAccess,
+		"docs/new/a.md":     CreateAccess,
+		"main.go":           NoAccess,
+		".git/hooks/commit": NoAccess,
+		".leaps_cot.json":   NoAccess,
+		"invites.json":      NoAccess,
+		"bin/other":         NoAccess,
+		"docs/../bin/x":     NoAccess,
+		"":                  CreateAccess,
+	}
+	for id, exp := range createCases {
+		if level := AuthenticateCreate(gate, "alice", "", id); level != exp {
+			t.Errorf("%v: wrong gated create access level: %v != %v", id, level, exp)
+		}
+	}
+	if level, by := ExplainCreate(gate, "alice", "", "bin/other"); level != NoAccess || by != "file_exists" {
+		t.Errorf("Wrong explanation: %v, %v", level, by)
+	}
+
+	chain := NewMinOf("chain", []Link{{Name: "gate", Authenticator: gate}}, logger(), metrics.DudType{})
+	if level := AuthenticateCreate(chain, "alice", "", "bin/other"); level != NoAccess {
+		t.Errorf("Wrong chained create access level: %v", level)
+	}
+	if level := AuthenticateCreate(chain, "alice", "", "new.go"); level != CreateAccess {
+		t.Errorf("Wrong chained create access level: %v", level)
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
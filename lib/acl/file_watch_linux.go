@@ -0,0 +1,149 @@
+// +build linux
+
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, sub to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package acl
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+//--------------------------------------------------------------------------------------------------
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM |
+	syscall.IN_MOVED_TO | syscall.IN_CLOSE_WRITE | syscall.IN_ONLYDIR
+
+// inotifyWatcher - A dirWatcher implemented with inotify.
+type inotifyWatcher struct {
+	file   *os.File
+	fd     int
+	events chan dirEvent
+
+	mutex   sync.Mutex
+	watches map[string]int
+	dirs    map[int]string
+}
+
+// newDirWatcher - Creates an inotify based dirWatcher.
+func newDirWatcher() (dirWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	w := &inotifyWatcher{
+		// With a non-blocking descriptor reads are managed by the runtime poller, which allows
+		// Close to interrupt a pending read.
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		fd:      fd,
+		events:  make(chan dirEvent, 100),
+		watches: map[string]int{},
+		dirs:    map[int]string{},
+	}
+	go w.loop()
+	return w, nil
+}
+
+// Add - Starts watching a directory, identified in events by its relative path.
+func (w *inotifyWatcher) Add(relPath, dir string) error {
+	wd, err := syscall.InotifyAddWatch(w.fd, dir, inotifyMask)
+	if err != nil {
+		return err
+	}
+	w.mutex.Lock()
+	w.watches[relPath] = wd
+	w.dirs[wd] = relPath
+	w.mutex.Unlock()
+	return nil
+}
+
+// Remove - Stops watching a directory and all watched directories within it.
+func (w *inotifyWatcher) Remove(relPath string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for p, wd := range w.watches {
+		if withinDir(relPath, p) {
+			syscall.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.watches, p)
+			delete(w.dirs, wd)
+		}
+	}
+}
+
+// Events - Returns a channel of events, which is closed when the watcher is closed.
+func (w *inotifyWatcher) Events() <-chan dirEvent {
+	return w.events
+}
+
+// Close - Stops watching all directories.
+func (w *inotifyWatcher) Close() error {
+	return w.file.Close()
+}
+
+func (w *inotifyWatcher) loop() {
+	defer close(w.events)
+
+	buf := make([]byte, (syscall.SizeofInotifyEvent+syscall.NAME_MAX+1)*64)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			return
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			name := string(bytes.TrimRight(buf[nameStart:nameStart+int(raw.Len)], "\x00"))
+			offset = nameStart + int(raw.Len)
+
+			if raw.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				w.events <- dirEvent{Overflow: true}
+				continue
+			}
+
+			w.mutex.Lock()
+			dir, exists := w.dirs[int(raw.Wd)]
+			if raw.Mask&syscall.IN_IGNORED != 0 && exists {
+				delete(w.dirs, int(raw.Wd))
+				if w.watches[dir] == int(raw.Wd) {
+					delete(w.watches, dir)
+				}
+			}
+			w.mutex.Unlock()
+			if !exists || len(name) == 0 {
+				continue
+			}
+
+			w.events <- dirEvent{
+				Path:    filepath.ToSlash(filepath.Join(dir, name)),
+				IsDir:   raw.Mask&syscall.IN_ISDIR != 0,
+				Removed: raw.Mask&(syscall.IN_DELETE|syscall.IN_MOVED_FROM) != 0,
+			}
+		}
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
@@ -0,0 +1,179 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, sub to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package acl
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jeffail/leaps/lib/util/service/log"
+	"golang.org/x/crypto/bcrypt"
+)
+
+//--------------------------------------------------------------------------------------------------
+
+// Errors for the Htpasswd type.
+var (
+	ErrNoUsersPath = errors.New("a path to a users file must be provided")
+)
+
+// HtpasswdConfig - A config object for the Htpasswd object.
+type HtpasswdConfig struct {
+	Path string `json:"path" yaml:"path"`
+}
+
+// NewHtpasswdConfig - Returns a default config object for a Htpasswd object.
+func NewHtpasswdConfig() HtpasswdConfig {
+	return HtpasswdConfig{
+		Path: "",
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
+
+/*
+Htpasswd - Verifies the passwords of users listed within an htpasswd style file, where each line is
+a username and a bcrypt hash of their password separated by a colon:
+
+alice:$2y$10$...
+
+Files of this format can be created with `htpasswd -B`. Blank lines and lines starting with # are
+skipped, and users with any other type of hash are ignored. The file is read again whenever it has
+been modified since it was last read.
+*/
+type Htpasswd struct {
+	logger log.Modular
+	config HtpasswdConfig
+
+	users   map[string][]byte
+	modTime time.Time
+	mutex   *sync.RWMutex
+}
+
+// NewHtpasswd - Creates a Htpasswd using the provided configuration.
+func NewHtpasswd(config HtpasswdConfig, logger log.Modular) (*Htpasswd, error) {
+	if len(config.Path) == 0 {
+		return nil, ErrNoUsersPath
+	}
+	h := Htpasswd{
+		logger: logger.NewModule(":htpasswd"),
+		config: config,
+		users:  map[string][]byte{},
+		mutex:  &sync.RWMutex{},
+	}
+	if err := h.reload(); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// parseHtpasswd - Parses the bcrypt hashed users of an htpasswd file.
+func (h *Htpasswd) parseHtpasswd(data []byte) (map[string][]byte, error) {
+	users := map[string][]byte{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		split := strings.Index(line, ":")
+		if split <= 0 {
+			return nil, fmt.Errorf("line %v: expected username:hash", lineNum)
+		}
+		username, hash := line[:split], line[split+1:]
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			h.logger.Warnf("Ignoring user %v as their password is not a bcrypt hash\n", username)
+			continue
+		}
+		users[username] = []byte(hash)
+	}
+	return users, scanner.Err()
+}
+
+// reload - Reads the users file if it has been modified since it was last read.
+func (h *Htpasswd) reload() error {
+	info, err := os.Stat(h.config.Path)
+	if err != nil {
+		return err
+	}
+
+	h.mutex.RLock()
+	unchanged := info.ModTime().Equal(h.modTime)
+	h.mutex.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(h.config.Path)
+	if err != nil {
+		return err
+	}
+	users, err := h.parseHtpasswd(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse users file %v: %v", h.config.Path, err)
+	}
+
+	h.mutex.Lock()
+	h.users = users
+	h.modTime = info.ModTime()
+	h.mutex.Unlock()
+	return nil
+}
+
+// dummyHash - Compared against when a user does not exist, so that the time taken to reject an
+// unknown user is similar to that of a wrong password.
+var (
+	dummyHash     []byte
+	dummyHashOnce sync.Once
+)
+
+// Verify - Returns whether a password is correct for a user.
+func (h *Htpasswd) Verify(username, password string) bool {
+	if err := h.reload(); err != nil {
+		h.logger.Errorf("Failed to reload users, the previous users remain in use: %v\n", err)
+	}
+
+	h.mutex.RLock()
+	hash, exists := h.users[username]
+	h.mutex.RUnlock()
+
+	if !exists {
+		dummyHashOnce.Do(func() {
+			dummyHash, _ = bcrypt.GenerateFromPassword([]byte("leaps"), bcrypt.DefaultCost)
+		})
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+}
+
+//--------------------------------------------------------------------------------------------------
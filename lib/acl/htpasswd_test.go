@@ -0,0 +1,96 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, sub to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package acl
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+//--------------------------------------------------------------------------------------------------
+
+func TestHtpasswd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_htpasswd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	hash := func(password string) string {
+		h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(h)
+	}
+
+	usersPath := filepath.Join(dir, "users")
+	writeTestFile(t, usersPath, []byte(
+		"# users\n\nalice:"+hash("wonderland")+"\nbob:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n",
+	))
+
+	conf := NewHtpasswdConfig()
+	conf.Path = usersPath
+
+	h, err := NewHtpasswd(conf, logger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !h.Verify("alice", "wonderland") {
+		t.Error("Expected correct password to verify")
+	}
+	if h.Verify("alice", "looking glass") {
+		t.Error("Expected wrong password to fail")
+	}
+	if h.Verify("bob", "password") {
+		t.Error("Expected user without bcrypt hash to fail")
+	}
+	if h.Verify("carol", "wonderland") {
+		t.Error("Expected unknown user to fail")
+	}
+
+	mtime := time.Now().Add(time.Minute)
+	writeTestFile(t, usersPath, []byte("carol:"+hash("secret")+"\n"))
+	if err = os.Chtimes(usersPath, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if !h.Verify("carol", "secret") {
+		t.Error("Expected modified users file to be reloaded")
+	}
+	if h.Verify("alice", "wonderland") {
+		t.Error("Expected removed user to fail")
+	}
+
+	writeTestFile(t, usersPath, []byte("no separator\n"))
+	if _, err = NewHtpasswd(conf, logger()); err == nil {
+		t.Error("Expected error from malformed users file")
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
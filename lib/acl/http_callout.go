@@ -0,0 +1,346 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, sub to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package acl
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Jeffail/leaps/lib/util/service/log"
+	"github.com/Jeffail/leaps/lib/util/service/metrics"
+)
+
+//--------------------------------------------------------------------------------------------------
+
+func init() {
+	constructors["http_callout"] = typeSpec{
+		constructor: func(conf Config, logger log.Modular, stats metrics.Type) (Authenticator, error) {
+			return NewHTTPCallout(conf.HTTPCallout, logger)
+		},
+		description: `
+Asks an external HTTP service for access decisions, caching its responses and
+returning a fallback access level while the service is unavailable.`,
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// Errors for the HTTPCallout type.
+var (
+	ErrNoCalloutURL = errors.New("a URL must be provided for HTTP callouts")
+	ErrCircuitOpen  = errors.New("circuit breaker is open")
+)
+
+// HTTPCalloutConfig - A config object for the HTTPCallout acl object.
+type HTTPCalloutConfig struct {
+	URL              string            `json:"url" yaml:"url"`
+	Headers          map[string]string `json:"headers" yaml:"headers"`
+	TimeoutMS        int64             `json:"timeout_ms" yaml:"timeout_ms"`
+	CacheTTLMS       int64             `json:"cache_ttl_ms" yaml:"cache_ttl_ms"`
+	CacheMaxEntries  int               `json:"cache_max_entries" yaml:"cache_max_entries"`
+	BreakerThreshold int               `json:"breaker_threshold" yaml:"breaker_threshold"`
+	BreakerResetMS   int64             `json:"breaker_reset_ms" yaml:"breaker_reset_ms"`
+	FallbackAccess   string            `json:"fallback_access" yaml:"fallback_access"`
+}
+
+// NewHTTPCalloutConfig - Returns a default config object for a HTTPCallout object.
+func NewHTTPCalloutConfig() HTTPCalloutConfig {
+	return HTTPCalloutConfig{
+		URL:              "",
+		Headers:          map[string]string{},
+		TimeoutMS:        2000,
+		CacheTTLMS:       5000,
+		CacheMaxEntries:  10000,
+		BreakerThreshold: 5,
+		BreakerResetMS:   10000,
+		FallbackAccess:   "NONE",
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// calloutRequest - The body of a request sent to the callout URL. Groups are sent explicitly as they
+// are not always serialised with the user metadata.
+type calloutRequest struct {
+	UserMetadata interface{} `json:"user_metadata"`
+	Groups       []string    `json:"groups,omitempty"`
+	Token        string      `json:"token"`
+	DocumentID   string      `json:"document_id"`
+}
+
+// calloutResponse - The expected body of a response from the callout URL.
+type calloutResponse struct {
+	AccessLevel string `json:"access_level"`
+}
+
+// calloutCacheEntry - A cached access level and the time at which it expires.
+type calloutCacheEntry struct {
+	level   AccessLevel
+	expires time.Time
+}
+
+/*
+HTTPCallout - An Authenticator type that delegates access decisions to an external service over
+HTTP.
+
+For each decision a POST request is made to the configured URL with a JSON body:
+
+	{
+		"user_metadata":<user_metadata>,
+		"groups":[<groups>],
+		"token":"<token>",
+		"document_id":"<document_id>"
+	}
+
+The groups of the user (see UserGroups) are omitted when there are none. The document ID is blank
+when the service is asked whether the user may create documents. The
+service should respond with a 200 status and a JSON body containing the granted access level:
+
+{ "access_level":"<access_level>" }
+
+The options for <access_level> are `CREATE`, `EDIT`, `READ` and `NONE`. A 401 or 403 status is
+treated as a decision of NONE. Decisions are cached for a configurable TTL.
+
+Any other status, a malformed response, or a request exceeding the timeout counts as a failure, and
+the fallback access level is returned instead, including when the HTTPCallout is a link of a chain
+(see FallbackDecider). After a number of consecutive failures the circuit breaker opens and requests
+are not attempted, with the fallback level returned immediately, until the reset period has passed.
+A single request is then attempted, which closes the breaker again if it succeeds.
+*/
+type HTTPCallout struct {
+	logger   log.Modular
+	config   HTTPCalloutConfig
+	client   *http.Client
+	fallback AccessLevel
+	now      func() time.Time
+
+	cache map[string]calloutCacheEntry
+
+	failures  int
+	openUntil time.Time
+	probing   bool
+
+	mutex *sync.Mutex
+}
+
+// NewHTTPCallout - Creates an HTTPCallout using the provided configuration.
+func NewHTTPCallout(config HTTPCalloutConfig, logger log.Modular) (*HTTPCallout, error) {
+	if len(config.URL) == 0 {
+		return nil, ErrNoCalloutURL
+	}
+	fallback := parseAccessLevel(config.FallbackAccess)
+	if fallback == NoAccess && len(config.FallbackAccess) > 0 && config.FallbackAccess != "NONE" {
+		return nil, fmt.Errorf("unrecognised fallback access level: %v", config.FallbackAccess)
+	}
+	return &HTTPCallout{
+		logger: logger.NewModule(":http_auth"),
+		config: config,
+		client: &http.Client{
+			Timeout: time.Duration(config.TimeoutMS) * time.Millisecond,
+		},
+		fallback: fallback,
+		now:      time.Now,
+		cache:    map[string]calloutCacheEntry{},
+		mutex:    &sync.Mutex{},
+	}, nil
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// cached - Returns a cached decision for a request, if one exists and has not expired.
+func (h *HTTPCallout) cached(key string) (AccessLevel, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	entry, exists := h.cache[key]
+	if !exists {
+		return NoAccess, false
+	}
+	if !h.now().Before(entry.expires) {
+		delete(h.cache, key)
+		return NoAccess, false
+	}
+	return entry.level, true
+}
+
+// store - Caches a decision for a request, evicting entries when the cache is full.
+func (h *HTTPCallout) store(key string, level AccessLevel) {
+	if h.config.CacheTTLMS <= 0 {
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	now := h.now()
+	if h.config.CacheMaxEntries > 0 && len(h.cache) >= h.config.CacheMaxEntries {
+		for k, entry := range h.cache {
+			if !now.Before(entry.expires) {
+				delete(h.cache, k)
+			}
+		}
+		for k := range h.cache {
+			if len(h.cache) < h.config.CacheMaxEntries {
+				break
+			}
+			delete(h.cache, k)
+		}
+	}
+	h.cache[key] = calloutCacheEntry{
+		level:   level,
+		expires: now.Add(time.Duration(h.config.CacheTTLMS) * time.Millisecond),
+	}
+}
+
+// allow - Returns whether a request may be attempted according to the circuit breaker.
+func (h *HTTPCallout) allow() bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.config.BreakerThreshold <= 0 || h.failures < h.config.BreakerThreshold {
+		return true
+	}
+	if h.probing || h.now().Before(h.openUntil) {
+		return false
+	}
+	// Half open, allow a single request through.
+	h.probing = true
+	return true
+}
+
+// report - Records the outcome of a request with the circuit breaker.
+func (h *HTTPCallout) report(err error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.probing = false
+	if err == nil {
+		h.failures = 0
+		return
+	}
+	h.failures++
+	if h.config.BreakerThreshold > 0 && h.failures >= h.config.BreakerThreshold {
+		h.openUntil = h.now().Add(time.Duration(h.config.BreakerResetMS) * time.Millisecond)
+	}
+}
+
+// call - Performs a single callout request and parses the decision.
+func (h *HTTPCallout) call(body []byte) (AccessLevel, error) {
+	req, err := http.NewRequest("POST", h.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return NoAccess, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range h.config.Headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := h.client.Do(req)
+	if err != nil {
+		return NoAccess, err
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, res.Body)
+		res.Body.Close()
+	}()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return NoAccess, nil
+	default:
+		return NoAccess, fmt.Errorf("unexpected status: %v", res.Status)
+	}
+
+	var decision calloutResponse
+	if err = json.NewDecoder(res.Body).Decode(&decision); err != nil {
+		return NoAccess, fmt.Errorf("failed to parse response: %v", err)
+	}
+	level := parseAccessLevel(decision.AccessLevel)
+	if level == NoAccess && decision.AccessLevel != "NONE" {
+		return NoAccess, fmt.Errorf("unrecognised access level: %v", decision.AccessLevel)
+	}
+	return level, nil
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// Decide - Asks the external service for an access level, or returns a cached decision. An error is
+// returned when the service could not be reached or gave an invalid response.
+func (h *HTTPCallout) Decide(userMetadata interface{}, token, documentID string) (AccessLevel, error) {
+	body, err := json.Marshal(calloutRequest{
+		UserMetadata: userMetadata,
+		Groups:       groupsFromMetadata(userMetadata),
+		Token:        token,
+		DocumentID:   documentID,
+	})
+	if err != nil {
+		return NoAccess, err
+	}
+	key := string(body)
+
+	if level, ok := h.cached(key); ok {
+		return level, nil
+	}
+	if !h.allow() {
+		return NoAccess, ErrCircuitOpen
+	}
+
+	level, err := h.call(body)
+	h.report(err)
+	if err != nil {
+		return NoAccess, err
+	}
+	h.store(key, level)
+	return level, nil
+}
+
+// FallbackLevel - Returns the configured fallback access level, which is used when the service is
+// unavailable both by Authenticate and by chains that consult Decide.
+func (h *HTTPCallout) FallbackLevel() AccessLevel {
+	return h.fallback
+}
+
+// Authenticate - Asks the external service for an access level, returning the fallback access level
+// when the service is unavailable.
+func (h *HTTPCallout) Authenticate(userMetadata interface{}, token, documentID string) AccessLevel {
+	level, err := h.Decide(userMetadata, token, documentID)
+	if err == ErrCircuitOpen {
+		h.logger.Debugf("Returning fallback access for `%v`: %v\n", documentID, err)
+		return h.fallback
+	}
+	if err != nil {
+		h.logger.Errorf("Callout failed, returning fallback access for `%v`: %v\n", documentID, err)
+		return h.fallback
+	}
+	return level
+}
+
+//--------------------------------------------------------------------------------------------------
@@ -0,0 +1,215 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, sub to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package acl
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//--------------------------------------------------------------------------------------------------
+
+type calloutServer struct {
+	calls  int32
+	fail   int32
+	delay  time.Duration
+	levels map[string]string
+}
+
+func (c *calloutServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt32(&c.calls, 1)
+	if atomic.LoadInt32(&c.fail) == 1 {
+		http.Error(w, "broken", http.StatusInternalServerError)
+		return
+	}
+	if c.delay > 0 {
+		time.Sleep(c.delay)
+	}
+	if r.Header.Get("X-Secret") != "foo" {
+		http.Error(w, "missing secret", http.StatusUnauthorized)
+		return
+	}
+	var req calloutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	level, exists := c.levels[userIDFromMetadata(req.UserMetadata)+":"+req.DocumentID]
+	for _, group := range req.Groups {
+		if !exists {
+			level, exists = c.levels["@"+group+":"+req.DocumentID]
+		}
+	}
+	if !exists {
+		http.Error(w, "no access", http.StatusForbidden)
+		return
+	}
+	json.NewEncoder(w).Encode(calloutResponse{AccessLevel: level})
+}
+
+// groupedUser - User metadata with groups that are not serialised.
+type groupedUser struct {
+	Username string `json:"username"`
+	groups   []string
+}
+
+func (g groupedUser) UserGroups() []string {
+	return g.groups
+}
+
+func newTestCallout(t *testing.T, url string) *HTTPCallout {
+	conf := NewHTTPCalloutConfig()
+	conf.URL = url
+	conf.Headers["X-Secret"] = "foo"
+	conf.TimeoutMS = 100
+	conf.BreakerThreshold = 2
+	conf.FallbackAccess = "READ"
+
+	h, err := NewHTTPCallout(conf, logger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestHTTPCalloutDecisions(t *testing.T) {
+	srv := &calloutServer{levels: map[string]string{
+		"alice:foo":  "EDIT",
+		"alice:":     "CREATE",
+		"bob:foo":    "NONE",
+		"bob:bar":    "WRITE",
+		"@staff:foo": "READ",
+	}}
+	server := httptest.NewServer(srv)
+	defer server.Close()
+
+	h := newTestCallout(t, server.URL)
+
+	testCases := []struct {
+		user     interface{}
+		document string
+		expected AccessLevel
+	}{
+		{"alice", "foo", EditAccess},
+		{map[string]interface{}{"username": "alice"}, "", CreateAccess},
+		{"bob", "foo", NoAccess},
+		{"carol", "foo", NoAccess},
+		{"bob", "bar", ReadAccess},
+		{groupedUser{Username: "dave", groups: []string{"guests", "staff"}}, "foo", ReadAccess},
+		{groupedUser{Username: "dave"}, "foo", NoAccess},
+	}
+	for _, test := range testCases {
+		if actual := h.Authenticate(test.user, "token", test.document); actual != test.expected {
+			t.Errorf("%v %v: wrong access level: %v != %v", test.user, test.document, actual, test.expected)
+		}
+	}
+}
+
+func TestHTTPCalloutCache(t *testing.T) {
+	srv := &calloutServer{levels: map[string]string{"alice:foo": "EDIT"}}
+	server := httptest.NewServer(srv)
+	defer server.Close()
+
+	now := time.Now()
+	h := newTestCallout(t, server.URL)
+	h.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if actual := h.Authenticate("alice", "token", "foo"); actual != EditAccess {
+			t.Errorf("Wrong access level: %v != %v", actual, EditAccess)
+		}
+	}
+	if calls := atomic.LoadInt32(&srv.calls); calls != 1 {
+		t.Errorf("Wrong number of callouts: %v != 1", calls)
+	}
+
+	h.Authenticate("alice", "other token", "foo")
+	if calls := atomic.LoadInt32(&srv.calls); calls != 2 {
+		t.Errorf("Wrong number of callouts: %v != 2", calls)
+	}
+
+	now = now.Add(time.Duration(h.config.CacheTTLMS) * time.Millisecond)
+	h.Authenticate("alice", "token", "foo")
+	if calls := atomic.LoadInt32(&srv.calls); calls != 3 {
+		t.Errorf("Wrong number of callouts after expiry: %v != 3", calls)
+	}
+}
+
+func TestHTTPCalloutCircuitBreaker(t *testing.T) {
+	srv := &calloutServer{levels: map[string]string{"alice:foo": "EDIT"}}
+	server := httptest.NewServer(srv)
+	defer server.Close()
+
+	now := time.Now()
+	h := newTestCallout(t, server.URL)
+	h.config.CacheTTLMS = 0
+	h.now = func() time.Time { return now }
+
+	atomic.StoreInt32(&srv.fail, 1)
+	for i := 0; i < 5; i++ {
+		if actual := h.Authenticate("alice", "token", "foo"); actual != ReadAccess {
+			t.Errorf("Wrong fallback access level: %v != %v", actual, ReadAccess)
+		}
+	}
+	if calls := atomic.LoadInt32(&srv.calls); calls != 2 {
+		t.Errorf("Breaker did not open: %v != 2", calls)
+	}
+
+	atomic.StoreInt32(&srv.fail, 0)
+	if actual := h.Authenticate("alice", "token", "foo"); actual != ReadAccess {
+		t.Errorf("Breaker closed early: %v != %v", actual, ReadAccess)
+	}
+
+	now = now.Add(time.Duration(h.config.BreakerResetMS) * time.Millisecond)
+	if actual := h.Authenticate("alice", "token", "foo"); actual != EditAccess {
+		t.Errorf("Breaker did not reset: %v != %v", actual, EditAccess)
+	}
+	if actual := h.Authenticate("alice", "token", "foo"); actual != EditAccess {
+		t.Errorf("Breaker did not close: %v != %v", actual, EditAccess)
+	}
+}
+
+func TestHTTPCalloutTimeout(t *testing.T) {
+	srv := &calloutServer{
+		delay:  time.Millisecond * 500,
+		levels: map[string]string{"alice:foo": "EDIT"},
+	}
+	server := httptest.NewServer(srv)
+	defer server.Close()
+
+	h := newTestCallout(t, server.URL)
+
+	start := time.Now()
+	if actual := h.Authenticate("alice", "token", "foo"); actual != ReadAccess {
+		t.Errorf("Wrong fallback access level: %v != %v", actual, ReadAccess)
+	}
+	if elapsed := time.Since(start); elapsed >= srv.delay {
+		t.Errorf("Callout did not time out: %v", elapsed)
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
@@ -0,0 +1,192 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, sub to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package acl
+
+import (
+	"bufio"
+	"io"
+	"path"
+	"strings"
+)
+
+//--------------------------------------------------------------------------------------------------
+
+/*
+ignorePattern - A single pattern of an ignore file, following the syntax of .gitignore files:
+
+  - Blank lines and lines starting with # are skipped, use \# for a pattern starting with #.
+  - A leading ! negates the pattern, re-including paths excluded by previous patterns.
+  - A trailing / means the pattern only matches directories.
+  - A pattern containing a / (other than a trailing one) is relative to the directory of the
+    ignore file, otherwise it matches a name at any depth below that directory. A leading ./ is
+    treated the same as a leading /.
+  - A ** segment matches zero or more directories, and a trailing /** matches everything inside.
+*/
+type ignorePattern struct {
+	segments []string
+	negate   bool
+	dirOnly  bool
+}
+
+// parseIgnorePattern - Parses a line of an ignore file, returns false if the line holds no pattern.
+func parseIgnorePattern(line string) (ignorePattern, bool) {
+	p := ignorePattern{}
+
+	line = strings.TrimRight(line, "\r")
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	if len(line) == 0 || line[0] == '#' {
+		return p, false
+	}
+	if line[0] == '!' {
+		p.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(strings.TrimPrefix(line, "./"), "/")
+	if len(line) == 0 {
+		return p, false
+	}
+
+	p.segments = strings.Split(line, "/")
+	if !anchored {
+		p.segments = append([]string{"**"}, p.segments...)
+	}
+	if last := len(p.segments) - 1; p.segments[last] == "**" && last > 0 {
+		// A trailing ** matches everything inside a directory, but not the directory itself.
+		p.segments = append(p.segments[:last], "*", "**")
+	}
+	return p, true
+}
+
+// matches - Returns whether the pattern matches a slash separated path relative to the directory of
+// its ignore file.
+func (p ignorePattern) matches(relPath string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	return matchSegments(p.segments, strings.Split(relPath, "/"))
+}
+
+// parseIgnoreFile - Parses all patterns of an ignore file.
+func parseIgnoreFile(r io.Reader) ([]ignorePattern, error) {
+	patterns := []ignorePattern{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if p, ok := parseIgnorePattern(scanner.Text()); ok {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns, scanner.Err()
+}
+
+//--------------------------------------------------------------------------------------------------
+
+/*
+ignoreMatcher - Holds the ignore patterns of a directory tree, keyed by the slash separated path of
+the directory they were read from relative to the root of the tree (the root being ""). Patterns in
+deeper directories take precedence over those in parent directories, and later patterns take
+precedence over earlier ones. Reserved patterns apply relative to the root and can not be negated
+by ignore files.
+*/
+type ignoreMatcher struct {
+	reserved []ignorePattern
+	patterns map[string][]ignorePattern
+}
+
+func newIgnoreMatcher(reserved []string) *ignoreMatcher {
+	m := &ignoreMatcher{
+		patterns: map[string][]ignorePattern{},
+	}
+	for _, r := range reserved {
+		if p, ok := parseIgnorePattern(r); ok {
+			m.reserved = append(m.reserved, p)
+		}
+	}
+	return m
+}
+
+// set - Sets the patterns read from the ignore files of a directory.
+func (m *ignoreMatcher) set(dir string, patterns []ignorePattern) {
+	if len(patterns) == 0 {
+		delete(m.patterns, dir)
+		return
+	}
+	m.patterns[dir] = patterns
+}
+
+// clear - Removes the patterns of a directory and all directories below it.
+func (m *ignoreMatcher) clear(dir string) {
+	for d := range m.patterns {
+		if withinDir(dir, d) {
+			delete(m.patterns, d)
+		}
+	}
+}
+
+// ignored - Returns whether a slash separated path relative to the root of the tree is ignored.
+func (m *ignoreMatcher) ignored(relPath string, isDir bool) bool {
+	ignored := false
+	apply := func(patterns []ignorePattern, rel string) {
+		for _, p := range patterns {
+			if p.matches(rel, isDir) {
+				ignored = !p.negate
+			}
+		}
+	}
+
+	segments := strings.Split(relPath, "/")
+	apply(m.patterns[""], relPath)
+	for i := 1; i < len(segments); i++ {
+		if patterns, exists := m.patterns[strings.Join(segments[:i], "/")]; exists {
+			apply(patterns, strings.Join(segments[i:], "/"))
+		}
+	}
+	for _, p := range m.reserved {
+		if p.matches(relPath, isDir) && !p.negate {
+			return true
+		}
+	}
+	return ignored
+}
+
+// withinDir - Returns whether a slash separated path is a directory or lies within it, where the
+// directory "" contains everything.
+func withinDir(dir, p string) bool {
+	return len(dir) == 0 || p == dir || strings.HasPrefix(p, dir+"/")
+}
+
+// cleanRelPath - Cleans a slash separated relative path, where the root is represented by "".
+func cleanRelPath(p string) string {
+	return path.Clean("/" + p)[1:]
+}
+
+//--------------------------------------------------------------------------------------------------
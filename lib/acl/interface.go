@@ -42,6 +42,32 @@ const (
 	CreateAccess
 )
 
+// String - Returns the name of the access level, as used within configs and tokens.
+func (a AccessLevel) String() string {
+	switch a {
+	case ReadAccess:
+		return "READ"
+	case EditAccess:
+		return "EDIT"
+	case CreateAccess:
+		return "CREATE"
+	}
+	return "NONE"
+}
+
+// parseAccessLevel - Parses the string representation of an access level.
+func parseAccessLevel(level string) AccessLevel {
+	switch level {
+	case "CREATE":
+		return CreateAccess
+	case "EDIT":
+		return EditAccess
+	case "READ":
+		return ReadAccess
+	}
+	return NoAccess
+}
+
 /*
 Authenticator - Implemented by types able to validate tokens for editing or creating documents.
 This is abstracted in order to accommodate for multiple authentication strategies.
@@ -59,3 +85,122 @@ type Authenticator interface {
 	// Authenticate - Check a users access level. Leave documentID blank to check for CreateAccess.
 	Authenticate(userMetadata interface{}, token, documentID string) AccessLevel
 }
+
+/*
+CreateAuthenticator - May be implemented by authenticators that are able to restrict CreateAccess to
+particular document IDs. When the ID of a new document is known it is checked with AuthenticateCreate
+instead of calling Authenticate with a blank documentID, see the AuthenticateCreate function.
+*/
+type CreateAuthenticator interface {
+	// AuthenticateCreate - Check whether a user has CreateAccess for a new document ID.
+	AuthenticateCreate(userMetadata interface{}, token, documentID string) AccessLevel
+}
+
+/*
+Identifier - Implemented by authenticators that are able to resolve the identity of the user that
+owns a token. When available the resolved identity should be trusted over any identity that a user
+declares themselves.
+*/
+type Identifier interface {
+	// Identify - Returns the username of the owner of a token, or an error if the token is invalid.
+	Identify(token string) (string, error)
+}
+
+/*
+Decider - May be implemented by authenticators that depend on an external service, in order to
+distinguish a decision of NoAccess from a failure to reach a decision. This allows a Fallback chain
+to consult another authenticator when a service is unavailable.
+*/
+type Decider interface {
+	// Decide - Check a users access level, or return an error if a decision could not be made.
+	Decide(userMetadata interface{}, token, documentID string) (AccessLevel, error)
+}
+
+/*
+FallbackDecider - May be implemented by a Decider that has an access level to fall back to when it
+fails to reach a decision, such as the fallback access level of an HTTPCallout. Chains use the
+fallback level of a failed link in place of NoAccess, although a Fallback chain still moves on to
+its next link and only uses the fallback level of its final link.
+*/
+type FallbackDecider interface {
+	Decider
+
+	// FallbackLevel - Returns the access level to use when a decision could not be made.
+	FallbackLevel() AccessLevel
+}
+
+/*
+Explainer - May be implemented by authenticators that combine other authenticators, in order to
+report which of them decided an access level.
+*/
+type Explainer interface {
+	// Explain - Returns an access level along with the name of the authenticator that decided it.
+	Explain(userMetadata interface{}, token, documentID string) (AccessLevel, string)
+}
+
+/*
+UserIdentity - May be implemented by the user metadata given to authenticators in order to expose the
+identity of the user in a structured way.
+*/
+type UserIdentity interface {
+	// UserID - Returns the unique username of the user.
+	UserID() string
+}
+
+// UserIDOf - Returns the username found within user metadata, or an empty string if there is none.
+func UserIDOf(userMetadata interface{}) string {
+	return userIDFromMetadata(userMetadata)
+}
+
+/*
+userIDFromMetadata - Attempts to extract a username from user metadata, which may either implement
+UserIdentity, be the username itself, or be a generic map with a username field. Returns an empty
+string if a username could not be found.
+*/
+func userIDFromMetadata(userMetadata interface{}) string {
+	switch t := userMetadata.(type) {
+	case UserIdentity:
+		return t.UserID()
+	case string:
+		return t
+	case map[string]interface{}:
+		if username, ok := t["username"].(string); ok {
+			return username
+		}
+	}
+	return ""
+}
+
+/*
+UserGroups - May be implemented by the user metadata given to authenticators in order to expose the
+groups that the user belongs to, such as those asserted by an authenticating reverse proxy.
+*/
+type UserGroups interface {
+	// UserGroups - Returns the names of the groups that the user belongs to.
+	UserGroups() []string
+}
+
+/*
+groupsFromMetadata - Attempts to extract the groups of a user from user metadata, which may either
+implement UserGroups or be a generic map with a groups field. Returns nil if no groups were found.
+*/
+func groupsFromMetadata(userMetadata interface{}) []string {
+	switch t := userMetadata.(type) {
+	case UserGroups:
+		return t.UserGroups()
+	case map[string]interface{}:
+		switch groups := t["groups"].(type) {
+		case []string:
+			return groups
+		case []interface{}:
+			names := []string{}
+			for _, g := range groups {
+				if name, ok := g.(string); ok {
+					names = append(names, name)
+				}
+			}
+			return names
+		}
+	}
+	return nil
+}
@@ -0,0 +1,315 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, sub to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package acl
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jeffail/leaps/lib/util/service/log"
+	"github.com/Jeffail/leaps/lib/util/service/metrics"
+)
+
+//--------------------------------------------------------------------------------------------------
+
+// Errors for the Invites type.
+var (
+	ErrInviteAccess  = errors.New("invites may only grant READ or EDIT access")
+	ErrInviteScope   = errors.New("invite scope must be a document or folder within the store")
+	ErrInviteTTL     = errors.New("invite lifetime must be positive and within the configured maximum")
+	ErrInviteInvalid = errors.New("invite is invalid, has expired or has already been redeemed")
+)
+
+// InvitesConfig - A config object for the Invites object.
+type InvitesConfig struct {
+	Path    string `json:"path" yaml:"path"`
+	MaxTTLS int64  `json:"max_ttl_s" yaml:"max_ttl_s"`
+}
+
+// NewInvitesConfig - Returns a default config object for an Invites object.
+func NewInvitesConfig() InvitesConfig {
+	return InvitesConfig{
+		Path:    "",
+		MaxTTLS: 604800,
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// Invite - The scope, access level and lifetime of an invite token. Redeemed is set on a single use
+// invite once the token of its link has been exchanged for the token of its holder.
+type Invite struct {
+	Scope     string `json:"scope"`
+	Access    string `json:"access"`
+	Expires   int64  `json:"expires"`
+	SingleUse bool   `json:"single_use"`
+	CreatedBy string `json:"created_by,omitempty"`
+	Redeemed  bool   `json:"redeemed,omitempty"`
+}
+
+// Covers - Returns whether a document ID lies within the scope of an invite.
+func (i Invite) Covers(documentID string) bool {
+	return documentID == i.Scope || strings.HasPrefix(documentID, i.Scope+"/")
+}
+
+/*
+CleanScope - Normalises the scope of an invite into the form of a document ID, returning an empty
+string if the scope is empty or escapes the store.
+*/
+func CleanScope(scope string) string {
+	scope = strings.TrimPrefix(path.Clean("/"+scope), "/")
+	if scope == "." {
+		return ""
+	}
+	return scope
+}
+
+//--------------------------------------------------------------------------------------------------
+
+/*
+Invites - An acl authenticator type that grants access to the holders of invite tokens. Each invite
+grants READ or EDIT access to a single document or to all documents within a folder until it
+expires. A single use invite grants nothing until it is redeemed, which exchanges the token of its
+link for a new token that is only given to the redeemer, such as within a cookie. The token of the
+link then grants nothing to anyone else, regardless of the username they claim.
+
+Tokens are random and only their SHA-256 hashes are kept, invites are persisted to a JSON file when
+a path is configured so that outstanding tokens survive restarts. Expired invites are discarded.
+*/
+type Invites struct {
+	config  InvitesConfig
+	logger  log.Modular
+	invites map[string]*Invite
+	mutex   sync.Mutex
+	now     func() time.Time
+}
+
+// NewInvites - Creates an Invites object, loading any previously persisted invites.
+func NewInvites(config InvitesConfig, logger log.Modular) (*Invites, error) {
+	i := &Invites{
+		config:  config,
+		logger:  logger.NewModule(":invites"),
+		invites: map[string]*Invite{},
+		now:     time.Now,
+	}
+	if len(config.Path) > 0 {
+		data, err := ioutil.ReadFile(config.Path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			if err = json.Unmarshal(data, &i.invites); err != nil {
+				return nil, err
+			}
+		}
+	}
+	i.prune()
+	return i, nil
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// hashToken - Returns the key that an invite token is stored under.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newInviteToken - Returns a new random invite token.
+func newInviteToken() (string, error) {
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(tokenBytes), nil
+}
+
+// usable - Returns the invite of a token if it exists, has not expired and, when it is single use,
+// has been redeemed. The mutex must be held by the caller.
+func (i *Invites) usable(token string) (*Invite, bool) {
+	invite, exists := i.invites[hashToken(token)]
+	if !exists || invite.Expires <= i.now().Unix() || (invite.SingleUse && !invite.Redeemed) {
+		return nil, false
+	}
+	return invite, true
+}
+
+// prune - Removes expired invites, the mutex must be held by the caller.
+func (i *Invites) prune() {
+	now := i.now().Unix()
+	for key, invite := range i.invites {
+		if invite.Expires <= now {
+			delete(i.invites, key)
+		}
+	}
+}
+
+// save - Writes all invites to the configured file, the mutex must be held by the caller.
+func (i *Invites) save() error {
+	if len(i.config.Path) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(i.invites)
+	if err != nil {
+		return err
+	}
+	tmpPath := i.config.Path + ".tmp"
+	if err = ioutil.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpPath, i.config.Path); err != nil {
+		os.Remove(tmpPath)
+	}
+	return err
+}
+
+// Mint - Creates a new invite and returns its token, which is only ever known to the caller.
+func (i *Invites) Mint(
+	scope string, access AccessLevel, ttl time.Duration, singleUse bool, createdBy string,
+) (string, Invite, error) {
+	if access != ReadAccess && access != EditAccess {
+		return "", Invite{}, ErrInviteAccess
+	}
+	if scope = CleanScope(scope); len(scope) == 0 {
+		return "", Invite{}, ErrInviteScope
+	}
+	if ttl < time.Second || ttl > time.Duration(i.config.MaxTTLS)*time.Second {
+		return "", Invite{}, ErrInviteTTL
+	}
+
+	token, err := newInviteToken()
+	if err != nil {
+		return "", Invite{}, err
+	}
+
+	invite := Invite{
+		Scope:     scope,
+		Access:    access.String(),
+		Expires:   i.now().Add(ttl).Unix(),
+		SingleUse: singleUse,
+		CreatedBy: createdBy,
+	}
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.prune()
+	i.invites[hashToken(token)] = &invite
+	if err := i.save(); err != nil {
+		delete(i.invites, hashToken(token))
+		return "", Invite{}, err
+	}
+	return token, invite, nil
+}
+
+/*
+Redeem - Exchanges the token of an invite link for the token to be kept by its holder. The token of
+an invite that may be used any number of times is returned unchanged. A single use invite is moved
+to a new token, which is only ever returned this once, and the token of its link is discarded.
+*/
+func (i *Invites) Redeem(token string) (string, Invite, error) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	key := hashToken(token)
+	invite, exists := i.invites[key]
+	if !exists || invite.Expires <= i.now().Unix() {
+		return "", Invite{}, ErrInviteInvalid
+	}
+	if !invite.SingleUse || invite.Redeemed {
+		return token, *invite, nil
+	}
+
+	holderToken, err := newInviteToken()
+	if err != nil {
+		return "", Invite{}, err
+	}
+	redeemed := *invite
+	redeemed.Redeemed = true
+
+	delete(i.invites, key)
+	i.invites[hashToken(holderToken)] = &redeemed
+	if err = i.save(); err != nil {
+		delete(i.invites, hashToken(holderToken))
+		i.invites[key] = invite
+		return "", Invite{}, err
+	}
+	i.logger.Infof("Single use invite to `%v` redeemed\n", redeemed.Scope)
+	return holderToken, redeemed, nil
+}
+
+// Lookup - Returns the invite of a token if it exists, has not expired and, when it is single use,
+// has been redeemed.
+func (i *Invites) Lookup(token string) (Invite, bool) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	invite, ok := i.usable(token)
+	if !ok {
+		return Invite{}, false
+	}
+	return *invite, true
+}
+
+// Authenticate - Returns the access level granted by an invite token for a document.
+func (i *Invites) Authenticate(userMetadata interface{}, token, documentID string) AccessLevel {
+	if len(token) == 0 || len(documentID) == 0 {
+		return NoAccess
+	}
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	invite, ok := i.usable(token)
+	if !ok || !invite.Covers(documentID) {
+		return NoAccess
+	}
+	return parseAccessLevel(invite.Access)
+}
+
+//--------------------------------------------------------------------------------------------------
+
+func init() {
+	constructors["invites"] = typeSpec{
+		constructor: func(conf Config, logger log.Modular, stats metrics.Type) (Authenticator, error) {
+			return NewInvites(conf.Invites, logger)
+		},
+		description: `
+Grants READ or EDIT access to a document or folder for the holders of expiring,
+optionally single use, invite tokens. Single use invites must be redeemed, which
+exchanges the token of the link for a token only known to the redeemer. Invites are minted by the service hosting
+leaps, and are persisted to a file so that they survive restarts.`,
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
@@ -0,0 +1,206 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, sub to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package acl
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//--------------------------------------------------------------------------------------------------
+
+func TestInvitesAuthenticate(t *testing.T) {
+	invites, err := NewInvites(NewInvitesConfig(), logger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	folderToken, invite, err := invites.Mint("/docs/../notes/", EditAccess, time.Hour, false, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invite.Scope != "notes" || invite.Access != "EDIT" {
+		t.Errorf("Wrong invite: %+v", invite)
+	}
+	fileToken, _, err := invites.Mint("readme.md", ReadAccess, time.Hour, false, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		token    string
+		document string
+		expected AccessLevel
+	}{
+		{folderToken, "notes/a/b.md", EditAccess},
+		{folderToken, "notes", EditAccess},
+		{folderToken, "notesfoo", NoAccess},
+		{folderToken, "readme.md", NoAccess},
+		{folderToken, "", NoAccess},
+		{fileToken, "readme.md", ReadAccess},
+		{fileToken, "notes/a/b.md", NoAccess},
+		{"nope", "readme.md", NoAccess},
+		{"", "readme.md", NoAccess},
+	}
+	for _, test := range testCases {
+		if actual := invites.Authenticate("bob", test.token, test.document); actual != test.expected {
+			t.Errorf("%v: wrong access level: %v != %v", test.document, actual, test.expected)
+		}
+	}
+
+	invites.now = func() time.Time { return time.Now().Add(time.Hour * 2) }
+	if actual := invites.Authenticate("bob", folderToken, "notes/a/b.md"); actual != NoAccess {
+		t.Errorf("Expired invite granted access: %v", actual)
+	}
+	if _, ok := invites.Lookup(folderToken); ok {
+		t.Error("Expired invite found by lookup")
+	}
+}
+
+func TestInvitesRedeemReusable(t *testing.T) {
+	invites, err := NewInvites(NewInvitesConfig(), logger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := invites.Mint("notes", ReadAccess, time.Hour, false, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if holderToken, invite, err := invites.Redeem(token); err != nil {
+			t.Fatal(err)
+		} else if holderToken != token || invite.Redeemed {
+			t.Errorf("Reusable invite was exchanged: %+v", invite)
+		}
+	}
+	if _, _, err = invites.Redeem("nope"); err != ErrInviteInvalid {
+		t.Errorf("Expected ErrInviteInvalid, received: %v", err)
+	}
+}
+
+func TestInvitesMintErrors(t *testing.T) {
+	invites, err := NewInvites(NewInvitesConfig(), logger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		scope  string
+		access AccessLevel
+		ttl    time.Duration
+		err    error
+	}{
+		{"foo", CreateAccess, time.Hour, ErrInviteAccess},
+		{"foo", NoAccess, time.Hour, ErrInviteAccess},
+		{"/", EditAccess, time.Hour, ErrInviteScope},
+		{"..", EditAccess, time.Hour, ErrInviteScope},
+		{"foo", EditAccess, 0, ErrInviteTTL},
+		{"foo", EditAccess, time.Hour * 24 * 8, ErrInviteTTL},
+	}
+	for _, test := range testCases {
+		if _, _, err := invites.Mint(test.scope, test.access, test.ttl, false, ""); err != test.err {
+			t.Errorf("%v %v %v: wrong error: %v != %v", test.scope, test.access, test.ttl, err, test.err)
+		}
+	}
+}
+
+func TestInvitesSingleUsePersisted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_invites")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := NewInvitesConfig()
+	conf.Path = filepath.Join(dir, "invites.json")
+
+	invites, err := NewInvites(conf, logger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := invites.Mint("notes", EditAccess, time.Hour, true, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = invites.Mint("old", EditAccess, time.Second, false, "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	if actual := invites.Authenticate("bob", token, "notes/foo"); actual != NoAccess {
+		t.Errorf("Unredeemed single use invite granted access: %v", actual)
+	}
+	if _, ok := invites.Lookup(token); ok {
+		t.Error("Unredeemed single use invite found by lookup")
+	}
+
+	holderToken, invite, err := invites.Redeem(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if holderToken == token || !invite.Redeemed || invite.Scope != "notes" {
+		t.Errorf("Wrong redeemed invite: %v %+v", holderToken == token, invite)
+	}
+	if _, _, err = invites.Redeem(token); err != ErrInviteInvalid {
+		t.Errorf("Expected ErrInviteInvalid from second redemption, received: %v", err)
+	}
+	if actual := invites.Authenticate("bob", holderToken, "notes/foo"); actual != EditAccess {
+		t.Errorf("Wrong access level for holder: %v", actual)
+	}
+	if actual := invites.Authenticate("bob", token, "notes/foo"); actual != NoAccess {
+		t.Errorf("Redeemed link granted access: %v", actual)
+	}
+
+	data, err := ioutil.ReadFile(conf.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) == 0 || string(data) == "{}" {
+		t.Fatal("Invites were not persisted")
+	}
+
+	// Reload the invites as if the service restarted, after the short lived
+	// invite has expired.
+	reloaded, err := NewInvites(conf, logger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := reloaded.Authenticate("carol", holderToken, "notes/bar"); actual != EditAccess {
+		t.Errorf("Wrong access level for holder after reload: %v", actual)
+	}
+	if _, _, err = reloaded.Redeem(token); err != ErrInviteInvalid {
+		t.Errorf("Expected ErrInviteInvalid from redemption after reload, received: %v", err)
+	}
+
+	reloaded.now = func() time.Time { return time.Now().Add(time.Minute) }
+	reloaded.mutex.Lock()
+	reloaded.prune()
+	count := len(reloaded.invites)
+	reloaded.mutex.Unlock()
+	if count != 1 {
+		t.Errorf("Expired invite not pruned: %v remaining", count)
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
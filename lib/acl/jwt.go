@@ -0,0 +1,547 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, sub to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package acl
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jeffail/leaps/lib/util/service/log"
+	"github.com/Jeffail/leaps/lib/util/service/metrics"
+	"github.com/dgrijalva/jwt-go"
+)
+
+//--------------------------------------------------------------------------------------------------
+
+func init() {
+	constructors["jwt"] = typeSpec{
+		constructor: func(conf Config, logger log.Modular, stats metrics.Type) (Authenticator, error) {
+			return NewJWT(conf.JWT, logger)
+		},
+		description: `
+Verifies stateless JSON Web Tokens signed with HS256, RS256 or ES256, granting
+access to the document patterns listed within a claim, and identifying users by
+the subject of the token.`,
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// Errors for the JWT type.
+var (
+	ErrNoVerificationKeys = errors.New("no keys were configured for verifying tokens")
+	ErrNoMatchingKey      = errors.New("no configured key matches the token")
+	ErrTokenExpired       = errors.New("token has expired")
+	ErrTokenNotYetValid   = errors.New("token is not valid yet")
+	ErrTokenIssuer        = errors.New("token issuer does not match")
+	ErrTokenAudience      = errors.New("token audience does not match")
+	ErrInvalidJWK         = errors.New("invalid key within JWKS file")
+)
+
+// JWTConfig - A config object for the JWT acl object.
+type JWTConfig struct {
+	Algorithms     []string `json:"algorithms" yaml:"algorithms"`
+	HMACSecretFile string   `json:"hmac_secret_file" yaml:"hmac_secret_file"`
+	PublicKeyFiles []string `json:"public_key_files" yaml:"public_key_files"`
+	JWKSFile       string   `json:"jwks_file" yaml:"jwks_file"`
+	Issuer         string   `json:"issuer" yaml:"issuer"`
+	Audience       string   `json:"audience" yaml:"audience"`
+	RequireExpiry  bool     `json:"require_expiry" yaml:"require_expiry"`
+	LeewayS        int64    `json:"leeway_s" yaml:"leeway_s"`
+	UsernameClaim  string   `json:"username_claim" yaml:"username_claim"`
+	MetadataClaim  string   `json:"metadata_claim" yaml:"metadata_claim"`
+	GrantsClaim    string   `json:"grants_claim" yaml:"grants_claim"`
+	RefreshPeriod  int64    `json:"refresh_period_s" yaml:"refresh_period_s"`
+}
+
+// NewJWTConfig - Returns a default config object for a JWT object.
+func NewJWTConfig() JWTConfig {
+	return JWTConfig{
+		Algorithms:     []string{"HS256", "RS256", "ES256"},
+		HMACSecretFile: "",
+		PublicKeyFiles: []string{},
+		JWKSFile:       "",
+		Issuer:         "",
+		Audience:       "",
+		RequireExpiry:  true,
+		LeewayS:        30,
+		UsernameClaim:  "sub",
+		MetadataClaim:  "user_metadata",
+		GrantsClaim:    "leaps_access",
+		RefreshPeriod:  300,
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// jwtKey - A verification key along with the key ID it was published under, if any.
+type jwtKey struct {
+	id  string
+	key interface{}
+}
+
+// matches - Returns whether the key is suitable for verifying a token signed with a method.
+func (k jwtKey) matches(method jwt.SigningMethod) bool {
+	switch method.(type) {
+	case *jwt.SigningMethodHMAC:
+		_, ok := k.key.([]byte)
+		return ok
+	case *jwt.SigningMethodRSA:
+		_, ok := k.key.(*rsa.PublicKey)
+		return ok
+	case *jwt.SigningMethodECDSA:
+		_, ok := k.key.(*ecdsa.PublicKey)
+		return ok
+	}
+	return false
+}
+
+/*
+JWT - An Authenticator type that verifies stateless JSON Web Tokens signed by a trusted service.
+
+Tokens may be signed with HS256, RS256 or ES256. Verification keys are read from a file containing a
+shared HMAC secret, PEM encoded public key files (the file name without its extension is used as the
+key ID) and/or a JWKS file. Key files are re-read periodically so that keys can be rotated without a
+restart. When a token header contains a `kid` only keys with that ID are tried.
+
+A token must not be expired (`exp`) or used before it becomes valid (`nbf`), with a configurable
+leeway for clock skew. The access granted by a token is listed within its grants claim, which maps
+document ID patterns to access levels:
+
+{ "sub": "<username>", "exp": 1500000000, "leaps_access": { "<pattern>": "<access_level>" } }
+
+A pattern is either an exact document ID, a prefix followed by `*` (`docs/*` matches any document
+ID starting with `docs/`), or `*` alone, which matches all documents. When several patterns match
+a document the highest access level is granted. The options for <access_level> are `CREATE`, `EDIT`
+and `READ`, and a new document may only be created when a `CREATE` grant is the highest access
+level granted by the patterns that match its ID.
+
+The user metadata of a token is taken from its metadata claim when present, otherwise from its
+username claim, and must match the user metadata provided to Authenticate.
+*/
+type JWT struct {
+	logger log.Modular
+	config JWTConfig
+	parser *jwt.Parser
+
+	keys  []jwtKey
+	mutex *sync.RWMutex
+}
+
+// NewJWT - Creates a JWT using the provided configuration.
+func NewJWT(config JWTConfig, logger log.Modular) (*JWT, error) {
+	j := JWT{
+		logger: logger.NewModule(":jwt_auth"),
+		config: config,
+		parser: &jwt.Parser{
+			ValidMethods:         config.Algorithms,
+			UseJSONNumber:        true,
+			SkipClaimsValidation: true,
+		},
+		mutex: &sync.RWMutex{},
+	}
+	if err := j.Reload(); err != nil {
+		return nil, err
+	}
+	if config.RefreshPeriod > 0 {
+		go j.loop()
+	}
+	return &j, nil
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// Reload - Reads all configured key files, replacing the keys currently used for verification.
+func (j *JWT) Reload() error {
+	keys := []jwtKey{}
+	if len(j.config.HMACSecretFile) > 0 {
+		secret, err := ioutil.ReadFile(j.config.HMACSecretFile)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, jwtKey{key: []byte(strings.TrimSpace(string(secret)))})
+	}
+	for _, path := range j.config.PublicKeyFiles {
+		key, err := readPublicKeyFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read key file %v: %v", path, err)
+		}
+		keys = append(keys, key)
+	}
+	if len(j.config.JWKSFile) > 0 {
+		jwks, err := readJWKSFile(j.config.JWKSFile)
+		if err != nil {
+			return fmt.Errorf("failed to read JWKS file %v: %v", j.config.JWKSFile, err)
+		}
+		keys = append(keys, jwks...)
+	}
+	if len(keys) == 0 {
+		return ErrNoVerificationKeys
+	}
+
+	j.mutex.Lock()
+	j.keys = keys
+	j.mutex.Unlock()
+	return nil
+}
+
+func (j *JWT) loop() {
+	for {
+		time.Sleep(time.Duration(j.config.RefreshPeriod) * time.Second)
+		if err := j.Reload(); err != nil {
+			j.logger.Errorf("Failed to reload token verification keys: %v\n", err)
+		}
+	}
+}
+
+// readPublicKeyFile - Reads a PEM encoded RSA or ECDSA public key (or certificate).
+func readPublicKeyFile(path string) (jwtKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return jwtKey{}, err
+	}
+	id := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	if key, err := jwt.ParseRSAPublicKeyFromPEM(data); err == nil {
+		return jwtKey{id: id, key: key}, nil
+	}
+	key, err := jwt.ParseECPublicKeyFromPEM(data)
+	if err != nil {
+		return jwtKey{}, err
+	}
+	return jwtKey{id: id, key: key}, nil
+}
+
+// jsonWebKey - The fields of a JSON Web Key that are used for verifying signatures.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// readJWKSFile - Reads the signature verification keys of a JSON Web Key Set.
+func readJWKSFile(path string) ([]jwtKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err = json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	keys := []jwtKey{}
+	for _, jwk := range set.Keys {
+		if len(jwk.Use) > 0 && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %v: %v", jwk.Kid, err)
+		}
+		keys = append(keys, jwtKey{id: jwk.Kid, key: key})
+	}
+	return keys, nil
+}
+
+// publicKey - Converts the JWK into a key suitable for verifying signatures.
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	decode := func(s string) ([]byte, error) {
+		return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	}
+	switch k.Kty {
+	case "oct":
+		return decode(k.K)
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			return nil, ErrInvalidJWK
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, ErrInvalidJWK
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, ErrInvalidJWK
+		}
+		return key, nil
+	}
+	return nil, ErrInvalidJWK
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// candidateKeys - Returns the keys that could have been used to sign a token.
+func (j *JWT) candidateKeys(token *jwt.Token) []jwtKey {
+	kid, _ := token.Header["kid"].(string)
+
+	j.mutex.RLock()
+	defer j.mutex.RUnlock()
+
+	candidates := []jwtKey{}
+	for _, k := range j.keys {
+		if len(kid) > 0 && len(k.id) > 0 && k.id != kid {
+			continue
+		}
+		if k.matches(token.Method) {
+			candidates = append(candidates, k)
+		}
+	}
+	return candidates
+}
+
+// Verify - Verifies the signature and time window of a token and returns its claims.
+func (j *JWT) Verify(tokenString string) (jwt.MapClaims, error) {
+	unverified, _, err := j.parser.ParseUnverified(tokenString, jwt.MapClaims{})
+	if err != nil {
+		return nil, err
+	}
+	candidates := j.candidateKeys(unverified)
+	if len(candidates) == 0 {
+		return nil, ErrNoMatchingKey
+	}
+
+	var claims jwt.MapClaims
+	for _, candidate := range candidates {
+		claims = jwt.MapClaims{}
+		key := candidate.key
+		if _, err = j.parser.ParseWithClaims(tokenString, claims, func(*jwt.Token) (interface{}, error) {
+			return key, nil
+		}); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	leeway := j.config.LeewayS
+	if !claims.VerifyExpiresAt(now-leeway, j.config.RequireExpiry) {
+		return nil, ErrTokenExpired
+	}
+	if !claims.VerifyNotBefore(now+leeway, false) {
+		return nil, ErrTokenNotYetValid
+	}
+	if len(j.config.Issuer) > 0 && !claims.VerifyIssuer(j.config.Issuer, true) {
+		return nil, ErrTokenIssuer
+	}
+	if len(j.config.Audience) > 0 && !audienceContains(claims["aud"], j.config.Audience) {
+		return nil, ErrTokenAudience
+	}
+	return claims, nil
+}
+
+// audienceContains - Checks an audience claim, which may be a string or a list of strings.
+func audienceContains(aud interface{}, audience string) bool {
+	switch t := aud.(type) {
+	case string:
+		return t == audience
+	case []interface{}:
+		for _, a := range t {
+			if s, ok := a.(string); ok && s == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// userMetadata - Returns the user metadata described by the claims of a token.
+func (j *JWT) userMetadata(claims jwt.MapClaims) interface{} {
+	if metadata, exists := claims[j.config.MetadataClaim]; exists && len(j.config.MetadataClaim) > 0 {
+		return normaliseClaim(metadata)
+	}
+	username, _ := claims[j.config.UsernameClaim].(string)
+	return username
+}
+
+// normaliseClaim - Converts the json.Number values of a parsed claim back into float64 values so
+// that the claim can be compared with user metadata parsed by the standard library.
+func normaliseClaim(claim interface{}) interface{} {
+	switch t := claim.(type) {
+	case json.Number:
+		f, _ := t.Float64()
+		return f
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			m[k] = normaliseClaim(v)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(t))
+		for i, v := range t {
+			s[i] = normaliseClaim(v)
+		}
+		return s
+	}
+	return claim
+}
+
+// grantMatches - Returns whether a grant pattern matches a document ID.
+func grantMatches(pattern, documentID string) bool {
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(documentID, strings.TrimSuffix(pattern, "*"))
+	}
+	return pattern == documentID
+}
+
+// accessLevel - Returns the highest access level granted by a set of claims for a document ID, or
+// whether any CREATE access is granted when the document ID is blank.
+func (j *JWT) accessLevel(claims jwt.MapClaims, documentID string) AccessLevel {
+	grants, _ := claims[j.config.GrantsClaim].(map[string]interface{})
+
+	accessLevel := NoAccess
+	for pattern, value := range grants {
+		levelStr, _ := value.(string)
+		level := parseAccessLevel(levelStr)
+		if len(documentID) == 0 {
+			if level == CreateAccess {
+				return CreateAccess
+			}
+			continue
+		}
+		if level > accessLevel && grantMatches(pattern, documentID) {
+			accessLevel = level
+		}
+	}
+	return accessLevel
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// Identify - Verifies a token and returns the username within its claims.
+func (j *JWT) Identify(token string) (string, error) {
+	claims, err := j.Verify(token)
+	if err != nil {
+		return "", err
+	}
+	username, _ := claims[j.config.UsernameClaim].(string)
+	if len(username) == 0 {
+		username = userIDFromMetadata(j.userMetadata(claims))
+	}
+	if len(username) == 0 {
+		return "", ErrNoUsername
+	}
+	return username, nil
+}
+
+// userClaims - Verifies a token and returns its claims if they belong to the provided user.
+func (j *JWT) userClaims(userMetadata interface{}, token string) (jwt.MapClaims, bool) {
+	claims, err := j.Verify(token)
+	if err != nil {
+		j.logger.Warnf("Rejected token: %v\n", err)
+		return nil, false
+	}
+
+	tokenMetadata := j.userMetadata(claims)
+	tokenUser := userIDFromMetadata(tokenMetadata)
+	if !reflect.DeepEqual(tokenMetadata, userMetadata) &&
+		(len(tokenUser) == 0 || tokenUser != userIDFromMetadata(userMetadata)) {
+		j.logger.Warnf(
+			"Incorrect user ID provided to authenticator, token user: `%v`, provided userMetadata: `%v`\n",
+			tokenUser, userMetadata,
+		)
+		return nil, false
+	}
+	return claims, true
+}
+
+// Authenticate - Verifies a token and returns the access level it grants for a document.
+func (j *JWT) Authenticate(userMetadata interface{}, token, documentID string) AccessLevel {
+	claims, ok := j.userClaims(userMetadata, token)
+	if !ok {
+		return NoAccess
+	}
+	return j.accessLevel(claims, documentID)
+}
+
+// AuthenticateCreate - Verifies a token and returns CreateAccess when the highest grant matching a
+// new document ID is CREATE.
+func (j *JWT) AuthenticateCreate(userMetadata interface{}, token, documentID string) AccessLevel {
+	claims, ok := j.userClaims(userMetadata, token)
+	if !ok {
+		return NoAccess
+	}
+	if len(documentID) == 0 {
+		return j.accessLevel(claims, "")
+	}
+	if level := j.accessLevel(claims, documentID); level != CreateAccess {
+		j.logger.Debugf("Denied CREATE for `%v`: highest grant is %v\n", documentID, level)
+		return NoAccess
+	}
+	return CreateAccess
+}
+
+//--------------------------------------------------------------------------------------------------
@@ -0,0 +1,265 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, sub to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package acl
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+//--------------------------------------------------------------------------------------------------
+
+func writeTestFile(t *testing.T, path string, data []byte) {
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func signTestToken(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	if len(kid) > 0 {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestJWTAuthenticate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_jwt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	secret := []byte("shared secret")
+	writeTestFile(t, filepath.Join(dir, "secret"), append(secret, '\n'))
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPub, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(dir, "rsa-key.pem"), pem.EncodeToMemory(&pem.Block{
+		Type: "PUBLIC KEY", Bytes: rsaPub,
+	}))
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "EC",
+			"kid": "ec-key",
+			"use": "sig",
+			"crv": "P-256",
+			"x":   base64.RawURLEncoding.EncodeToString(ecKey.X.Bytes()),
+			"y":   base64.RawURLEncoding.EncodeToString(ecKey.Y.Bytes()),
+		}},
+	})
+	writeTestFile(t, filepath.Join(dir, "jwks.json"), jwks)
+
+	conf := NewJWTConfig()
+	conf.HMACSecretFile = filepath.Join(dir, "secret")
+	conf.PublicKeyFiles = []string{filepath.Join(dir, "rsa-key.pem")}
+	conf.JWKSFile = filepath.Join(dir, "jwks.json")
+	conf.Audience = "leaps"
+	conf.RefreshPeriod = 0
+
+	auth, err := NewJWT(conf, logger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().Unix()
+	claims := func(extra jwt.MapClaims) jwt.MapClaims {
+		c := jwt.MapClaims{
+			"sub": "alice",
+			"aud": []string{"leaps", "other"},
+			"exp": now + 60,
+			"leaps_access": map[string]string{
+				"docs/*":    "EDIT",
+				"docs/spec": "READ",
+				"notes.md":  "READ",
+			},
+		}
+		for k, v := range extra {
+			c[k] = v
+		}
+		return c
+	}
+
+	hsToken := signTestToken(t, jwt.SigningMethodHS256, "", secret, claims(nil))
+	rsToken := signTestToken(t, jwt.SigningMethodRS256, "rsa-key", rsaKey, claims(nil))
+	esToken := signTestToken(t, jwt.SigningMethodES256, "ec-key", ecKey, claims(jwt.MapClaims{
+		"leaps_access": map[string]string{"*": "CREATE"},
+	}))
+
+	testCases := []struct {
+		name     string
+		user     interface{}
+		token    string
+		document string
+		expected AccessLevel
+	}{
+		{"hs256 prefix grant", "alice", hsToken, "docs/readme", EditAccess},
+		{"hs256 highest grant wins", "alice", hsToken, "docs/spec", EditAccess},
+		{"hs256 exact grant", "alice", hsToken, "notes.md", ReadAccess},
+		{"hs256 no grant", "alice", hsToken, "other.md", NoAccess},
+		{"hs256 no create", "alice", hsToken, "", NoAccess},
+		{"hs256 user metadata map", map[string]interface{}{"username": "alice"}, hsToken, "notes.md", ReadAccess},
+		{"hs256 wrong user", "bob", hsToken, "notes.md", NoAccess},
+		{"rs256 pem key", "alice", rsToken, "docs/readme", EditAccess},
+		{"es256 jwks wildcard", "alice", esToken, "anything", CreateAccess},
+		{"es256 jwks create", "alice", esToken, "", CreateAccess},
+		{"expired", "alice", signTestToken(t, jwt.SigningMethodHS256, "", secret, claims(jwt.MapClaims{
+			"exp": now - 120,
+		})), "notes.md", NoAccess},
+		{"expired within leeway", "alice", signTestToken(t, jwt.SigningMethodHS256, "", secret, claims(jwt.MapClaims{
+			"exp": now - 5,
+		})), "notes.md", ReadAccess},
+		{"not yet valid", "alice", signTestToken(t, jwt.SigningMethodHS256, "", secret, claims(jwt.MapClaims{
+			"nbf": now + 120,
+		})), "notes.md", NoAccess},
+		{"missing expiry", "alice", signTestToken(t, jwt.SigningMethodHS256, "", secret, jwt.MapClaims{
+			"sub": "alice", "aud": "leaps", "leaps_access": map[string]string{"*": "READ"},
+		}), "notes.md", NoAccess},
+		{"wrong audience", "alice", signTestToken(t, jwt.SigningMethodHS256, "", secret, claims(jwt.MapClaims{
+			"aud": "someone else",
+		})), "notes.md", NoAccess},
+		{"wrong secret", "alice", signTestToken(t, jwt.SigningMethodHS256, "", []byte("nope"), claims(nil)), "notes.md", NoAccess},
+		{"unknown kid", "alice", signTestToken(t, jwt.SigningMethodES256, "missing", ecKey, claims(nil)), "notes.md", NoAccess},
+		{"disallowed algorithm", "alice", signTestToken(t, jwt.SigningMethodHS512, "", secret, claims(nil)), "notes.md", NoAccess},
+		{"garbage", "alice", "not.a.token", "notes.md", NoAccess},
+	}
+
+	for _, test := range testCases {
+		if actual := auth.Authenticate(test.user, test.token, test.document); actual != test.expected {
+			t.Errorf("%v: wrong access level: %v != %v", test.name, actual, test.expected)
+		}
+	}
+
+	createToken := signTestToken(t, jwt.SigningMethodHS256, "", secret, claims(jwt.MapClaims{
+		"leaps_access": map[string]string{
+			"docs/*":   "EDIT",
+			"drafts/*": "CREATE",
+		},
+	}))
+	createCases := []struct {
+		name     string
+		user     interface{}
+		token    string
+		document string
+		expected AccessLevel
+	}{
+		{"create within pattern", "alice", createToken, "drafts/new.md", CreateAccess},
+		{"create outside pattern", "alice", createToken, "docs/new.md", NoAccess},
+		{"create unmatched", "alice", createToken, "new.md", NoAccess},
+		{"create any", "alice", createToken, "", CreateAccess},
+		{"create wrong user", "bob", createToken, "drafts/new.md", NoAccess},
+		{"create without grant", "alice", hsToken, "docs/new.md", NoAccess},
+		{"create wildcard", "alice", esToken, "anything", CreateAccess},
+	}
+	for _, test := range createCases {
+		if actual := AuthenticateCreate(auth, test.user, test.token, test.document); actual != test.expected {
+			t.Errorf("%v: wrong create access level: %v != %v", test.name, actual, test.expected)
+		}
+	}
+
+	if user, err := auth.Identify(rsToken); err != nil {
+		t.Error(err)
+	} else if user != "alice" {
+		t.Errorf("Wrong identity: %v != alice", user)
+	}
+	if _, err := auth.Identify(signTestToken(t, jwt.SigningMethodHS256, "", []byte("nope"), claims(nil))); err == nil {
+		t.Error("Expected error from identifying a forged token")
+	}
+}
+
+func TestJWTUserMetadataClaim(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_jwt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	secret := []byte("shared secret")
+	writeTestFile(t, filepath.Join(dir, "secret"), secret)
+
+	conf := NewJWTConfig()
+	conf.HMACSecretFile = filepath.Join(dir, "secret")
+	conf.RefreshPeriod = 0
+
+	auth, err := NewJWT(conf, logger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := signTestToken(t, jwt.SigningMethodHS256, "", secret, jwt.MapClaims{
+		"exp":           time.Now().Unix() + 60,
+		"user_metadata": map[string]interface{}{"username": "bob", "colour": "red", "age": 30},
+		"leaps_access":  map[string]string{"doc": "EDIT"},
+	})
+
+	if user, err := auth.Identify(token); err != nil {
+		t.Error(err)
+	} else if user != "bob" {
+		t.Errorf("Wrong identity: %v != bob", user)
+	}
+
+	var metadata interface{}
+	if err = json.Unmarshal([]byte(`{"username":"bob","colour":"red","age":30}`), &metadata); err != nil {
+		t.Fatal(err)
+	}
+	if actual := auth.Authenticate(metadata, token, "doc"); actual != EditAccess {
+		t.Errorf("Wrong access level: %v != %v", actual, EditAccess)
+	}
+	if actual := auth.Authenticate("alice", token, "doc"); actual != NoAccess {
+		t.Errorf("Wrong access level: %v != %v", actual, NoAccess)
+	}
+
+	if _, err = NewJWT(NewJWTConfig(), logger()); err != ErrNoVerificationKeys {
+		t.Errorf("Wrong error without keys: %v != %v", err, ErrNoVerificationKeys)
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
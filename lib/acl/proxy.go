@@ -0,0 +1,174 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, sub to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package acl
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+//--------------------------------------------------------------------------------------------------
+
+// Errors for the TrustedProxy type.
+var (
+	ErrNoTrustedSources = errors.New("no trusted proxy source CIDRs were provided")
+	ErrUntrustedSource  = errors.New("request did not originate from a trusted proxy")
+	ErrNoProxyUser      = errors.New("request did not contain a proxy user header")
+)
+
+// TrustedProxyConfig - A config object for the TrustedProxy object.
+type TrustedProxyConfig struct {
+	SourceCIDRs     []string `json:"source_cidrs" yaml:"source_cidrs"`
+	UserHeader      string   `json:"user_header" yaml:"user_header"`
+	GroupsHeader    string   `json:"groups_header" yaml:"groups_header"`
+	GroupsSeparator string   `json:"groups_separator" yaml:"groups_separator"`
+}
+
+// NewTrustedProxyConfig - Returns a default config object for a TrustedProxy object.
+func NewTrustedProxyConfig() TrustedProxyConfig {
+	return TrustedProxyConfig{
+		SourceCIDRs:     []string{},
+		UserHeader:      "X-Forwarded-User",
+		GroupsHeader:    "X-Forwarded-Groups",
+		GroupsSeparator: ",",
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
+
+/*
+TrustedProxy - Extracts the identity of a user from the headers of HTTP requests that have been sent
+by an authenticating reverse proxy. Headers are only trusted when the request originates from an
+address within the configured source CIDRs, a bare IP address is treated as a single host.
+
+The groups header is optional, and contains the group names of the user separated by the configured
+separator. The resulting ProxyIdentity can be given to authenticators as user metadata.
+*/
+type TrustedProxy struct {
+	config  TrustedProxyConfig
+	sources []*net.IPNet
+}
+
+// NewTrustedProxy - Creates a TrustedProxy object using the provided configuration.
+func NewTrustedProxy(config TrustedProxyConfig) (*TrustedProxy, error) {
+	if len(config.SourceCIDRs) == 0 {
+		return nil, ErrNoTrustedSources
+	}
+	sources := []*net.IPNet{}
+	for _, cidr := range config.SourceCIDRs {
+		cidr = strings.TrimSpace(cidr)
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy source: %v", cidr)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			cidr = fmt.Sprintf("%v/%v", cidr, bits)
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy source: %v", err)
+		}
+		sources = append(sources, ipNet)
+	}
+	return &TrustedProxy{
+		config:  config,
+		sources: sources,
+	}, nil
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// ProxyIdentity - The identity of a user as asserted by a trusted proxy, implements UserIdentity and
+// UserGroups.
+type ProxyIdentity struct {
+	Username string
+	Groups   []string
+}
+
+// UserID - Returns the username asserted by the proxy.
+func (p ProxyIdentity) UserID() string {
+	return p.Username
+}
+
+// UserGroups - Returns the groups asserted by the proxy.
+func (p ProxyIdentity) UserGroups() []string {
+	return p.Groups
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// Trusts - Returns whether a remote address, in the form given by http.Request.RemoteAddr, lies
+// within the trusted source CIDRs.
+func (t *TrustedProxy) Trusts(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, source := range t.sources {
+		if source.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Identify - Returns the identity asserted by the headers of a request, or an error if the request
+// did not come from a trusted source or does not carry a username.
+func (t *TrustedProxy) Identify(r *http.Request) (ProxyIdentity, error) {
+	if !t.Trusts(r.RemoteAddr) {
+		return ProxyIdentity{}, ErrUntrustedSource
+	}
+	identity := ProxyIdentity{
+		Username: strings.TrimSpace(r.Header.Get(t.config.UserHeader)),
+	}
+	if len(identity.Username) == 0 {
+		return ProxyIdentity{}, ErrNoProxyUser
+	}
+	separator := t.config.GroupsSeparator
+	if len(separator) == 0 {
+		separator = ","
+	}
+	if len(t.config.GroupsHeader) > 0 {
+		for _, header := range r.Header[http.CanonicalHeaderKey(t.config.GroupsHeader)] {
+			for _, group := range strings.Split(header, separator) {
+				if group = strings.TrimSpace(group); len(group) > 0 {
+					identity.Groups = append(identity.Groups, group)
+				}
+			}
+		}
+	}
+	return identity, nil
+}
+
+//--------------------------------------------------------------------------------------------------
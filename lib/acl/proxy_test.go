@@ -0,0 +1,91 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, sub to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package acl
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+//--------------------------------------------------------------------------------------------------
+
+func TestTrustedProxyIdentify(t *testing.T) {
+	conf := NewTrustedProxyConfig()
+	conf.SourceCIDRs = []string{"10.0.0.0/8", " 192.168.1.5", "::1"}
+
+	proxy, err := NewTrustedProxy(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		remoteAddr string
+		user       string
+		groups     []string
+		expected   ProxyIdentity
+		err        error
+	}{
+		{"10.1.2.3:4000", "alice", []string{"editors, admins", "viewers"},
+			ProxyIdentity{Username: "alice", Groups: []string{"editors", "admins", "viewers"}}, nil},
+		{"192.168.1.5:4000", "bob", nil, ProxyIdentity{Username: "bob"}, nil},
+		{"[::1]:4000", "carol", []string{" ,"}, ProxyIdentity{Username: "carol"}, nil},
+		{"192.168.1.6:4000", "alice", nil, ProxyIdentity{}, ErrUntrustedSource},
+		{"11.0.0.1:4000", "alice", nil, ProxyIdentity{}, ErrUntrustedSource},
+		{"10.1.2.3:4000", "", []string{"editors"}, ProxyIdentity{}, ErrNoProxyUser},
+	}
+	for _, test := range testCases {
+		r := httptest.NewRequest("GET", "/leaps/ws", nil)
+		r.RemoteAddr = test.remoteAddr
+		if len(test.user) > 0 {
+			r.Header.Set("X-Forwarded-User", test.user)
+		}
+		for _, g := range test.groups {
+			r.Header.Add("X-Forwarded-Groups", g)
+		}
+		identity, err := proxy.Identify(r)
+		if err != test.err {
+			t.Errorf("%v: wrong error: %v != %v", test.remoteAddr, err, test.err)
+		}
+		if !reflect.DeepEqual(identity, test.expected) {
+			t.Errorf("%v: wrong identity: %+v != %+v", test.remoteAddr, identity, test.expected)
+		}
+	}
+}
+
+func TestTrustedProxyConfigErrors(t *testing.T) {
+	if _, err := NewTrustedProxy(NewTrustedProxyConfig()); err != ErrNoTrustedSources {
+		t.Errorf("Wrong error for missing sources: %v", err)
+	}
+	conf := NewTrustedProxyConfig()
+	conf.SourceCIDRs = []string{"10.0.0.0/33"}
+	if _, err := NewTrustedProxy(conf); err == nil {
+		t.Error("Expected error from invalid CIDR")
+	}
+	conf.SourceCIDRs = []string{"not an ip"}
+	if _, err := NewTrustedProxy(conf); err == nil {
+		t.Error("Expected error from invalid IP")
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
@@ -30,10 +30,24 @@ import (
 
 	"github.com/garyburd/redigo/redis"
 	"github.com/Jeffail/leaps/lib/util/service/log"
+	"github.com/Jeffail/leaps/lib/util/service/metrics"
 )
 
 //--------------------------------------------------------------------------------------------------
 
+func init() {
+	constructors["redis"] = typeSpec{
+		constructor: func(conf Config, logger log.Modular, stats metrics.Type) (Authenticator, error) {
+			return NewRedis(conf.Redis, logger), nil
+		},
+		description: `
+Grants access according to one-use tokens that a service writes to Redis, which
+may also be used to identify users.`,
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
+
 // RedisConfig - A config object for the redis authentication object.
 type RedisConfig struct {
 	URL          string `json:"url" yaml:"url"`
@@ -82,7 +96,8 @@ func newPool(config RedisConfig) *redis.Pool {
 
 // Errors for the Redis type.
 var (
-	ErrNoKey = errors.New("key did not exist")
+	ErrNoKey      = errors.New("key did not exist")
+	ErrNoUsername = errors.New("token user metadata did not contain a username")
 )
 
 /*
@@ -96,17 +111,20 @@ The key should be the unique, one-use access token that is also shared with the
 JSON blob that details the credentials and access level of the authentication:
 
 Key:   <token>
-Value: { "access_level":"<access_level>", "user_id":"<user_id>", "document_id":"<document_id>" }
+Value: { "access_level":"<access_level>", "user_metadata":<user_metadata>, "document_id":"<document_id>" }
 
-<token>        The shared token that the user is also given and subsequently provides to leaps.
-<access_level> The access level that your service wishes to grant the user.
-<user_id>      The id of the authenticated user.
-<document_id>  The id of the document, omit or leave this blank if you are granting CREATE access.
+<token>         The shared token that the user is also given and subsequently provides to leaps.
+<access_level>  The access level that your service wishes to grant the user.
+<user_metadata> The metadata of the authenticated user.
+<document_id>   The id of the document, omit or leave this blank if you are granting CREATE access.
 
 The options for <access_level> are `CREATE`, `EDIT` and `READ`.
 
 Once leaps has read and verified the auth token it will delete the key. Key/value pairs should have
 a TTL such that they will expire if not used.
+
+Redis can also be used to identify users by their token, in which case <user_metadata> should either
+be the username itself or an object containing a `username` field.
 */
 type Redis struct {
 	logger log.Modular
@@ -125,6 +143,31 @@ func NewRedis(config RedisConfig, logger log.Modular) Authenticator {
 
 //--------------------------------------------------------------------------------------------------
 
+// redisCredentials - The structure of a token value stored in redis.
+type redisCredentials struct {
+	AccessLevel  string      `json:"access_level"`
+	UserMetadata interface{} `json:"user_metadata"`
+	DocumentID   string      `json:"document_id"`
+}
+
+// Identify - Reads a key (token) from redis and returns the username within its user metadata. The
+// key is not deleted.
+func (s *Redis) Identify(token string) (string, error) {
+	value, err := s.ReadKey(token)
+	if err != nil {
+		return "", err
+	}
+	var credentials redisCredentials
+	if err = json.Unmarshal([]byte(value), &credentials); err != nil {
+		return "", err
+	}
+	username := userIDFromMetadata(credentials.UserMetadata)
+	if len(username) == 0 {
+		return "", ErrNoUsername
+	}
+	return username, nil
+}
+
 // Authenticate - Reads a key (token) from redis and parses the value to check for an access level.
 func (s *Redis) Authenticate(userMetadata interface{}, token, documentID string) AccessLevel {
 	value, err := s.ReadKey(token)
@@ -133,12 +176,7 @@ func (s *Redis) Authenticate(userMetadata interface{}, token, documentID string)
 		return NoAccess
 	}
 
-	credentials := struct {
-		AccessLevel  string      `json:"access_level"`
-		UserMetadata interface{} `json:"user_metadata"`
-		DocumentID   string      `json:"document_id"`
-	}{}
-
+	var credentials redisCredentials
 	if err := json.Unmarshal([]byte(value), &credentials); err != nil {
 		s.logger.Errorf("Token value `%v` could not be parsed: %v\n", value, err)
 		return NoAccess
@@ -164,7 +202,9 @@ func (s *Redis) Authenticate(userMetadata interface{}, token, documentID string)
 		return NoAccess
 	}
 
-	if !reflect.DeepEqual(credentials.UserMetadata, userMetadata) {
+	tokenUser := userIDFromMetadata(credentials.UserMetadata)
+	if !reflect.DeepEqual(credentials.UserMetadata, userMetadata) &&
+		(len(tokenUser) == 0 || tokenUser != userIDFromMetadata(userMetadata)) {
 		s.logger.Warnf(
 			"Incorrect user ID provided to authenticator, token contents: `%v`,  provided userMetadata: `%v`\n",
 			value, userMetadata,
@@ -0,0 +1,418 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, sub to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package acl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jeffail/leaps/lib/util/service/log"
+	"github.com/Jeffail/leaps/lib/util/service/metrics"
+	"gopkg.in/yaml.v2"
+)
+
+//--------------------------------------------------------------------------------------------------
+
+func init() {
+	constructors["rules"] = typeSpec{
+		constructor: func(conf Config, logger log.Modular, stats metrics.Type) (Authenticator, error) {
+			var identity Authenticator
+			if conf.Identity != nil {
+				var err error
+				if identity, err = New(*conf.Identity, logger, stats); err != nil {
+					return nil, fmt.Errorf("identity: %v", err)
+				}
+			}
+			return NewRules(conf.Rules, identity, logger)
+		},
+		description: `
+Grants access according to a YAML or JSON file of rules matching paths and users,
+which is reloaded when modified. Users are identified by the authenticator
+configured in the identity field when it is able, otherwise by their metadata.`,
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// Errors for the Rules type.
+var (
+	ErrNoRulesPath = errors.New("a path to a rules file must be provided")
+)
+
+// RulesConfig - A config object for the Rules acl object.
+type RulesConfig struct {
+	Path          string `json:"path" yaml:"path"`
+	RefreshPeriod int64  `json:"refresh_period_s" yaml:"refresh_period_s"`
+}
+
+// NewRulesConfig - Returns a default config object for a Rules object.
+func NewRulesConfig() RulesConfig {
+	return RulesConfig{
+		Path:          "",
+		RefreshPeriod: 5,
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// Rule - A single rule of a policy, granting an access level to a set of users for a set of paths.
+type Rule struct {
+	Name   string   `json:"name" yaml:"name"`
+	Paths  []string `json:"paths" yaml:"paths"`
+	Users  []string `json:"users" yaml:"users"`
+	Groups []string `json:"groups" yaml:"groups"`
+	Access string   `json:"access" yaml:"access"`
+}
+
+// Policy - The contents of a rules file.
+type Policy struct {
+	Groups  map[string][]string `json:"groups" yaml:"groups"`
+	Rules   []Rule              `json:"rules" yaml:"rules"`
+	Default string              `json:"default" yaml:"default"`
+}
+
+// ParsePolicy - Parses a policy from its JSON or YAML representation, the format is determined by
+// the extension of the file it was read from.
+func ParsePolicy(filename string, data []byte) (Policy, error) {
+	var policy Policy
+	var err error
+	switch filepath.Ext(filename) {
+	case ".js", ".json":
+		err = json.Unmarshal(data, &policy)
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(data, &policy)
+	default:
+		err = fmt.Errorf("rules file extension not recognised: %v", filename)
+	}
+	if err != nil {
+		return policy, err
+	}
+	return policy, policy.validate()
+}
+
+// validate - Checks that all access levels and path patterns of a policy are well formed.
+func (p Policy) validate() error {
+	checkLevel := func(level string) error {
+		if parseAccessLevel(level) == NoAccess && level != "NONE" {
+			return fmt.Errorf("unrecognised access level: %v", level)
+		}
+		return nil
+	}
+	if len(p.Default) > 0 {
+		if err := checkLevel(p.Default); err != nil {
+			return fmt.Errorf("default: %v", err)
+		}
+	}
+	for i, rule := range p.Rules {
+		if err := checkLevel(rule.Access); err != nil {
+			return fmt.Errorf("rule %v: %v", rule.describe(i), err)
+		}
+		for _, pattern := range rule.Paths {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("rule %v: pattern %v: %v", rule.describe(i), pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// describe - Returns a human readable reference to a rule for logs and errors.
+func (r Rule) describe(index int) string {
+	if len(r.Name) > 0 {
+		return fmt.Sprintf("#%v (%v)", index, r.Name)
+	}
+	return fmt.Sprintf("#%v", index)
+}
+
+// matchesUser - Returns whether a rule applies to a user, and the reason why. A user is a member of
+// a group either when listed by the policy or when the group is within the groups of their metadata.
+func (r Rule) matchesUser(groups map[string][]string, username string, memberOf []string) (bool, string) {
+	for _, u := range r.Users {
+		if u == "*" {
+			return true, "all users"
+		}
+		if len(username) > 0 && u == username {
+			return true, "user " + username
+		}
+	}
+	if len(username) == 0 {
+		return false, ""
+	}
+	for _, g := range r.Groups {
+		for _, u := range groups[g] {
+			if u == username {
+				return true, "member of group " + g
+			}
+		}
+		for _, m := range memberOf {
+			if m == g {
+				return true, "member of asserted group " + g
+			}
+		}
+	}
+	return false, ""
+}
+
+// matchesPath - Returns the first path pattern of a rule that matches a document ID.
+func (r Rule) matchesPath(documentID string) (bool, string) {
+	for _, pattern := range r.Paths {
+		if matchGlob(pattern, documentID) {
+			return true, pattern
+		}
+	}
+	return false, ""
+}
+
+/*
+matchGlob - Matches a slash separated path against a glob pattern. Each segment of the pattern is
+matched with path.Match, and a `**` segment matches zero or more segments of the path.
+*/
+func matchGlob(pattern, documentID string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(path.Clean(documentID), "/"))
+}
+
+func matchSegments(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := len(segments); i >= 0; i-- {
+				if matchSegments(pattern[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segments) == 0 {
+			return false
+		}
+		if matched, _ := path.Match(pattern[0], segments[0]); !matched {
+			return false
+		}
+		pattern, segments = pattern[1:], segments[1:]
+	}
+	return len(segments) == 0
+}
+
+//--------------------------------------------------------------------------------------------------
+
+/*
+Rules - An acl authenticator type that grants access levels according to a policy file of rules,
+written in either YAML or JSON. The file is checked for changes periodically and reloaded, if a
+modified file fails to parse then the previous policy remains in use.
+
+A policy looks like this:
+
+	groups:
+	  editors: [ alice, bob ]
+	default: NONE
+	rules:
+	  - name: drafts are private
+	    paths: [ "drafts/**" ]
+	    users: [ alice ]
+	    access: EDIT
+	  - paths: [ "drafts/**" ]
+	    users: [ "*" ]
+	    access: NONE
+	  - paths: [ "docs/**", "*.md" ]
+	    groups: [ editors ]
+	    access: CREATE
+	  - paths: [ "**" ]
+	    users: [ "*" ]
+	    access: READ
+
+Rules are evaluated in order and the first rule that matches both the user and the document ID
+decides the access level, when no rule matches the default level (NONE unless specified) is used.
+Path patterns are globs where `**` matches any number of directories. The user `*` matches every
+user, including anonymous ones. When checking for CreateAccess of a new document ID the rule that
+decides access to that ID must grant CREATE (see CreateAuthenticator). When the ID of the new document
+is not known CreateAccess is granted if any rule matching the user grants CREATE.
+
+The username of a user is taken from their user metadata, unless the Rules object wraps an
+authenticator that implements Identifier, in which case the identity resolved from the token is
+used instead. Groups may also be asserted by the user metadata (see UserGroups), such as the groups
+given by a trusted reverse proxy, and a rule may refer to these groups without the policy listing
+them. Decisions are explained within debug logs.
+*/
+type Rules struct {
+	logger   log.Modular
+	config   RulesConfig
+	identity Authenticator
+
+	policy  Policy
+	modTime time.Time
+	mutex   *sync.RWMutex
+}
+
+// NewRules - Creates a Rules object using the provided configuration. The identity authenticator is
+// optional and, when it implements Identifier, is used to resolve the identity of tokens.
+func NewRules(config RulesConfig, identity Authenticator, logger log.Modular) (*Rules, error) {
+	if len(config.Path) == 0 {
+		return nil, ErrNoRulesPath
+	}
+	r := Rules{
+		logger:   logger.NewModule(":rules_auth"),
+		config:   config,
+		identity: identity,
+		mutex:    &sync.RWMutex{},
+	}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	if config.RefreshPeriod > 0 {
+		go r.loop()
+	}
+	return &r, nil
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// Reload - Reads the rules file if it has been modified since it was last read, returns true if the
+// policy was replaced.
+func (r *Rules) Reload() (bool, error) {
+	info, err := os.Stat(r.config.Path)
+	if err != nil {
+		return false, err
+	}
+
+	r.mutex.RLock()
+	unchanged := info.ModTime().Equal(r.modTime)
+	r.mutex.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	data, err := ioutil.ReadFile(r.config.Path)
+	if err != nil {
+		return false, err
+	}
+	policy, err := ParsePolicy(r.config.Path, data)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse rules file %v: %v", r.config.Path, err)
+	}
+
+	r.mutex.Lock()
+	r.policy = policy
+	r.modTime = info.ModTime()
+	r.mutex.Unlock()
+	return true, nil
+}
+
+func (r *Rules) loop() {
+	for {
+		time.Sleep(time.Duration(r.config.RefreshPeriod) * time.Second)
+		if reloaded, err := r.Reload(); err != nil {
+			r.logger.Errorf("Failed to reload rules, the previous rules remain in use: %v\n", err)
+		} else if reloaded {
+			r.logger.Infof("Reloaded rules from %v\n", r.config.Path)
+		}
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// username - Returns the username that rules are evaluated against.
+func (r *Rules) username(userMetadata interface{}, token string) (string, error) {
+	if identifier, ok := r.identity.(Identifier); ok {
+		return identifier.Identify(token)
+	}
+	return userIDFromMetadata(userMetadata), nil
+}
+
+// Authenticate - Returns the access level granted by the first rule matching the user and document.
+func (r *Rules) Authenticate(userMetadata interface{}, token, documentID string) AccessLevel {
+	username, err := r.username(userMetadata, token)
+	if err != nil {
+		r.logger.Debugf("Denied access to `%v`: failed to identify token: %v\n", documentID, err)
+		return NoAccess
+	}
+
+	// Groups asserted by the metadata only apply when the metadata describes the same user that the
+	// rules are evaluated against.
+	var memberOf []string
+	if len(username) > 0 && userIDFromMetadata(userMetadata) == username {
+		memberOf = groupsFromMetadata(userMetadata)
+	}
+
+	r.mutex.RLock()
+	policy := r.policy
+	r.mutex.RUnlock()
+
+	if len(documentID) == 0 {
+		for i, rule := range policy.Rules {
+			if parseAccessLevel(rule.Access) != CreateAccess {
+				continue
+			}
+			if matched, reason := rule.matchesUser(policy.Groups, username, memberOf); matched {
+				r.logger.Debugf(
+					"Granted CREATE to user `%v`: rule %v applies to %v\n", username, rule.describe(i), reason,
+				)
+				return CreateAccess
+			}
+		}
+		r.logger.Debugf("Denied CREATE to user `%v`: no rule grants CREATE\n", username)
+		return NoAccess
+	}
+
+	for i, rule := range policy.Rules {
+		userMatched, reason := rule.matchesUser(policy.Groups, username, memberOf)
+		if !userMatched {
+			continue
+		}
+		if pathMatched, pattern := rule.matchesPath(documentID); pathMatched {
+			level := parseAccessLevel(rule.Access)
+			r.logger.Debugf(
+				"Granted %v to user `%v` for `%v`: rule %v matched pattern `%v` and applies to %v\n",
+				level, username, documentID, rule.describe(i), pattern, reason,
+			)
+			return level
+		}
+	}
+
+	level := parseAccessLevel(policy.Default)
+	r.logger.Debugf(
+		"Granted default %v to user `%v` for `%v`: no rule matched\n", level, username, documentID,
+	)
+	return level
+}
+
+// AuthenticateCreate - Returns CreateAccess when the rule that decides access to a new document ID,
+// or the default level when no rule matches, grants CREATE.
+func (r *Rules) AuthenticateCreate(userMetadata interface{}, token, documentID string) AccessLevel {
+	if len(documentID) == 0 {
+		return r.Authenticate(userMetadata, token, "")
+	}
+	if level := r.Authenticate(userMetadata, token, documentID); level != CreateAccess {
+		r.logger.Debugf("Denied CREATE for `%v`: deciding level is %v\n", documentID, level)
+		return NoAccess
+	}
+	return CreateAccess
+}
+
+//--------------------------------------------------------------------------------------------------
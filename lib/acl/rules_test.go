@@ -0,0 +1,221 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, sub to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package acl
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//--------------------------------------------------------------------------------------------------
+
+type tokenIdentifier map[string]string
+
+func (t tokenIdentifier) Authenticate(_ interface{}, _, _ string) AccessLevel {
+	return NoAccess
+}
+
+func (t tokenIdentifier) Identify(token string) (string, error) {
+	if user, ok := t[token]; ok {
+		return user, nil
+	}
+	return "", errors.New("unknown token")
+}
+
+const testPolicy = `
+groups:
+  editors: [ bob, carol ]
+default: NONE
+rules:
+  - name: private drafts
+    paths: [ "drafts/**" ]
+    users: [ alice ]
+    access: EDIT
+  - paths: [ "drafts/**" ]
+    users: [ "*" ]
+    access: NONE
+  - paths: [ "docs/**", "*.md" ]
+    groups: [ editors ]
+    access: CREATE
+  - paths: [ "**" ]
+    users: [ "*" ]
+    access: READ
+`
+
+func TestRulesAuthenticate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rulesPath := filepath.Join(dir, "rules.yaml")
+	writeTestFile(t, rulesPath, []byte(testPolicy))
+
+	conf := NewRulesConfig()
+	conf.Path = rulesPath
+	conf.RefreshPeriod = 0
+
+	rules, err := NewRules(conf, nil, logger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		user     interface{}
+		document string
+		expected AccessLevel
+	}{
+		{"alice", "drafts/plan.md", EditAccess},
+		{"bob", "drafts/plan.md", NoAccess},
+		{"bob", "docs/a/b/c.txt", CreateAccess},
+		{"bob", "readme.md", CreateAccess},
+		{"bob", "src/readme.md", ReadAccess},
+		{map[string]interface{}{"username": "carol"}, "docs/index", CreateAccess},
+		{ProxyIdentity{Username: "dave", Groups: []string{"editors"}}, "docs/index", CreateAccess},
+		{map[string]interface{}{"username": "erin", "groups": []interface{}{"editors"}}, "readme.md", CreateAccess},
+		{ProxyIdentity{Username: "dave", Groups: []string{"viewers"}}, "docs/index", ReadAccess},
+		{"alice", "docs/index", ReadAccess},
+		{"", "docs/index", ReadAccess},
+		{"bob", "", CreateAccess},
+		{"alice", "", NoAccess},
+	}
+	for _, test := range testCases {
+		if actual := rules.Authenticate(test.user, "", test.document); actual != test.expected {
+			t.Errorf("%v %v: wrong access level: %v != %v", test.user, test.document, actual, test.expected)
+		}
+	}
+
+	createCases := []struct {
+		user     interface{}
+		document string
+		expected AccessLevel
+	}{
+		{"bob", "docs/new.txt", CreateAccess},
+		{"bob", "notes.md", CreateAccess},
+		{"bob", "src/main.go", NoAccess},
+		{"bob", "drafts/new.md", NoAccess},
+		{"alice", "docs/new.txt", NoAccess},
+		{"bob", "", CreateAccess},
+	}
+	for _, test := range createCases {
+		if actual := rules.AuthenticateCreate(test.user, "", test.document); actual != test.expected {
+			t.Errorf("%v create %v: wrong access level: %v != %v", test.user, test.document, actual, test.expected)
+		}
+	}
+
+	wrapped, err := NewRules(conf, tokenIdentifier{"t1": "alice"}, logger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := wrapped.Authenticate("bob", "t1", "drafts/plan.md"); actual != EditAccess {
+		t.Errorf("Wrong access level for identified token: %v != %v", actual, EditAccess)
+	}
+	if actual := wrapped.Authenticate("alice", "t2", "drafts/plan.md"); actual != NoAccess {
+		t.Errorf("Wrong access level for unknown token: %v != %v", actual, NoAccess)
+	}
+	if actual := wrapped.Authenticate(
+		ProxyIdentity{Username: "bob", Groups: []string{"editors"}}, "t1", "docs/index",
+	); actual != ReadAccess {
+		t.Errorf("Groups of another user applied to identified token: %v != %v", actual, ReadAccess)
+	}
+}
+
+func TestRulesReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rulesPath := filepath.Join(dir, "rules.json")
+	writeTestFile(t, rulesPath, []byte(`{"rules":[{"paths":["**"],"users":["*"],"access":"READ"}]}`))
+
+	conf := NewRulesConfig()
+	conf.Path = rulesPath
+	conf.RefreshPeriod = 0
+
+	rules, err := NewRules(conf, nil, logger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := rules.Authenticate("alice", "", "foo"); actual != ReadAccess {
+		t.Errorf("Wrong access level: %v != %v", actual, ReadAccess)
+	}
+
+	mtime := time.Now().Add(time.Minute)
+	writeTestFile(t, rulesPath, []byte(`{"rules":[{"paths":["**"],"users":["alice"],"access":"EDIT"}]}`))
+	if err = os.Chtimes(rulesPath, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded, err := rules.Reload(); err != nil || !reloaded {
+		t.Fatalf("Expected reload: %v, %v", reloaded, err)
+	}
+	if actual := rules.Authenticate("alice", "", "foo"); actual != EditAccess {
+		t.Errorf("Wrong access level: %v != %v", actual, EditAccess)
+	}
+	if reloaded, err := rules.Reload(); err != nil || reloaded {
+		t.Errorf("Expected no reload of unchanged file: %v, %v", reloaded, err)
+	}
+
+	mtime = mtime.Add(time.Minute)
+	writeTestFile(t, rulesPath, []byte(`{"rules":[{"paths":["**"],"users":["alice"],"access":"WRITE"}]}`))
+	if err = os.Chtimes(rulesPath, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rules.Reload(); err == nil {
+		t.Error("Expected error from invalid access level")
+	}
+	if actual := rules.Authenticate("alice", "", "foo"); actual != EditAccess {
+		t.Errorf("Previous rules not retained: %v != %v", actual, EditAccess)
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	testCases := []struct {
+		pattern  string
+		path     string
+		expected bool
+	}{
+		{"**", "a/b/c", true},
+		{"docs/**", "docs", true},
+		{"docs/**", "docs/a/b", true},
+		{"docs/**/*.md", "docs/a/b/c.md", true},
+		{"docs/**/*.md", "docs/c.md", true},
+		{"docs/**/*.md", "docs/a/c.txt", false},
+		{"*.md", "a/b.md", false},
+		{"*.md", "b.md", true},
+		{"docs/*", "docs/a/b", false},
+	}
+	for _, test := range testCases {
+		if actual := matchGlob(test.pattern, test.path); actual != test.expected {
+			t.Errorf("%v %v: %v != %v", test.pattern, test.path, actual, test.expected)
+		}
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
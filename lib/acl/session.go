@@ -0,0 +1,150 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, sub to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package acl
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//--------------------------------------------------------------------------------------------------
+
+// Errors for the Sessions type.
+var (
+	ErrInvalidSession = errors.New("session is invalid")
+	ErrSessionExpired = errors.New("session has expired")
+	ErrSecretTooShort = errors.New("session secret must be at least 32 bytes")
+)
+
+// SessionsConfig - A config object for the Sessions object.
+type SessionsConfig struct {
+	SecretFile string `json:"secret_file" yaml:"secret_file"`
+	TTLS       int64  `json:"ttl_s" yaml:"ttl_s"`
+}
+
+// NewSessionsConfig - Returns a default config object for a Sessions object.
+func NewSessionsConfig() SessionsConfig {
+	return SessionsConfig{
+		SecretFile: "",
+		TTLS:       86400,
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
+
+/*
+Sessions - Issues and verifies stateless session tokens for logged in users, suitable for storing
+within cookies. A token contains the username and expiry time of a session, signed with
+HMAC-SHA256.
+
+The signing secret is read from a file, which should contain at least 32 bytes. When no file is
+configured a random secret is generated, in which case all sessions are invalidated when the
+service restarts.
+
+Sessions implements Identifier, resolving a session token to its username.
+*/
+type Sessions struct {
+	config SessionsConfig
+	secret []byte
+	now    func() time.Time
+}
+
+// NewSessions - Creates a Sessions object using the provided configuration.
+func NewSessions(config SessionsConfig) (*Sessions, error) {
+	var secret []byte
+	if len(config.SecretFile) > 0 {
+		data, err := ioutil.ReadFile(config.SecretFile)
+		if err != nil {
+			return nil, err
+		}
+		secret = []byte(strings.TrimSpace(string(data)))
+		if len(secret) < 32 {
+			return nil, ErrSecretTooShort
+		}
+	} else {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+	}
+	return &Sessions{
+		config: config,
+		secret: secret,
+		now:    time.Now,
+	}, nil
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// sign - Returns the signature of the payload of a session token.
+func (s *Sessions) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Issue - Creates a session token for a user, returning the token and the time at which it expires.
+func (s *Sessions) Issue(username string) (string, time.Time) {
+	expires := s.now().Add(time.Duration(s.config.TTLS) * time.Second)
+	payload := base64.RawURLEncoding.EncodeToString([]byte(username)) + "." +
+		strconv.FormatInt(expires.Unix(), 10)
+	return payload + "." + s.sign(payload), expires
+}
+
+// Identify - Verifies a session token and returns the username of its user.
+func (s *Sessions) Identify(token string) (string, error) {
+	split := strings.LastIndex(token, ".")
+	if split < 0 {
+		return "", ErrInvalidSession
+	}
+	payload, signature := token[:split], token[split+1:]
+	if !hmac.Equal([]byte(signature), []byte(s.sign(payload))) {
+		return "", ErrInvalidSession
+	}
+
+	parts := strings.Split(payload, ".")
+	if len(parts) != 2 {
+		return "", ErrInvalidSession
+	}
+	username, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || len(username) == 0 {
+		return "", ErrInvalidSession
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", ErrInvalidSession
+	}
+	if s.now().Unix() >= expires {
+		return "", ErrSessionExpired
+	}
+	return string(username), nil
+}
+
+//--------------------------------------------------------------------------------------------------
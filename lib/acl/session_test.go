@@ -0,0 +1,109 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, sub to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package acl
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+//--------------------------------------------------------------------------------------------------
+
+func TestSessions(t *testing.T) {
+	conf := NewSessionsConfig()
+	conf.TTLS = 60
+
+	s, err := NewSessions(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	token, expires := s.Issue("alice.smith")
+	if exp := now.Add(time.Minute); !expires.Equal(exp) {
+		t.Errorf("Wrong expiry: %v != %v", expires, exp)
+	}
+	if user, err := s.Identify(token); err != nil || user != "alice.smith" {
+		t.Errorf("Wrong identity: %v, %v", user, err)
+	}
+
+	other, err := NewSessions(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = other.Identify(token); err != ErrInvalidSession {
+		t.Errorf("Expected session signed by another secret to be invalid: %v", err)
+	}
+
+	forged := strings.Replace(token, token[:4], "Ym9i", 1)
+	if _, err = s.Identify(forged); err != ErrInvalidSession {
+		t.Errorf("Expected forged session to be invalid: %v", err)
+	}
+	for _, garbage := range []string{"", ".", "a.b", "a.b.c.d"} {
+		if _, err = s.Identify(garbage); err != ErrInvalidSession {
+			t.Errorf("Expected %q to be invalid: %v", garbage, err)
+		}
+	}
+
+	now = now.Add(time.Minute)
+	if _, err = s.Identify(token); err != ErrSessionExpired {
+		t.Errorf("Expected session to expire: %v", err)
+	}
+}
+
+func TestSessionsSecretFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := NewSessionsConfig()
+	conf.SecretFile = filepath.Join(dir, "secret")
+
+	writeTestFile(t, conf.SecretFile, []byte("too short\n"))
+	if _, err = NewSessions(conf); err != ErrSecretTooShort {
+		t.Errorf("Wrong error: %v != %v", err, ErrSecretTooShort)
+	}
+
+	writeTestFile(t, conf.SecretFile, []byte(strings.Repeat("s", 32)+"\n"))
+	first, err := NewSessions(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewSessions(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _ := first.Issue("alice")
+	if user, err := second.Identify(token); err != nil || user != "alice" {
+		t.Errorf("Expected sessions to survive a restart: %v, %v", user, err)
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
@@ -50,6 +50,10 @@ var logger, stats = func() (log.Modular, metrics.Type) {
 type dudPortal struct {
 	clientMetadata interface{}
 	id             string
+	readOnly       bool
+
+	removed bool
+	newID   string
 
 	closedChan chan struct{}
 
@@ -63,6 +67,7 @@ type dudPortal struct {
 func (d *dudPortal) ClientMetadata() interface{} { return d.clientMetadata }
 func (d *dudPortal) BaseVersion() int            { return 0 }
 func (d *dudPortal) ReleaseDocument()            {}
+func (d *dudPortal) ReadOnly() bool              { return d.readOnly }
 func (d *dudPortal) Document() store.Document {
 	return store.Document{
 		ID:      d.id,
@@ -81,6 +86,9 @@ func (d *dudPortal) SendMetadata(metadata interface{}) {
 	}
 }
 func (d *dudPortal) SendTransform(ot text.OTransform, timeout time.Duration) (int, error) {
+	if d.readOnly {
+		return 0, binder.ErrReadOnlyPortal
+	}
 	select {
 	case d.sentTChan <- ot:
 	case <-time.After(timeout):
@@ -93,6 +101,7 @@ func (d *dudPortal) Exit(timeout time.Duration) {
 	close(d.tChan)
 	close(d.mChan)
 }
+func (d *dudPortal) Removed() (bool, string) { return d.removed, d.newID }
 
 //------------------------------------------------------------------------------
 
@@ -104,11 +113,18 @@ type dudCurator struct {
 
 func (d *dudCurator) EditDocument(
 	userMetadata interface{}, token, documentID string, timeout time.Duration,
+) (binder.Portal, error) {
+	return d.OpenDocument(userMetadata, token, documentID, false, timeout)
+}
+
+func (d *dudCurator) OpenDocument(
+	userMetadata interface{}, token, documentID string, readOnly bool, timeout time.Duration,
 ) (binder.Portal, error) {
 	if _, ok := d.dudDocs[documentID]; ok {
 		p := &dudPortal{
 			clientMetadata: userMetadata,
 			id:             documentID,
+			readOnly:       readOnly,
 			closedChan:     make(chan struct{}),
 			tChan:          make(chan text.OTransform),
 			mChan:          make(chan binder.ClientMetadata),
@@ -130,9 +146,33 @@ func (d *dudCurator) ReadDocument(
 func (d *dudCurator) CreateDocument(
 	userMetadata interface{}, token string, document store.Document, timeout time.Duration,
 ) (binder.Portal, error) {
+	if _, ok := d.dudDocs[document.ID]; ok {
+		return nil, store.ErrDocumentExists
+	}
+	d.dudDocs[document.ID] = struct{}{}
+	return d.OpenDocument(userMetadata, token, document.ID, false, timeout)
+}
+
+func (d *dudCurator) DeleteDocument(userMetadata interface{}, token, documentID string) error {
+	return errors.New("Not allowed")
+}
+
+func (d *dudCurator) RenameDocument(userMetadata interface{}, token, documentID, newID string) error {
+	return errors.New("Not allowed")
+}
+
+func (d *dudCurator) ListDocuments(userMetadata interface{}, token, prefix string) ([]string, error) {
 	return nil, errors.New("Not allowed")
 }
 
+func (d *dudCurator) OpenDocumentIDs() []string {
+	return nil
+}
+
+func (d *dudCurator) ReloadDocument(documentID string, timeout time.Duration) error {
+	return errors.New("Not found")
+}
+
 func (d *dudCurator) Close() {}
 
 //------------------------------------------------------------------------------
@@ -28,9 +28,11 @@ import (
 	"sync"
 	"time"
 
+	"github.com/Jeffail/leaps/lib/acl"
 	"github.com/Jeffail/leaps/lib/api/events"
 	"github.com/Jeffail/leaps/lib/binder"
 	"github.com/Jeffail/leaps/lib/curator"
+	"github.com/Jeffail/leaps/lib/store"
 	"github.com/Jeffail/leaps/lib/text"
 	"github.com/Jeffail/leaps/lib/util/service/log"
 	"github.com/Jeffail/leaps/lib/util/service/metrics"
@@ -42,15 +44,19 @@ import (
 // gateway is responsible for tracking a client session as it attempts to
 // create, connect to and edit documents.
 type CuratorSession struct {
-	emitter Emitter
-	cur     curator.Type
+	emitter    Emitter
+	cur        curator.Type
+	identifier acl.Identifier
 
 	timeout time.Duration
 	logger  log.Modular
 	stats   metrics.Type
 
 	username  string
+	groups    []string
+	verified  bool
 	uuid      string
+	token     string
 	portals   map[string]binder.Portal
 	portalMut sync.Mutex
 }
@@ -58,8 +64,15 @@ type CuratorSession struct {
 // NewCuratorSession - Creates a curator gateway API for a user IO session that
 // binds to events from the provided network IO emitter. Currently the API is
 // limited to single document access, this could, however, be changed later.
+//
+// The token, which may be empty, is given to the curator in order to
+// authenticate each subscription, and can be replaced by the client with an
+// auth request. If an identifier is provided then tokens given through auth
+// requests are resolved into the username of the session, otherwise the
+// username is left as is.
 func NewCuratorSession(
-	username, uuid string,
+	username, uuid, token string,
+	identifier acl.Identifier,
 	emitter Emitter,
 	cur curator.Type,
 	timeout time.Duration,
@@ -67,16 +80,23 @@ func NewCuratorSession(
 	stats metrics.Type,
 ) *CuratorSession {
 	s := &CuratorSession{
-		username: username,
-		uuid:     uuid,
-		emitter:  emitter,
-		portals:  map[string]binder.Portal{},
-		cur:      cur,
-		timeout:  timeout,
-		logger:   logger.NewModule(":api:session"),
-		stats:    stats,
+		username:   username,
+		uuid:       uuid,
+		token:      token,
+		identifier: identifier,
+		emitter:    emitter,
+		portals:    map[string]binder.Portal{},
+		cur:        cur,
+		timeout:    timeout,
+		logger:     logger.NewModule(":api:session"),
+		stats:      stats,
 	}
+	emitter.OnReceive(events.Auth, s.auth)
 	emitter.OnReceive(events.Subscribe, s.subscribe)
+	emitter.OnReceive(events.Create, s.create)
+	emitter.OnReceive(events.Delete, s.delete)
+	emitter.OnReceive(events.Rename, s.rename)
+	emitter.OnReceive(events.List, s.list)
 	emitter.OnReceive(events.Unsubscribe, s.unsubscribe)
 	emitter.OnReceive(events.Transform, s.transform)
 	emitter.OnReceive(events.Metadata, s.metadata)
@@ -93,10 +113,107 @@ func NewCuratorSession(
 	return s
 }
 
+// SetGroups - Sets the groups of the session user, as asserted by a trusted
+// source such as an authenticating proxy. The groups are given to the curator
+// along with the username in order to authenticate each subscription, and are
+// dropped if the session is later authenticated as a different user.
+func (s *CuratorSession) SetGroups(groups []string) {
+	s.portalMut.Lock()
+	s.groups = groups
+	s.portalMut.Unlock()
+}
+
+// VerifyIdentity - Marks the username of the session as verified by a trusted
+// source, such as a login session or an authenticating proxy. From then on any
+// token given by the client, either through an auth request or with a
+// subscription, is rejected unless the identifier resolves it to the same user.
+func (s *CuratorSession) VerifyIdentity() {
+	s.portalMut.Lock()
+	s.verified = true
+	s.portalMut.Unlock()
+}
+
+// identify - Resolves the username of a token when the session has an
+// identifier, or returns the current username otherwise. Tokens of a different
+// user are rejected when the session identity is verified. The portal mutex
+// must be held by the caller.
+func (s *CuratorSession) identify(op, token string) (string, events.TypedError) {
+	if s.identifier == nil {
+		return s.username, nil
+	}
+	username, err := s.identifier.Identify(token)
+	if err != nil {
+		s.stats.Incr("api.session."+op+".error.identify", 1)
+		s.logger.Warnf("Identify error on %v: %v\n", op, err)
+		return "", events.NewAPIError(events.ErrAuth, "Failed to authenticate token")
+	}
+	if s.verified && username != s.username {
+		s.stats.Incr("api.session."+op+".error.wrong_user", 1)
+		s.logger.Warnf("Rejected %v: token of %v used by %v\n", op, username, s.username)
+		return "", events.NewAPIError(events.ErrAuth, "Token does not belong to the session user")
+	}
+	return username, nil
+}
+
+// requestToken - Returns the token that authenticates a request, which is the
+// token of the session unless the request provides its own. The portal mutex
+// must be held by the caller.
+func (s *CuratorSession) requestToken(op, token string) (string, events.TypedError) {
+	if len(token) == 0 {
+		return s.token, nil
+	}
+	if s.verified {
+		if _, terr := s.identify(op, token); terr != nil {
+			return "", terr
+		}
+	}
+	return token, nil
+}
+
+// client - Returns the client metadata of the session, the portal mutex must be
+// held by the caller.
+func (s *CuratorSession) client() events.Client {
+	return events.Client{Username: s.username, SessionID: s.uuid, Groups: s.groups}
+}
+
 //------------------------------------------------------------------------------
 
 // API Handlers
 
+// Authenticate the session by providing a token, which is used for subsequent
+// subscriptions.
+func (s *CuratorSession) auth(body []byte) events.TypedError {
+	var req events.AuthMessage
+	if err := json.Unmarshal(body, &req); err != nil {
+		s.stats.Incr("api.session.auth.error.json", 1)
+		s.logger.Warnf("Auth parse error: %v\n", err)
+		return events.NewAPIError(events.ErrBadJSON, err.Error())
+	}
+	if len(req.Token) == 0 {
+		s.stats.Incr("api.session.auth.error.no_token", 1)
+		return events.NewAPIError(events.ErrBadReq, "Auth request did not contain a token")
+	}
+
+	s.portalMut.Lock()
+	username, terr := s.identify("auth", req.Token)
+	if terr != nil {
+		s.portalMut.Unlock()
+		return terr
+	}
+	if username != s.username {
+		s.groups = nil
+	}
+	s.username = username
+	s.token = req.Token
+	s.portalMut.Unlock()
+
+	s.stats.Incr("api.session.auth.success", 1)
+	s.emitter.Send(events.Auth, events.AuthMessage{
+		Client: &events.Client{Username: username, SessionID: s.uuid},
+	})
+	return nil
+}
+
 // Subscribe to a document by providing an ID.
 func (s *CuratorSession) subscribe(body []byte) events.TypedError {
 	var req events.SubscriptionMessage
@@ -118,64 +235,178 @@ func (s *CuratorSession) subscribe(body []byte) events.TypedError {
 		)
 	}
 
-	portal, err := s.cur.EditDocument(
-		events.Client{Username: s.username, SessionID: s.uuid}, "", req.Document.ID, s.timeout,
+	token, terr := s.requestToken("subscribe", req.Token)
+	if terr != nil {
+		return terr
+	}
+
+	portal, err := s.cur.OpenDocument(
+		s.client(), token, req.Document.ID,
+		req.ReadOnly, s.timeout,
 	)
 	if err != nil {
 		s.stats.Incr("api.session.subscribe.error.curator", 1)
 		s.logger.Warnf("Subscribe edit error: %v\n", err)
+		if err == curator.ErrBinderCapacity {
+			return events.NewAPIError(events.ErrCapacity, err.Error())
+		}
 		return events.NewAPIError(events.ErrSubscribe, err.Error())
 	}
-	s.emitter.Send(events.Subscribe, events.SubscriptionMessage{
-		Document: events.DocumentFull{
-			ID:      portal.Document().ID,
-			Content: portal.Document().Content,
-			Version: portal.BaseVersion(),
-		},
-	})
 	s.stats.Incr("api.session.subscribe.success", 1)
-	s.stats.Incr("api.session.subscribed", 1)
-	s.portals[req.Document.ID] = portal
-	portal.ReleaseDocument()
+	s.addPortal(portal)
+	return nil
+}
 
-	go func() {
-		open := true
-		for open {
-			var m binder.ClientMetadata
-			var t text.OTransform
-			select {
-			case m, open = <-portal.MetadataReadChan():
-				if open {
-					s.emitter.Send(events.Metadata, events.MetadataMessage{
-						Document: events.DocumentStripped{
-							ID: portal.Document().ID,
-						},
-						Client:   m.Client,
-						Metadata: m.Metadata,
-					})
-				}
-			case t, open = <-portal.TransformReadChan():
-				if open {
-					s.emitter.Send(events.Transforms, events.TransformsMessage{
-						Document: events.DocumentStripped{
-							ID: portal.Document().ID,
-						},
-						Transforms: []text.OTransform{t},
-					})
-				}
-			}
+// Create a new document, with an optional ID and content, and subscribe to it.
+func (s *CuratorSession) create(body []byte) events.TypedError {
+	var req events.CreateMessage
+	if err := json.Unmarshal(body, &req); err != nil {
+		s.stats.Incr("api.session.create.error.json", 1)
+		s.logger.Warnf("Create parse error: %v\n", err)
+		return events.NewAPIError(events.ErrBadJSON, err.Error())
+	}
+
+	doc := store.NewDocument(req.Document.Content)
+	if len(req.Document.ID) > 0 {
+		doc.ID = req.Document.ID
+	}
+	doc.Metadata = req.Document.Metadata
+
+	s.portalMut.Lock()
+	defer s.portalMut.Unlock()
+
+	if _, exists := s.portals[doc.ID]; exists {
+		s.stats.Incr("api.session.create.error.already_subscribed", 1)
+		return events.NewAPIError(
+			events.ErrExistingSub,
+			fmt.Sprintf("This session is already subscribed to document %v", doc.ID),
+		)
+	}
+
+	token, terr := s.requestToken("create", req.Token)
+	if terr != nil {
+		return terr
+	}
+
+	portal, err := s.cur.CreateDocument(
+		s.client(), token, doc, s.timeout,
+	)
+	if err != nil {
+		s.stats.Incr("api.session.create.error.curator", 1)
+		s.logger.Warnf("Create document error: %v\n", err)
+		if err == curator.ErrBinderCapacity {
+			return events.NewAPIError(events.ErrCapacity, err.Error())
 		}
-		s.portalMut.Lock()
-		delete(s.portals, req.Document.ID)
-		s.portalMut.Unlock()
+		return events.NewAPIError(events.ErrCreate, err.Error())
+	}
+	s.stats.Incr("api.session.create.success", 1)
+	s.addPortal(portal)
+	return nil
+}
 
-		s.stats.Decr("api.session.subscribed", 1)
-		s.emitter.Send(events.Unsubscribe, events.UnsubscriptionMessage{
-			Document: events.DocumentStripped{
-				ID: req.Document.ID,
-			},
-		})
-	}()
+// Delete a document, which tells all clients of the document of its deletion
+// and unsubscribes them.
+func (s *CuratorSession) delete(body []byte) events.TypedError {
+	var req events.DeleteMessage
+	if err := json.Unmarshal(body, &req); err != nil {
+		s.stats.Incr("api.session.delete.error.json", 1)
+		s.logger.Warnf("Delete parse error: %v\n", err)
+		return events.NewAPIError(events.ErrBadJSON, err.Error())
+	}
+	if len(req.Document.ID) == 0 {
+		s.stats.Incr("api.session.delete.error.no_id", 1)
+		return events.NewAPIError(events.ErrBadReq, "Delete request did not contain a document ID")
+	}
+
+	// The portal mutex is released before calling the curator, as closing the
+	// binder of the document also closes any portal of this session.
+	s.portalMut.Lock()
+	token, terr := s.requestToken("delete", req.Token)
+	client := s.client()
+	_, subscribed := s.portals[req.Document.ID]
+	s.portalMut.Unlock()
+	if terr != nil {
+		return terr
+	}
+
+	if err := s.cur.DeleteDocument(client, token, req.Document.ID); err != nil {
+		s.stats.Incr("api.session.delete.error.curator", 1)
+		s.logger.Warnf("Delete document error: %v\n", err)
+		return events.NewAPIError(events.ErrDelete, err.Error())
+	}
+	s.stats.Incr("api.session.delete.success", 1)
+
+	// Subscribed sessions are told of the deletion along with all other
+	// subscribers of the document.
+	if !subscribed {
+		s.emitter.Send(events.Delete, events.DeleteMessage{Document: req.Document})
+	}
+	return nil
+}
+
+// Rename a document, which tells all clients of the document of its new ID and
+// unsubscribes them.
+func (s *CuratorSession) rename(body []byte) events.TypedError {
+	var req events.RenameMessage
+	if err := json.Unmarshal(body, &req); err != nil {
+		s.stats.Incr("api.session.rename.error.json", 1)
+		s.logger.Warnf("Rename parse error: %v\n", err)
+		return events.NewAPIError(events.ErrBadJSON, err.Error())
+	}
+	if len(req.Document.ID) == 0 || len(req.NewID) == 0 {
+		s.stats.Incr("api.session.rename.error.no_id", 1)
+		return events.NewAPIError(events.ErrBadReq, "Rename request did not contain both document IDs")
+	}
+
+	s.portalMut.Lock()
+	token, terr := s.requestToken("rename", req.Token)
+	client := s.client()
+	_, subscribed := s.portals[req.Document.ID]
+	s.portalMut.Unlock()
+	if terr != nil {
+		return terr
+	}
+
+	if err := s.cur.RenameDocument(client, token, req.Document.ID, req.NewID); err != nil {
+		s.stats.Incr("api.session.rename.error.curator", 1)
+		s.logger.Warnf("Rename document error: %v\n", err)
+		return events.NewAPIError(events.ErrRename, err.Error())
+	}
+	s.stats.Incr("api.session.rename.success", 1)
+
+	// Subscribed sessions are told of the rename along with all other
+	// subscribers of the document.
+	if !subscribed {
+		s.emitter.Send(events.Rename, events.RenameMessage{Document: req.Document, NewID: req.NewID})
+	}
+	return nil
+}
+
+// List the IDs of documents beginning with a prefix that the client may read.
+func (s *CuratorSession) list(body []byte) events.TypedError {
+	var req events.ListMessage
+	if err := json.Unmarshal(body, &req); err != nil {
+		s.stats.Incr("api.session.list.error.json", 1)
+		s.logger.Warnf("List parse error: %v\n", err)
+		return events.NewAPIError(events.ErrBadJSON, err.Error())
+	}
+
+	s.portalMut.Lock()
+	token, terr := s.requestToken("list", req.Token)
+	client := s.client()
+	s.portalMut.Unlock()
+	if terr != nil {
+		return terr
+	}
+
+	ids, err := s.cur.ListDocuments(client, token, req.Prefix)
+	if err != nil {
+		s.stats.Incr("api.session.list.error.curator", 1)
+		s.logger.Warnf("List documents error: %v\n", err)
+		return events.NewAPIError(events.ErrList, err.Error())
+	}
+	s.stats.Incr("api.session.list.success", 1)
+	s.emitter.Send(events.List, events.ListMessage{Prefix: req.Prefix, Documents: ids})
 	return nil
 }
 
@@ -225,10 +456,21 @@ func (s *CuratorSession) transform(body []byte) events.TypedError {
 		)
 	}
 
+	if portal.ReadOnly() {
+		s.stats.Incr("api.session.transform.error.read_only", 1)
+		return events.NewAPIError(
+			events.ErrReadOnly,
+			fmt.Sprintf("This session is subscribed to document %v as read only", req.Document.ID),
+		)
+	}
+
 	v, err := portal.SendTransform(req.Transform, s.timeout)
 	if err != nil {
 		s.stats.Incr("api.session.transform.error.send", 1)
 		s.logger.Warnf("Transform send error: %v\n", err)
+		if err == binder.ErrReadOnlyPortal {
+			return events.NewAPIError(events.ErrReadOnly, err.Error())
+		}
 		return events.NewAPIError(events.ErrTransform, err.Error())
 	}
 	s.stats.Incr("api.session.transform.success", 1)
@@ -275,3 +517,76 @@ func (s *CuratorSession) ping(body []byte) events.TypedError {
 }
 
 //------------------------------------------------------------------------------
+
+// addPortal - Sends the subscribe event for a newly opened portal, adds it to
+// the subscriptions of the session and forwards its events to the client until
+// the portal is closed. Must be called whilst holding the portal mutex.
+func (s *CuratorSession) addPortal(portal binder.Portal) {
+	documentID := portal.Document().ID
+	s.emitter.Send(events.Subscribe, events.SubscriptionMessage{
+		Document: events.DocumentFull{
+			ID:       portal.Document().ID,
+			Content:  portal.Document().Content,
+			Version:  portal.BaseVersion(),
+			Metadata: portal.Document().Metadata,
+		},
+		ReadOnly: portal.ReadOnly(),
+	})
+	s.stats.Incr("api.session.subscribed", 1)
+	s.portals[documentID] = portal
+	portal.ReleaseDocument()
+
+	go func() {
+		open := true
+		for open {
+			var m binder.ClientMetadata
+			var t text.OTransform
+			select {
+			case m, open = <-portal.MetadataReadChan():
+				if open {
+					s.emitter.Send(events.Metadata, events.MetadataMessage{
+						Document: events.DocumentStripped{
+							ID: portal.Document().ID,
+						},
+						Client:   m.Client,
+						Metadata: m.Metadata,
+					})
+				}
+			case t, open = <-portal.TransformReadChan():
+				if open {
+					s.emitter.Send(events.Transforms, events.TransformsMessage{
+						Document: events.DocumentStripped{
+							ID: portal.Document().ID,
+						},
+						Transforms: []text.OTransform{t},
+					})
+				}
+			}
+		}
+		s.portalMut.Lock()
+		delete(s.portals, documentID)
+		s.portalMut.Unlock()
+
+		// Clients are told when they are unsubscribed because the document
+		// was deleted or renamed.
+		if removed, newID := portal.Removed(); removed && len(newID) > 0 {
+			s.emitter.Send(events.Rename, events.RenameMessage{
+				Document: events.DocumentStripped{ID: documentID},
+				NewID:    newID,
+			})
+		} else if removed {
+			s.emitter.Send(events.Delete, events.DeleteMessage{
+				Document: events.DocumentStripped{ID: documentID},
+			})
+		}
+
+		s.stats.Decr("api.session.subscribed", 1)
+		s.emitter.Send(events.Unsubscribe, events.UnsubscriptionMessage{
+			Document: events.DocumentStripped{
+				ID: documentID,
+			},
+		})
+	}()
+}
+
+//------------------------------------------------------------------------------
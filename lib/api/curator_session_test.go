@@ -23,7 +23,10 @@ THE SOFTWARE.
 package api
 
 import (
+	"errors"
 	"reflect"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
@@ -44,7 +47,7 @@ func TestBasicCuratorSession(t *testing.T) {
 
 	dCurator.dudDocs["testdoc1"] = struct{}{}
 
-	NewCuratorSession("testUser1", "nope", dEmitter, dCurator, time.Second, logger, stats)
+	NewCuratorSession("testUser1", "nope", "", nil, dEmitter, dCurator, time.Second, logger, stats)
 
 	// Send ping
 	if err := dEmitter.reqHandlers[events.Ping](nil); err != nil {
@@ -173,7 +176,7 @@ func TestCuratorSessionErrors(t *testing.T) {
 		nil, make(chan dudSendType, 1),
 	}
 
-	NewCuratorSession("testUser1", "nope", dEmitter, dCurator, time.Second, logger, stats)
+	NewCuratorSession("testUser1", "nope", "", nil, dEmitter, dCurator, time.Second, logger, stats)
 
 	// Send ping
 	if err := dEmitter.reqHandlers[events.Ping](nil); err != nil {
@@ -227,6 +230,384 @@ func TestCuratorSessionErrors(t *testing.T) {
 	}
 }
 
+func TestCuratorSessionReadOnly(t *testing.T) {
+	dCurator := &dudCurator{make(map[string]*dudPortal), make(map[string]struct{}), make(chan struct{})}
+	dEmitter := &dudEmitter{
+		make(map[string]RequestHandler),
+		make(map[string]ResponseHandler),
+		nil, make(chan dudSendType, 1),
+	}
+
+	dCurator.dudDocs["testdoc1"] = struct{}{}
+
+	NewCuratorSession("testUser1", "nope", "", nil, dEmitter, dCurator, time.Second, logger, stats)
+
+	// Send read only subscribe request
+	if err := dEmitter.reqHandlers[events.Subscribe](
+		[]byte(`{"document":{"id":"testdoc1"},"read_only":true}`),
+	); err != nil {
+		t.Error(err)
+	}
+
+	select {
+	case d := <-dEmitter.sendChan:
+		if exp, act := events.Subscribe, d.Type; exp != act {
+			t.Errorf("Wrong event type returned: %v != %v", exp, act)
+		}
+		if bodyObj, ok := d.Body.(events.SubscriptionMessage); ok {
+			if !bodyObj.ReadOnly {
+				t.Error("Expected subscription to be read only")
+			}
+		} else {
+			t.Errorf("Wrong type of body: %T", d.Body)
+		}
+	case <-time.After(time.Second):
+		t.Error("Timed out waiting for subscriber send")
+	}
+
+	// Transforms should be rejected
+	if err := dEmitter.reqHandlers[events.Transform](
+		[]byte(`{"document":{"id":"testdoc1"},"transform":{"insert":"foo"}}`),
+	); err == nil {
+		t.Error("Expected error from read only transform")
+	} else if exp, act := events.ErrReadOnly, err.Type(); exp != act {
+		t.Errorf("Wrong error type returned: %v != %v", exp, act)
+	}
+
+	// Metadata is still permitted
+	go func() {
+		<-dCurator.dudPortals["testdoc1"].sentMChan
+	}()
+	if err := dEmitter.reqHandlers[events.Metadata](
+		[]byte(`{"document":{"id":"testdoc1"},"metadata":"foo"}`),
+	); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCuratorSessionCreate(t *testing.T) {
+	dCurator := &dudCurator{make(map[string]*dudPortal), make(map[string]struct{}), make(chan struct{})}
+	dEmitter := &dudEmitter{
+		make(map[string]RequestHandler),
+		make(map[string]ResponseHandler),
+		nil, make(chan dudSendType, 1),
+	}
+
+	dCurator.dudDocs["testdoc1"] = struct{}{}
+
+	NewCuratorSession("testUser1", "nope", "", nil, dEmitter, dCurator, time.Second, logger, stats)
+
+	// Creating an existing document should fail
+	if err := dEmitter.reqHandlers[events.Create](
+		[]byte(`{"document":{"id":"testdoc1","content":"hello"}}`),
+	); err == nil {
+		t.Error("Expected error from creating existing document")
+	} else if exp, act := events.ErrCreate, err.Type(); exp != act {
+		t.Errorf("Wrong error type returned: %v != %v", exp, act)
+	}
+
+	for _, req := range []string{
+		`{"document":{"id":"testdoc2","content":"hello"}}`,
+		`{"document":{"content":"hello"}}`,
+	} {
+		if err := dEmitter.reqHandlers[events.Create]([]byte(req)); err != nil {
+			t.Error(err)
+		}
+
+		select {
+		case d := <-dEmitter.sendChan:
+			if exp, act := events.Subscribe, d.Type; exp != act {
+				t.Errorf("Wrong event type returned: %v != %v", exp, act)
+			}
+			if bodyObj, ok := d.Body.(events.SubscriptionMessage); ok {
+				if len(bodyObj.Document.ID) == 0 {
+					t.Error("Expected document ID in subscription")
+				}
+				if _, exists := dCurator.dudPortals[bodyObj.Document.ID]; !exists {
+					t.Errorf("Document %v was not created", bodyObj.Document.ID)
+				}
+			} else {
+				t.Errorf("Wrong type of body: %T", d.Body)
+			}
+		case <-time.After(time.Second):
+			t.Error("Timed out waiting for subscriber send")
+		}
+	}
+
+	if _, exists := dCurator.dudPortals["testdoc2"]; !exists {
+		t.Error("Expected testdoc2 to be created")
+	}
+
+	// The session is now subscribed to the new document
+	go func() {
+		<-dCurator.dudPortals["testdoc2"].sentTChan
+	}()
+	if err := dEmitter.reqHandlers[events.Transform](
+		[]byte(`{"document":{"id":"testdoc2"},"transform":{"insert":"foo"}}`),
+	); err != nil {
+		t.Error(err)
+	}
+}
+
+type dudIdentifier map[string]string
+
+func (d dudIdentifier) Identify(token string) (string, error) {
+	if username, ok := d[token]; ok {
+		return username, nil
+	}
+	return "", errors.New("Not found")
+}
+
+type tokenCurator struct {
+	*dudCurator
+	tokens []string
+}
+
+func (d *tokenCurator) OpenDocument(
+	userMetadata interface{}, token, documentID string, readOnly bool, timeout time.Duration,
+) (binder.Portal, error) {
+	d.tokens = append(d.tokens, token)
+	return d.dudCurator.OpenDocument(userMetadata, token, documentID, readOnly, timeout)
+}
+
+func TestCuratorSessionAuth(t *testing.T) {
+	dCurator := &tokenCurator{
+		dudCurator: &dudCurator{make(map[string]*dudPortal), make(map[string]struct{}), make(chan struct{})},
+	}
+	dEmitter := &dudEmitter{
+		make(map[string]RequestHandler),
+		make(map[string]ResponseHandler),
+		nil, make(chan dudSendType, 1),
+	}
+
+	dCurator.dudDocs["testdoc1"] = struct{}{}
+	dCurator.dudDocs["testdoc2"] = struct{}{}
+
+	session := NewCuratorSession(
+		"testUser1", "nope", "connect_token", dudIdentifier{"auth_token": "realUser"},
+		dEmitter, dCurator, time.Second, logger, stats,
+	)
+	session.SetGroups([]string{"editors"})
+
+	// Subscribe with the connection token
+	if err := dEmitter.reqHandlers[events.Subscribe](
+		[]byte(`{"document":{"id":"testdoc1"}}`),
+	); err != nil {
+		t.Error(err)
+	}
+	<-dEmitter.sendChan
+
+	expGroups := events.Client{Username: "testUser1", SessionID: "nope", Groups: []string{"editors"}}
+	if act := dCurator.dudPortals["testdoc1"].clientMetadata; !reflect.DeepEqual(expGroups, act) {
+		t.Errorf("Wrong client metadata given to curator: %v != %v", expGroups, act)
+	}
+
+	// Send an unrecognised token
+	if err := dEmitter.reqHandlers[events.Auth](
+		[]byte(`{"token":"bad_token"}`),
+	); err == nil {
+		t.Error("Expected error from bad token")
+	} else if exp, act := events.ErrAuth, err.Type(); exp != act {
+		t.Errorf("Wrong error type returned: %v != %v", exp, act)
+	}
+
+	// Send a valid token
+	if err := dEmitter.reqHandlers[events.Auth](
+		[]byte(`{"token":"auth_token"}`),
+	); err != nil {
+		t.Error(err)
+	}
+	select {
+	case d := <-dEmitter.sendChan:
+		exp := events.AuthMessage{
+			Client: &events.Client{Username: "realUser", SessionID: "nope"},
+		}
+		if !reflect.DeepEqual(exp, d.Body) {
+			t.Errorf("Wrong event body returned: %v != %v", exp, d.Body)
+		}
+	case <-time.After(time.Second):
+		t.Error("Timed out waiting for auth send")
+	}
+
+	// Subscribe with a per-subscription token
+	if err := dEmitter.reqHandlers[events.Subscribe](
+		[]byte(`{"document":{"id":"testdoc2"},"token":"sub_token"}`),
+	); err != nil {
+		t.Error(err)
+	}
+	<-dEmitter.sendChan
+
+	if exp, act := []string{"connect_token", "sub_token"}, dCurator.tokens; !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong tokens given to curator: %v != %v", exp, act)
+	}
+	exp := events.Client{Username: "realUser", SessionID: "nope"}
+	if act := dCurator.dudPortals["testdoc2"].clientMetadata; !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong client metadata given to curator: %v != %v", exp, act)
+	}
+}
+
+func TestCuratorSessionVerifiedAuth(t *testing.T) {
+	dCurator := &tokenCurator{
+		dudCurator: &dudCurator{make(map[string]*dudPortal), make(map[string]struct{}), make(chan struct{})},
+	}
+	dEmitter := &dudEmitter{
+		make(map[string]RequestHandler),
+		make(map[string]ResponseHandler),
+		nil, make(chan dudSendType, 1),
+	}
+
+	dCurator.dudDocs["testdoc1"] = struct{}{}
+
+	session := NewCuratorSession(
+		"testUser1", "nope", "", dudIdentifier{"own_token": "testUser1", "other_token": "otherUser"},
+		dEmitter, dCurator, time.Second, logger, stats,
+	)
+	session.VerifyIdentity()
+
+	// Send a token of another user
+	if err := dEmitter.reqHandlers[events.Auth](
+		[]byte(`{"token":"other_token"}`),
+	); err == nil {
+		t.Error("Expected error from token of another user")
+	} else if exp, act := events.ErrAuth, err.Type(); exp != act {
+		t.Errorf("Wrong error type returned: %v != %v", exp, act)
+	}
+
+	// Subscribe with a token of another user
+	if err := dEmitter.reqHandlers[events.Subscribe](
+		[]byte(`{"document":{"id":"testdoc1"},"token":"other_token"}`),
+	); err == nil {
+		t.Error("Expected error from token of another user")
+	} else if exp, act := events.ErrAuth, err.Type(); exp != act {
+		t.Errorf("Wrong error type returned: %v != %v", exp, act)
+	}
+
+	// Send a token of the session user
+	if err := dEmitter.reqHandlers[events.Auth](
+		[]byte(`{"token":"own_token"}`),
+	); err != nil {
+		t.Error(err)
+	}
+	<-dEmitter.sendChan
+
+	if err := dEmitter.reqHandlers[events.Subscribe](
+		[]byte(`{"document":{"id":"testdoc1"}}`),
+	); err != nil {
+		t.Error(err)
+	}
+	<-dEmitter.sendChan
+
+	if exp, act := []string{"own_token"}, dCurator.tokens; !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong tokens given to curator: %v != %v", exp, act)
+	}
+	exp := events.Client{Username: "testUser1", SessionID: "nope"}
+	if act := dCurator.dudPortals["testdoc1"].clientMetadata; !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong client metadata given to curator: %v != %v", exp, act)
+	}
+}
+
+type docsCurator struct {
+	*dudCurator
+}
+
+func (d docsCurator) DeleteDocument(userMetadata interface{}, token, documentID string) error {
+	if _, ok := d.dudDocs[documentID]; !ok {
+		return errors.New("Not found")
+	}
+	delete(d.dudDocs, documentID)
+	return nil
+}
+
+func (d docsCurator) RenameDocument(userMetadata interface{}, token, documentID, newID string) error {
+	if _, ok := d.dudDocs[documentID]; !ok {
+		return errors.New("Not found")
+	}
+	delete(d.dudDocs, documentID)
+	d.dudDocs[newID] = struct{}{}
+	return nil
+}
+
+func (d docsCurator) ListDocuments(userMetadata interface{}, token, prefix string) ([]string, error) {
+	ids := []string{}
+	for id := range d.dudDocs {
+		if strings.HasPrefix(id, prefix) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func TestCuratorSessionDeleteRenameList(t *testing.T) {
+	dCurator := docsCurator{
+		&dudCurator{make(map[string]*dudPortal), make(map[string]struct{}), make(chan struct{})},
+	}
+	dEmitter := &dudEmitter{
+		make(map[string]RequestHandler),
+		make(map[string]ResponseHandler),
+		nil, make(chan dudSendType, 1),
+	}
+
+	dCurator.dudDocs["foo/a"] = struct{}{}
+	dCurator.dudDocs["foo/b"] = struct{}{}
+	dCurator.dudDocs["bar/c"] = struct{}{}
+
+	NewCuratorSession("testUser1", "nope", "", nil, dEmitter, dCurator, time.Second, logger, stats)
+
+	expectSend := func(exp dudSendType) {
+		select {
+		case d := <-dEmitter.sendChan:
+			if !reflect.DeepEqual(exp, d) {
+				t.Errorf("Wrong event sent: %v != %v", exp, d)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("Timed out waiting for %v send", exp.Type)
+		}
+	}
+
+	if err := dEmitter.reqHandlers[events.Delete](
+		[]byte(`{"document":{"id":"nope"}}`),
+	); err == nil {
+		t.Error("Expected error from deleting missing document")
+	} else if exp, act := events.ErrDelete, err.Type(); exp != act {
+		t.Errorf("Wrong error type returned: %v != %v", exp, act)
+	}
+	if err := dEmitter.reqHandlers[events.Rename](
+		[]byte(`{"document":{"id":"foo/a"}}`),
+	); err == nil {
+		t.Error("Expected error from rename without new ID")
+	} else if exp, act := events.ErrBadReq, err.Type(); exp != act {
+		t.Errorf("Wrong error type returned: %v != %v", exp, act)
+	}
+
+	if err := dEmitter.reqHandlers[events.Delete](
+		[]byte(`{"document":{"id":"bar/c"}}`),
+	); err != nil {
+		t.Error(err)
+	}
+	expectSend(dudSendType{events.Delete, events.DeleteMessage{
+		Document: events.DocumentStripped{ID: "bar/c"},
+	}})
+
+	if err := dEmitter.reqHandlers[events.Rename](
+		[]byte(`{"document":{"id":"foo/a"},"new_id":"bar/a"}`),
+	); err != nil {
+		t.Error(err)
+	}
+	expectSend(dudSendType{events.Rename, events.RenameMessage{
+		Document: events.DocumentStripped{ID: "foo/a"}, NewID: "bar/a",
+	}})
+
+	if err := dEmitter.reqHandlers[events.List](
+		[]byte(`{"prefix":""}`),
+	); err != nil {
+		t.Error(err)
+	}
+	expectSend(dudSendType{events.List, events.ListMessage{
+		Documents: []string{"bar/a", "foo/b"},
+	}})
+}
+
 func TestCuratorSessionUnsub(t *testing.T) {
 	dCurator := &dudCurator{make(map[string]*dudPortal), make(map[string]struct{}), make(chan struct{})}
 	dEmitter := &dudEmitter{
@@ -238,7 +619,7 @@ func TestCuratorSessionUnsub(t *testing.T) {
 	dCurator.dudDocs["testdoc1"] = struct{}{}
 	dCurator.dudDocs["testdoc2"] = struct{}{}
 
-	NewCuratorSession("testUser1", "nope", dEmitter, dCurator, time.Second, logger, stats)
+	NewCuratorSession("testUser1", "nope", "", nil, dEmitter, dCurator, time.Second, logger, stats)
 
 	// Send subscribe request
 	if err := dEmitter.reqHandlers[events.Subscribe](
@@ -341,7 +722,7 @@ func TestCuratorSessionEmitterClose(t *testing.T) {
 
 	dCurator.dudDocs["testdoc1"] = struct{}{}
 
-	NewCuratorSession("testUser1", "nope", dEmitter, dCurator, time.Second, logger, stats)
+	NewCuratorSession("testUser1", "nope", "", nil, dEmitter, dCurator, time.Second, logger, stats)
 
 	// Send subscribe request
 	if err := dEmitter.reqHandlers[events.Subscribe](
@@ -390,7 +771,7 @@ func TestCuratorSessionSending(t *testing.T) {
 
 	dCurator.dudDocs["testdoc1"] = struct{}{}
 
-	NewCuratorSession("testUser1", "nope", dEmitter, dCurator, time.Second, logger, stats)
+	NewCuratorSession("testUser1", "nope", "", nil, dEmitter, dCurator, time.Second, logger, stats)
 
 	// Send subscribe request
 	if err := dEmitter.reqHandlers[events.Subscribe](
@@ -497,7 +878,7 @@ func TestCuratorSessionWithBadData(t *testing.T) {
 
 	dCurator.dudDocs["testdoc1"] = struct{}{}
 
-	NewCuratorSession("testUser1", "nope", dEmitter, dCurator, time.Second, logger, stats)
+	NewCuratorSession("testUser1", "nope", "", nil, dEmitter, dCurator, time.Second, logger, stats)
 
 	// Send subscribe request
 	if err := dEmitter.reqHandlers[events.Subscribe](
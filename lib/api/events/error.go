@@ -28,11 +28,20 @@ package events
 var (
 	ErrNoSub       = "ERR_NO_SUB"
 	ErrSubscribe   = "ERR_SUB"
+	ErrCreate      = "ERR_CREATE"
+	ErrDelete      = "ERR_DELETE"
+	ErrRename      = "ERR_RENAME"
+	ErrList        = "ERR_LIST"
 	ErrExistingSub = "ERR_EXISTING_SUB"
 	ErrBadJSON     = "ERR_BAD_JSON"
 	ErrTransform   = "ERR_TRANSFORM"
 	ErrMetadata    = "ERR_METADATA"
 	ErrBadReq      = "ERR_BAD_REQ"
+	ErrCapacity    = "ERR_CAPACITY"
+	ErrReadOnly    = "ERR_READ_ONLY"
+	ErrAuth        = "ERR_AUTH"
+	ErrRateLimit   = "ERR_RATE_LIMIT"
+	ErrQuota       = "ERR_QUOTA"
 )
 
 //------------------------------------------------------------------------------
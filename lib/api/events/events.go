@@ -35,6 +35,27 @@ const (
 	// Server: Send confirmation that document is now subscribed
 	Subscribe = "subscribe"
 
+	// Create event type
+	// Client: Send intent to create a new document and subscribe to it
+	Create = "create"
+
+	// Delete event type
+	// Client: Send intent to delete a document
+	// Server: Send confirmation that document is now deleted, which is also
+	// sent to all subscribers of the document before they are unsubscribed
+	Delete = "delete"
+
+	// Rename event type
+	// Client: Send intent to move a document to a new ID
+	// Server: Send confirmation that document is now renamed, which is also
+	// sent to all subscribers of the document before they are unsubscribed
+	Rename = "rename"
+
+	// List event type
+	// Client: Send intent to list documents beginning with a prefix
+	// Server: Send IDs of the documents that the client may read
+	List = "list"
+
 	// Unsubscribe event type
 	// Client: Send intent to unsubscribe from a document
 	// Server: Send confirmation that document is now unsubscribed
@@ -68,6 +89,11 @@ const (
 	// Server: Send information regarding an API error
 	Error = "error"
 
+	// Auth event type
+	// Client: Send a token in order to authenticate the session
+	// Server: Send confirmation of the authenticated identity of the session
+	Auth = "auth"
+
 	// Ping event type
 	// Client: Send intent to annoy the server
 	Ping = "ping"
@@ -86,17 +112,40 @@ type DocumentStripped struct {
 }
 
 // DocumentFull contains all data related to a document, including the current
-// version of the content.
+// version of the content and any metadata stored with the document.
 type DocumentFull struct {
-	ID      string `json:"id"`
-	Content string `json:"content"`
-	Version int    `json:"version"`
+	ID       string            `json:"id"`
+	Content  string            `json:"content"`
+	Version  int               `json:"version"`
+	Metadata map[string]string `json:"metadata,omitempty"`
 }
 
 // Client contains data about a client session.
+//
+// Groups are the groups of the user as asserted by a trusted source, such as an
+// authenticating proxy, and are never shared with other clients.
 type Client struct {
-	Username  string `json:"username"`
-	SessionID string `json:"session_id"`
+	Username  string   `json:"username"`
+	SessionID string   `json:"session_id"`
+	Groups    []string `json:"-"`
+}
+
+// UserID returns the username of the client, which allows authenticators to
+// identify the user behind a session.
+func (c Client) UserID() string {
+	return c.Username
+}
+
+// UserSession returns the session ID of the client, which allows access logs to
+// identify the session behind a request.
+func (c Client) UserSession() string {
+	return c.SessionID
+}
+
+// UserGroups returns the groups of the client, which allows authenticators to
+// grant access based on group membership.
+func (c Client) UserGroups() []string {
+	return c.Groups
 }
 
 // TformCorrection contains fields used to correct a transform.
@@ -154,9 +203,58 @@ type UnsubscriptionMessage struct {
 }
 
 // SubscriptionMessage is an API body encompassing fields identifying a document
-// that has been subscribed as well as its full contents.
+// that has been subscribed as well as its full contents. ReadOnly is set by a
+// client to request a read only subscription, and is set by the server when the
+// subscription is read only. Token may be set by a client in order to
+// authenticate the subscription with a different token to the session.
 type SubscriptionMessage struct {
 	Document DocumentFull `json:"document"`
+	ReadOnly bool         `json:"read_only"`
+	Token    string       `json:"token,omitempty"`
+}
+
+// CreateMessage is an API body encompassing a new document to be created, both
+// the ID and content of the document are optional. Token may be set by a
+// client in order to authenticate the creation with a different token to the
+// session.
+type CreateMessage struct {
+	Document DocumentFull `json:"document"`
+	Token    string       `json:"token,omitempty"`
+}
+
+// DeleteMessage is an API body encompassing fields identifying a document to be
+// deleted, or that has been deleted. Token may be set by a client in order to
+// authenticate the deletion with a different token to the session.
+type DeleteMessage struct {
+	Document DocumentStripped `json:"document"`
+	Token    string           `json:"token,omitempty"`
+}
+
+// RenameMessage is an API body encompassing fields identifying a document to be
+// moved to a new ID, or that has been moved. Token may be set by a client in
+// order to authenticate the rename with a different token to the session.
+type RenameMessage struct {
+	Document DocumentStripped `json:"document"`
+	NewID    string           `json:"new_id"`
+	Token    string           `json:"token,omitempty"`
+}
+
+// ListMessage is an API body encompassing a prefix of document IDs to list sent
+// by a client, and the IDs of the matching documents that the client may read
+// sent back by the server. Token may be set by a client in order to
+// authenticate the listing with a different token to the session.
+type ListMessage struct {
+	Prefix    string   `json:"prefix"`
+	Documents []string `json:"documents"`
+	Token     string   `json:"token,omitempty"`
+}
+
+// AuthMessage is an API body encompassing a token sent by a client in order to
+// authenticate a session, and the resulting identity of the client sent back
+// by the server.
+type AuthMessage struct {
+	Token  string  `json:"token,omitempty"`
+	Client *Client `json:"client,omitempty"`
 }
 
 //------------------------------------------------------------------------------
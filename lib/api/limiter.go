@@ -0,0 +1,459 @@
+/*
+Copyright (c) 2017 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, sub to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jeffail/leaps/lib/api/events"
+	"github.com/Jeffail/leaps/lib/util/service/log"
+	"github.com/Jeffail/leaps/lib/util/service/metrics"
+)
+
+//------------------------------------------------------------------------------
+
+// RateLimitConfig - Configures a token bucket, which allows bursts of up to
+// Burst requests and refills at Rate requests per second.
+type RateLimitConfig struct {
+	Rate  float64 `json:"rate" yaml:"rate"`
+	Burst int     `json:"burst" yaml:"burst"`
+}
+
+// LimitsConfig - Holds rate limits per request type, which are applied to each
+// session and to each user across all of their sessions, and quotas per user.
+// The request type "*" applies to all types that are not listed. A quota of
+// zero is unlimited.
+//
+// Users are only identified for the purpose of limits when their identity is
+// authenticated, by a login session, a trusted proxy or a token resolved by the
+// authenticator. A session with a username chosen by the client is limited as
+// a user of its own, and so reconnecting resets its user limits. The count of
+// documents created by each user is held in memory and resets when the service
+// restarts.
+type LimitsConfig struct {
+	SessionRates            map[string]RateLimitConfig `json:"session_rates" yaml:"session_rates"`
+	UserRates               map[string]RateLimitConfig `json:"user_rates" yaml:"user_rates"`
+	MaxSubscriptions        int                        `json:"max_subscriptions" yaml:"max_subscriptions"`
+	MaxCreatedDocuments     int                        `json:"max_created_documents" yaml:"max_created_documents"`
+	MaxInsertBytesPerMinute int64                      `json:"max_insert_bytes_per_minute" yaml:"max_insert_bytes_per_minute"`
+}
+
+// NewLimitsConfig - Returns a default limits config, which does not limit
+// anything.
+func NewLimitsConfig() LimitsConfig {
+	return LimitsConfig{
+		SessionRates:            map[string]RateLimitConfig{},
+		UserRates:               map[string]RateLimitConfig{},
+		MaxSubscriptions:        0,
+		MaxCreatedDocuments:     0,
+		MaxInsertBytesPerMinute: 0,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// tokenBucket - A token bucket that refills continuously.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst float64, now time.Time) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: now}
+}
+
+// take - Attempts to remove n tokens from the bucket.
+func (b *tokenBucket) take(n float64, now time.Time) bool {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+	if b.tokens < n {
+		return false
+	}
+	b.tokens -= n
+	return true
+}
+
+// limitState - The rate limit buckets and open subscriptions of a session or a
+// user. The state of a session also holds the username it was opened with and
+// the key of the user it is limited as.
+type limitState struct {
+	buckets       map[string]*tokenBucket
+	subscriptions map[string]struct{}
+	insertBytes   *tokenBucket
+	sessions      int
+
+	username string
+	user     string
+}
+
+func newLimitState() *limitState {
+	return &limitState{
+		buckets:       map[string]*tokenBucket{},
+		subscriptions: map[string]struct{}{},
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// Limiter - Applies rate limits and quotas to the requests of clients. Each
+// emitter is wrapped by the limiter, and requests that exceed a rate limit or
+// quota are rejected with an ERR_RATE_LIMIT or ERR_QUOTA error before reaching
+// the handlers of the request type.
+//
+// Open subscriptions are counted per user across all of their sessions, the
+// number of documents created by an authenticated user is counted for the
+// lifetime of the limiter, or for the lifetime of an anonymous session, and the
+// bytes inserted by the transforms of a user are limited per minute, with the
+// allowance refilling continuously. When a session changes its
+// identity with an auth request its limits move to the state of the new user.
+type Limiter struct {
+	config LimitsConfig
+	logger log.Modular
+	stats  metrics.Type
+
+	users       map[string]*limitState
+	sessions    map[string]*limitState
+	createdDocs map[string]int
+	mut         sync.Mutex
+
+	now func() time.Time
+}
+
+// NewLimiter - Create a new limiter.
+func NewLimiter(config LimitsConfig, logger log.Modular, stats metrics.Type) *Limiter {
+	return &Limiter{
+		config:      config,
+		logger:      logger.NewModule(":api:limiter"),
+		stats:       stats,
+		users:       map[string]*limitState{},
+		sessions:    map[string]*limitState{},
+		createdDocs: map[string]int{},
+		now:         time.Now,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// rateFor - Returns the rate limit config of a request type, if there is one.
+func rateFor(rates map[string]RateLimitConfig, reqType string) (RateLimitConfig, bool) {
+	rate, ok := rates[reqType]
+	if !ok {
+		rate, ok = rates["*"]
+	}
+	if ok && rate.Rate <= 0 && rate.Burst <= 0 {
+		return rate, false
+	}
+	return rate, ok
+}
+
+// allowRate - Takes a token from the bucket of a request type, creating the
+// bucket if it does not yet exist. Must be called whilst holding the mutex.
+func (l *Limiter) allowRate(
+	state *limitState, rates map[string]RateLimitConfig, reqType string, now time.Time,
+) bool {
+	rate, ok := rateFor(rates, reqType)
+	if !ok {
+		return true
+	}
+	bucket, exists := state.buckets[reqType]
+	if !exists {
+		burst := float64(rate.Burst)
+		if burst < 1 {
+			burst = 1
+		}
+		bucket = newTokenBucket(rate.Rate, burst, now)
+		state.buckets[reqType] = bucket
+	}
+	return bucket.take(1, now)
+}
+
+// anonymousUser - Prefixes the session ID of a session without an authenticated
+// identity in order to key its user state. Only authenticated usernames are
+// used as keys otherwise, which are not expected to contain a null byte.
+const anonymousUser = "\x00session:"
+
+// sessionUser - Returns the session and user states of a session. Must be
+// called whilst holding the mutex.
+func (l *Limiter) sessionUser(uuid string) (*limitState, *limitState) {
+	session := l.sessions[uuid]
+	if session == nil {
+		return nil, nil
+	}
+	return session, l.users[session.user]
+}
+
+// check - Returns an error if a request exceeds a rate limit or quota.
+func (l *Limiter) check(uuid, reqType string, body []byte) events.TypedError {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	session, user := l.sessionUser(uuid)
+	if session == nil || user == nil {
+		return nil
+	}
+	now := l.now()
+
+	if !l.allowRate(session, l.config.SessionRates, reqType, now) {
+		l.stats.Incr("api.limiter.rate_limited.session."+reqType, 1)
+		return events.NewAPIError(
+			events.ErrRateLimit, fmt.Sprintf("Too many %v requests from this session", reqType),
+		)
+	}
+	if !l.allowRate(user, l.config.UserRates, reqType, now) {
+		l.stats.Incr("api.limiter.rate_limited.user."+reqType, 1)
+		return events.NewAPIError(
+			events.ErrRateLimit, fmt.Sprintf("Too many %v requests from this user", reqType),
+		)
+	}
+
+	switch reqType {
+	case events.Subscribe, events.Create:
+		if l.config.MaxSubscriptions > 0 && len(user.subscriptions) >= l.config.MaxSubscriptions {
+			l.stats.Incr("api.limiter.quota.subscriptions", 1)
+			return events.NewAPIError(
+				events.ErrQuota, fmt.Sprintf("Users may only open %v documents at once", l.config.MaxSubscriptions),
+			)
+		}
+		if reqType == events.Create && l.config.MaxCreatedDocuments > 0 &&
+			l.createdDocs[session.user] >= l.config.MaxCreatedDocuments {
+			l.stats.Incr("api.limiter.quota.created_documents", 1)
+			return events.NewAPIError(
+				events.ErrQuota, fmt.Sprintf("Users may only create %v documents", l.config.MaxCreatedDocuments),
+			)
+		}
+	case events.Transform:
+		if l.config.MaxInsertBytesPerMinute <= 0 {
+			break
+		}
+		var req events.TransformMessage
+		if err := json.Unmarshal(body, &req); err != nil {
+			// Malformed requests are rejected by the handler.
+			break
+		}
+		if user.insertBytes == nil {
+			quota := float64(l.config.MaxInsertBytesPerMinute)
+			user.insertBytes = newTokenBucket(quota/60, quota, now)
+		}
+		if !user.insertBytes.take(float64(len(req.Transform.Insert)), now) {
+			l.stats.Incr("api.limiter.quota.insert_bytes", 1)
+			return events.NewAPIError(
+				events.ErrQuota,
+				fmt.Sprintf("Users may only insert %v bytes per minute", l.config.MaxInsertBytesPerMinute),
+			)
+		}
+	}
+	return nil
+}
+
+//------------------------------------------------------------------------------
+
+// join - Adds a session to the state of a user, carrying its open
+// subscriptions. Must be called whilst holding the mutex.
+func (l *Limiter) join(session *limitState, userKey, uuid string) {
+	user, exists := l.users[userKey]
+	if !exists {
+		user = newLimitState()
+		l.users[userKey] = user
+	}
+	user.sessions++
+	for id := range session.subscriptions {
+		user.subscriptions[uuid+":"+id] = struct{}{}
+	}
+	session.user = userKey
+}
+
+// leave - Removes a session from the state of its user along with its open
+// subscriptions, and removes the state of the user once they have no sessions
+// left. The count of documents created by an anonymous session is also removed,
+// as its key is never used again. Must be called whilst holding the mutex.
+func (l *Limiter) leave(session *limitState, uuid string) {
+	user := l.users[session.user]
+	if user == nil {
+		return
+	}
+	for id := range session.subscriptions {
+		delete(user.subscriptions, uuid+":"+id)
+	}
+	if user.sessions--; user.sessions <= 0 {
+		delete(l.users, session.user)
+	}
+	if strings.HasPrefix(session.user, anonymousUser) {
+		delete(l.createdDocs, session.user)
+	}
+}
+
+// open - Registers a new session of a user.
+func (l *Limiter) open(username, uuid string, authenticated bool) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	session := newLimitState()
+	session.username = username
+	l.sessions[uuid] = session
+
+	userKey := username
+	if !authenticated {
+		userKey = anonymousUser + uuid
+	}
+	l.join(session, userKey, uuid)
+}
+
+// close - Removes a session along with its open subscriptions.
+func (l *Limiter) close(uuid string) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	if session := l.sessions[uuid]; session != nil {
+		l.leave(session, uuid)
+		delete(l.sessions, uuid)
+	}
+}
+
+// identified - Moves a session to the state of the user it has authenticated
+// as. A username that matches the one the session was opened with is not a new
+// identity, as a session without an identifier keeps its chosen username.
+func (l *Limiter) identified(uuid, username string) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	session := l.sessions[uuid]
+	if session == nil || username == session.username {
+		return
+	}
+	l.leave(session, uuid)
+	session.username = username
+	l.join(session, username, uuid)
+}
+
+// subscribed - Records an opened or closed subscription of a session.
+func (l *Limiter) subscribed(uuid, documentID string, open bool) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	session, user := l.sessionUser(uuid)
+	if session == nil || user == nil {
+		return
+	}
+	if open {
+		session.subscriptions[documentID] = struct{}{}
+		user.subscriptions[uuid+":"+documentID] = struct{}{}
+	} else {
+		delete(session.subscriptions, documentID)
+		delete(user.subscriptions, uuid+":"+documentID)
+	}
+}
+
+// created - Counts a document created by the user of a session.
+func (l *Limiter) created(uuid string) {
+	l.mut.Lock()
+	if session := l.sessions[uuid]; session != nil {
+		l.createdDocs[session.user]++
+	}
+	l.mut.Unlock()
+}
+
+//------------------------------------------------------------------------------
+
+// Wrap - Returns an emitter that applies the limits of the limiter to requests
+// received from a session of a user. All components of the session must
+// register their handlers with the returned emitter. The session is only
+// limited as the user when authenticated is true, otherwise it is limited as a
+// user of its own until an auth request identifies it as someone else.
+func (l *Limiter) Wrap(username, uuid string, authenticated bool, e Emitter) Emitter {
+	l.open(username, uuid, authenticated)
+	e.OnClose(func() {
+		l.close(uuid)
+	})
+	e.OnSend(events.Auth, func(body interface{}) bool {
+		if authBody, ok := body.(events.AuthMessage); ok && authBody.Client != nil {
+			l.identified(uuid, authBody.Client.Username)
+		}
+		return true
+	})
+	e.OnSend(events.Subscribe, func(body interface{}) bool {
+		if subBody, ok := body.(events.SubscriptionMessage); ok {
+			l.subscribed(uuid, subBody.Document.ID, true)
+		}
+		return true
+	})
+	e.OnSend(events.Unsubscribe, func(body interface{}) bool {
+		if subBody, ok := body.(events.UnsubscriptionMessage); ok {
+			l.subscribed(uuid, subBody.Document.ID, false)
+		}
+		return true
+	})
+	return &limitedEmitter{
+		Emitter:  e,
+		limiter:  l,
+		uuid:     uuid,
+		handlers: map[string][]RequestHandler{},
+	}
+}
+
+// limitedEmitter - Wraps the request handlers of an emitter with the checks of
+// a limiter. Handlers of the same request type are grouped so that each
+// request is only checked once.
+type limitedEmitter struct {
+	Emitter
+
+	limiter  *Limiter
+	uuid     string
+	handlers map[string][]RequestHandler
+}
+
+// OnReceive - Register a handler for a particular incoming event type.
+func (e *limitedEmitter) OnReceive(reqType string, handler RequestHandler) {
+	handlers, exists := e.handlers[reqType]
+	e.handlers[reqType] = append(handlers, handler)
+	if exists {
+		return
+	}
+	e.Emitter.OnReceive(reqType, func(body []byte) events.TypedError {
+		if err := e.limiter.check(e.uuid, reqType, body); err != nil {
+			return err
+		}
+		var firstErr events.TypedError
+		for _, h := range e.handlers[reqType] {
+			if err := h(body); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		if firstErr == nil && reqType == events.Create {
+			e.limiter.created(e.uuid)
+		}
+		return firstErr
+	})
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,244 @@
+/*
+Copyright (c) 2017 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, sub to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Jeffail/leaps/lib/api/events"
+)
+
+//------------------------------------------------------------------------------
+
+type listEmitter struct {
+	reqHandlers   map[string][]RequestHandler
+	resHandlers   map[string][]ResponseHandler
+	closeHandlers []EventHandler
+}
+
+func newListEmitter() *listEmitter {
+	return &listEmitter{
+		reqHandlers: map[string][]RequestHandler{},
+		resHandlers: map[string][]ResponseHandler{},
+	}
+}
+
+func (d *listEmitter) OnClose(eventHandler EventHandler) {
+	d.closeHandlers = append(d.closeHandlers, eventHandler)
+}
+
+func (d *listEmitter) OnReceive(reqType string, handler RequestHandler) {
+	d.reqHandlers[reqType] = append(d.reqHandlers[reqType], handler)
+}
+
+func (d *listEmitter) OnSend(resType string, handler ResponseHandler) {
+	d.resHandlers[resType] = append(d.resHandlers[resType], handler)
+}
+
+func (d *listEmitter) Send(resType string, body interface{}) error {
+	for _, h := range d.resHandlers[resType] {
+		h(body)
+	}
+	return nil
+}
+
+func (d *listEmitter) receive(reqType, body string) events.TypedError {
+	for _, h := range d.reqHandlers[reqType] {
+		if err := h([]byte(body)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *listEmitter) close() {
+	for _, h := range d.closeHandlers {
+		h()
+	}
+}
+
+func expectErrType(t *testing.T, desc string, err events.TypedError, errType string) {
+	if len(errType) == 0 {
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", desc, err)
+		}
+		return
+	}
+	if err == nil {
+		t.Errorf("%v: expected %v error", desc, errType)
+	} else if err.Type() != errType {
+		t.Errorf("%v: wrong error type: %v != %v", desc, err.Type(), errType)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestLimiterRates(t *testing.T) {
+	conf := NewLimitsConfig()
+	conf.SessionRates["*"] = RateLimitConfig{Rate: 1, Burst: 2}
+	conf.UserRates[events.GlobalMetadata] = RateLimitConfig{Rate: 1, Burst: 1}
+
+	now := time.Unix(1000, 0)
+	limiter := NewLimiter(conf, logger, stats)
+	limiter.now = func() time.Time { return now }
+
+	e1, e2 := newListEmitter(), newListEmitter()
+	l1, l2 := limiter.Wrap("alice", "s1", true, e1), limiter.Wrap("alice", "s2", true, e2)
+
+	pings, globals := 0, 0
+	l1.OnReceive(events.Ping, func(body []byte) events.TypedError {
+		pings++
+		return nil
+	})
+	for _, l := range []Emitter{l1, l2} {
+		// Two handlers of the same type must only be checked once.
+		for i := 0; i < 2; i++ {
+			l.OnReceive(events.GlobalMetadata, func(body []byte) events.TypedError {
+				globals++
+				return nil
+			})
+		}
+	}
+
+	expectErrType(t, "ping 1", e1.receive(events.Ping, `{}`), "")
+	expectErrType(t, "ping 2", e1.receive(events.Ping, `{}`), "")
+	expectErrType(t, "ping 3", e1.receive(events.Ping, `{}`), events.ErrRateLimit)
+	now = now.Add(time.Second)
+	expectErrType(t, "ping 4", e1.receive(events.Ping, `{}`), "")
+	if pings != 3 {
+		t.Errorf("Wrong count of handled pings: %v", pings)
+	}
+
+	expectErrType(t, "global 1", e1.receive(events.GlobalMetadata, `{}`), "")
+	expectErrType(t, "global 2", e2.receive(events.GlobalMetadata, `{}`), events.ErrRateLimit)
+	if globals != 2 {
+		t.Errorf("Wrong count of handled global metadata: %v", globals)
+	}
+}
+
+func TestLimiterQuotas(t *testing.T) {
+	conf := NewLimitsConfig()
+	conf.MaxSubscriptions = 1
+	conf.MaxCreatedDocuments = 1
+	conf.MaxInsertBytesPerMinute = 10
+
+	now := time.Unix(1000, 0)
+	limiter := NewLimiter(conf, logger, stats)
+	limiter.now = func() time.Time { return now }
+
+	e1 := newListEmitter()
+	l1 := limiter.Wrap("alice", "s1", true, e1)
+	subscribe := func(body []byte) events.TypedError {
+		l1.Send(events.Subscribe, events.SubscriptionMessage{Document: events.DocumentFull{ID: "foo"}})
+		return nil
+	}
+	l1.OnReceive(events.Subscribe, subscribe)
+	l1.OnReceive(events.Create, subscribe)
+	l1.OnReceive(events.Transform, func(body []byte) events.TypedError { return nil })
+
+	expectErrType(t, "subscribe 1", e1.receive(events.Subscribe, `{}`), "")
+	expectErrType(t, "subscribe 2", e1.receive(events.Subscribe, `{}`), events.ErrQuota)
+	l1.Send(events.Unsubscribe, events.UnsubscriptionMessage{Document: events.DocumentStripped{ID: "foo"}})
+
+	expectErrType(t, "create 1", e1.receive(events.Create, `{}`), "")
+	e1.close()
+
+	e2 := newListEmitter()
+	l2 := limiter.Wrap("alice", "s2", true, e2)
+	l2.OnReceive(events.Create, func(body []byte) events.TypedError { return nil })
+	l2.OnReceive(events.Transform, func(body []byte) events.TypedError { return nil })
+
+	expectErrType(t, "create 2", e2.receive(events.Create, `{}`), events.ErrQuota)
+
+	insert := func(content string) string {
+		return `{"document":{"id":"foo"},"transform":{"position":0,"insert":"` + content + `","version":1}}`
+	}
+	expectErrType(t, "insert 1", e2.receive(events.Transform, insert("12345678")), "")
+	expectErrType(t, "insert 2", e2.receive(events.Transform, insert("12345")), events.ErrQuota)
+	now = now.Add(time.Second * 30)
+	expectErrType(t, "insert 3", e2.receive(events.Transform, insert("12345")), "")
+}
+
+func TestLimiterIdentities(t *testing.T) {
+	conf := NewLimitsConfig()
+	conf.UserRates[events.Ping] = RateLimitConfig{Rate: 0, Burst: 1}
+	conf.MaxSubscriptions = 1
+	conf.MaxCreatedDocuments = 1
+
+	limiter := NewLimiter(conf, logger, stats)
+	limiter.now = func() time.Time { return time.Unix(1000, 0) }
+
+	wrap := func(username, uuid string, authenticated bool) (*listEmitter, Emitter) {
+		e := newListEmitter()
+		l := limiter.Wrap(username, uuid, authenticated, e)
+		l.OnReceive(events.Ping, func(body []byte) events.TypedError { return nil })
+		l.OnReceive(events.Create, func(body []byte) events.TypedError { return nil })
+		l.OnReceive(events.Subscribe, func(body []byte) events.TypedError {
+			l.Send(events.Subscribe, events.SubscriptionMessage{Document: events.DocumentFull{ID: "foo"}})
+			return nil
+		})
+		return e, l
+	}
+
+	// Chosen usernames do not share limits across sessions.
+	e1, _ := wrap("bob", "s1", false)
+	e2, _ := wrap("bob", "s2", false)
+	expectErrType(t, "anonymous ping 1", e1.receive(events.Ping, `{}`), "")
+	expectErrType(t, "anonymous ping 2", e2.receive(events.Ping, `{}`), "")
+	expectErrType(t, "anonymous ping 3", e1.receive(events.Ping, `{}`), events.ErrRateLimit)
+
+	// An auth response with the chosen username is not a new identity.
+	e2.Send(events.Auth, events.AuthMessage{Client: &events.Client{Username: "bob", SessionID: "s2"}})
+	expectErrType(t, "anonymous ping 4", e2.receive(events.Ping, `{}`), events.ErrRateLimit)
+
+	// Authenticating as a user moves the session and its subscriptions to the
+	// state of that user.
+	e3, _ := wrap("alice", "s3", true)
+	expectErrType(t, "alice create", e3.receive(events.Create, `{}`), "")
+	expectErrType(t, "alice subscribe", e3.receive(events.Subscribe, `{}`), "")
+	expectErrType(t, "alice ping", e3.receive(events.Ping, `{}`), "")
+	e1.Send(events.Auth, events.AuthMessage{Client: &events.Client{Username: "alice", SessionID: "s1"}})
+	expectErrType(t, "identified ping", e1.receive(events.Ping, `{}`), events.ErrRateLimit)
+	expectErrType(t, "identified subscribe", e1.receive(events.Subscribe, `{}`), events.ErrQuota)
+
+	e3.close()
+	e1.close()
+	e4, _ := wrap("alice", "s4", true)
+	expectErrType(t, "alice reconnect create", e4.receive(events.Create, `{}`), events.ErrQuota)
+	e5, _ := wrap("alice", "s5", false)
+	expectErrType(t, "anonymous create", e5.receive(events.Create, `{}`), "")
+
+	// The counts of anonymous sessions are removed when they close.
+	e5.close()
+	limiter.mut.Lock()
+	if _, exists := limiter.createdDocs[anonymousUser+"s5"]; exists {
+		t.Error("Expected created documents of closed anonymous session to be removed")
+	}
+	if exp, act := 1, limiter.createdDocs["alice"]; exp != act {
+		t.Errorf("Wrong created documents of alice: %v != %v", act, exp)
+	}
+	limiter.mut.Unlock()
+}
+
+//------------------------------------------------------------------------------
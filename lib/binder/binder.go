@@ -24,9 +24,12 @@ package binder
 
 import (
 	"fmt"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/Jeffail/leaps/lib/acl"
 	"github.com/Jeffail/leaps/lib/audit"
 	"github.com/Jeffail/leaps/lib/store"
 	"github.com/Jeffail/leaps/lib/text"
@@ -62,15 +65,27 @@ func NewConfig() Config {
 // impl - A Type implementation that contains a single document and acts as a
 // broker between multiple readers, writers and the storage strategy.
 type impl struct {
+	// contentSize is accessed atomically and must remain 64-bit aligned.
+	contentSize int64
+
 	id       string
 	config   Config
-	otBuffer TransformSink
+	otBuffer *text.OTBuffer
 	block    store.Type
 	auditor  audit.Auditor
 
 	log   log.Modular
 	stats metrics.Type
 
+	// Names of users that submitted transforms since the last flush.
+	authors map[string]struct{}
+
+	// The content and store revision of the document as of the last flush,
+	// which the stored document is compared against in order to detect
+	// external changes.
+	content  string
+	revision string
+
 	// Clients
 	clients       []*binderClient
 	subscribeChan chan subscribeRequest
@@ -81,6 +96,8 @@ type impl struct {
 	transformChan chan transformSubmission
 	metadataChan  chan metadataSubmission
 	exitChan      chan *binderClient
+	reloadChan    chan chan<- error
+	removeChan    chan removeRequest
 	errorChan     chan<- Error
 	closedChan    chan struct{}
 }
@@ -102,11 +119,14 @@ func New(
 		auditor:       auditor,
 		log:           log.NewModule(":binder"),
 		stats:         stats,
+		authors:       map[string]struct{}{},
 		clients:       make([]*binderClient, 0),
 		subscribeChan: make(chan subscribeRequest),
 		transformChan: make(chan transformSubmission),
 		metadataChan:  make(chan metadataSubmission),
 		exitChan:      make(chan *binderClient),
+		reloadChan:    make(chan chan<- error),
+		removeChan:    make(chan removeRequest),
 		errorChan:     errorChan,
 		closedChan:    make(chan struct{}),
 	}
@@ -118,6 +138,8 @@ func New(
 		return nil, err
 	}
 
+	binder.content, binder.revision = doc.Content, doc.Revision
+	binder.contentSize = int64(len(doc.Content))
 	binder.otBuffer = text.NewOTBuffer(doc.Content, config.OTBufferConfig)
 	go binder.loop()
 
@@ -132,6 +154,20 @@ func (b *impl) ID() string {
 	return b.id
 }
 
+// ClientCount - Returns the number of clients currently subscribed to the
+// binder.
+func (b *impl) ClientCount() int {
+	b.clientMux.Lock()
+	defer b.clientMux.Unlock()
+	return len(b.clients)
+}
+
+// ContentSize - Returns the size in bytes of the document content as of the
+// most recent flush.
+func (b *impl) ContentSize() int64 {
+	return atomic.LoadInt64(&b.contentSize)
+}
+
 type subscribeRequest struct {
 	metadata   interface{}
 	portalChan chan<- *portalImpl
@@ -193,6 +229,38 @@ func (b *impl) SubscribeReadOnly(metadata interface{}, timeout time.Duration) (P
 	return nil, ErrTimeout
 }
 
+// Reload - Flushes the binder, which merges any changes made to the stored
+// document outside of the binder and broadcasts them to all clients.
+func (b *impl) Reload(timeout time.Duration) error {
+	errChan := make(chan error, 1)
+	select {
+	case b.reloadChan <- errChan:
+	case <-b.closedChan:
+		return ErrClosed
+	case <-time.After(timeout):
+		return ErrTimeout
+	}
+	select {
+	case err := <-errChan:
+		return err
+	case <-time.After(timeout):
+	}
+	return ErrTimeout
+}
+
+// Remove - Flushes the binder and then removes the stored document with the
+// remove function, shutting down the binder if it succeeds. The binder does not
+// process transforms during the removal.
+func (b *impl) Remove(remove func() error, newID string) error {
+	errChan := make(chan error, 1)
+	select {
+	case b.removeChan <- removeRequest{remove: remove, newID: newID, errChan: errChan}:
+	case <-b.closedChan:
+		return ErrClosed
+	}
+	return <-errChan
+}
+
 // Close - Close the binder, before closing the client channels the binder will
 // flush changes and store the document.
 func (b *impl) Close() {
@@ -242,7 +310,9 @@ func (b *impl) processSubscriber(request subscribeRequest) error {
 	case request.portalChan <- &portal:
 		b.stats.Incr("binder.subscribed_clients", 1)
 		b.log.Debugf("Subscribed new client %v\n", request.metadata)
+		b.clientMux.Lock()
 		b.clients = append(b.clients, &client)
+		b.clientMux.Unlock()
 	case <-time.After(time.Duration(b.config.ClientKickPeriodMS) * time.Millisecond):
 		/* We're not bothered if you suck, you just don't get enrolled, and this isn't
 		 * considered an error. Deal with it.
@@ -299,6 +369,9 @@ func (b *impl) processTransform(request transformSubmission) {
 		b.sendClientError(request.errorChan, err)
 		return
 	}
+	if author := authorName(request.client.metadata); len(author) > 0 {
+		b.authors[author] = struct{}{}
+	}
 	// If we have an auditor then send it our transforms.
 	if b.auditor != nil {
 		b.auditor.OnTransform(dispatch)
@@ -310,21 +383,26 @@ func (b *impl) processTransform(request transformSubmission) {
 		b.stats.Incr("binder.send_client_version.blocked", 1)
 	}
 	b.stats.Incr("binder.process_job.success", 1)
+	b.broadcastTransform(dispatch, request.client)
+}
 
+// broadcastTransform - Sends a transform out to all clients other than the
+// client it originated from, which is nil for transforms that do not originate
+// from a client.
+func (b *impl) broadcastTransform(dispatch text.OTransform, origin *binderClient) {
 	clientKickPeriod := (time.Duration(b.config.ClientKickPeriodMS) * time.Millisecond)
 
 	wg := sync.WaitGroup{}
 
 	clients := b.clients
-	wg.Add(len(clients) - 1)
-
 	for i := 0; i < len(clients); i++ {
 		client := clients[i]
 
 		// Skip sends for client from which the message came
-		if client == request.client {
+		if client == origin {
 			continue
 		}
+		wg.Add(1)
 		go func(c *binderClient) {
 			select {
 			case c.transformChan <- dispatch:
@@ -385,31 +463,121 @@ func (b *impl) processMetadata(request metadataSubmission) {
 	wg.Wait()
 }
 
+// authorName - Returns the name of the user behind client metadata, or an empty
+// string if the user cannot be determined.
+func authorName(metadata interface{}) string {
+	switch t := metadata.(type) {
+	case acl.UserIdentity:
+		return t.UserID()
+	case fmt.Stringer:
+		return t.String()
+	case string:
+		return t
+	}
+	return ""
+}
+
+// maxFlushAttempts - The number of times a flush is attempted when the stored
+// document is modified concurrently.
+const maxFlushAttempts = 3
+
 // flush - Obtain latest document content, flush current changes to document,
-// and store the updated version.
+// and store the updated version. If the stored document was changed since the
+// last flush then the change is merged into the binder as a transform and
+// broadcast to all clients.
 func (b *impl) flush() (store.Document, error) {
-	var (
-		errStore, errFlush error
-		changed            bool
-		doc                store.Document
-	)
-	doc, errStore = b.block.Read(b.id)
-	if errStore != nil {
-		b.stats.Incr("binder.block_fetch.error", 1)
-		return doc, errStore
-	}
-	changed, errFlush = b.otBuffer.FlushTransforms(&doc.Content, b.config.RetentionPeriodS)
-	if changed {
-		errStore = b.block.Update(doc)
-	}
-	if errStore != nil || errFlush != nil {
-		b.stats.Incr("binder.flush.error", 1)
-		return doc, fmt.Errorf("%v, %v", errFlush, errStore)
-	}
-	if changed {
-		b.stats.Incr("binder.flush.success", 1)
+	for attempt := 1; ; attempt++ {
+		doc, err := b.block.Read(b.id)
+		if err != nil {
+			b.stats.Incr("binder.block_fetch.error", 1)
+			return doc, err
+		}
+
+		external := doc.Content != b.content &&
+			(len(doc.Revision) == 0 || doc.Revision != b.revision)
+		if !external && !b.otBuffer.IsDirty() {
+			b.revision = doc.Revision
+			return doc, nil
+		}
+
+		// Changes are made to a copy of the buffer so that they can be
+		// discarded if the store is modified before our update.
+		otBuffer := b.otBuffer.Copy()
+
+		var externalTform *text.OTransform
+		if external {
+			tform, _ := text.DiffTransform(b.content, doc.Content)
+			tform.Version = otBuffer.GetVersion() - len(otBuffer.Unapplied) + 1
+			dispatch, _, err := otBuffer.PushMergeTransform(tform)
+			if err != nil {
+				b.stats.Incr("binder.flush.merge.error", 1)
+				return doc, fmt.Errorf("failed to merge external change: %v", err)
+			}
+			externalTform = &dispatch
+		}
+
+		content := b.content
+		if _, err = otBuffer.FlushTransforms(&content, b.config.RetentionPeriodS); err != nil {
+			b.stats.Incr("binder.flush.error", 1)
+			return doc, err
+		}
+
+		if content != doc.Content {
+			update := store.Document{
+				ID:       b.id,
+				Content:  content,
+				Revision: doc.Revision,
+				Metadata: doc.Metadata,
+			}
+			if authored, ok := b.block.(store.AuthoredUpdater); ok {
+				authors := make([]string, 0, len(b.authors))
+				for author := range b.authors {
+					authors = append(authors, author)
+				}
+				sort.Strings(authors)
+				err = authored.UpdateAuthored(update, authors)
+			} else {
+				err = b.block.Update(update)
+			}
+			if err == store.ErrRevisionMismatch && attempt < maxFlushAttempts {
+				b.stats.Incr("binder.flush.revision_mismatch", 1)
+				continue
+			}
+			if err != nil {
+				b.stats.Incr("binder.flush.error", 1)
+				return doc, err
+			}
+			b.authors = map[string]struct{}{}
+			b.stats.Incr("binder.flush.success", 1)
+		}
+
+		b.otBuffer = otBuffer
+		b.content = content
+		atomic.StoreInt64(&b.contentSize, int64(len(content)))
+
+		// The revision of our update is only known by reading it back, if the
+		// content differs then another change has already occurred and is
+		// merged during the next flush.
+		b.revision = doc.Revision
+		if content != doc.Content {
+			b.revision = ""
+			if stored, err := b.block.Read(b.id); err == nil && stored.Content == content {
+				b.revision = stored.Revision
+			}
+		}
+
+		if externalTform != nil {
+			b.stats.Incr("binder.flush.merge.success", 1)
+			b.log.Infof("Merged external change to %v\n", b.id)
+			b.broadcastTransform(*externalTform, nil)
+		}
+		return store.Document{
+			ID:       b.id,
+			Content:  content,
+			Revision: b.revision,
+			Metadata: doc.Metadata,
+		}, nil
 	}
-	return doc, nil
 }
 
 //------------------------------------------------------------------------------
@@ -468,6 +636,32 @@ func (b *impl) loop() {
 				b.log.Infoln("Exit channel closed, shutting down")
 				running = false
 			}
+		case errChan := <-b.reloadChan:
+			_, err := b.flush()
+			errChan <- err
+			if err != nil {
+				b.log.Errorf("Reload error: %v, shutting down\n", err)
+				b.errorChan <- Error{ID: b.id, Err: err}
+				running = false
+			}
+		case request := <-b.removeChan:
+			if _, err := b.flush(); err != nil {
+				request.errChan <- err
+				b.log.Errorf("Flush error: %v, shutting down\n", err)
+				b.errorChan <- Error{ID: b.id, Err: err}
+				running = false
+			} else if err = request.remove(); err != nil {
+				request.errChan <- err
+			} else {
+				b.log.Infoln("Document removed, shutting down")
+				b.clientMux.Lock()
+				for _, client := range b.clients {
+					client.removed, client.newID = true, request.newID
+				}
+				b.clientMux.Unlock()
+				request.errChan <- nil
+				running = false
+			}
 		case <-flushTimer.C:
 			if b.otBuffer.IsDirty() {
 				if _, err := b.flush(); err != nil {
@@ -480,11 +674,10 @@ func (b *impl) loop() {
 		case <-closeTimer.C:
 			if 0 == len(b.clients) {
 				b.log.Infoln("Binder inactive, requesting shutdown")
-				// Send graceful close request
+				// Send graceful close request, which is repeated if ignored
 				b.errorChan <- Error{ID: b.id, Err: nil}
-			} else {
-				closeTimer.Reset(closePeriod)
 			}
+			closeTimer.Reset(closePeriod)
 		}
 		if !running {
 			flushTimer.Stop()
@@ -492,8 +685,10 @@ func (b *impl) loop() {
 
 			b.stats.Incr("binder.closing", 1)
 			b.log.Infoln("Closing, shutting down client channels")
+			b.clientMux.Lock()
 			oldClients := b.clients
 			b.clients = make([]*binderClient, 0)
+			b.clientMux.Unlock()
 			for _, client := range oldClients {
 				close(client.transformChan)
 				close(client.metadataChan)
@@ -27,6 +27,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"reflect"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -70,6 +71,20 @@ func (s *testStore) Read(id string) (store.Document, error) {
 	return doc, nil
 }
 
+// authoredStore - Records the authors of each update.
+type authoredStore struct {
+	testStore
+	authors [][]string
+}
+
+// UpdateAuthored - Store document in memory and record the authors.
+func (s *authoredStore) UpdateAuthored(doc store.Document, authors []string) error {
+	s.mutex.Lock()
+	s.authors = append(s.authors, authors)
+	s.mutex.Unlock()
+	return s.Update(doc)
+}
+
 //--------------------------------------------------------------------------------------------------
 
 func TestFailedInitialFlush(t *testing.T) {
@@ -748,3 +763,308 @@ func TestBinderStories(t *testing.T) {
 		binder.Close()
 	}
 }
+
+func TestAuthoredUpdates(t *testing.T) {
+	errChan := make(chan Error)
+	doc := store.NewDocument("hello world")
+	logger, stats := loggerAndStats()
+
+	block := &authoredStore{testStore: testStore{documents: map[string]store.Document{doc.ID: doc}}}
+
+	binder, err := New(doc.ID, block, NewConfig(), errChan, logger, stats, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	portal1, err := binder.Subscribe("alice", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	portal2, err := binder.Subscribe("bob", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for range portal2.TransformReadChan() {
+		}
+	}()
+	go func() {
+		for range portal1.TransformReadChan() {
+		}
+	}()
+
+	if _, err = portal2.SendTransform(text.OTransform{Version: 2, Insert: "b"}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = portal1.SendTransform(text.OTransform{Version: 3, Insert: "a"}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	portal1.Exit(time.Second)
+	portal2.Exit(time.Second)
+	binder.Close()
+
+	block.mutex.RLock()
+	defer block.mutex.RUnlock()
+
+	if exp, act := [][]string{{"alice", "bob"}}, block.authors; !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong authors recorded: %v != %v", exp, act)
+	}
+}
+
+func TestMetadataPreserved(t *testing.T) {
+	errChan := make(chan Error)
+	logger, stats := loggerAndStats()
+
+	metadata := map[string]string{"title": "Hello", "owner": "alice"}
+	block := store.NewMemory()
+	if err := block.Create(store.Document{ID: "foo", Content: "hello world", Metadata: metadata}); err != nil {
+		t.Fatal(err)
+	}
+
+	binder, err := New("foo", block, NewConfig(), errChan, logger, stats, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	portal, err := binder.Subscribe("alice", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if act := portal.Document().Metadata; !reflect.DeepEqual(metadata, act) {
+		t.Errorf("Wrong portal metadata: %v != %v", metadata, act)
+	}
+
+	go func() {
+		for range portal.TransformReadChan() {
+		}
+	}()
+	if _, err = portal.SendTransform(text.OTransform{Version: 2, Insert: "oh "}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	portal.Exit(time.Second)
+	binder.Close()
+
+	doc, err := block.Read("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := "oh hello world", doc.Content; exp != act {
+		t.Errorf("Wrong content: %v != %v", exp, act)
+	}
+	if act := doc.Metadata; !reflect.DeepEqual(metadata, act) {
+		t.Errorf("Wrong stored metadata: %v != %v", metadata, act)
+	}
+}
+
+func TestExternalChanges(t *testing.T) {
+	errChan := make(chan Error)
+	doc := store.NewDocument("hello world")
+	logger, stats := loggerAndStats()
+
+	block := store.NewMemory()
+	if err := block.Create(doc); err != nil {
+		t.Fatal(err)
+	}
+
+	binder, err := New(doc.ID, block, NewConfig(), errChan, logger, stats, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for e := range errChan {
+			t.Errorf("From error channel: %v", e.Err)
+		}
+	}()
+
+	portal1, err := binder.Subscribe("alice", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = portal1.SendTransform(text.OTransform{Version: 2, Position: 11, Insert: "!"}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// Modify the stored document without the binder being aware.
+	if err = block.Update(store.Document{ID: doc.ID, Content: "Hello world"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new subscription causes a flush, which merges the external change.
+	portal2, err := binder.Subscribe("bob", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := "Hello world!", portal2.Document().Content; exp != act {
+		t.Errorf("Wrong merged content: %v != %v", exp, act)
+	}
+
+	select {
+	case tform := <-portal1.TransformReadChan():
+		if tform.Position != 0 || tform.Delete != 1 || tform.Insert != "H" || tform.Version != 3 {
+			t.Errorf("Wrong external transform: %v", tform)
+		}
+	case <-time.After(time.Second):
+		t.Error("Timed out waiting for external transform")
+	}
+
+	stored, err := block.Read(doc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := "Hello world!", stored.Content; exp != act {
+		t.Errorf("Wrong stored content: %v != %v", exp, act)
+	}
+
+	portal1.Exit(time.Second)
+	portal2.Exit(time.Second)
+	binder.Close()
+}
+
+func TestLargeExternalChanges(t *testing.T) {
+	errChan := make(chan Error)
+	doc := store.NewDocument("hello world")
+	logger, stats := loggerAndStats()
+
+	block := store.NewMemory()
+	if err := block.Create(doc); err != nil {
+		t.Fatal(err)
+	}
+
+	conf := NewConfig()
+	binder, err := New(doc.ID, block, conf, errChan, logger, stats, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for e := range errChan {
+			t.Errorf("From error channel: %v", e.Err)
+		}
+	}()
+
+	portal1, err := binder.Subscribe("alice", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = portal1.SendTransform(text.OTransform{Version: 2, Position: 11, Insert: "!"}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// An external change larger than the transform limit of clients.
+	large := strings.Repeat("a", int(conf.OTBufferConfig.MaxTransformLength)*2)
+	if err = block.Update(store.Document{ID: doc.ID, Content: large + "hello world"}); err != nil {
+		t.Fatal(err)
+	}
+
+	portal2, err := binder.Subscribe("bob", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := large+"hello world!", portal2.Document().Content; exp != act {
+		t.Errorf("Wrong merged content length: %v != %v", len(exp), len(act))
+	}
+
+	select {
+	case tform := <-portal1.TransformReadChan():
+		if tform.Position != 0 || tform.Insert != large {
+			t.Errorf("Wrong external transform: %v %v", tform.Position, len(tform.Insert))
+		}
+	case <-time.After(time.Second):
+		t.Error("Timed out waiting for external transform")
+	}
+
+	// Client transforms remain limited.
+	if _, err = portal2.SendTransform(text.OTransform{Version: 4, Position: 0, Insert: large}, time.Second); err == nil {
+		t.Error("Expected error from oversized client transform")
+	}
+
+	stored, err := block.Read(doc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := large+"hello world!", stored.Content; exp != act {
+		t.Errorf("Wrong stored content length: %v != %v", len(exp), len(act))
+	}
+
+	portal1.Exit(time.Second)
+	portal2.Exit(time.Second)
+	binder.Close()
+}
+
+func TestRemove(t *testing.T) {
+	errChan := make(chan Error)
+	doc := store.NewDocument("hello world")
+	logger, stats := loggerAndStats()
+
+	block := store.NewMemory().(*store.Memory)
+	if err := block.Create(doc); err != nil {
+		t.Fatal(err)
+	}
+
+	binder, err := New(doc.ID, block, NewConfig(), errChan, logger, stats, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for e := range errChan {
+			t.Errorf("From error channel: %v", e.Err)
+		}
+	}()
+
+	portal, err := binder.Subscribe("alice", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = portal.SendTransform(text.OTransform{Version: 2, Position: 11, Insert: "!"}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed removal leaves the binder running.
+	if err = binder.Remove(func() error {
+		return block.Rename("nope", "bar")
+	}, "bar"); err != store.ErrDocumentNotExist {
+		t.Errorf("Wrong error from failed removal: %v", err)
+	}
+	if _, err = portal.SendTransform(text.OTransform{Version: 3, Position: 0, Insert: "oh "}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = binder.Remove(func() error {
+		return block.Rename(doc.ID, "bar")
+	}, "bar"); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case _, open := <-portal.TransformReadChan():
+		if open {
+			t.Error("Expected closed portal")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for closed portal")
+	}
+	if removed, newID := portal.Removed(); !removed || newID != "bar" {
+		t.Errorf("Wrong removal: %v %v", removed, newID)
+	}
+
+	stored, err := block.Read("bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := "oh hello world!", stored.Content; exp != act {
+		t.Errorf("Wrong stored content: %v != %v", exp, act)
+	}
+
+	if err = binder.Remove(func() error {
+		t.Error("Remove called on closed binder")
+		return nil
+	}, ""); err != ErrClosed {
+		t.Errorf("Wrong error from closed binder: %v", err)
+	}
+	binder.Close()
+}
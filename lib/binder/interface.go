@@ -70,6 +70,10 @@ type Portal interface {
 	// ReleaseDocument - Releases the cached document.
 	ReleaseDocument()
 
+	// ReadOnly - Returns whether this portal is read only, in which case
+	// transforms cannot be submitted through it.
+	ReadOnly() bool
+
 	// TransformReadChan - Get the channel for reading transforms from other
 	// binder clients.
 	TransformReadChan() <-chan text.OTransform
@@ -92,6 +96,11 @@ type Portal interface {
 	// will block until acknowledged by the binder. Therefore, you may specify a
 	// timeout.
 	Exit(timeout time.Duration)
+
+	// Removed - Returns whether the portal was closed because its document was
+	// deleted or renamed, along with the new ID of a renamed document. This is
+	// only known once the read channels of the portal are closed.
+	Removed() (removed bool, newID string)
 }
 
 // Type - Provides thread safe implementations of binder and session creation.
@@ -99,6 +108,14 @@ type Type interface {
 	// ID - Returns the ID of this binder.
 	ID() string
 
+	// ClientCount - Returns the number of clients currently subscribed to this
+	// binder.
+	ClientCount() int
+
+	// ContentSize - Returns the size in bytes of the document content held by
+	// this binder.
+	ContentSize() int64
+
 	// Subscribe - Register a new client as an editor of this binder document.
 	// Metadata can be provided in order to identify submissions from the
 	// client.
@@ -108,6 +125,19 @@ type Type interface {
 	// binder document.
 	SubscribeReadOnly(metadata interface{}, timeout time.Duration) (Portal, error)
 
+	// Reload - Checks the stored document for changes made outside of this
+	// binder and merges them into the binder, broadcasting them to all
+	// clients.
+	Reload(timeout time.Duration) error
+
+	// Remove - Flushes the binder and then calls remove, which is expected to
+	// delete the stored document or move it to newID. If remove succeeds then
+	// the binder shuts down and its clients are told that the document was
+	// deleted, or renamed when newID is set, otherwise the binder keeps
+	// running and the error is returned. Returns ErrClosed if the binder was
+	// already closed, in which case remove is not called.
+	Remove(remove func() error, newID string) error
+
 	// Close - Close the binder and shut down all clients, also flushes and
 	// cleans up the document.
 	Close()
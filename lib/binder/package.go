@@ -33,4 +33,5 @@ import "errors"
 // Errors used throughout the package.
 var (
 	ErrTimeout = errors.New("timed out")
+	ErrClosed  = errors.New("binder is closed")
 )
@@ -77,6 +77,11 @@ func (p *portalImpl) ReleaseDocument() {
 	p.document.Content = ""
 }
 
+// ReadOnly - Returns whether this portal is read only.
+func (p *portalImpl) ReadOnly() bool {
+	return p.transformSndChan == nil
+}
+
 // TransformReadChan - Returns a channel for receiving live transforms from the
 // binder.
 func (p *portalImpl) TransformReadChan() <-chan text.OTransform {
@@ -133,4 +138,10 @@ func (p *portalImpl) Exit(timeout time.Duration) {
 	}
 }
 
+// Removed - Returns whether the portal was closed because its document was
+// deleted or renamed, and the new ID of a renamed document.
+func (p *portalImpl) Removed() (bool, string) {
+	return p.client.removed, p.client.newID
+}
+
 //------------------------------------------------------------------------------
@@ -78,6 +78,18 @@ type binderClient struct {
 
 	transformChan chan<- text.OTransform
 	metadataChan  chan<- ClientMetadata
+
+	// Set before the channels are closed when the document was removed.
+	removed bool
+	newID   string
+}
+
+// removeRequest - A request for a binder to remove its document from the store
+// and shut down, see Type.Remove.
+type removeRequest struct {
+	remove  func() error
+	newID   string
+	errChan chan<- error
 }
 
 //------------------------------------------------------------------------------
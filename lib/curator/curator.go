@@ -25,9 +25,11 @@ package curator
 import (
 	"errors"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/Jeffail/leaps/lib/accesslog"
 	"github.com/Jeffail/leaps/lib/acl"
 	"github.com/Jeffail/leaps/lib/audit"
 	"github.com/Jeffail/leaps/lib/binder"
@@ -39,15 +41,25 @@ import (
 //------------------------------------------------------------------------------
 
 // Config - Holds configuration options for a curator.
+//
+// MaxOpenBinders and MaxBufferedBytes cap the number of binders that may be
+// open at once and the total size of the documents they hold, a value of zero
+// disables the cap. When a cap is reached binders without any subscribed
+// clients are closed, least recently used first, in order to make room. If
+// there is still no room the new binder is rejected with ErrBinderCapacity.
 type Config struct {
-	BinderConfig binder.Config `json:"binder" yaml:"binder"`
+	MaxOpenBinders   int           `json:"max_open_binders" yaml:"max_open_binders"`
+	MaxBufferedBytes int64         `json:"max_buffered_bytes" yaml:"max_buffered_bytes"`
+	BinderConfig     binder.Config `json:"binder" yaml:"binder"`
 }
 
 // NewConfig - Returns a fully defined curator configuration with the default
 // values for each field.
 func NewConfig() Config {
 	return Config{
-		BinderConfig: binder.NewConfig(),
+		MaxOpenBinders:   0,
+		MaxBufferedBytes: 0,
+		BinderConfig:     binder.NewConfig(),
 	}
 }
 
@@ -55,24 +67,43 @@ func NewConfig() Config {
 
 // Errors for the Curator type.
 var (
-	ErrBinderNotFound = errors.New("binder was not found")
+	ErrBinderNotFound    = errors.New("binder was not found")
+	ErrStoreNotSupported = errors.New("document store does not support this operation")
+	ErrBinderCapacity    = errors.New("too many documents are currently open, try again later")
+	ErrDocumentBusy      = errors.New("document is being deleted or renamed, try again later")
 )
 
+// binderUsage - Tracks how a binder is being used by the curator in order to
+// determine which binders can be evicted.
+type binderUsage struct {
+	lastAccess time.Time
+
+	// pending - The number of subscriptions currently being made to the
+	// binder, a binder with pending subscriptions must not be evicted.
+	pending int
+}
+
 // Impl - The underlying implementation of the curator type. Creates and manages
 // the entire lifecycle of binders internally.
 type Impl struct {
-	config   Config
-	store    store.Type
-	auth     acl.Authenticator
-	auditors AuditorContainer
+	config    Config
+	store     store.Type
+	auth      acl.Authenticator
+	auditors  AuditorContainer
+	accessLog accesslog.Sink
 
 	log   log.Modular
 	stats metrics.Type
 
 	// Binders
 	openBinders map[string]binder.Type
+	binderUsage map[string]*binderUsage
 	binderMutex sync.RWMutex
 
+	// busy - The IDs of documents being deleted or renamed, which cannot be
+	// opened, created or evicted until the store has finished.
+	busy map[string]struct{}
+
 	// Control channels
 	errorChan  chan binder.Error
 	closeChan  chan struct{}
@@ -97,6 +128,8 @@ func New(
 		auth:        auth,
 		auditors:    auditors,
 		openBinders: make(map[string]binder.Type),
+		binderUsage: make(map[string]*binderUsage),
+		busy:        make(map[string]struct{}),
 		errorChan:   make(chan binder.Error, 10),
 		closeChan:   make(chan struct{}),
 		closedChan:  make(chan struct{}),
@@ -106,6 +139,43 @@ func New(
 	return &curator, nil
 }
 
+// SetAccessLog - Records the access decision of each attempt to open, create,
+// delete, rename or list a document to a sink. Must be called before the curator is used.
+func (c *Impl) SetAccessLog(sink accesslog.Sink) {
+	c.accessLog = sink
+}
+
+// authorise - Returns the access level granted to a client for a document, and
+// records the decision to the access log when one is set. When the required
+// level is CreateAccess the documentID is the ID of the document being created.
+// The operation is allowed when the required level is granted, which may be
+// lower than the level requested by the client.
+func (c *Impl) authorise(
+	operation string,
+	userMetadata interface{},
+	token, documentID string,
+	requested, required acl.AccessLevel,
+) acl.AccessLevel {
+	create := required == acl.CreateAccess
+	if c.accessLog == nil {
+		if create {
+			return acl.AuthenticateCreate(c.auth, userMetadata, token, documentID)
+		}
+		return c.auth.Authenticate(userMetadata, token, documentID)
+	}
+	var granted acl.AccessLevel
+	var by string
+	if create {
+		granted, by = acl.ExplainCreate(c.auth, userMetadata, token, documentID)
+	} else {
+		granted, by = acl.Explain(c.auth, userMetadata, token, documentID)
+	}
+	entry := accesslog.NewEntry(operation, userMetadata, documentID, requested, granted, by)
+	entry.Allowed = granted >= required
+	c.accessLog.Record(entry)
+	return granted
+}
+
 // Close - Shut the curator and all subsequent binders down. This call blocks
 // until the shut down is finished, and you must ensure that this curator cannot
 // be accessed after closing.
@@ -137,9 +207,16 @@ func (c *Impl) loop() {
 				c.log.Infof("Binder (%v) has requested shutdown\n", err.ID)
 			}
 			c.binderMutex.Lock()
+			if _, busy := c.busy[err.ID]; busy && err.Err == nil {
+				// The binder shuts down by itself once its document is
+				// removed, otherwise it repeats the request.
+				c.binderMutex.Unlock()
+				continue
+			}
 			if b, ok := c.openBinders[err.ID]; ok {
 				b.Close()
 				delete(c.openBinders, err.ID)
+				delete(c.binderUsage, err.ID)
 				c.log.Infof("Binder (%v) was closed\n", err.ID)
 				c.stats.Incr("curator.binder_shutdown.success", 1)
 				c.stats.Decr("curator.open_binders", 1)
@@ -175,6 +252,173 @@ func (c *Impl) newBinder(id string) (binder.Type, error) {
 	)
 }
 
+// evictBinder - Closes the least recently used binder that has no subscribed
+// clients or pending subscriptions, ignoring the binder of the document being
+// admitted. Returns false if no binder could be evicted. Must be called whilst
+// holding the binder mutex.
+func (c *Impl) evictBinder(admittingID string) bool {
+	var (
+		evictID   string
+		evictTime time.Time
+		found     bool
+	)
+	for id, b := range c.openBinders {
+		if _, busy := c.busy[id]; busy || id == admittingID {
+			continue
+		}
+		var lastAccess time.Time
+		if usage, ok := c.binderUsage[id]; ok {
+			if usage.pending > 0 {
+				continue
+			}
+			lastAccess = usage.lastAccess
+		}
+		if b.ClientCount() > 0 {
+			continue
+		}
+		if !found || lastAccess.Before(evictTime) {
+			evictID, evictTime, found = id, lastAccess, true
+		}
+	}
+	if !found {
+		return false
+	}
+	c.log.Infof("Evicting inactive binder (%v) to make room\n", evictID)
+	c.stats.Incr("curator.binder_evicted", 1)
+	c.closeBinder(evictID)
+	return true
+}
+
+// bufferedBytes - Returns the total size of the documents held by open
+// binders. Must be called whilst holding the binder mutex.
+func (c *Impl) bufferedBytes() int64 {
+	var total int64
+	for _, b := range c.openBinders {
+		total += b.ContentSize()
+	}
+	return total
+}
+
+// admitBinderCount - Ensures there is room for another open binder, evicting
+// inactive binders if necessary. Must be called whilst holding the binder
+// mutex.
+func (c *Impl) admitBinderCount() error {
+	if c.config.MaxOpenBinders <= 0 {
+		return nil
+	}
+	for len(c.openBinders) >= c.config.MaxOpenBinders {
+		if !c.evictBinder("") {
+			c.stats.Incr("curator.admission.rejected", 1)
+			c.log.Warnf("Rejecting new binder, %v binders are open\n", len(c.openBinders))
+			return ErrBinderCapacity
+		}
+	}
+	return nil
+}
+
+// admitBinderSize - Ensures there is room for a newly opened binder to be
+// added without exceeding the buffered bytes cap, evicting inactive binders if
+// necessary. Must be called whilst holding the binder mutex.
+func (c *Impl) admitBinderSize(newBinder binder.Type) error {
+	if c.config.MaxBufferedBytes <= 0 {
+		return nil
+	}
+	for c.bufferedBytes()+newBinder.ContentSize() > c.config.MaxBufferedBytes {
+		if !c.evictBinder(newBinder.ID()) {
+			c.stats.Incr("curator.admission.rejected", 1)
+			c.log.Warnf(
+				"Rejecting new binder (%v), %v bytes are already buffered\n",
+				newBinder.ID(), c.bufferedBytes(),
+			)
+			return ErrBinderCapacity
+		}
+	}
+	return nil
+}
+
+// addBinder - Adds a newly opened binder to the curator after checking that it
+// fits within the configured caps. If the binder is rejected then it is closed
+// and an error is returned. Must be called whilst holding the binder mutex.
+func (c *Impl) addBinder(newBinder binder.Type) error {
+	if err := c.admitBinderSize(newBinder); err != nil {
+		newBinder.Close()
+		return err
+	}
+	c.openBinders[newBinder.ID()] = newBinder
+	c.stats.Incr("curator.open_binders", 1)
+	return nil
+}
+
+// acquireBinder - Returns the open binder of a document, opening a new binder
+// if necessary. The binder is protected from eviction until releaseBinder is
+// called. Must be called whilst holding the binder mutex.
+func (c *Impl) acquireBinder(documentID string) (binder.Type, error) {
+	if _, busy := c.busy[documentID]; busy {
+		return nil, ErrDocumentBusy
+	}
+	openBinder, ok := c.openBinders[documentID]
+	if !ok {
+		if err := c.admitBinderCount(); err != nil {
+			return nil, err
+		}
+		var err error
+		if openBinder, err = c.newBinder(documentID); err != nil {
+			return nil, err
+		}
+		if err = c.addBinder(openBinder); err != nil {
+			return nil, err
+		}
+	}
+	c.markPending(documentID)
+	return openBinder, nil
+}
+
+// markPending - Records access to a binder and flags that a subscription is
+// pending. Must be called whilst holding the binder mutex.
+func (c *Impl) markPending(documentID string) {
+	usage, ok := c.binderUsage[documentID]
+	if !ok {
+		usage = &binderUsage{}
+		c.binderUsage[documentID] = usage
+	}
+	usage.lastAccess = time.Now()
+	usage.pending++
+}
+
+// markBusy - Flags documents as being deleted or renamed, or returns
+// ErrDocumentBusy if any of them already are. Must be called whilst holding the
+// binder mutex.
+func (c *Impl) markBusy(documentIDs ...string) error {
+	for _, id := range documentIDs {
+		if _, busy := c.busy[id]; busy {
+			return ErrDocumentBusy
+		}
+	}
+	for _, id := range documentIDs {
+		c.busy[id] = struct{}{}
+	}
+	return nil
+}
+
+// releaseBusy - Flags that the deletion or renaming of documents has finished.
+func (c *Impl) releaseBusy(documentIDs ...string) {
+	c.binderMutex.Lock()
+	for _, id := range documentIDs {
+		delete(c.busy, id)
+	}
+	c.binderMutex.Unlock()
+}
+
+// releaseBinder - Flags that a pending subscription to a binder has finished,
+// allowing it to be evicted once it has no clients.
+func (c *Impl) releaseBinder(documentID string) {
+	c.binderMutex.Lock()
+	if usage, ok := c.binderUsage[documentID]; ok && usage.pending > 0 {
+		usage.pending--
+	}
+	c.binderMutex.Unlock()
+}
+
 //------------------------------------------------------------------------------
 
 // EditDocument - Locates or creates a Binder for an existing document and
@@ -185,7 +429,9 @@ func (c *Impl) EditDocument(
 ) (binder.Portal, error) {
 	c.log.Debugf("finding document %v, with userMetadata %v token %v\n", documentID, userMetadata, token)
 
-	if c.auth.Authenticate(userMetadata, token, documentID) < acl.EditAccess {
+	if c.authorise(
+		"edit", userMetadata, token, documentID, acl.EditAccess, acl.EditAccess,
+	) < acl.EditAccess {
 		c.stats.Incr("curator.edit.rejected_client", 1)
 		return nil, fmt.Errorf(
 			"failed to authorise join of document id: %v with token: %v", documentID, token,
@@ -194,24 +440,15 @@ func (c *Impl) EditDocument(
 	c.stats.Incr("curator.edit.accepted_client", 1)
 
 	c.binderMutex.Lock()
-
-	// Check for existing binder
-	if openBinder, ok := c.openBinders[documentID]; ok {
-		c.binderMutex.Unlock()
-		return openBinder.Subscribe(userMetadata, timeout)
-	}
-	openBinder, err := c.newBinder(documentID)
+	openBinder, err := c.acquireBinder(documentID)
+	c.binderMutex.Unlock()
 	if err != nil {
-		c.binderMutex.Unlock()
-
 		c.stats.Incr("curator.bind_new.failed", 1)
 		c.log.Errorf("Failed to bind to document %v: %v\n", documentID, err)
 		return nil, err
 	}
-	c.openBinders[documentID] = openBinder
-	c.binderMutex.Unlock()
+	defer c.releaseBinder(documentID)
 
-	c.stats.Incr("curator.open_binders", 1)
 	return openBinder.Subscribe(userMetadata, timeout)
 }
 
@@ -223,7 +460,9 @@ func (c *Impl) ReadDocument(
 ) (binder.Portal, error) {
 	c.log.Debugf("finding document %v, with userMetadata %v token %v\n", documentID, userMetadata, token)
 
-	if c.auth.Authenticate(userMetadata, token, documentID) < acl.ReadAccess {
+	if c.authorise(
+		"read", userMetadata, token, documentID, acl.ReadAccess, acl.ReadAccess,
+	) < acl.ReadAccess {
 		c.stats.Incr("curator.read.rejected_client", 1)
 		return nil, fmt.Errorf(
 			"failed to authorise read only join of document id: %v with token: %v",
@@ -233,59 +472,320 @@ func (c *Impl) ReadDocument(
 	c.stats.Incr("curator.read.accepted_client", 1)
 
 	c.binderMutex.Lock()
+	openBinder, err := c.acquireBinder(documentID)
+	c.binderMutex.Unlock()
+	if err != nil {
+		c.stats.Incr("curator.bind_existing.failed", 1)
+		c.log.Errorf("Failed to bind to document %v: %v\n", documentID, err)
+		return nil, err
+	}
+	defer c.releaseBinder(documentID)
 
-	// Check for existing binder
-	if openBinder, ok := c.openBinders[documentID]; ok {
-		c.binderMutex.Unlock()
-		return openBinder.SubscribeReadOnly(userMetadata, timeout)
+	return openBinder.SubscribeReadOnly(userMetadata, timeout)
+}
+
+// OpenDocument - Locates or creates a Binder for an existing document and
+// returns that Binder for subscribing to. Clients with edit access are given an
+// editing portal unless readOnly is set, clients with only read access are
+// given a read only portal. Returns an error if there was a problem locating
+// the document.
+func (c *Impl) OpenDocument(
+	userMetadata interface{}, token, documentID string, readOnly bool, timeout time.Duration,
+) (binder.Portal, error) {
+	c.log.Debugf("finding document %v, with userMetadata %v token %v\n", documentID, userMetadata, token)
+
+	requested := acl.EditAccess
+	if readOnly {
+		requested = acl.ReadAccess
+	}
+	level := c.authorise("open", userMetadata, token, documentID, requested, acl.ReadAccess)
+	if level < acl.ReadAccess {
+		c.stats.Incr("curator.open.rejected_client", 1)
+		return nil, fmt.Errorf(
+			"failed to authorise join of document id: %v with token: %v", documentID, token,
+		)
+	}
+	if level < acl.EditAccess {
+		readOnly = true
+	}
+	if readOnly {
+		c.stats.Incr("curator.open.accepted_read_only_client", 1)
+	} else {
+		c.stats.Incr("curator.open.accepted_client", 1)
 	}
-	openBinder, err := c.newBinder(documentID)
-	if err != nil {
-		c.binderMutex.Unlock()
 
+	c.binderMutex.Lock()
+	openBinder, err := c.acquireBinder(documentID)
+	c.binderMutex.Unlock()
+	if err != nil {
 		c.stats.Incr("curator.bind_existing.failed", 1)
 		c.log.Errorf("Failed to bind to document %v: %v\n", documentID, err)
 		return nil, err
 	}
-	c.openBinders[documentID] = openBinder
-	c.binderMutex.Unlock()
+	defer c.releaseBinder(documentID)
 
-	c.stats.Incr("curator.open_binders", 1)
-	return openBinder.SubscribeReadOnly(userMetadata, timeout)
+	if readOnly {
+		return openBinder.SubscribeReadOnly(userMetadata, timeout)
+	}
+	return openBinder.Subscribe(userMetadata, timeout)
 }
 
 // CreateDocument - Creates a fresh Binder for a new document, which is
 // subsequently stored, returns an error if either the document ID is already
-// currently in use, or if there is a problem storing the new document. May
+// currently in use or stored, or if there is a problem storing the new document. May
 // require authentication, if so a userMetadata is supplied.
 func (c *Impl) CreateDocument(
 	userMetadata interface{}, token string, doc store.Document, timeout time.Duration,
 ) (binder.Portal, error) {
 	c.log.Debugf("Creating new document with userMetadata %v token %v\n", userMetadata, token)
 
-	if c.auth.Authenticate(userMetadata, token, "") < acl.CreateAccess {
+	if c.authorise(
+		"create", userMetadata, token, doc.ID, acl.CreateAccess, acl.CreateAccess,
+	) < acl.CreateAccess {
 		c.stats.Incr("curator.create.rejected_client", 1)
 		return nil, fmt.Errorf("failed to gain permission to create with token: %v", token)
 	}
 	c.stats.Incr("curator.create.accepted_client", 1)
 
+	c.binderMutex.Lock()
+	if _, busy := c.busy[doc.ID]; busy {
+		c.binderMutex.Unlock()
+		return nil, ErrDocumentBusy
+	}
+	if _, exists := c.openBinders[doc.ID]; exists {
+		c.binderMutex.Unlock()
+		c.stats.Incr("curator.create_new.exists", 1)
+		return nil, store.ErrDocumentExists
+	}
+	if _, err := c.store.Read(doc.ID); err == nil {
+		c.binderMutex.Unlock()
+		c.stats.Incr("curator.create_new.exists", 1)
+		return nil, store.ErrDocumentExists
+	}
+	if err := c.admitBinderCount(); err != nil {
+		c.binderMutex.Unlock()
+		return nil, err
+	}
 	if err := c.store.Create(doc); err != nil {
+		c.binderMutex.Unlock()
 		c.stats.Incr("curator.create_new.failed", 1)
 		c.log.Errorf("Failed to create new document: %v\n", err)
 		return nil, err
 	}
 	openBinder, err := c.newBinder(doc.ID)
+	if err == nil {
+		err = c.addBinder(openBinder)
+	}
 	if err != nil {
+		c.binderMutex.Unlock()
 		c.stats.Incr("curator.bind_new.failed", 1)
 		c.log.Errorf("Failed to bind to new document: %v\n", err)
 		return nil, err
 	}
-	c.binderMutex.Lock()
-	c.openBinders[doc.ID] = openBinder
+	c.markPending(doc.ID)
 	c.binderMutex.Unlock()
-	c.stats.Incr("curator.open_binders", 1)
+	defer c.releaseBinder(doc.ID)
 
 	return openBinder.Subscribe(userMetadata, timeout)
 }
 
+// closeBinder - Closes and forgets an open binder for a document, if one
+// exists. Closing a binder flushes any outstanding changes and disconnects its
+// clients. Must be called whilst holding the binder mutex.
+func (c *Impl) closeBinder(documentID string) {
+	if b, ok := c.openBinders[documentID]; ok {
+		b.Close()
+		delete(c.openBinders, documentID)
+		delete(c.binderUsage, documentID)
+		c.log.Infof("Binder (%v) was closed\n", documentID)
+		c.stats.Decr("curator.open_binders", 1)
+	}
+}
+
+// removeDocument - Removes a document from the store through its open binder,
+// if there is one, so that outstanding changes are flushed beforehand and its
+// clients are told of the removal. The document must be flagged as busy, and
+// the binder mutex must not be held, as the store is called.
+func (c *Impl) removeDocument(documentID string, remove func() error, newID string) error {
+	c.binderMutex.RLock()
+	openBinder, open := c.openBinders[documentID]
+	c.binderMutex.RUnlock()
+	if !open {
+		return remove()
+	}
+
+	err := openBinder.Remove(remove, newID)
+	if err == binder.ErrClosed {
+		// The binder has already flushed and shut down.
+		return remove()
+	}
+	if err != nil {
+		return err
+	}
+
+	// The binder has shut down, and so is forgotten rather than closed.
+	c.binderMutex.Lock()
+	if b, ok := c.openBinders[documentID]; ok && b == openBinder {
+		delete(c.openBinders, documentID)
+		delete(c.binderUsage, documentID)
+		c.log.Infof("Binder (%v) was removed\n", documentID)
+		c.stats.Decr("curator.open_binders", 1)
+	}
+	c.binderMutex.Unlock()
+	return nil
+}
+
+// DeleteDocument - Removes a document from the store, any clients subscribed to
+// the document are told that it was deleted and are unsubscribed. Requires the
+// store to implement store.Deleter.
+func (c *Impl) DeleteDocument(userMetadata interface{}, token, documentID string) error {
+	c.log.Debugf("Deleting document %v, with userMetadata %v token %v\n", documentID, userMetadata, token)
+
+	deleter, ok := c.store.(store.Deleter)
+	if !ok {
+		return ErrStoreNotSupported
+	}
+	if c.authorise(
+		"delete", userMetadata, token, documentID, acl.EditAccess, acl.EditAccess,
+	) < acl.EditAccess {
+		c.stats.Incr("curator.delete.rejected_client", 1)
+		return fmt.Errorf(
+			"failed to authorise delete of document id: %v with token: %v", documentID, token,
+		)
+	}
+	c.stats.Incr("curator.delete.accepted_client", 1)
+
+	c.binderMutex.Lock()
+	err := c.markBusy(documentID)
+	c.binderMutex.Unlock()
+	if err != nil {
+		c.stats.Incr("curator.delete.failed", 1)
+		return err
+	}
+	defer c.releaseBusy(documentID)
+
+	if err = c.removeDocument(documentID, func() error {
+		return deleter.Delete(documentID)
+	}, ""); err != nil {
+		c.stats.Incr("curator.delete.failed", 1)
+		c.log.Errorf("Failed to delete document %v: %v\n", documentID, err)
+		return err
+	}
+	c.stats.Incr("curator.delete.success", 1)
+	return nil
+}
+
+// RenameDocument - Moves a document to a new ID within the store, any clients
+// subscribed to the document are told of its new ID and are unsubscribed.
+// Requires edit access to the document and create access for the new ID, as
+// well as the store to implement store.Renamer. Returns store.ErrDocumentExists
+// if a binder is open for the new ID.
+func (c *Impl) RenameDocument(userMetadata interface{}, token, documentID, newID string) error {
+	c.log.Debugf(
+		"Renaming document %v to %v, with userMetadata %v token %v\n",
+		documentID, newID, userMetadata, token,
+	)
+
+	renamer, ok := c.store.(store.Renamer)
+	if !ok {
+		return ErrStoreNotSupported
+	}
+	editLevel := c.authorise(
+		"rename", userMetadata, token, documentID, acl.EditAccess, acl.EditAccess,
+	)
+	if editLevel < acl.EditAccess || c.authorise(
+		"rename", userMetadata, token, newID, acl.CreateAccess, acl.CreateAccess,
+	) < acl.CreateAccess {
+		c.stats.Incr("curator.rename.rejected_client", 1)
+		return fmt.Errorf(
+			"failed to authorise rename of document id: %v with token: %v", documentID, token,
+		)
+	}
+	c.stats.Incr("curator.rename.accepted_client", 1)
+
+	c.binderMutex.Lock()
+	if _, exists := c.openBinders[newID]; exists {
+		c.binderMutex.Unlock()
+		c.stats.Incr("curator.rename.failed", 1)
+		return store.ErrDocumentExists
+	}
+	err := c.markBusy(documentID, newID)
+	c.binderMutex.Unlock()
+	if err != nil {
+		c.stats.Incr("curator.rename.failed", 1)
+		return err
+	}
+	defer c.releaseBusy(documentID, newID)
+
+	if err = c.removeDocument(documentID, func() error {
+		return renamer.Rename(documentID, newID)
+	}, newID); err != nil {
+		c.stats.Incr("curator.rename.failed", 1)
+		c.log.Errorf("Failed to rename document %v: %v\n", documentID, err)
+		return err
+	}
+	c.stats.Incr("curator.rename.success", 1)
+	return nil
+}
+
+// ListDocuments - Returns the IDs of all documents in the store that begin with
+// a prefix, omitting any that the client does not have read access to. The
+// decision for each document is recorded to the access log. Requires the store to implement store.Lister.
+func (c *Impl) ListDocuments(userMetadata interface{}, token, prefix string) ([]string, error) {
+	lister, ok := c.store.(store.Lister)
+	if !ok {
+		return nil, ErrStoreNotSupported
+	}
+	ids, err := lister.List(prefix)
+	if err != nil {
+		c.stats.Incr("curator.list.failed", 1)
+		c.log.Errorf("Failed to list documents: %v\n", err)
+		return nil, err
+	}
+	visible := []string{}
+	for _, id := range ids {
+		if c.authorise(
+			"list", userMetadata, token, id, acl.ReadAccess, acl.ReadAccess,
+		) >= acl.ReadAccess {
+			visible = append(visible, id)
+		}
+	}
+	c.stats.Incr("curator.list.success", 1)
+	return visible, nil
+}
+
+// OpenDocumentIDs - Returns the IDs of all documents with an open binder in
+// lexicographical order.
+func (c *Impl) OpenDocumentIDs() []string {
+	c.binderMutex.Lock()
+	defer c.binderMutex.Unlock()
+
+	ids := make([]string, 0, len(c.openBinders))
+	for id := range c.openBinders {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// ReloadDocument - Reloads the open binder of a document, which merges any
+// changes made to the stored document outside of leaps and broadcasts them to
+// all clients. Returns ErrBinderNotFound if the document is not open.
+func (c *Impl) ReloadDocument(documentID string, timeout time.Duration) error {
+	c.binderMutex.Lock()
+	openBinder, ok := c.openBinders[documentID]
+	c.binderMutex.Unlock()
+
+	if !ok {
+		return ErrBinderNotFound
+	}
+	if err := openBinder.Reload(timeout); err != nil {
+		c.stats.Incr("curator.reload.failed", 1)
+		c.log.Errorf("Failed to reload document %v: %v\n", documentID, err)
+		return err
+	}
+	c.stats.Incr("curator.reload.success", 1)
+	return nil
+}
+
 //------------------------------------------------------------------------------
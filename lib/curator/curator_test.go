@@ -26,10 +26,13 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"reflect"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/Jeffail/leaps/lib/accesslog"
 	"github.com/Jeffail/leaps/lib/acl"
 	"github.com/Jeffail/leaps/lib/binder"
 	"github.com/Jeffail/leaps/lib/store"
@@ -88,6 +91,15 @@ func TestReadOnlyCurator(t *testing.T) {
 		return
 	}
 
+	if _, err := curator.CreateDocument("", "", store.Document{
+		ID: "exists", Content: "overwritten",
+	}, time.Second); err != store.ErrDocumentExists {
+		t.Errorf("Wrong error from creating existing stored document: %v", err)
+	}
+	if _, err := curator.CreateDocument("", "", doc, time.Second); err != store.ErrDocumentExists {
+		t.Errorf("Wrong error from creating existing open document: %v", err)
+	}
+
 	if _, err := curator.ReadDocument("test not exist", "", "doesn't exist", time.Second); err == nil {
 		t.Error("expected error from non existing document read")
 	}
@@ -161,6 +173,301 @@ func TestPermissions(t *testing.T) {
 	cur.Close()
 }
 
+func TestReloadDocument(t *testing.T) {
+	log, stats := loggerAndStats()
+	auth, storage := authAndStore(log, stats)
+
+	storage.Create(store.Document{ID: "foo", Content: "hello world"})
+
+	cur, err := New(NewConfig(), log, stats, auth, storage, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cur.Close()
+
+	if err = cur.ReloadDocument("foo", time.Second); err != ErrBinderNotFound {
+		t.Errorf("Wrong error from reloading closed document: %v", err)
+	}
+
+	portal, err := cur.EditDocument("test", "", "foo", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := []string{"foo"}, cur.OpenDocumentIDs(); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong open documents: %v != %v", exp, act)
+	}
+
+	if err = storage.Update(store.Document{ID: "foo", Content: "hello big world"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = cur.ReloadDocument("foo", time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case tform := <-portal.TransformReadChan():
+		if tform.Position != 6 || tform.Insert != "big " || tform.Delete != 0 {
+			t.Errorf("Wrong reload transform: %v", tform)
+		}
+	case <-time.After(time.Second):
+		t.Error("Timed out waiting for reload transform")
+	}
+}
+
+func TestDeleteRenameList(t *testing.T) {
+	log, stats := loggerAndStats()
+	auth, storage := authAndStore(log, stats)
+
+	for _, id := range []string{"foo/a", "foo/b", "bar/c"} {
+		storage.Create(store.Document{ID: id, Content: id})
+	}
+
+	cur, err := New(NewConfig(), log, stats, auth, storage, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cur.Close()
+
+	if ids, err := cur.ListDocuments("", "", "foo/"); err != nil {
+		t.Error(err)
+	} else if exp := []string{"foo/a", "foo/b"}; !reflect.DeepEqual(exp, ids) {
+		t.Errorf("Wrong listed IDs: %v != %v", exp, ids)
+	}
+
+	portal, err := cur.EditDocument("test", "", "foo/a", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = portal.SendTransform(text.OTransform{
+		Version: portal.BaseVersion() + 1, Insert: "hello ",
+	}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = cur.RenameDocument("", "", "foo/a", "bar/c"); err != store.ErrDocumentExists {
+		t.Errorf("Expected ErrDocumentExists, received: %v", err)
+	}
+	if err = cur.RenameDocument("", "", "foo/a", "bar/a"); err != nil {
+		t.Error(err)
+	}
+
+	// The subscribed client should be disconnected.
+	select {
+	case _, open := <-portal.TransformReadChan():
+		if open {
+			t.Error("Expected portal to be closed")
+		}
+	case <-time.After(time.Second):
+		t.Error("Timed out waiting for portal closure")
+	}
+
+	// Outstanding changes should be flushed before the rename.
+	if doc, err := storage.Read("bar/a"); err != nil {
+		t.Error(err)
+	} else if exp, act := "hello foo/a", doc.Content; exp != act {
+		t.Errorf("Wrong renamed content: %v != %v", exp, act)
+	}
+
+	if err = cur.DeleteDocument("", "", "bar/c"); err != nil {
+		t.Error(err)
+	}
+	if ids, err := cur.ListDocuments("", "", ""); err != nil {
+		t.Error(err)
+	} else if exp := []string{"bar/a", "foo/b"}; !reflect.DeepEqual(exp, ids) {
+		t.Errorf("Wrong listed IDs: %v != %v", exp, ids)
+	}
+
+	auth = &dummyAuth{level: acl.ReadAccess}
+	readCur, err := New(NewConfig(), log, stats, auth, storage, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer readCur.Close()
+
+	if err = readCur.DeleteDocument("", "", "foo/b"); err == nil {
+		t.Error("Expected rejection from delete on read access")
+	}
+	if err = readCur.RenameDocument("", "", "foo/b", "foo/c"); err == nil {
+		t.Error("Expected rejection from rename on read access")
+	}
+	if ids, err := readCur.ListDocuments("", "", ""); err != nil {
+		t.Error(err)
+	} else if exp := []string{"bar/a", "foo/b"}; !reflect.DeepEqual(exp, ids) {
+		t.Errorf("Wrong listed IDs: %v != %v", exp, ids)
+	}
+}
+
+// prefixAuth grants edit access to all documents and create access only to
+// document IDs with a prefix.
+type prefixAuth string
+
+func (p prefixAuth) Authenticate(userMetadata interface{}, token, documentID string) acl.AccessLevel {
+	if len(documentID) == 0 {
+		return acl.CreateAccess
+	}
+	return acl.EditAccess
+}
+
+func (p prefixAuth) AuthenticateCreate(userMetadata interface{}, token, documentID string) acl.AccessLevel {
+	if strings.HasPrefix(documentID, string(p)) {
+		return acl.CreateAccess
+	}
+	return acl.NoAccess
+}
+
+func TestCreateTargets(t *testing.T) {
+	log, stats := loggerAndStats()
+	_, storage := authAndStore(log, stats)
+
+	storage.Create(store.Document{ID: "foo", Content: "hello world"})
+
+	cur, err := New(NewConfig(), log, stats, prefixAuth("public/"), storage, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cur.Close()
+
+	if _, err = cur.CreateDocument("", "", store.Document{ID: "private/a"}, time.Second); err == nil {
+		t.Error("Expected rejection from create outside of prefix")
+	}
+	if _, err = cur.CreateDocument("", "", store.Document{ID: "public/a"}, time.Second); err != nil {
+		t.Error(err)
+	}
+	if err = cur.RenameDocument("", "", "foo", "private/foo"); err == nil {
+		t.Error("Expected rejection from rename outside of prefix")
+	}
+	if err = cur.RenameDocument("", "", "foo", "public/foo"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCuratorMaxOpenBinders(t *testing.T) {
+	log, stats := loggerAndStats()
+	auth, storage := authAndStore(log, stats)
+
+	for _, id := range []string{"a", "b", "c"} {
+		storage.Create(store.Document{ID: id, Content: "hello world"})
+	}
+
+	conf := NewConfig()
+	conf.MaxOpenBinders = 2
+
+	cur, err := New(conf, log, stats, auth, storage, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cur.Close()
+
+	portalA, err := cur.EditDocument("", "", "a", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	portalB, err := cur.EditDocument("", "", "b", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both open binders have clients and therefore cannot be evicted.
+	if _, err = cur.EditDocument("", "", "c", time.Second); err != ErrBinderCapacity {
+		t.Errorf("Expected ErrBinderCapacity, received: %v", err)
+	}
+
+	portalA.Exit(time.Second)
+	portalB.Exit(time.Second)
+
+	// Allow the binders to process the exits.
+	<-time.After(time.Millisecond * 50)
+
+	if _, err = cur.EditDocument("", "", "c", time.Second); err != nil {
+		t.Errorf("Expected admission after eviction: %v", err)
+	}
+
+	cur.binderMutex.Lock()
+	if exp, act := 2, len(cur.openBinders); exp != act {
+		t.Errorf("Wrong count of open binders: %v != %v", exp, act)
+	}
+	if _, open := cur.openBinders["a"]; open {
+		t.Error("Expected least recently used binder to be evicted")
+	}
+	cur.binderMutex.Unlock()
+}
+
+func TestCuratorMaxBufferedBytes(t *testing.T) {
+	log, stats := loggerAndStats()
+	auth, storage := authAndStore(log, stats)
+
+	storage.Create(store.Document{ID: "small", Content: "hello"})
+	storage.Create(store.Document{ID: "large", Content: "hello world, this is big"})
+
+	conf := NewConfig()
+	conf.MaxBufferedBytes = 20
+
+	cur, err := New(conf, log, stats, auth, storage, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cur.Close()
+
+	if _, err = cur.EditDocument("", "", "large", time.Second); err != ErrBinderCapacity {
+		t.Errorf("Expected ErrBinderCapacity, received: %v", err)
+	}
+	if _, err = cur.EditDocument("", "", "small", time.Second); err != nil {
+		t.Error(err)
+	}
+
+	cur.binderMutex.Lock()
+	if exp, act := 1, len(cur.openBinders); exp != act {
+		t.Errorf("Wrong count of open binders: %v != %v", exp, act)
+	}
+	cur.binderMutex.Unlock()
+}
+
+func TestOpenDocument(t *testing.T) {
+	log, stats := loggerAndStats()
+	_, storage := authAndStore(log, stats)
+	auth := dummyAuth{level: acl.NoAccess}
+
+	storage.Create(store.Document{ID: "exists", Content: "hello world"})
+
+	cur, err := New(NewConfig(), log, stats, &auth, storage, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cur.Close()
+
+	if bNil, err := cur.OpenDocument("", "", "exists", false, time.Second); err == nil || bNil != nil {
+		t.Error("Expected rejection from open on no access")
+	}
+
+	// Read access downgrades to a read only portal
+	auth.level = acl.ReadAccess
+	portal, err := cur.OpenDocument("", "", "exists", false, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !portal.ReadOnly() {
+		t.Error("Expected read only portal from read access")
+	}
+	if _, err = portal.SendTransform(text.OTransform{}, time.Second); err != binder.ErrReadOnlyPortal {
+		t.Errorf("Read only portal unexpected error: %v", err)
+	}
+
+	// Edit access provides an editing portal unless read only is requested
+	auth.level = acl.EditAccess
+	if portal, err = cur.OpenDocument("", "", "exists", true, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if !portal.ReadOnly() {
+		t.Error("Expected read only portal when requested")
+	}
+	if portal, err = cur.OpenDocument("", "", "exists", false, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if portal.ReadOnly() {
+		t.Error("Expected editing portal from edit access")
+	}
+}
+
 func goodClient(b binder.Portal, expecting int, t *testing.T, wg *sync.WaitGroup) {
 	changes := b.BaseVersion() + 1
 	seen := 0
@@ -188,6 +495,22 @@ func (d *dummyBinder) ID() string {
 	return d.id
 }
 
+func (d *dummyBinder) ClientCount() int {
+	return 0
+}
+
+func (d *dummyBinder) ContentSize() int64 {
+	return 0
+}
+
+func (d *dummyBinder) Reload(timeout time.Duration) error {
+	return nil
+}
+
+func (d *dummyBinder) Remove(remove func() error, newID string) error {
+	return remove()
+}
+
 func (d *dummyBinder) Subscribe(metadata interface{}, timeout time.Duration) (binder.Portal, error) {
 	return nil, nil
 }
@@ -354,3 +677,66 @@ func TestCuratorClients(t *testing.T) {
 		t.Errorf("Timeout occurred waiting for test finish.")
 	}
 }
+
+type recordedAccess struct {
+	sync.Mutex
+	entries []accesslog.Entry
+}
+
+func (r *recordedAccess) Record(entry accesslog.Entry) {
+	r.Lock()
+	r.entries = append(r.entries, entry)
+	r.Unlock()
+}
+
+func TestCuratorAccessLog(t *testing.T) {
+	log, stats := loggerAndStats()
+	_, storage := authAndStore(log, stats)
+
+	storage.Create(store.Document{ID: "exists", Content: "hello world"})
+
+	cur, err := New(NewConfig(), log, stats, &dummyAuth{level: acl.ReadAccess}, storage, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cur.Close()
+
+	sink := &recordedAccess{}
+	cur.SetAccessLog(sink)
+
+	if _, err = cur.EditDocument("alice", "", "exists", time.Second); err == nil {
+		t.Error("Expected rejection from edit on read access")
+	}
+	if _, err = cur.OpenDocument("alice", "", "exists", false, time.Second); err != nil {
+		t.Error(err)
+	}
+	if _, err = cur.CreateDocument("alice", "", store.Document{ID: "new"}, time.Second); err == nil {
+		t.Error("Expected rejection from create on read access")
+	}
+	if ids, err := cur.ListDocuments("alice", "", ""); err != nil {
+		t.Error(err)
+	} else if exp := []string{"exists"}; !reflect.DeepEqual(exp, ids) {
+		t.Errorf("Wrong listed IDs: %v != %v", exp, ids)
+	}
+
+	exp := []struct {
+		operation, document, requested string
+		allowed                        bool
+	}{
+		{"edit", "exists", "EDIT", false},
+		{"open", "exists", "EDIT", true},
+		{"create", "new", "CREATE", false},
+		{"list", "exists", "READ", true},
+	}
+	if len(sink.entries) != len(exp) {
+		t.Fatalf("Wrong count of access log entries: %v != %v", len(sink.entries), len(exp))
+	}
+	for i, e := range exp {
+		act := sink.entries[i]
+		if act.Operation != e.operation || act.Document != e.document || act.Requested != e.requested ||
+			act.Allowed != e.allowed || act.Granted != "READ" || act.User != "alice" ||
+			act.Authenticator != "curator.dummyAuth" {
+			t.Errorf("Wrong access log entry %v: %+v", i, act)
+		}
+	}
+}
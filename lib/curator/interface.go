@@ -57,12 +57,40 @@ type Type interface {
 		userMetadata interface{}, token, documentID string, timeout time.Duration,
 	) (binder.Portal, error)
 
+	// OpenDocument - Find and return a binder portal to an existing document
+	// with the highest privileges the client is granted, a read only portal is
+	// returned when the client only has read access or when readOnly is set.
+	OpenDocument(
+		userMetadata interface{}, token, documentID string, readOnly bool, timeout time.Duration,
+	) (binder.Portal, error)
+
 	// CreateDocument - Create and return a binder portal to a new document,
 	// providing metadata for identifying content produced by the client.
 	CreateDocument(
 		userMetadata interface{}, token string, document store.Document, timeout time.Duration,
 	) (binder.Portal, error)
 
+	// DeleteDocument - Remove an existing document from storage, any clients
+	// subscribed to the document are told of its deletion and unsubscribed.
+	DeleteDocument(userMetadata interface{}, token, documentID string) error
+
+	// RenameDocument - Move an existing document to a new ID, any clients
+	// subscribed to the document under its old ID are told of the new ID and
+	// unsubscribed.
+	RenameDocument(userMetadata interface{}, token, documentID, newID string) error
+
+	// ListDocuments - List the IDs of stored documents beginning with a prefix
+	// that the client has at least read access to.
+	ListDocuments(userMetadata interface{}, token, prefix string) ([]string, error)
+
+	// OpenDocumentIDs - Returns the IDs of all documents that currently have
+	// an open binder.
+	OpenDocumentIDs() []string
+
+	// ReloadDocument - Merges changes made to a stored document outside of
+	// leaps into its open binder, which broadcasts the changes to all clients.
+	ReloadDocument(documentID string, timeout time.Duration) error
+
 	// Close - Close the Curator
 	Close()
 }
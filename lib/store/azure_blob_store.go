@@ -63,16 +63,10 @@ func NewAzureBlob(config AzureConfig) (Type, error) {
 			config.AccessType)
 		return nil, err
 	}
-	b := backoff.NewExponentialBackOff()
-	b.InitialInterval = 500 * time.Second
-	b.RandomizationFactor = 0.5
-	b.Multiplier = 1.5
-	b.MaxInterval = 20
-	b.MaxElapsedTime = 1 * time.Minute
 	err = backoff.Retry(func() error {
 		_, err := blobStorage.CreateContainerIfNotExists(config.Container, accessType)
 		return err
-	}, b)
+	}, azureBackoff(time.Minute))
 	if err != nil {
 		return nil, err
 	}
@@ -83,25 +77,77 @@ func NewAzureBlob(config AzureConfig) (Type, error) {
 	}, nil
 }
 
+// azureBackoff - Returns the backoff of requests to azure that are retried.
+func azureBackoff(maxElapsed time.Duration) backoff.BackOff {
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = 500 * time.Millisecond
+	b.RandomizationFactor = 0.5
+	b.Multiplier = 1.5
+	b.MaxInterval = 10 * time.Second
+	b.MaxElapsedTime = maxElapsed
+	return b
+}
+
+// retryAzure - Retries a request to azure whilst it fails, except for errors
+// other than server errors which are returned immediately.
+func retryAzure(maxElapsed time.Duration, request func() error) error {
+	var retErr error
+	err := backoff.Retry(func() error {
+		err := request()
+		if e, ok := err.(azure.AzureStorageServiceError); ok && e.StatusCode < 500 {
+			// Don't retry on non-500 errors
+			retErr = e
+			return nil
+		}
+		return err
+	}, azureBackoff(maxElapsed))
+	if retErr != nil {
+		return retErr
+	}
+	return err
+}
+
+// azureStatus - Returns the status code of an azure error, or zero.
+func azureStatus(err error) int {
+	if e, ok := err.(azure.AzureStorageServiceError); ok {
+		return e.StatusCode
+	}
+	return 0
+}
+
+// putHeaders - Returns the headers for writing a blob with document metadata.
+func putHeaders(metadata map[string]string) map[string]string {
+	headers := map[string]string{}
+	for k, v := range metadata {
+		headers["x-ms-meta-"+k] = v
+	}
+	return headers
+}
+
 // Create - Create a new document in azure blob storage
 func (m *AzureBlob) Create(doc Document) error {
 	return m.Update(doc)
 }
 
-// Update - Update document in azure blob storage
+// Update - Update document in azure blob storage. If the document has a revision
+// then it is sent as the If-Match condition of the write, so that the blob is
+// only replaced when its ETag is unchanged. Document metadata is written along
+// with the content as the metadata of the blob.
 func (m *AzureBlob) Update(doc Document) error {
-	b := backoff.NewExponentialBackOff()
-	b.InitialInterval = 500 * time.Second
-	b.RandomizationFactor = 0.5
-	b.Multiplier = 2
-	b.MaxInterval = 60
-	b.MaxElapsedTime = 15 * time.Minute
-	return backoff.Retry(func() error {
+	headers := putHeaders(doc.Metadata)
+	if len(doc.Revision) > 0 {
+		headers["If-Match"] = doc.Revision
+	}
+	err := retryAzure(time.Minute, func() error {
 		r := strings.NewReader(doc.Content)
 		return m.blobStorage.CreateBlockBlobFromReader(
-			m.config.Container, doc.ID, uint64(r.Len()), r,
+			m.config.Container, doc.ID, uint64(r.Len()), r, headers,
 		)
-	}, b)
+	})
+	if azureStatus(err) == 412 {
+		return ErrRevisionMismatch
+	}
+	return err
 }
 
 // Read - Read document from a azure blob storage
@@ -109,46 +155,116 @@ func (m *AzureBlob) Read(id string) (Document, error) {
 	doc := Document{
 		ID: id,
 	}
-	b := backoff.NewExponentialBackOff()
-	b.InitialInterval = 500 * time.Second
-	b.RandomizationFactor = 0.5
-	b.Multiplier = 1.5
-	b.MaxInterval = 10
-	b.MaxElapsedTime = 45 * time.Second
-	var retErr error
-	err := backoff.Retry(func() error {
-		rc, err := m.blobStorage.GetBlob(m.config.Container, id)
-		if rc != nil {
-			defer rc.Close()
+	err := retryAzure(45*time.Second, func() error {
+		// Properties are read first so that the revision is never newer than
+		// the content.
+		props, err := m.blobStorage.GetBlobProperties(m.config.Container, id)
+		if err != nil {
+			return err
+		}
+		doc.Revision = props.Etag
+		if doc.Metadata, err = m.blobStorage.GetBlobMetadata(m.config.Container, id); err != nil {
+			return err
 		}
+		doc.Metadata = copyMetadata(doc.Metadata)
+		rc, err := m.blobStorage.GetBlob(m.config.Container, id)
 		if err != nil {
-			switch e := err.(type) {
-			case azure.AzureStorageServiceError:
-				if e.StatusCode == 404 {
-					retErr = ErrDocumentNotExist
-					return nil
-				}
-				if e.StatusCode >= 500 {
-					return fmt.Errorf("Internal server error from azure: %d", e.StatusCode)
-				}
-				// Don't retry on non-500 errors
-				retErr = e
-				return nil
-			default:
-				return err
-			}
+			return err
 		}
+		defer rc.Close()
+
 		// Read body
 		b := new(bytes.Buffer)
-		_, err = b.ReadFrom(rc)
-		if err != nil {
+		if _, err = b.ReadFrom(rc); err != nil {
 			return err
 		}
 		doc.Content = b.String()
 		return nil
-	}, b)
-	if retErr != nil {
-		return Document{}, retErr
+	})
+	if azureStatus(err) == 404 {
+		return Document{}, ErrDocumentNotExist
+	}
+	if err != nil {
+		return Document{}, err
+	}
+	return doc, nil
+}
+
+// Delete - Remove a document from azure blob storage
+func (m *AzureBlob) Delete(id string) error {
+	var existed bool
+	err := retryAzure(45*time.Second, func() error {
+		var err error
+		existed, err = m.blobStorage.DeleteBlobIfExists(m.config.Container, id, nil)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if !existed {
+		return ErrDocumentNotExist
+	}
+	return nil
+}
+
+// Rename - Move a document to a new ID in azure blob storage. The document is
+// only written to the new ID if no blob exists there, and the original is then
+// only deleted if it is unchanged since it was read. Otherwise the new blob is
+// deleted again and ErrRevisionMismatch is returned.
+func (m *AzureBlob) Rename(oldID, newID string) error {
+	doc, err := m.Read(oldID)
+	if err != nil {
+		return err
+	}
+
+	headers := putHeaders(doc.Metadata)
+	headers["If-None-Match"] = "*"
+	err = retryAzure(time.Minute, func() error {
+		r := strings.NewReader(doc.Content)
+		return m.blobStorage.CreateBlockBlobFromReader(
+			m.config.Container, newID, uint64(r.Len()), r, headers,
+		)
+	})
+	if status := azureStatus(err); status == 409 || status == 412 {
+		return ErrDocumentExists
+	} else if err != nil {
+		return err
+	}
+
+	err = retryAzure(45*time.Second, func() error {
+		return m.blobStorage.DeleteBlob(
+			m.config.Container, oldID, map[string]string{"If-Match": doc.Revision},
+		)
+	})
+	if err == nil {
+		return nil
+	}
+	if delErr := m.Delete(newID); delErr != nil {
+		return fmt.Errorf("failed to remove %v after failed rename: %v: %v", newID, delErr, err)
+	}
+	if status := azureStatus(err); status == 404 || status == 412 {
+		return ErrRevisionMismatch
+	}
+	return err
+}
+
+// List - List the IDs of documents in azure blob storage that begin with a
+// prefix.
+func (m *AzureBlob) List(prefix string) ([]string, error) {
+	ids := []string{}
+	params := azure.ListBlobsParameters{Prefix: prefix}
+	for {
+		res, err := m.blobStorage.ListBlobs(m.config.Container, params)
+		if err != nil {
+			return nil, err
+		}
+		for _, blob := range res.Blobs {
+			ids = append(ids, blob.Name)
+		}
+		if len(res.NextMarker) == 0 {
+			break
+		}
+		params.Marker = res.NextMarker
 	}
-	return doc, err
+	return ids, nil
 }
@@ -0,0 +1,423 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, sub to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Jeffail/leaps/lib/util/service/log"
+)
+
+//------------------------------------------------------------------------------
+
+// Errors shared by store decorators.
+var (
+	ErrNotSupported = errors.New("operation is not supported by the underlying store")
+)
+
+//------------------------------------------------------------------------------
+
+// CacheConfig - Holds configuration options for a caching store decorator.
+// Documents written to the cache are written to the underlying store every
+// FlushPeriodMS, and unmodified documents are read from the cache for TTLMS
+// before being read again from the underlying store. At most MaxDocuments
+// unmodified documents are kept in the cache.
+type CacheConfig struct {
+	Enabled       bool  `json:"enabled" yaml:"enabled"`
+	FlushPeriodMS int64 `json:"flush_period_ms" yaml:"flush_period_ms"`
+	TTLMS         int64 `json:"ttl_ms" yaml:"ttl_ms"`
+	MaxDocuments  int   `json:"max_documents" yaml:"max_documents"`
+}
+
+// NewCacheConfig - Returns a default configuration for a cache.
+func NewCacheConfig() CacheConfig {
+	return CacheConfig{
+		Enabled:       false,
+		FlushPeriodMS: 1000,
+		TTLMS:         10000,
+		MaxDocuments:  1000,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// cacheEntry - A cached document, seq is incremented with each write so that a
+// flush can tell whether the entry was modified whilst it was being written.
+type cacheEntry struct {
+	doc      Document
+	dirty    bool
+	seq      uint64
+	authors  map[string]struct{}
+	cachedAt time.Time
+	lastUsed time.Time
+}
+
+/*
+Cache - A store decorator that caches documents read from an underlying store,
+and coalesces updates so that the underlying store is written to at most once
+per flush period for each document, regardless of how often it is updated.
+
+New documents are written through to the underlying store immediately. The
+revision of a cached document is derived from a hash of its content. The cache
+assumes that it is the only writer of the underlying store, changes made to the
+underlying store by other means are only seen once a cached document expires,
+and are overwritten by pending updates.
+*/
+type Cache struct {
+	config CacheConfig
+	store  Type
+	log    log.Modular
+
+	mut     sync.Mutex
+	entries map[string]*cacheEntry
+
+	// flushMut is held whilst writing to the underlying store so that flushes
+	// are not interleaved with deletes and renames.
+	flushMut sync.Mutex
+
+	closeChan  chan struct{}
+	closedChan chan struct{}
+}
+
+// NewCache - Wraps a store with a cache.
+func NewCache(config CacheConfig, store Type, logger log.Modular) *Cache {
+	c := &Cache{
+		config:     config,
+		store:      store,
+		log:        logger.NewModule(":store:cache"),
+		entries:    map[string]*cacheEntry{},
+		closeChan:  make(chan struct{}),
+		closedChan: make(chan struct{}),
+	}
+	go c.loop()
+	return c
+}
+
+//------------------------------------------------------------------------------
+
+// Create - Creates a document in the underlying store and caches it.
+func (c *Cache) Create(doc Document) error {
+	doc.Revision = ""
+	if err := c.store.Create(doc); err != nil {
+		return err
+	}
+	c.mut.Lock()
+	c.put(doc.ID, &cacheEntry{doc: doc})
+	c.mut.Unlock()
+	return nil
+}
+
+// Read - Reads a document from the cache, or from the underlying store if the
+// document is not cached or has expired.
+func (c *Cache) Read(id string) (Document, error) {
+	c.mut.Lock()
+	if entry, exists := c.entries[id]; exists && (entry.dirty || !c.expired(entry)) {
+		entry.lastUsed = time.Now()
+		doc := entry.copyDoc()
+		c.mut.Unlock()
+		return doc, nil
+	}
+	c.mut.Unlock()
+
+	doc, err := c.store.Read(id)
+	if err != nil {
+		return doc, err
+	}
+	doc.Revision = ""
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	// An update might have been cached whilst we were reading.
+	if entry, exists := c.entries[id]; exists && entry.dirty {
+		entry.lastUsed = time.Now()
+		return entry.copyDoc(), nil
+	}
+	entry := &cacheEntry{doc: doc}
+	c.put(id, entry)
+	return entry.copyDoc(), nil
+}
+
+// Update - Updates a cached document, the update is written to the underlying
+// store during the next flush.
+func (c *Cache) Update(doc Document) error {
+	return c.UpdateAuthored(doc, nil)
+}
+
+// UpdateAuthored - Updates a cached document, the update and its authors are
+// written to the underlying store during the next flush.
+func (c *Cache) UpdateAuthored(doc Document, authors []string) error {
+	if len(doc.Revision) > 0 {
+		current, err := c.Read(doc.ID)
+		if err != nil || current.Revision != doc.Revision {
+			return ErrRevisionMismatch
+		}
+	}
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	entry, exists := c.entries[doc.ID]
+	if len(doc.Revision) > 0 && (!exists || contentRevision(entry.doc.Content) != doc.Revision) {
+		// Modified since our revision check.
+		return ErrRevisionMismatch
+	}
+	if !exists {
+		entry = &cacheEntry{}
+	}
+	doc.Revision = ""
+	doc.Metadata = copyMetadata(doc.Metadata)
+	entry.doc = doc
+	entry.dirty = true
+	entry.seq++
+	if entry.authors == nil {
+		entry.authors = map[string]struct{}{}
+	}
+	for _, author := range authors {
+		entry.authors[author] = struct{}{}
+	}
+	c.put(doc.ID, entry)
+	return nil
+}
+
+// Delete - Removes a document from the cache and the underlying store.
+func (c *Cache) Delete(id string) error {
+	deleter, ok := c.store.(Deleter)
+	if !ok {
+		return ErrNotSupported
+	}
+	c.flushMut.Lock()
+	defer c.flushMut.Unlock()
+
+	c.mut.Lock()
+	delete(c.entries, id)
+	c.mut.Unlock()
+	return deleter.Delete(id)
+}
+
+// Rename - Writes any pending update of a document and then moves it to a new
+// ID in the underlying store.
+func (c *Cache) Rename(oldID, newID string) error {
+	renamer, ok := c.store.(Renamer)
+	if !ok {
+		return ErrNotSupported
+	}
+	c.flushMut.Lock()
+	defer c.flushMut.Unlock()
+
+	if err := c.flushEntry(oldID); err != nil {
+		return err
+	}
+	c.mut.Lock()
+	delete(c.entries, oldID)
+	delete(c.entries, newID)
+	c.mut.Unlock()
+	return renamer.Rename(oldID, newID)
+}
+
+// List - Lists the documents of the underlying store.
+func (c *Cache) List(prefix string) ([]string, error) {
+	lister, ok := c.store.(Lister)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	return lister.List(prefix)
+}
+
+// ListRevisions - Writes any pending update of a document and then lists its
+// revisions in the underlying store.
+func (c *Cache) ListRevisions(id string) ([]RevisionInfo, error) {
+	versioned, ok := c.store.(Versioned)
+	if !ok {
+		return nil, ErrNotVersioned
+	}
+	c.flushMut.Lock()
+	err := c.flushEntry(id)
+	c.flushMut.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return versioned.ListRevisions(id)
+}
+
+// ReadRevision - Reads a revision of a document from the underlying store.
+func (c *Cache) ReadRevision(id, revision string) (Document, error) {
+	versioned, ok := c.store.(Versioned)
+	if !ok {
+		return Document{}, ErrNotVersioned
+	}
+	return versioned.ReadRevision(id, revision)
+}
+
+// PruneRevisions - Prunes the revisions of a document in the underlying store.
+func (c *Cache) PruneRevisions(id string, policy PrunePolicy) error {
+	versioned, ok := c.store.(Versioned)
+	if !ok {
+		return ErrNotVersioned
+	}
+	return versioned.PruneRevisions(id, policy)
+}
+
+// Flush - Writes all pending updates to the underlying store.
+func (c *Cache) Flush() error {
+	c.flushMut.Lock()
+	defer c.flushMut.Unlock()
+
+	c.mut.Lock()
+	ids := []string{}
+	for id, entry := range c.entries {
+		if entry.dirty {
+			ids = append(ids, id)
+		}
+	}
+	c.mut.Unlock()
+
+	var err error
+	for _, id := range ids {
+		if fErr := c.flushEntry(id); fErr != nil {
+			c.log.Errorf("Failed to flush document %v: %v\n", id, fErr)
+			err = fErr
+		}
+	}
+	return err
+}
+
+// Close - Writes all pending updates and closes the underlying store if it
+// holds resources.
+func (c *Cache) Close() error {
+	close(c.closeChan)
+	<-c.closedChan
+
+	err := c.Flush()
+	if closer, ok := c.store.(io.Closer); ok {
+		if cErr := closer.Close(); err == nil {
+			err = cErr
+		}
+	}
+	return err
+}
+
+//------------------------------------------------------------------------------
+
+// copyDoc - Returns a copy of the cached document with its revision.
+func (e *cacheEntry) copyDoc() Document {
+	doc := e.doc
+	doc.Metadata = copyMetadata(doc.Metadata)
+	doc.Revision = contentRevision(doc.Content)
+	return doc
+}
+
+// expired - Returns whether a cached document should be read again from the
+// underlying store. Must be called with mut held.
+func (c *Cache) expired(entry *cacheEntry) bool {
+	return time.Since(entry.cachedAt) > time.Duration(c.config.TTLMS)*time.Millisecond
+}
+
+// put - Adds an entry to the cache and evicts the least recently used
+// unmodified entries beyond the configured maximum. Must be called with mut
+// held.
+func (c *Cache) put(id string, entry *cacheEntry) {
+	now := time.Now()
+	if !entry.dirty {
+		entry.cachedAt = now
+	}
+	entry.lastUsed = now
+	c.entries[id] = entry
+
+	for c.config.MaxDocuments > 0 && len(c.entries) > c.config.MaxDocuments {
+		var oldestID string
+		var oldest *cacheEntry
+		for eID, e := range c.entries {
+			if !e.dirty && eID != id && (oldest == nil || e.lastUsed.Before(oldest.lastUsed)) {
+				oldestID, oldest = eID, e
+			}
+		}
+		if oldest == nil {
+			return
+		}
+		delete(c.entries, oldestID)
+	}
+}
+
+// flushEntry - Writes a pending update of a document to the underlying store.
+// Must be called with flushMut held.
+func (c *Cache) flushEntry(id string) error {
+	c.mut.Lock()
+	entry, exists := c.entries[id]
+	if !exists || !entry.dirty {
+		c.mut.Unlock()
+		return nil
+	}
+	doc, seq := entry.doc, entry.seq
+	authors := make([]string, 0, len(entry.authors))
+	for author := range entry.authors {
+		authors = append(authors, author)
+	}
+	c.mut.Unlock()
+
+	sort.Strings(authors)
+
+	var err error
+	if authored, ok := c.store.(AuthoredUpdater); ok {
+		err = authored.UpdateAuthored(doc, authors)
+	} else {
+		err = c.store.Update(doc)
+	}
+	if err != nil {
+		return err
+	}
+
+	c.mut.Lock()
+	if current, exists := c.entries[id]; exists && current == entry && entry.seq == seq {
+		entry.dirty = false
+		entry.authors = nil
+		entry.cachedAt = time.Now()
+	}
+	c.mut.Unlock()
+	return nil
+}
+
+// loop - Flushes pending updates periodically until the cache is closed.
+func (c *Cache) loop() {
+	defer close(c.closedChan)
+	if c.config.FlushPeriodMS <= 0 {
+		<-c.closeChan
+		return
+	}
+	ticker := time.NewTicker(time.Duration(c.config.FlushPeriodMS) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.Flush()
+		case <-c.closeChan:
+			return
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
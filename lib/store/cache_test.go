@@ -0,0 +1,161 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, sub to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/Jeffail/leaps/lib/util/service/log"
+)
+
+//------------------------------------------------------------------------------
+
+// countingStore - Counts the reads and updates of an underlying store.
+type countingStore struct {
+	*Memory
+
+	mut     sync.Mutex
+	reads   int
+	updates int
+	authors [][]string
+}
+
+func (c *countingStore) Read(id string) (Document, error) {
+	c.mut.Lock()
+	c.reads++
+	c.mut.Unlock()
+	return c.Memory.Read(id)
+}
+
+func (c *countingStore) UpdateAuthored(doc Document, authors []string) error {
+	c.mut.Lock()
+	c.updates++
+	c.authors = append(c.authors, authors)
+	c.mut.Unlock()
+	return c.Memory.Update(doc)
+}
+
+func (c *countingStore) Update(doc Document) error {
+	return c.UpdateAuthored(doc, nil)
+}
+
+func TestCacheWriteBehind(t *testing.T) {
+	underlying := &countingStore{Memory: NewMemory().(*Memory)}
+
+	conf := NewCacheConfig()
+	conf.FlushPeriodMS = 0
+
+	c := NewCache(conf, underlying, log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"}))
+	if err := c.Create(Document{ID: "foo", Content: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := c.Read("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, content := range []string{"hello world", "hello world!", "hello world!!"} {
+		author := []string{"alice", "bob", "alice"}[i]
+		if err = c.UpdateAuthored(Document{ID: "foo", Content: content, Revision: doc.Revision}, []string{author}); err != nil {
+			t.Fatal(err)
+		}
+		if doc, err = c.Read("foo"); err != nil {
+			t.Fatal(err)
+		}
+		if doc.Content != content {
+			t.Errorf("Wrong cached content: %v != %v", content, doc.Content)
+		}
+	}
+	if err = c.Update(Document{ID: "foo", Content: "stale", Revision: "nope"}); err != ErrRevisionMismatch {
+		t.Errorf("Expected ErrRevisionMismatch, received: %v", err)
+	}
+
+	if underlying.reads != 0 || underlying.updates != 0 {
+		t.Errorf("Expected no underlying reads or updates: %v, %v", underlying.reads, underlying.updates)
+	}
+	if stored, _ := underlying.Memory.Read("foo"); stored.Content != "hello" {
+		t.Errorf("Expected update to be pending: %v", stored.Content)
+	}
+
+	if err = c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if underlying.updates != 1 {
+		t.Errorf("Expected updates to be coalesced: %v", underlying.updates)
+	}
+	if exp := [][]string{{"alice", "bob"}}; !reflect.DeepEqual(exp, underlying.authors) {
+		t.Errorf("Wrong authors: %v != %v", exp, underlying.authors)
+	}
+	if stored, _ := underlying.Memory.Read("foo"); stored.Content != "hello world!!" {
+		t.Errorf("Wrong flushed content: %v", stored.Content)
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	underlying := &countingStore{Memory: NewMemory().(*Memory)}
+	for _, id := range []string{"a", "b", "c"} {
+		if err := underlying.Memory.Create(Document{ID: id, Content: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conf := NewCacheConfig()
+	conf.FlushPeriodMS = 0
+	conf.MaxDocuments = 2
+
+	c := NewCache(conf, underlying, log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"}))
+	defer c.Close()
+
+	if err := c.Update(Document{ID: "a", Content: "a updated"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"b", "c", "b", "c"} {
+		if _, err := c.Read(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The modified document is never evicted, so b and c evict each other.
+	if exp, act := 4, underlying.reads; exp != act {
+		t.Errorf("Wrong count of underlying reads: %v != %v", exp, act)
+	}
+	if doc, err := c.Read("a"); err != nil {
+		t.Error(err)
+	} else if doc.Content != "a updated" {
+		t.Errorf("Wrong content: %v", doc.Content)
+	}
+
+	if err := c.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Read("a"); err != ErrDocumentNotExist {
+		t.Errorf("Expected ErrDocumentNotExist, received: %v", err)
+	}
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,150 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, sub to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Jeffail/leaps/lib/util/service/log"
+)
+
+//--------------------------------------------------------------------------------------------------
+
+// Errors for the store constructor.
+var (
+	ErrInvalidStoreType = errors.New("invalid document store type")
+)
+
+//--------------------------------------------------------------------------------------------------
+
+// typeSpec - Constructor and a usage description for each store type.
+type typeSpec struct {
+	constructor func(conf Config, logger log.Modular) (Type, error)
+	description string
+}
+
+var constructors = map[string]typeSpec{}
+
+// unsupportedTypes - Store types that are not available within this build, along with the reason.
+var unsupportedTypes = map[string]string{
+	"azure": "the azure blob store is not supported by this build",
+}
+
+// typeNames - Returns the names of each store type in alphabetical order.
+func typeNames() []string {
+	names := []string{}
+	for name := range constructors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// Config - The all encompassing configuration struct for all store types, along with the optional
+// decorators that wrap the store.
+type Config struct {
+	Type       string           `json:"type" yaml:"type"`
+	File       FileConfig       `json:"file" yaml:"file"`
+	Git        GitConfig        `json:"git" yaml:"git"`
+	Log        LogConfig        `json:"log" yaml:"log"`
+	SQL        SQLConfig        `json:"sql" yaml:"sql"`
+	Encryption EncryptionConfig `json:"encryption" yaml:"encryption"`
+	Cache      CacheConfig      `json:"cache" yaml:"cache"`
+}
+
+// NewConfig - Returns a configuration struct fully populated with default values.
+func NewConfig() Config {
+	return Config{
+		Type:       "memory",
+		File:       NewFileConfig(),
+		Git:        NewGitConfig(),
+		Log:        NewLogConfig(),
+		SQL:        NewSQLConfig(),
+		Encryption: NewEncryptionConfig(),
+		Cache:      NewCacheConfig(),
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// Descriptions - Returns a formatted string of collated descriptions of each type.
+func Descriptions() string {
+	// Order our store types alphabetically
+	names := typeNames()
+
+	buf := bytes.Buffer{}
+	buf.WriteString("DOCUMENT STORES\n")
+	buf.WriteString(strings.Repeat("=", 80))
+	buf.WriteString("\n\n")
+
+	// Append each description
+	for i, name := range names {
+		buf.WriteString(name)
+		buf.WriteString("\n")
+		buf.WriteString(strings.Repeat("-", 80))
+		buf.WriteString("\n")
+		buf.WriteString(constructors[name].description)
+		buf.WriteString("\n")
+		if i != (len(names) - 1) {
+			buf.WriteString("\n")
+		}
+	}
+	return buf.String()
+}
+
+// New - Create a document store type based on a configuration. When enabled the store is wrapped
+// with encryption and then with a cache, so that cached documents are held decrypted. Stores that
+// hold resources, such as the git and log stores, implement io.Closer and should be closed when no
+// longer needed. An unrecognised type results in an error that lists the supported types.
+func New(conf Config, logger log.Modular) (Type, error) {
+	c, ok := constructors[conf.Type]
+	if !ok {
+		if reason, exists := unsupportedTypes[conf.Type]; exists {
+			return nil, fmt.Errorf("%v '%v': %v", ErrInvalidStoreType, conf.Type, reason)
+		}
+		return nil, fmt.Errorf(
+			"%v '%v', expected one of: %v", ErrInvalidStoreType, conf.Type, strings.Join(typeNames(), ", "),
+		)
+	}
+	s, err := c.constructor(conf, logger)
+	if err != nil {
+		return nil, err
+	}
+	if conf.Encryption.Enabled {
+		if s, err = NewEncrypted(conf.Encryption, s); err != nil {
+			return nil, err
+		}
+	}
+	if conf.Cache.Enabled {
+		s = NewCache(conf.Cache, s, logger)
+	}
+	return s, nil
+}
+
+//--------------------------------------------------------------------------------------------------
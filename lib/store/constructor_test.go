@@ -0,0 +1,60 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Jeffail/leaps/lib/util/service/log"
+)
+
+//--------------------------------------------------------------------------------------------------
+
+func TestNewStoreTypes(t *testing.T) {
+	logger := log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})
+
+	conf := NewConfig()
+	if _, err := New(conf, logger); err != nil {
+		t.Fatal(err)
+	}
+
+	conf.Type = "azure"
+	if _, err := New(conf, logger); err == nil || !strings.Contains(err.Error(), "not supported by this build") {
+		t.Errorf("Expected unsupported azure store error, received: %v", err)
+	}
+
+	conf.Type = "nope"
+	_, err := New(conf, logger)
+	if err == nil {
+		t.Fatal("Expected error from unrecognised store type")
+	}
+	for _, name := range []string{"nope", "file", "git", "log", "memory", "mysql", "postgres"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("Expected error to mention %v: %v", name, err)
+		}
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
@@ -22,14 +22,27 @@ THE SOFTWARE.
 
 package store
 
-import "github.com/Jeffail/leaps/lib/util"
+import (
+	"fmt"
+	"hash/fnv"
+
+	"github.com/Jeffail/leaps/lib/util"
+)
 
 //------------------------------------------------------------------------------
 
-// Document - A representation of a leap document, must have a unique ID.
+// Document - A representation of a leap document, must have a unique ID. The
+// Revision is set by stores that support optimistic concurrency and identifies
+// the stored version of the content, when a document with a non-empty Revision
+// is updated the store fails with ErrRevisionMismatch if the stored document
+// has since changed. Metadata is an optional set of fields describing the
+// document, such as a title, owner or MIME type, which is persisted alongside
+// the content by each store.
 type Document struct {
-	ID      string `json:"id" yaml:"id"`
-	Content string `json:"content" yaml:"content"`
+	ID       string            `json:"id" yaml:"id"`
+	Content  string            `json:"content" yaml:"content"`
+	Revision string            `json:"revision,omitempty" yaml:"revision,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty" yaml:"metadata,omitempty"`
 }
 
 //------------------------------------------------------------------------------
@@ -43,3 +56,26 @@ func NewDocument(content string) Document {
 }
 
 //------------------------------------------------------------------------------
+
+// copyMetadata - Returns a copy of document metadata so that stores do not
+// share maps with callers, empty metadata is returned as nil.
+func copyMetadata(metadata map[string]string) map[string]string {
+	if len(metadata) == 0 {
+		return nil
+	}
+	c := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		c[k] = v
+	}
+	return c
+}
+
+// contentRevision - Returns a revision derived from a hash of document content,
+// for stores that do not track revisions by other means.
+func contentRevision(content string) string {
+	h := fnv.New64a()
+	h.Write([]byte(content))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+//------------------------------------------------------------------------------
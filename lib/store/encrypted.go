@@ -0,0 +1,403 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, sub to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+//------------------------------------------------------------------------------
+
+// Errors for the Encrypted store decorator.
+var (
+	ErrUnknownKey     = errors.New("document is encrypted with an unknown key")
+	ErrDecryptFailed  = errors.New("failed to decrypt document")
+	ErrNotEncrypted   = errors.New("document is not encrypted")
+	ErrMissingKey     = errors.New("current encryption key is not configured")
+	ErrInvalidKeyID   = errors.New("encryption key IDs must be non-empty and must not contain ':'")
+	ErrInvalidKeySize = errors.New("encryption keys must be 16, 24 or 32 bytes")
+)
+
+// encryptedPrefix - Prefixes all encrypted values, and is followed by the ID
+// of the key and then the base64 encoded nonce and ciphertext.
+const encryptedPrefix = "leaps:aes-gcm:"
+
+//------------------------------------------------------------------------------
+
+// EncryptionConfig - Holds configuration options for an encrypting store
+// decorator. Keys maps key IDs to base64 encoded AES keys of 16, 24 or 32
+// bytes, new writes are encrypted with the key of CurrentKeyID and documents
+// encrypted with any configured key can be read. When AllowPlaintext is set
+// documents that are not encrypted are read as they are, which allows
+// encryption to be enabled for an existing store.
+type EncryptionConfig struct {
+	Enabled        bool              `json:"enabled" yaml:"enabled"`
+	Keys           map[string]string `json:"keys" yaml:"keys"`
+	CurrentKeyID   string            `json:"current_key_id" yaml:"current_key_id"`
+	AllowPlaintext bool              `json:"allow_plaintext" yaml:"allow_plaintext"`
+}
+
+// NewEncryptionConfig - Returns a default configuration for encryption.
+func NewEncryptionConfig() EncryptionConfig {
+	return EncryptionConfig{
+		Enabled:        false,
+		Keys:           map[string]string{},
+		CurrentKeyID:   "",
+		AllowPlaintext: false,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+/*
+Encrypted - A store decorator that encrypts the content and metadata values of
+documents with AES-GCM before they reach an underlying store. Document IDs and
+metadata keys are not encrypted, but each value is bound to the ID of its
+document and its metadata key, so that values cannot be swapped between
+documents or fields without detection. Renaming a document therefore re-encrypts
+it under its new ID, although revisions retained from before the rename remain
+bound to the old ID and can no longer be read.
+
+Keys are rotated by adding a new key to the configuration and making it the
+current key, documents are then re-encrypted as they are written, or all at once
+with RotateKeys (as run by the leaps rotate_keys command), after which the old
+key can be removed.
+*/
+type Encrypted struct {
+	store          Type
+	keys           map[string]cipher.AEAD
+	currentKeyID   string
+	allowPlaintext bool
+}
+
+// NewEncrypted - Wraps a store with encryption.
+func NewEncrypted(config EncryptionConfig, store Type) (*Encrypted, error) {
+	e := &Encrypted{
+		store:          store,
+		keys:           map[string]cipher.AEAD{},
+		currentKeyID:   config.CurrentKeyID,
+		allowPlaintext: config.AllowPlaintext,
+	}
+	for id, keyStr := range config.Keys {
+		if len(id) == 0 || strings.Contains(id, ":") {
+			return nil, ErrInvalidKeyID
+		}
+		key, err := base64.StdEncoding.DecodeString(keyStr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode encryption key %v: %v", id, err)
+		}
+		switch len(key) {
+		case 16, 24, 32:
+		default:
+			return nil, ErrInvalidKeySize
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		if e.keys[id], err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+	if _, exists := e.keys[e.currentKeyID]; !exists {
+		return nil, ErrMissingKey
+	}
+	return e, nil
+}
+
+//------------------------------------------------------------------------------
+
+// additionalData - Returns the data authenticated along with the ciphertext of a
+// value, which is the prefix and key ID of the value along with the document ID
+// and field that it belongs to.
+func additionalData(header, id, field string) []byte {
+	return []byte(header + "\x00" + id + "\x00" + field)
+}
+
+// metadataField - Returns the field of a metadata value used in additional data.
+func metadataField(key string) string {
+	return "metadata:" + key
+}
+
+// encrypt - Encrypts a value of a document field with the current key.
+func (e *Encrypted) encrypt(value, id, field string) (string, error) {
+	aead := e.keys[e.currentKeyID]
+	header := encryptedPrefix + e.currentKeyID + ":"
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), additionalData(header, id, field))
+	return header + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decrypt - Decrypts a value of a document field and returns the ID of the key
+// it was encrypted with, plaintext values have an empty key ID.
+func (e *Encrypted) decrypt(value, id, field string) (string, string, error) {
+	if !strings.HasPrefix(value, encryptedPrefix) {
+		if e.allowPlaintext {
+			return value, "", nil
+		}
+		return "", "", ErrNotEncrypted
+	}
+	rest := value[len(encryptedPrefix):]
+	sep := strings.Index(rest, ":")
+	if sep < 0 {
+		return "", "", ErrDecryptFailed
+	}
+	keyID := rest[:sep]
+	aead, exists := e.keys[keyID]
+	if !exists {
+		return "", "", ErrUnknownKey
+	}
+	sealed, err := base64.StdEncoding.DecodeString(rest[sep+1:])
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", "", ErrDecryptFailed
+	}
+	header := value[:len(encryptedPrefix)+sep+1]
+	plain, err := aead.Open(
+		nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additionalData(header, id, field),
+	)
+	if err != nil {
+		return "", "", ErrDecryptFailed
+	}
+	return string(plain), keyID, nil
+}
+
+// encryptDocument - Returns a copy of a document with encrypted content and
+// metadata values.
+func (e *Encrypted) encryptDocument(doc Document) (Document, error) {
+	var err error
+	if doc.Content, err = e.encrypt(doc.Content, doc.ID, "content"); err != nil {
+		return doc, err
+	}
+	if len(doc.Metadata) > 0 {
+		metadata := make(map[string]string, len(doc.Metadata))
+		for k, v := range doc.Metadata {
+			if metadata[k], err = e.encrypt(v, doc.ID, metadataField(k)); err != nil {
+				return doc, err
+			}
+		}
+		doc.Metadata = metadata
+	}
+	return doc, nil
+}
+
+// decryptDocument - Returns a copy of a document read with an ID with decrypted
+// content and metadata values, and whether any value was not encrypted with the
+// current key.
+func (e *Encrypted) decryptDocument(id string, doc Document) (Document, bool, error) {
+	content, keyID, err := e.decrypt(doc.Content, id, "content")
+	if err != nil {
+		return doc, false, err
+	}
+	stale := keyID != e.currentKeyID
+	doc.Content = content
+	if len(doc.Metadata) > 0 {
+		metadata := make(map[string]string, len(doc.Metadata))
+		for k, v := range doc.Metadata {
+			if metadata[k], keyID, err = e.decrypt(v, id, metadataField(k)); err != nil {
+				return doc, false, err
+			}
+			stale = stale || keyID != e.currentKeyID
+		}
+		doc.Metadata = metadata
+	}
+	return doc, stale, nil
+}
+
+//------------------------------------------------------------------------------
+
+// Create - Encrypts and creates a document in the underlying store.
+func (e *Encrypted) Create(doc Document) error {
+	encDoc, err := e.encryptDocument(doc)
+	if err != nil {
+		return err
+	}
+	return e.store.Create(encDoc)
+}
+
+// Read - Reads and decrypts a document from the underlying store.
+func (e *Encrypted) Read(id string) (Document, error) {
+	doc, err := e.store.Read(id)
+	if err != nil {
+		return doc, err
+	}
+	doc, _, err = e.decryptDocument(id, doc)
+	if err != nil {
+		return Document{}, fmt.Errorf("failed to decrypt document %v: %v", id, err)
+	}
+	return doc, nil
+}
+
+// Update - Encrypts and updates a document in the underlying store.
+func (e *Encrypted) Update(doc Document) error {
+	return e.UpdateAuthored(doc, nil)
+}
+
+// UpdateAuthored - Encrypts and updates a document in the underlying store,
+// along with its authors if supported by the underlying store.
+func (e *Encrypted) UpdateAuthored(doc Document, authors []string) error {
+	encDoc, err := e.encryptDocument(doc)
+	if err != nil {
+		return err
+	}
+	if authored, ok := e.store.(AuthoredUpdater); ok {
+		return authored.UpdateAuthored(encDoc, authors)
+	}
+	return e.store.Update(encDoc)
+}
+
+// Delete - Removes a document from the underlying store.
+func (e *Encrypted) Delete(id string) error {
+	if deleter, ok := e.store.(Deleter); ok {
+		return deleter.Delete(id)
+	}
+	return ErrNotSupported
+}
+
+// Rename - Moves a document to a new ID in the underlying store and then
+// re-encrypts it under the new ID. If re-encrypting fails the document is moved
+// back to its old ID.
+func (e *Encrypted) Rename(oldID, newID string) error {
+	renamer, ok := e.store.(Renamer)
+	if !ok {
+		return ErrNotSupported
+	}
+	doc, err := e.Read(oldID)
+	if err != nil {
+		return err
+	}
+	if err = renamer.Rename(oldID, newID); err != nil {
+		return err
+	}
+	doc.ID, doc.Revision = newID, ""
+	if err = e.Update(doc); err != nil {
+		if undoErr := renamer.Rename(newID, oldID); undoErr != nil {
+			return fmt.Errorf(
+				"failed to re-encrypt document %v: %v, and failed to move it back: %v", newID, err, undoErr,
+			)
+		}
+		return fmt.Errorf("failed to re-encrypt document %v: %v", newID, err)
+	}
+	return nil
+}
+
+// List - Lists the documents of the underlying store.
+func (e *Encrypted) List(prefix string) ([]string, error) {
+	if lister, ok := e.store.(Lister); ok {
+		return lister.List(prefix)
+	}
+	return nil, ErrNotSupported
+}
+
+// ListRevisions - Lists the revisions of a document in the underlying store.
+func (e *Encrypted) ListRevisions(id string) ([]RevisionInfo, error) {
+	if versioned, ok := e.store.(Versioned); ok {
+		return versioned.ListRevisions(id)
+	}
+	return nil, ErrNotVersioned
+}
+
+// ReadRevision - Reads and decrypts a revision of a document from the
+// underlying store.
+func (e *Encrypted) ReadRevision(id, revision string) (Document, error) {
+	versioned, ok := e.store.(Versioned)
+	if !ok {
+		return Document{}, ErrNotVersioned
+	}
+	doc, err := versioned.ReadRevision(id, revision)
+	if err != nil {
+		return doc, err
+	}
+	if doc, _, err = e.decryptDocument(id, doc); err != nil {
+		return Document{}, fmt.Errorf("failed to decrypt document %v: %v", id, err)
+	}
+	return doc, nil
+}
+
+// PruneRevisions - Prunes the revisions of a document in the underlying store.
+func (e *Encrypted) PruneRevisions(id string, policy PrunePolicy) error {
+	if versioned, ok := e.store.(Versioned); ok {
+		return versioned.PruneRevisions(id, policy)
+	}
+	return ErrNotVersioned
+}
+
+// RotateKeys - Re-encrypts all documents beginning with a prefix that are not
+// encrypted with the current key, and returns the number of documents that were
+// re-encrypted. Revisions retained by the underlying store are not modified.
+func (e *Encrypted) RotateKeys(prefix string) (int, error) {
+	lister, ok := e.store.(Lister)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	ids, err := lister.List(prefix)
+	if err != nil {
+		return 0, err
+	}
+	rotated := 0
+	for _, id := range ids {
+		doc, err := e.store.Read(id)
+		if err != nil {
+			return rotated, err
+		}
+		plainDoc, stale, err := e.decryptDocument(id, doc)
+		if err != nil {
+			return rotated, fmt.Errorf("failed to decrypt document %v: %v", id, err)
+		}
+		if !stale {
+			continue
+		}
+		// The revision ensures that concurrent writes are not overwritten by
+		// stores that support it.
+		plainDoc.ID, plainDoc.Revision = id, doc.Revision
+		encDoc, err := e.encryptDocument(plainDoc)
+		if err != nil {
+			return rotated, err
+		}
+		if err = e.store.Update(encDoc); err != nil {
+			return rotated, fmt.Errorf("failed to re-encrypt document %v: %v", id, err)
+		}
+		rotated++
+	}
+	return rotated, nil
+}
+
+// Close - Closes the underlying store if it holds resources.
+func (e *Encrypted) Close() error {
+	if closer, ok := e.store.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,187 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, sub to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//------------------------------------------------------------------------------
+
+func testEncryptionConfig(currentKeyID string, keyIDs ...string) EncryptionConfig {
+	conf := NewEncryptionConfig()
+	conf.Enabled = true
+	conf.CurrentKeyID = currentKeyID
+	for _, id := range keyIDs {
+		conf.Keys[id] = base64.StdEncoding.EncodeToString([]byte(strings.Repeat(id, 16)))
+	}
+	return conf
+}
+
+func TestEncryptedRoundTrip(t *testing.T) {
+	underlying := NewMemory()
+	e, err := NewEncrypted(testEncryptionConfig("k1", "k1"), underlying)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := Document{ID: "foo", Content: "secret content", Metadata: map[string]string{"title": "secret title"}}
+	if err = e.Create(doc); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := underlying.Read("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(stored.Content, "secret") || strings.Contains(stored.Metadata["title"], "secret") {
+		t.Errorf("Plaintext found in underlying store: %v", stored)
+	}
+	if !strings.HasPrefix(stored.Content, encryptedPrefix+"k1:") {
+		t.Errorf("Wrong encrypted format: %v", stored.Content)
+	}
+
+	read, err := e.Read("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Content != doc.Content || !reflect.DeepEqual(read.Metadata, doc.Metadata) {
+		t.Errorf("Wrong decrypted document: %v != %v", doc, read)
+	}
+	if read.Revision != stored.Revision {
+		t.Errorf("Wrong revision: %v != %v", stored.Revision, read.Revision)
+	}
+
+	// Tampering with the key ID or ciphertext is detected.
+	tampered := stored
+	tampered.Revision = ""
+	tampered.Content = strings.Replace(stored.Content, "k1:", "k2:", 1)
+	underlying.Update(tampered)
+	if _, err = e.Read("foo"); err == nil {
+		t.Error("Expected error from unknown key")
+	}
+	tampered.Content = stored.Content[:len(stored.Content)-4] + "AAA="
+	underlying.Update(tampered)
+	if _, err = e.Read("foo"); err == nil {
+		t.Error("Expected error from modified ciphertext")
+	}
+
+	// Values are bound to their document and field.
+	if err = e.Create(Document{ID: "bar", Content: "other content"}); err != nil {
+		t.Fatal(err)
+	}
+	swapped, err := underlying.Read("bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	swapped.ID, swapped.Revision = "foo", ""
+	underlying.Update(swapped)
+	if _, err = e.Read("foo"); err == nil {
+		t.Error("Expected error from content of another document")
+	}
+	swapped.Content = stored.Content
+	swapped.Metadata = map[string]string{"owner": stored.Metadata["title"]}
+	underlying.Update(swapped)
+	if _, err = e.Read("foo"); err == nil {
+		t.Error("Expected error from metadata value of another key")
+	}
+
+	// Renamed documents are re-encrypted under their new ID.
+	if err = e.Rename("bar", "baz"); err != nil {
+		t.Fatal(err)
+	}
+	if read, err = e.Read("baz"); err != nil {
+		t.Error(err)
+	} else if exp, act := "other content", read.Content; exp != act {
+		t.Errorf("Wrong renamed content: %v != %v", exp, act)
+	}
+
+	underlying.Update(Document{ID: "plain", Content: "not encrypted"})
+	if _, err = e.Read("plain"); err == nil {
+		t.Error("Expected error from plaintext document")
+	}
+
+	conf := testEncryptionConfig("k1", "k1")
+	conf.AllowPlaintext = true
+	if e, err = NewEncrypted(conf, underlying); err != nil {
+		t.Fatal(err)
+	}
+	if read, err = e.Read("plain"); err != nil {
+		t.Error(err)
+	} else if read.Content != "not encrypted" {
+		t.Errorf("Wrong plaintext content: %v", read.Content)
+	}
+}
+
+func TestEncryptedKeyRotation(t *testing.T) {
+	underlying := NewMemory()
+	e, err := NewEncrypted(testEncryptionConfig("k1", "k1"), underlying)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", "b"} {
+		if err = e.Create(Document{ID: id, Content: "content of " + id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if e, err = NewEncrypted(testEncryptionConfig("k2", "k1", "k2"), underlying); err != nil {
+		t.Fatal(err)
+	}
+	if err = e.Update(Document{ID: "a", Content: "content of a"}); err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := e.RotateKeys("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated != 1 {
+		t.Errorf("Wrong count of rotated documents: %v != %v", 1, rotated)
+	}
+
+	// The old key is no longer needed.
+	if e, err = NewEncrypted(testEncryptionConfig("k2", "k2"), underlying); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", "b"} {
+		if doc, err := e.Read(id); err != nil {
+			t.Error(err)
+		} else if doc.Content != "content of "+id {
+			t.Errorf("Wrong content: %v", doc.Content)
+		}
+	}
+
+	if _, err = NewEncrypted(testEncryptionConfig("k3", "k2"), underlying); err != ErrMissingKey {
+		t.Errorf("Expected ErrMissingKey, received: %v", err)
+	}
+	badConf := testEncryptionConfig("k2", "k2")
+	badConf.Keys["k2"] = base64.StdEncoding.EncodeToString([]byte("short"))
+	if _, err = NewEncrypted(badConf, underlying); err != ErrInvalidKeySize {
+		t.Errorf("Expected ErrInvalidKeySize, received: %v", err)
+	}
+}
+
+//------------------------------------------------------------------------------
@@ -23,21 +23,81 @@ THE SOFTWARE.
 package store
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/Jeffail/leaps/lib/util"
+	"github.com/Jeffail/leaps/lib/util/service/log"
 )
 
 //------------------------------------------------------------------------------
 
+func init() {
+	constructors["file"] = typeSpec{
+		constructor: func(conf Config, logger log.Modular) (Type, error) {
+			return NewFileFromConfig(conf.File)
+		},
+		description: `Stores each document as a file within a directory, where the ID of a document
+is its path relative to the directory.`,
+	}
+}
+
+//------------------------------------------------------------------------------
+
 // Errors for the FileStore type.
 var (
-	ErrInvalidDirectory = errors.New("invalid directory")
+	ErrInvalidDirectory  = errors.New("invalid directory")
+	ErrInvalidDocumentID = errors.New("document ID must be a relative path within the store directory")
+	ErrSymlinkNotAllowed = errors.New("document path contains a symlink that is not allowed")
 )
 
+// Symlink policies for the FileStore type.
+const (
+	// SymlinksFollow - Symlinks are followed regardless of their target.
+	SymlinksFollow = "follow"
+
+	// SymlinksConfine - Symlinks are followed only when their target is
+	// within the store directory.
+	SymlinksConfine = "confine"
+
+	// SymlinksReject - Documents with a symlink in their path are rejected.
+	SymlinksReject = "reject"
+)
+
+//------------------------------------------------------------------------------
+
+// FileConfig - Holds configuration options for a file based document store.
+type FileConfig struct {
+	Path          string `json:"path" yaml:"path"`
+	AllowWrites   bool   `json:"allow_writes" yaml:"allow_writes"`
+	SymlinkPolicy string `json:"symlink_policy" yaml:"symlink_policy"`
+	MetadataDir   string `json:"metadata_dir" yaml:"metadata_dir"`
+
+	RevisionsDir   string      `json:"revisions_dir" yaml:"revisions_dir"`
+	RevisionPolicy PrunePolicy `json:"revision_policy" yaml:"revision_policy"`
+}
+
+// NewFileConfig - Returns a default configuration for a file store.
+func NewFileConfig() FileConfig {
+	return FileConfig{
+		Path:          ".",
+		AllowWrites:   true,
+		SymlinkPolicy: SymlinksConfine,
+		MetadataDir:   filepath.Join(".leaps", "metadata"),
+
+		RevisionsDir:   "",
+		RevisionPolicy: PrunePolicy{},
+	}
+}
+
 //------------------------------------------------------------------------------
 
 /*
@@ -47,37 +107,290 @@ filepath relative to the configured directory.
 
 For example, with StoreDirectory set to /var/www, a document can be given the ID
 css/main.css to create and edit the file /var/www/css/main.css
+
+Document IDs that are absolute or that would escape the store directory are
+rejected, and symlinks are treated according to the configured policy. Updates
+are written to a temporary file which is synced and then renamed over the
+original, preserving the mode and owner of the original file, so that a crash
+never leaves a partially written document.
+
+Documents are presented as UTF-8 with LF line endings regardless of the format
+of the file. The encoding (UTF-8, UTF-16 or Latin-1), byte order mark and CRLF
+line endings of a file are detected when it is read and restored when it is
+written.
+
+Document metadata is stored as JSON in sidecar files within the configured
+metadata directory, which is relative to the store directory unless absolute,
+mirroring the paths of the documents, which defaults to .leaps/metadata. Metadata
+is not stored when the metadata directory is empty. As with other stores, writing
+a document replaces its metadata, and so writing it with nil or empty metadata
+removes the sidecar file.
+
+When a revisions directory is configured each write of a document is also
+retained as a revision within it, and revisions outside of the configured
+revision policy are pruned after each write. Revisions are kept when a document
+is deleted so that it can be restored.
+
+The revision of a document is derived from both the modification time and a
+hash of the file contents, which allows changes made to files by other programs
+to be detected. The state of each file after it is written is also reported by
+LastWritten.
 */
 type File struct {
 	storeDirectory string
 	allowWrites    bool
+	symlinkPolicy  string
+	metadataDir    string
+	revisionsDir   string
+	revisionPolicy PrunePolicy
+
+	updateLock sync.Mutex
 
 	cacheLock      sync.Mutex
 	unwrittenCache map[string]Document
+
+	writtenLock sync.Mutex
+	written     map[string]os.FileInfo
 }
 
 // NewFile - Just a func that returns a File based store type.
 func NewFile(storeDirectory string, allowWrites bool) (Type, error) {
-	if len(storeDirectory) == 0 {
+	conf := NewFileConfig()
+	conf.Path = storeDirectory
+	conf.AllowWrites = allowWrites
+	return NewFileFromConfig(conf)
+}
+
+// NewFileFromConfig - Returns a File based store type using a configuration.
+func NewFileFromConfig(config FileConfig) (Type, error) {
+	if len(config.Path) == 0 {
 		return nil, ErrInvalidDirectory
 	}
-	if _, err := os.Stat(storeDirectory); os.IsNotExist(err) {
-		if allowWrites {
-			err = os.MkdirAll(storeDirectory, os.ModePerm)
+	switch config.SymlinkPolicy {
+	case SymlinksFollow, SymlinksConfine, SymlinksReject:
+	default:
+		return nil, fmt.Errorf("symlink policy not recognised: %v", config.SymlinkPolicy)
+	}
+	if _, err := os.Stat(config.Path); os.IsNotExist(err) {
+		if config.AllowWrites {
+			err = os.MkdirAll(config.Path, os.ModePerm)
 		}
 		if err != nil {
 			return nil, fmt.Errorf("cannot access file store for documents: %v", err)
 		}
 	}
 	return &File{
-		storeDirectory: storeDirectory,
-		allowWrites:    allowWrites,
+		storeDirectory: config.Path,
+		allowWrites:    config.AllowWrites,
+		symlinkPolicy:  config.SymlinkPolicy,
+		metadataDir:    storeRelativePath(config.Path, config.MetadataDir),
+		revisionsDir:   storeRelativePath(config.Path, config.RevisionsDir),
+		revisionPolicy: config.RevisionPolicy,
 		unwrittenCache: map[string]Document{},
+		written:        map[string]os.FileInfo{},
 	}, nil
 }
 
 //------------------------------------------------------------------------------
 
+// documentPath - Returns the file path of a document after checking that the ID
+// is confined to the store directory and that the path satisfies the symlink
+// policy. If resolve is set then symlinks are resolved in the returned path.
+func (s *File) documentPath(id string, resolve bool) (string, error) {
+	cleanID := filepath.Clean(filepath.FromSlash(id))
+	if len(id) == 0 || cleanID == "." || filepath.IsAbs(cleanID) || strings.HasPrefix(id, "/") ||
+		len(filepath.VolumeName(cleanID)) > 0 ||
+		cleanID == ".." || strings.HasPrefix(cleanID, ".."+string(filepath.Separator)) {
+		return "", ErrInvalidDocumentID
+	}
+	docPath := filepath.Join(s.storeDirectory, cleanID)
+	if s.isInternalPath(docPath) {
+		return "", ErrInvalidDocumentID
+	}
+
+	switch s.symlinkPolicy {
+	case SymlinksReject:
+		// Check each component of the path below the store directory.
+		p := s.storeDirectory
+		for _, component := range strings.Split(cleanID, string(filepath.Separator)) {
+			p = filepath.Join(p, component)
+			info, err := os.Lstat(p)
+			if err != nil {
+				break
+			}
+			if info.Mode()&os.ModeSymlink != 0 {
+				return "", ErrSymlinkNotAllowed
+			}
+		}
+	case SymlinksConfine:
+		root, err := filepath.EvalSymlinks(s.storeDirectory)
+		if err != nil {
+			return "", err
+		}
+		resolved, err := resolveExisting(docPath)
+		if err != nil {
+			return "", err
+		}
+		if rel, err := filepath.Rel(root, resolved); err != nil || rel == ".." ||
+			strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", ErrSymlinkNotAllowed
+		}
+		if resolve {
+			return resolved, nil
+		}
+	case SymlinksFollow:
+		if resolve {
+			return resolveExisting(docPath)
+		}
+	}
+	return docPath, nil
+}
+
+// storeRelativePath - Returns a path joined with the store directory unless it
+// is empty or absolute.
+func storeRelativePath(storeDirectory, p string) string {
+	if len(p) == 0 || filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(storeDirectory, p)
+}
+
+// isInternalPath - Returns whether a path is within the metadata or revisions
+// directories, which cannot be used for documents.
+func (s *File) isInternalPath(p string) bool {
+	for _, dir := range []string{s.metadataDir, s.revisionsDir} {
+		if len(dir) == 0 {
+			continue
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// metadataPath - Returns the path of the metadata sidecar file of a document,
+// the ID must have already been validated with documentPath.
+func (s *File) metadataPath(id string) string {
+	return filepath.Join(s.metadataDir, filepath.Clean(filepath.FromSlash(id))+".json")
+}
+
+// readMetadata - Reads the metadata of a document from its sidecar file.
+func (s *File) readMetadata(id string) (map[string]string, error) {
+	if len(s.metadataDir) == 0 {
+		return nil, nil
+	}
+	metaBytes, err := ioutil.ReadFile(s.metadataPath(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var metadata map[string]string
+	if err = json.Unmarshal(metaBytes, &metadata); err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+// writeMetadata - Writes the metadata of a document to its sidecar file, or
+// removes the sidecar file if the metadata is empty.
+func (s *File) writeMetadata(id string, metadata map[string]string) error {
+	if len(s.metadataDir) == 0 {
+		return nil
+	}
+	if len(metadata) == 0 {
+		return s.removeMetadata(id)
+	}
+	metaPath := s.metadataPath(id)
+	metaBytes, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(metaPath), os.ModePerm); err != nil {
+		return err
+	}
+	return writeFile(metaPath, metaBytes)
+}
+
+// removeMetadata - Removes the metadata sidecar file of a document, if any.
+func (s *File) removeMetadata(id string) error {
+	if len(s.metadataDir) == 0 {
+		return nil
+	}
+	if err := os.Remove(s.metadataPath(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// resolveExisting - Resolves the symlinks of the deepest existing ancestor of a
+// path, and returns it joined with the remaining components.
+func resolveExisting(p string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(p)
+	if err == nil {
+		return resolved, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+	parent := filepath.Dir(p)
+	if parent == p {
+		return p, nil
+	}
+	if resolved, err = resolveExisting(parent); err != nil {
+		return "", err
+	}
+	return filepath.Join(resolved, filepath.Base(p)), nil
+}
+
+// isTempFile - Returns whether a file name belongs to a temporary file created
+// by writeFile.
+func isTempFile(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.Contains(name, ".leaps-")
+}
+
+// writeFile - Writes content to a temporary file in the same directory as the
+// target, syncs it and then renames it over the target. The mode and owner of
+// an existing target are preserved.
+func writeFile(target string, content []byte) error {
+	dir, base := filepath.Split(target)
+
+	// The temporary file is created with the usual permissions of new files,
+	// which are replaced with those of the target if it already exists.
+	tmpPath := filepath.Join(dir, "."+base+".leaps-"+util.GenerateUUID())
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	if info, statErr := os.Stat(target); statErr == nil {
+		if err = tmp.Chmod(info.Mode().Perm()); err == nil {
+			err = chownLike(tmp, info)
+		}
+	}
+	if err == nil {
+		_, err = tmp.Write(content)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cErr := tmp.Close(); err == nil {
+		err = cErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, target)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return syncDir(dir)
+}
+
+//------------------------------------------------------------------------------
+
 // Create - Create a new document in a file location
 func (s *File) Create(doc Document) error {
 	return s.Update(doc)
@@ -85,27 +398,64 @@ func (s *File) Create(doc Document) error {
 
 // Update - Update a document in its file location.
 func (s *File) Update(doc Document) error {
+	return s.UpdateAuthored(doc, nil)
+}
+
+// UpdateAuthored - Update a document in its file location, the authors are
+// recorded with the revision of the document when revisions are retained.
+func (s *File) UpdateAuthored(doc Document, authors []string) error {
+	filePath, err := s.documentPath(doc.ID, true)
+	if err != nil {
+		return err
+	}
+
+	s.updateLock.Lock()
+	defer s.updateLock.Unlock()
+
+	if len(doc.Revision) > 0 {
+		if current, err := s.Read(doc.ID); err != nil || current.Revision != doc.Revision {
+			return ErrRevisionMismatch
+		}
+	}
+
 	if !s.allowWrites {
 		// Write changes to a local cache rather than the file itself.
+		doc.Revision = contentRevision(doc.Content)
 		s.cacheLock.Lock()
 		s.unwrittenCache[doc.ID] = doc
 		s.cacheLock.Unlock()
 		return nil
 	}
 
-	filePath := filepath.Join(s.storeDirectory, doc.ID)
 	fileDir := filepath.Dir(filePath)
-
-	if _, err := os.Stat(fileDir); os.IsNotExist(err) {
+	if _, err = os.Stat(fileDir); os.IsNotExist(err) {
 		if err = os.MkdirAll(fileDir, os.ModePerm); err != nil {
 			return fmt.Errorf("cannot create file path for document: %v, err: %v", doc.ID, err)
 		}
 	}
-	return ioutil.WriteFile(filePath, []byte(doc.Content), 0666)
+	// Preserve the encoding and line endings of an existing file.
+	format := defaultTextFormat
+	if existing, readErr := ioutil.ReadFile(filePath); readErr == nil {
+		_, format = decodeText(existing)
+	}
+	if err = s.writeDocumentFile(doc.ID, filePath, encodeText(doc.Content, format)); err != nil {
+		return fmt.Errorf("failed to write document file: %v", err)
+	}
+	if err = s.writeMetadata(doc.ID, doc.Metadata); err != nil {
+		return fmt.Errorf("failed to write document metadata: %v", err)
+	}
+	if err = s.writeRevision(doc, authors); err != nil {
+		return fmt.Errorf("failed to record document revision: %v", err)
+	}
+	return nil
 }
 
 // Read - Read document from its file location.
 func (s *File) Read(id string) (Document, error) {
+	filePath, err := s.documentPath(id, true)
+	if err != nil {
+		return Document{}, err
+	}
 	if !s.allowWrites {
 		// If we aren't writing to disk then we might have cached our changes.
 		s.cacheLock.Lock()
@@ -116,14 +466,168 @@ func (s *File) Read(id string) (Document, error) {
 		}
 	}
 
-	bytes, err := ioutil.ReadFile(filepath.Join(s.storeDirectory, id))
+	f, err := os.Open(filePath)
 	if err != nil {
 		return Document{}, fmt.Errorf("failed to read content from document file: %v", err)
 	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return Document{}, fmt.Errorf("failed to read content from document file: %v", err)
+	}
+	bytes, err := ioutil.ReadAll(f)
+	if err != nil {
+		return Document{}, fmt.Errorf("failed to read content from document file: %v", err)
+	}
+	content, _ := decodeText(bytes)
+	metadata, err := s.readMetadata(id)
+	if err != nil {
+		return Document{}, fmt.Errorf("failed to read document metadata: %v", err)
+	}
 	return Document{
-		Content: string(bytes),
-		ID:      id,
+		Content:  content,
+		ID:       id,
+		Revision: fmt.Sprintf("%x-%v", info.ModTime().UnixNano(), contentRevision(content)),
+		Metadata: metadata,
 	}, nil
 }
 
+// writeDocumentFile - Writes the file of a document and records its state after
+// the write. The lock is held throughout so that LastWritten never reports the
+// previous state to a watcher that has already observed the new file.
+func (s *File) writeDocumentFile(id, filePath string, content []byte) error {
+	s.writtenLock.Lock()
+	defer s.writtenLock.Unlock()
+	if err := writeFile(filePath, content); err != nil {
+		return err
+	}
+	if info, err := os.Stat(filePath); err == nil {
+		s.written[id] = info
+	} else {
+		delete(s.written, id)
+	}
+	return nil
+}
+
+// LastWritten - Returns the modification time and size of the file of a
+// document after the most recent write of the store.
+func (s *File) LastWritten(id string) (time.Time, int64, bool) {
+	s.writtenLock.Lock()
+	defer s.writtenLock.Unlock()
+	info, ok := s.written[id]
+	if !ok {
+		return time.Time{}, 0, false
+	}
+	return info.ModTime(), info.Size(), true
+}
+
+// Delete - Remove a document from its file location.
+func (s *File) Delete(id string) error {
+	if !s.allowWrites {
+		return ErrReadOnlyStore
+	}
+	filePath, err := s.documentPath(id, false)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(filePath); err != nil {
+		if os.IsNotExist(err) {
+			return ErrDocumentNotExist
+		}
+		return fmt.Errorf("failed to remove document file: %v", err)
+	}
+	if err = s.removeMetadata(id); err != nil {
+		return fmt.Errorf("failed to remove document metadata: %v", err)
+	}
+	return nil
+}
+
+// Rename - Move a document from its file location to the location of a new ID.
+func (s *File) Rename(oldID, newID string) error {
+	if !s.allowWrites {
+		return ErrReadOnlyStore
+	}
+
+	oldPath, err := s.documentPath(oldID, false)
+	if err != nil {
+		return err
+	}
+	newPath, err := s.documentPath(newID, false)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(oldPath); os.IsNotExist(err) {
+		return ErrDocumentNotExist
+	}
+	if _, err := os.Stat(newPath); err == nil {
+		return ErrDocumentExists
+	}
+	if err := os.MkdirAll(filepath.Dir(newPath), os.ModePerm); err != nil {
+		return fmt.Errorf("cannot create file path for document: %v, err: %v", newID, err)
+	}
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return fmt.Errorf("failed to move document file: %v", err)
+	}
+	if err := s.moveRevisions(oldID, newID); err != nil {
+		return fmt.Errorf("failed to move document revisions: %v", err)
+	}
+	if len(s.metadataDir) > 0 {
+		oldMetaPath, newMetaPath := s.metadataPath(oldID), s.metadataPath(newID)
+		if _, err := os.Stat(oldMetaPath); err == nil {
+			if err = os.MkdirAll(filepath.Dir(newMetaPath), os.ModePerm); err == nil {
+				err = os.Rename(oldMetaPath, newMetaPath)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to move document metadata: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// List - Walks the store directory and returns the relative paths of all files
+// that begin with a prefix.
+func (s *File) List(prefix string) ([]string, error) {
+	idSet := map[string]struct{}{}
+	if err := filepath.Walk(s.storeDirectory, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && s.isInternalPath(p) {
+			return filepath.SkipDir
+		}
+		if !info.Mode().IsRegular() || isTempFile(info.Name()) {
+			return nil
+		}
+		relPath, err := filepath.Rel(s.storeDirectory, p)
+		if err != nil {
+			return err
+		}
+		if id := filepath.ToSlash(relPath); strings.HasPrefix(id, prefix) {
+			idSet[id] = struct{}{}
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to walk document files: %v", err)
+	}
+
+	// Documents created whilst not writing to disk only exist in our cache.
+	s.cacheLock.Lock()
+	for id := range s.unwrittenCache {
+		if strings.HasPrefix(id, prefix) {
+			idSet[id] = struct{}{}
+		}
+	}
+	s.cacheLock.Unlock()
+
+	ids := make([]string, 0, len(idSet))
+	for id := range idSet {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
 //------------------------------------------------------------------------------
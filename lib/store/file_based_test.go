@@ -0,0 +1,609 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+//------------------------------------------------------------------------------
+
+func TestFileDeleteRenameList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_file_store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewFile(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"foo/a.txt", "foo/b.txt", "c.txt"} {
+		if err = s.Create(Document{ID: id, Content: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lister := s.(Lister)
+	if ids, err := lister.List("foo/"); err != nil {
+		t.Error(err)
+	} else if exp := []string{"foo/a.txt", "foo/b.txt"}; !reflect.DeepEqual(exp, ids) {
+		t.Errorf("Wrong listed IDs: %v != %v", exp, ids)
+	}
+
+	renamer := s.(Renamer)
+	if err = renamer.Rename("foo/a.txt", "c.txt"); err != ErrDocumentExists {
+		t.Errorf("Expected ErrDocumentExists, received: %v", err)
+	}
+	if err = renamer.Rename("foo/a.txt", "bar/baz/a.txt"); err != nil {
+		t.Error(err)
+	}
+	if doc, err := s.Read("bar/baz/a.txt"); err != nil {
+		t.Error(err)
+	} else if doc.Content != "foo/a.txt" {
+		t.Errorf("Wrong renamed content: %v", doc.Content)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "foo", "a.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected old file to be removed: %v", err)
+	}
+
+	deleter := s.(Deleter)
+	if err = deleter.Delete("c.txt"); err != nil {
+		t.Error(err)
+	}
+	if err = deleter.Delete("c.txt"); err != ErrDocumentNotExist {
+		t.Errorf("Expected ErrDocumentNotExist, received: %v", err)
+	}
+	if ids, err := lister.List(""); err != nil {
+		t.Error(err)
+	} else if exp := []string{"bar/baz/a.txt", "foo/b.txt"}; !reflect.DeepEqual(exp, ids) {
+		t.Errorf("Wrong listed IDs: %v != %v", exp, ids)
+	}
+}
+
+func TestFileLastWritten(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_file_store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewFile(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := s.(FileWriter)
+
+	if _, _, ok := writer.LastWritten("a.txt"); ok {
+		t.Error("Unwritten document reported as written")
+	}
+	if err = s.Create(Document{ID: "a.txt", Content: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	modTime, size, ok := writer.LastWritten("a.txt")
+	if !ok {
+		t.Fatal("Written document not reported")
+	}
+	if !modTime.Equal(info.ModTime()) || size != info.Size() {
+		t.Errorf("Wrong written state: %v %v != %v %v", modTime, size, info.ModTime(), info.Size())
+	}
+
+	readOnly, err := NewFile(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = readOnly.Update(Document{ID: "a.txt", Content: "cached"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok = readOnly.(FileWriter).LastWritten("a.txt"); ok {
+		t.Error("Cached update reported as written")
+	}
+}
+
+func TestFileReadOnlyModifications(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_file_store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err = ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewFile(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Create(Document{ID: "b.txt", Content: "cached"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if ids, err := s.(Lister).List(""); err != nil {
+		t.Error(err)
+	} else if exp := []string{"a.txt", "b.txt"}; !reflect.DeepEqual(exp, ids) {
+		t.Errorf("Wrong listed IDs: %v != %v", exp, ids)
+	}
+	if err = s.(Deleter).Delete("a.txt"); err != ErrReadOnlyStore {
+		t.Errorf("Expected ErrReadOnlyStore, received: %v", err)
+	}
+	if err = s.(Renamer).Rename("a.txt", "c.txt"); err != ErrReadOnlyStore {
+		t.Errorf("Expected ErrReadOnlyStore, received: %v", err)
+	}
+}
+
+func TestFileRevisions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_file_store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewFile(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Create(Document{ID: "a.txt", Content: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := s.Read("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Revision) == 0 {
+		t.Fatal("Expected document revision")
+	}
+
+	// Modify the file outside of the store.
+	if err = ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello world"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	doc.Content = "hello there"
+	if err = s.Update(doc); err != ErrRevisionMismatch {
+		t.Errorf("Wrong error from stale update: %v", err)
+	}
+
+	if doc, err = s.Read("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	doc.Content = "hello there"
+	if err = s.Update(doc); err != nil {
+		t.Error(err)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestFileInvalidIDs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_file_store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewFile(filepath.Join(dir, "store"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"", ".", "..", "../escaped.txt", "foo/../../escaped.txt", "/etc/passwd"} {
+		if err = s.Create(Document{ID: id, Content: "nope"}); err != ErrInvalidDocumentID {
+			t.Errorf("Expected ErrInvalidDocumentID for %q, received: %v", id, err)
+		}
+		if _, err = s.Read(id); err != ErrInvalidDocumentID {
+			t.Errorf("Expected ErrInvalidDocumentID for %q, received: %v", id, err)
+		}
+	}
+	if _, err = os.Stat(filepath.Join(dir, "escaped.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected escaped file to not exist: %v", err)
+	}
+	if err = s.Create(Document{ID: "foo/../inside.txt", Content: "yep"}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFileSymlinkPolicies(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks are not tested on windows")
+	}
+
+	dir, err := ioutil.TempDir("", "leaps_file_store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	storeDir, outsideDir := filepath.Join(dir, "store"), filepath.Join(dir, "outside")
+	for _, d := range []string{filepath.Join(storeDir, "real"), outsideDir} {
+		if err = os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = ioutil.WriteFile(filepath.Join(storeDir, "real", "a.txt"), []byte("inside"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(outsideDir, "b.txt"), []byte("outside"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Symlink(filepath.Join(storeDir, "real"), filepath.Join(storeDir, "inner")); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Symlink(outsideDir, filepath.Join(storeDir, "escape")); err != nil {
+		t.Fatal(err)
+	}
+
+	type expectation struct {
+		id      string
+		content string
+		err     error
+	}
+	tests := map[string][]expectation{
+		SymlinksFollow: {
+			{"real/a.txt", "inside", nil},
+			{"inner/a.txt", "inside", nil},
+			{"escape/b.txt", "outside", nil},
+		},
+		SymlinksConfine: {
+			{"real/a.txt", "inside", nil},
+			{"inner/a.txt", "inside", nil},
+			{"escape/b.txt", "", ErrSymlinkNotAllowed},
+		},
+		SymlinksReject: {
+			{"real/a.txt", "inside", nil},
+			{"inner/a.txt", "", ErrSymlinkNotAllowed},
+			{"escape/b.txt", "", ErrSymlinkNotAllowed},
+		},
+	}
+
+	for policy, exps := range tests {
+		conf := NewFileConfig()
+		conf.Path = storeDir
+		conf.SymlinkPolicy = policy
+
+		s, err := NewFileFromConfig(conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, exp := range exps {
+			doc, err := s.Read(exp.id)
+			if exp.err != nil {
+				if err != exp.err {
+					t.Errorf("Policy %v, id %v: expected %v, received: %v", policy, exp.id, exp.err, err)
+				}
+				if err = s.Update(Document{ID: exp.id, Content: "changed"}); err != exp.err {
+					t.Errorf("Policy %v, id %v: expected %v, received: %v", policy, exp.id, exp.err, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("Policy %v, id %v: %v", policy, exp.id, err)
+			} else if doc.Content != exp.content {
+				t.Errorf("Policy %v, id %v: wrong content: %v != %v", policy, exp.id, exp.content, doc.Content)
+			}
+		}
+	}
+
+	// Writing through a symlink must not replace the link itself.
+	conf := NewFileConfig()
+	conf.Path = storeDir
+	s, err := NewFileFromConfig(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Update(Document{ID: "inner/a.txt", Content: "updated"}); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Lstat(filepath.Join(storeDir, "inner")); err != nil {
+		t.Error(err)
+	} else if info.Mode()&os.ModeSymlink == 0 {
+		t.Error("Expected symlink to be preserved")
+	}
+	if content, err := ioutil.ReadFile(filepath.Join(storeDir, "real", "a.txt")); err != nil {
+		t.Error(err)
+	} else if string(content) != "updated" {
+		t.Errorf("Wrong content: %s", content)
+	}
+
+	conf.SymlinkPolicy = "nope"
+	if _, err = NewFileFromConfig(conf); err == nil {
+		t.Error("Expected error from unrecognised symlink policy")
+	}
+}
+
+func TestFileAtomicUpdate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_file_store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filePath := filepath.Join(dir, "a.txt")
+	if err = ioutil.WriteFile(filePath, []byte("original"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chmod(filePath, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewFile(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err = s.Update(Document{ID: "a.txt", Content: "updated"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if runtime.GOOS != "windows" {
+		if info, err := os.Stat(filePath); err != nil {
+			t.Error(err)
+		} else if exp, act := os.FileMode(0640), info.Mode().Perm(); exp != act {
+			t.Errorf("Wrong file mode: %v != %v", exp, act)
+		}
+	}
+	if content, err := ioutil.ReadFile(filePath); err != nil {
+		t.Error(err)
+	} else if string(content) != "updated" {
+		t.Errorf("Wrong content: %s", content)
+	}
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 {
+		names := []string{}
+		for _, info := range infos {
+			names = append(names, info.Name())
+		}
+		t.Errorf("Expected only the document file to remain: %v", names)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestFileTextFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_file_store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewFile(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type testCase struct {
+		raw        []byte
+		content    string
+		newContent string
+		newRaw     []byte
+	}
+	tests := map[string]testCase{
+		"utf8.txt": {
+			[]byte("hello\nworld"), "hello\nworld",
+			"hello\nthere", []byte("hello\nthere"),
+		},
+		"crlf.txt": {
+			[]byte("hello\r\nworld\r\n"), "hello\nworld\n",
+			"hello\nthere\n", []byte("hello\r\nthere\r\n"),
+		},
+		"mixed.txt": {
+			[]byte("hello\r\nworld\n"), "hello\r\nworld\n",
+			"hello\r\nthere\n", []byte("hello\r\nthere\n"),
+		},
+		"bom.txt": {
+			[]byte("\xEF\xBB\xBFhello\r\n"), "hello\n",
+			"héllo\n", []byte("\xEF\xBB\xBFh\xC3\xA9llo\r\n"),
+		},
+		"latin1.txt": {
+			[]byte("caf\xE9\n"), "café\n",
+			"café au lait\n", []byte("caf\xE9 au lait\n"),
+		},
+		"latin1_upgrade.txt": {
+			[]byte("caf\xE9\n"), "café\n",
+			"café ☕\n", []byte("café ☕\n"),
+		},
+		"utf16le.txt": {
+			[]byte{0xFF, 0xFE, 'h', 0, 'i', 0, '\r', 0, '\n', 0}, "hi\n",
+			"hé\n", []byte{0xFF, 0xFE, 'h', 0, 0xE9, 0, '\r', 0, '\n', 0},
+		},
+		"utf16be.txt": {
+			[]byte{0xFE, 0xFF, 0, 'h', 0, 'i'}, "hi",
+			"hé", []byte{0xFE, 0xFF, 0, 'h', 0, 0xE9},
+		},
+	}
+
+	for id, test := range tests {
+		if err = ioutil.WriteFile(filepath.Join(dir, id), test.raw, 0644); err != nil {
+			t.Fatal(err)
+		}
+		doc, err := s.Read(id)
+		if err != nil {
+			t.Errorf("%v: %v", id, err)
+			continue
+		}
+		if doc.Content != test.content {
+			t.Errorf("%v: wrong content: %q != %q", id, test.content, doc.Content)
+		}
+		if err = s.Update(Document{ID: id, Content: test.newContent}); err != nil {
+			t.Errorf("%v: %v", id, err)
+			continue
+		}
+		raw, err := ioutil.ReadFile(filepath.Join(dir, id))
+		if err != nil {
+			t.Errorf("%v: %v", id, err)
+		} else if !reflect.DeepEqual(test.newRaw, raw) {
+			t.Errorf("%v: wrong raw content: %q != %q", id, test.newRaw, raw)
+		}
+		if doc, err = s.Read(id); err != nil {
+			t.Errorf("%v: %v", id, err)
+		} else if doc.Content != test.newContent {
+			t.Errorf("%v: wrong updated content: %q != %q", id, test.newContent, doc.Content)
+		}
+	}
+}
+
+func TestFileMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_file_store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := NewFileConfig()
+	conf.Path = dir
+	conf.MetadataDir = filepath.Join(".leaps", "metadata")
+
+	s, err := NewFileFromConfig(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metadata := map[string]string{"title": "Main", "mime_type": "text/css"}
+	if err = s.Create(Document{ID: "css/main.css", Content: "body {}", Metadata: metadata}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Create(Document{ID: "plain.txt", Content: "plain"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if doc, err := s.Read("css/main.css"); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(metadata, doc.Metadata) {
+		t.Errorf("Wrong metadata: %v != %v", metadata, doc.Metadata)
+	}
+	if doc, err := s.Read("plain.txt"); err != nil {
+		t.Error(err)
+	} else if doc.Metadata != nil {
+		t.Errorf("Unexpected metadata: %v", doc.Metadata)
+	}
+
+	if ids, err := s.(Lister).List(""); err != nil {
+		t.Error(err)
+	} else if exp := []string{"css/main.css", "plain.txt"}; !reflect.DeepEqual(exp, ids) {
+		t.Errorf("Wrong listed IDs: %v != %v", exp, ids)
+	}
+	if err = s.Create(Document{ID: ".leaps/metadata/plain.txt.json", Content: "{}"}); err != ErrInvalidDocumentID {
+		t.Errorf("Expected ErrInvalidDocumentID, received: %v", err)
+	}
+
+	if err = s.(Renamer).Rename("css/main.css", "main.css"); err != nil {
+		t.Fatal(err)
+	}
+	if doc, err := s.Read("main.css"); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(metadata, doc.Metadata) {
+		t.Errorf("Wrong renamed metadata: %v != %v", metadata, doc.Metadata)
+	}
+
+	// Updates replace the existing metadata.
+	updated := map[string]string{"title": "Updated"}
+	if err = s.Update(Document{ID: "main.css", Content: "body { margin: 0; }", Metadata: updated}); err != nil {
+		t.Fatal(err)
+	}
+	if doc, err := s.Read("main.css"); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(updated, doc.Metadata) {
+		t.Errorf("Wrong metadata after update: %v != %v", updated, doc.Metadata)
+	}
+
+	if err = s.(Deleter).Delete("main.css"); err != nil {
+		t.Fatal(err)
+	}
+	metaPath := filepath.Join(dir, ".leaps", "metadata", "main.css.json")
+	if _, err = os.Stat(metaPath); !os.IsNotExist(err) {
+		t.Errorf("Expected metadata file to be removed: %v", err)
+	}
+
+	// Updates with empty metadata remove the existing metadata.
+	if err = s.Update(Document{ID: "plain.txt", Content: "plain", Metadata: metadata}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Update(Document{ID: "plain.txt", Content: "plain", Metadata: map[string]string{}}); err != nil {
+		t.Fatal(err)
+	}
+	if doc, err := s.Read("plain.txt"); err != nil {
+		t.Error(err)
+	} else if doc.Metadata != nil {
+		t.Errorf("Unexpected metadata after removal: %v", doc.Metadata)
+	}
+
+	// Updates with nil metadata also remove the existing metadata.
+	if err = s.Update(Document{ID: "plain.txt", Content: "plain", Metadata: metadata}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Update(Document{ID: "plain.txt", Content: "plain"}); err != nil {
+		t.Fatal(err)
+	}
+	if doc, err := s.Read("plain.txt"); err != nil {
+		t.Error(err)
+	} else if doc.Metadata != nil {
+		t.Errorf("Unexpected metadata after nil update: %v", doc.Metadata)
+	}
+
+	// Metadata is stored in a hidden directory by default.
+	if s, err = NewFile(dir, true); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Create(Document{ID: "other.txt", Content: "other", Metadata: metadata}); err != nil {
+		t.Fatal(err)
+	}
+	if doc, err := s.Read("other.txt"); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(metadata, doc.Metadata) {
+		t.Errorf("Wrong default metadata: %v != %v", metadata, doc.Metadata)
+	}
+
+	// Metadata is not stored without a metadata directory.
+	conf.MetadataDir = ""
+	if s, err = NewFileFromConfig(conf); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Create(Document{ID: "another.txt", Content: "another", Metadata: metadata}); err != nil {
+		t.Fatal(err)
+	}
+	if doc, err := s.Read("another.txt"); err != nil {
+		t.Error(err)
+	} else if doc.Metadata != nil {
+		t.Errorf("Unexpected metadata without metadata dir: %v", doc.Metadata)
+	}
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,155 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, sub to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+//------------------------------------------------------------------------------
+
+// Text encodings recognised by the file store.
+const (
+	EncodingUTF8    = "utf-8"
+	EncodingUTF16LE = "utf-16le"
+	EncodingUTF16BE = "utf-16be"
+	EncodingLatin1  = "latin-1"
+)
+
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+)
+
+//------------------------------------------------------------------------------
+
+// textFormat - Describes the encoding, byte order mark and line ending style of
+// a text file, which is used to restore the original format of a file after
+// its content has been normalised to UTF-8 with LF line endings.
+type textFormat struct {
+	Encoding string
+	BOM      bool
+	CRLF     bool
+}
+
+// defaultTextFormat - The format of newly created files.
+var defaultTextFormat = textFormat{Encoding: EncodingUTF8}
+
+// decodeText - Detects the format of raw file contents and returns the content
+// as UTF-8 with LF line endings. Files with a UTF-16 byte order mark are decoded
+// as UTF-16, files that are not valid UTF-8 are decoded as Latin-1. Line
+// endings are only normalised when every line of the file ends with CRLF, so
+// that files with mixed line endings are not changed by a round trip.
+func decodeText(data []byte) (string, textFormat) {
+	var content string
+	format := defaultTextFormat
+
+	switch {
+	case bytes.HasPrefix(data, bomUTF8):
+		format.BOM = true
+		content = string(data[len(bomUTF8):])
+	case bytes.HasPrefix(data, bomUTF16LE) && len(data)%2 == 0:
+		format.Encoding, format.BOM = EncodingUTF16LE, true
+		content = decodeUTF16(data[len(bomUTF16LE):], binary.LittleEndian)
+	case bytes.HasPrefix(data, bomUTF16BE) && len(data)%2 == 0:
+		format.Encoding, format.BOM = EncodingUTF16BE, true
+		content = decodeUTF16(data[len(bomUTF16BE):], binary.BigEndian)
+	case utf8.Valid(data):
+		content = string(data)
+	default:
+		format.Encoding = EncodingLatin1
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		content = string(runes)
+	}
+
+	if crlfCount := strings.Count(content, "\r\n"); crlfCount > 0 &&
+		crlfCount == strings.Count(content, "\n") {
+		format.CRLF = true
+		content = strings.Replace(content, "\r\n", "\n", -1)
+	}
+	return content, format
+}
+
+// encodeText - Converts normalised content back into the raw contents of a
+// file with the given format. Content that cannot be represented in Latin-1 is
+// written as UTF-8 instead in order to avoid losing characters.
+func encodeText(content string, format textFormat) []byte {
+	if format.CRLF {
+		content = strings.Replace(content, "\r\n", "\n", -1)
+		content = strings.Replace(content, "\n", "\r\n", -1)
+	}
+
+	switch format.Encoding {
+	case EncodingUTF16LE:
+		return encodeUTF16(content, bomUTF16LE, format.BOM, binary.LittleEndian)
+	case EncodingUTF16BE:
+		return encodeUTF16(content, bomUTF16BE, format.BOM, binary.BigEndian)
+	case EncodingLatin1:
+		data := make([]byte, 0, len(content))
+		for _, r := range content {
+			if r > 0xFF {
+				return []byte(content)
+			}
+			data = append(data, byte(r))
+		}
+		return data
+	}
+	if format.BOM {
+		return append(append([]byte{}, bomUTF8...), content...)
+	}
+	return []byte(content)
+}
+
+//------------------------------------------------------------------------------
+
+func decodeUTF16(data []byte, order binary.ByteOrder) string {
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		units[i] = order.Uint16(data[i*2:])
+	}
+	return string(utf16.Decode(units))
+}
+
+func encodeUTF16(content string, bom []byte, withBOM bool, order binary.ByteOrder) []byte {
+	units := utf16.Encode([]rune(content))
+	data := make([]byte, 0, len(bom)+len(units)*2)
+	if withBOM {
+		data = append(data, bom...)
+	}
+	unit := make([]byte, 2)
+	for _, u := range units {
+		order.PutUint16(unit, u)
+		data = append(data, unit...)
+	}
+	return data
+}
+
+//------------------------------------------------------------------------------
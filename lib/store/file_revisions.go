@@ -0,0 +1,263 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, sub to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+
+// fileRevision - The contents of a revision file.
+type fileRevision struct {
+	Timestamp time.Time         `json:"timestamp"`
+	Authors   []string          `json:"authors,omitempty"`
+	Content   string            `json:"content"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+}
+
+const fileRevisionExt = ".json"
+
+// fileRevisionID matches the IDs of file revisions, which are the hex encoded
+// nanosecond timestamp of the revision.
+var fileRevisionID = regexp.MustCompile("^[0-9a-f]{16}$")
+
+// revisionsPath - Returns the directory containing the revisions of a document,
+// the ID must have already been validated with documentPath.
+func (s *File) revisionsPath(id string) string {
+	return filepath.Join(s.revisionsDir, filepath.Clean(filepath.FromSlash(id)))
+}
+
+// writeRevision - Records a revision of a document and prunes revisions that
+// fall outside the revision policy. Must be called with the update lock held.
+func (s *File) writeRevision(doc Document, authors []string) error {
+	if len(s.revisionsDir) == 0 {
+		return nil
+	}
+	dir := s.revisionsPath(doc.ID)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	stamp := now.UnixNano()
+	revPath := filepath.Join(dir, fmt.Sprintf("%016x", stamp)+fileRevisionExt)
+	for {
+		// Revisions written within the same nanosecond are given the next
+		// free timestamp.
+		if _, err := os.Stat(revPath); os.IsNotExist(err) {
+			break
+		}
+		stamp++
+		revPath = filepath.Join(dir, fmt.Sprintf("%016x", stamp)+fileRevisionExt)
+	}
+
+	revBytes, err := json.Marshal(fileRevision{
+		Timestamp: now,
+		Authors:   authors,
+		Content:   doc.Content,
+		Metadata:  doc.Metadata,
+	})
+	if err != nil {
+		return err
+	}
+	if err = writeFile(revPath, revBytes); err != nil {
+		return err
+	}
+	if s.revisionPolicy == (PrunePolicy{}) {
+		return nil
+	}
+	return s.PruneRevisions(doc.ID, s.revisionPolicy)
+}
+
+// readRevisionFile - Reads a revision of a document.
+func (s *File) readRevisionFile(id, revision string) (fileRevision, error) {
+	var rev fileRevision
+	if !fileRevisionID.MatchString(revision) {
+		return rev, ErrRevisionNotExist
+	}
+	revBytes, err := ioutil.ReadFile(filepath.Join(s.revisionsPath(id), revision+fileRevisionExt))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return rev, ErrRevisionNotExist
+		}
+		return rev, err
+	}
+	if err = json.Unmarshal(revBytes, &rev); err != nil {
+		return rev, fmt.Errorf("failed to parse document revision: %v", err)
+	}
+	return rev, nil
+}
+
+// readRevisionInfo - Reads the timestamp and authors of a revision. These are
+// the first fields of a revision file and so the content and metadata that
+// follow them are not read.
+func (s *File) readRevisionInfo(id, revision string) (RevisionInfo, error) {
+	info := RevisionInfo{ID: revision}
+	revFile, err := os.Open(filepath.Join(s.revisionsPath(id), revision+fileRevisionExt))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return info, ErrRevisionNotExist
+		}
+		return info, err
+	}
+	defer revFile.Close()
+
+	dec := json.NewDecoder(revFile)
+	tok, err := dec.Token()
+	if err == nil && tok != json.Delim('{') {
+		err = fmt.Errorf("unexpected token %v", tok)
+	}
+	if err != nil {
+		return info, fmt.Errorf("failed to parse document revision: %v", err)
+	}
+	for dec.More() {
+		if tok, err = dec.Token(); err != nil {
+			return info, fmt.Errorf("failed to parse document revision: %v", err)
+		}
+		switch tok {
+		case "timestamp":
+			err = dec.Decode(&info.Timestamp)
+		case "authors":
+			err = dec.Decode(&info.Authors)
+		default:
+			return info, nil
+		}
+		if err != nil {
+			return info, fmt.Errorf("failed to parse document revision: %v", err)
+		}
+	}
+	return info, nil
+}
+
+// revisionIDs - Returns the IDs of all revisions of a document, newest first.
+func (s *File) revisionIDs(id string) ([]string, error) {
+	infos, err := ioutil.ReadDir(s.revisionsPath(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+	ids := []string{}
+	for _, info := range infos {
+		// The directory also contains the revisions of nested documents.
+		if info.IsDir() || !strings.HasSuffix(info.Name(), fileRevisionExt) {
+			continue
+		}
+		if revID := strings.TrimSuffix(info.Name(), fileRevisionExt); fileRevisionID.MatchString(revID) {
+			ids = append(ids, revID)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(ids)))
+	return ids, nil
+}
+
+// moveRevisions - Moves the revisions of a document to a new ID.
+func (s *File) moveRevisions(oldID, newID string) error {
+	if len(s.revisionsDir) == 0 {
+		return nil
+	}
+	revIDs, err := s.revisionIDs(oldID)
+	if err != nil || len(revIDs) == 0 {
+		return err
+	}
+	oldDir, newDir := s.revisionsPath(oldID), s.revisionsPath(newID)
+	if err = os.MkdirAll(newDir, os.ModePerm); err != nil {
+		return err
+	}
+	for _, revID := range revIDs {
+		name := revID + fileRevisionExt
+		if err = os.Rename(filepath.Join(oldDir, name), filepath.Join(newDir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//------------------------------------------------------------------------------
+
+// ListRevisions - Returns the retained revisions of a document, newest first.
+func (s *File) ListRevisions(id string) ([]RevisionInfo, error) {
+	if len(s.revisionsDir) == 0 {
+		return nil, ErrNotVersioned
+	}
+	if _, err := s.documentPath(id, false); err != nil {
+		return nil, err
+	}
+	revIDs, err := s.revisionIDs(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list document revisions: %v", err)
+	}
+	revisions := make([]RevisionInfo, 0, len(revIDs))
+	for _, revID := range revIDs {
+		info, err := s.readRevisionInfo(id, revID)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, info)
+	}
+	return revisions, nil
+}
+
+// ReadRevision - Reads the content and metadata of a document as of a retained
+// revision.
+func (s *File) ReadRevision(id, revision string) (Document, error) {
+	if len(s.revisionsDir) == 0 {
+		return Document{}, ErrNotVersioned
+	}
+	if _, err := s.documentPath(id, false); err != nil {
+		return Document{}, err
+	}
+	rev, err := s.readRevisionFile(id, revision)
+	if err != nil {
+		return Document{}, err
+	}
+	return Document{ID: id, Content: rev.Content, Metadata: rev.Metadata}, nil
+}
+
+// PruneRevisions - Removes the retained revisions of a document that fall
+// outside a policy.
+func (s *File) PruneRevisions(id string, policy PrunePolicy) error {
+	revisions, err := s.ListRevisions(id)
+	if err != nil {
+		return err
+	}
+	dir := s.revisionsPath(id)
+	for _, rev := range policy.Expired(revisions, time.Now()) {
+		if err = os.Remove(filepath.Join(dir, rev.ID+fileRevisionExt)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to prune document revision: %v", err)
+		}
+	}
+	return nil
+}
+
+//------------------------------------------------------------------------------
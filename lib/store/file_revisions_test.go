@@ -0,0 +1,217 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+
+func TestFileRetainedRevisions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_file_store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := NewFileConfig()
+	conf.Path = dir
+	conf.RevisionsDir = filepath.Join(".leaps", "revisions")
+	conf.RevisionPolicy.MaxRevisions = 3
+
+	s, err := NewFileFromConfig(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	versioned := s.(Versioned)
+
+	contents := []string{"first", "second", "third", "fourth"}
+	for i, content := range contents {
+		authors := []string{"alice"}
+		if i%2 == 1 {
+			authors = append(authors, "bob")
+		}
+		if err = s.(AuthoredUpdater).UpdateAuthored(Document{ID: "foo/a.txt", Content: content}, authors); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.Create(Document{ID: "foo/b.txt", Content: "other"}); err != nil {
+		t.Fatal(err)
+	}
+
+	revisions, err := versioned.ListRevisions("foo/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 3 {
+		t.Fatalf("Wrong count of revisions: %v != %v", 3, len(revisions))
+	}
+	for i, rev := range revisions {
+		doc, err := versioned.ReadRevision("foo/a.txt", rev.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exp := contents[len(contents)-1-i]; doc.Content != exp {
+			t.Errorf("Wrong revision content: %v != %v", exp, doc.Content)
+		}
+		if i > 0 && rev.Timestamp.After(revisions[i-1].Timestamp) {
+			t.Errorf("Revisions out of order: %v", revisions)
+		}
+	}
+	if exp, act := []string{"alice", "bob"}, revisions[0].Authors; !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong revision authors: %v != %v", exp, act)
+	}
+
+	if _, err = versioned.ReadRevision("foo/a.txt", "../b.txt"); err != ErrRevisionNotExist {
+		t.Errorf("Expected ErrRevisionNotExist, received: %v", err)
+	}
+	if _, err = versioned.ListRevisions("../a.txt"); err != ErrInvalidDocumentID {
+		t.Errorf("Expected ErrInvalidDocumentID, received: %v", err)
+	}
+
+	if err = versioned.PruneRevisions("foo/a.txt", PrunePolicy{MaxAge: time.Nanosecond}); err != nil {
+		t.Fatal(err)
+	}
+	if revisions, err = versioned.ListRevisions("foo/a.txt"); err != nil {
+		t.Fatal(err)
+	} else if len(revisions) != 1 {
+		t.Errorf("Expected only the newest revision to remain: %v", revisions)
+	}
+
+	if err = s.(Renamer).Rename("foo/a.txt", "bar.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if revisions, err = versioned.ListRevisions("bar.txt"); err != nil {
+		t.Fatal(err)
+	} else if len(revisions) != 1 {
+		t.Errorf("Expected revisions to be renamed: %v", revisions)
+	}
+	if revisions, err = versioned.ListRevisions("foo/b.txt"); err != nil {
+		t.Fatal(err)
+	} else if len(revisions) != 1 {
+		t.Errorf("Wrong revisions of other document: %v", revisions)
+	}
+
+	if ids, err := s.(Lister).List(""); err != nil {
+		t.Error(err)
+	} else if exp := []string{"bar.txt", "foo/b.txt"}; !reflect.DeepEqual(exp, ids) {
+		t.Errorf("Wrong listed IDs: %v != %v", exp, ids)
+	}
+
+	unversioned, err := NewFile(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = unversioned.(Versioned).ListRevisions("bar.txt"); err != ErrNotVersioned {
+		t.Errorf("Expected ErrNotVersioned, received: %v", err)
+	}
+}
+
+func TestFileRevisionInfoHeaders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_file_store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := NewFileConfig()
+	conf.Path = dir
+	conf.RevisionsDir = filepath.Join(".leaps", "revisions")
+
+	s, err := NewFileFromConfig(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stamp := time.Unix(10, 0).UTC()
+
+	// Listing revisions only reads the fields preceding the content.
+	revPath := filepath.Join(dir, ".leaps", "revisions", "a.txt", "000000000000000a.json")
+	if err = os.MkdirAll(filepath.Dir(revPath), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	header := `{"timestamp":"` + stamp.Format(time.RFC3339Nano) + `","authors":["alice"],"content":"trunc`
+	if err = ioutil.WriteFile(revPath, []byte(header), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	revisions, err := s.(Versioned).ListRevisions("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []RevisionInfo{{ID: "000000000000000a", Timestamp: stamp, Authors: []string{"alice"}}}
+	if len(revisions) != 1 || !revisions[0].Timestamp.Equal(stamp) {
+		t.Fatalf("Wrong revisions: %v != %v", exp, revisions)
+	}
+	revisions[0].Timestamp = stamp
+	if !reflect.DeepEqual(exp, revisions) {
+		t.Errorf("Wrong revisions: %v != %v", exp, revisions)
+	}
+	if _, err = s.(Versioned).ReadRevision("a.txt", "000000000000000a"); err == nil {
+		t.Error("Expected error from reading truncated revision")
+	}
+
+	if err = ioutil.WriteFile(revPath, []byte(`["not", "a", "revision"]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.(Versioned).ListRevisions("a.txt"); err == nil {
+		t.Error("Expected error from malformed revision")
+	}
+}
+
+func TestPrunePolicy(t *testing.T) {
+	now := time.Now()
+	revisions := []RevisionInfo{
+		{ID: "4", Timestamp: now.Add(-time.Hour * 4)},
+		{ID: "3", Timestamp: now.Add(-time.Hour * 5)},
+		{ID: "2", Timestamp: now.Add(-time.Hour * 6)},
+		{ID: "1", Timestamp: now.Add(-time.Hour * 7)},
+	}
+
+	type testCase struct {
+		policy PrunePolicy
+		exp    []string
+	}
+	for _, test := range []testCase{
+		{PrunePolicy{}, []string{}},
+		{PrunePolicy{MaxRevisions: 2}, []string{"2", "1"}},
+		{PrunePolicy{MaxAge: time.Hour * 6}, []string{"1"}},
+		{PrunePolicy{MaxAge: time.Hour}, []string{"3", "2", "1"}},
+		{PrunePolicy{MaxRevisions: 3, MaxAge: time.Hour * 5}, []string{"2", "1"}},
+	} {
+		act := []string{}
+		for _, rev := range test.policy.Expired(revisions, now) {
+			act = append(act, rev.ID)
+		}
+		if !reflect.DeepEqual(test.exp, act) {
+			t.Errorf("Wrong expired revisions for %+v: %v != %v", test.policy, test.exp, act)
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,432 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, sub to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jeffail/leaps/lib/util/service/log"
+)
+
+//------------------------------------------------------------------------------
+
+func init() {
+	constructors["git"] = typeSpec{
+		constructor: func(conf Config, logger log.Modular) (Type, error) {
+			return NewGit(conf.Git, logger)
+		},
+		description: `Stores documents as files within a git repository in the same way as the file
+store, and periodically commits changes to a dedicated branch.`,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// Errors for the Git store type.
+var (
+	ErrGitNotFound = errors.New("git binary was not found in PATH")
+)
+
+//------------------------------------------------------------------------------
+
+// GitConfig - Holds configuration options for a git based document store.
+type GitConfig struct {
+	Path           string `json:"path" yaml:"path"`
+	Branch         string `json:"branch" yaml:"branch"`
+	CommitPeriodMS int64  `json:"commit_period_ms" yaml:"commit_period_ms"`
+	CommitterName  string `json:"committer_name" yaml:"committer_name"`
+	CommitterEmail string `json:"committer_email" yaml:"committer_email"`
+	AuthorDomain   string `json:"author_email_domain" yaml:"author_email_domain"`
+	SymlinkPolicy  string `json:"symlink_policy" yaml:"symlink_policy"`
+}
+
+// NewGitConfig - Returns a default configuration for a git store.
+func NewGitConfig() GitConfig {
+	return GitConfig{
+		Path:           ".",
+		Branch:         "leaps",
+		CommitPeriodMS: 60000,
+		CommitterName:  "leaps",
+		CommitterEmail: "leaps@localhost",
+		AuthorDomain:   "localhost",
+		SymlinkPolicy:  SymlinksConfine,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+/*
+Git - A document store that writes documents to the working tree of a git
+repository in the same way as the File store, but also records periodic commits
+of changed documents to a dedicated branch.
+
+Commits are built with a separate index file so that neither the checked out
+branch nor the index of the user are modified. If the branch does not exist it
+is created from HEAD. Users that contributed to a document since the last
+commit, as provided through UpdateAuthored, are recorded as the author and
+co-authors of the commit.
+*/
+type Git struct {
+	config    GitConfig
+	files     *File
+	indexPath string
+
+	log log.Modular
+
+	pendingMut sync.Mutex
+	pending    map[string]map[string]struct{}
+
+	commitMut sync.Mutex
+
+	closeChan  chan struct{}
+	closedChan chan struct{}
+}
+
+// NewGit - Creates a git store for a directory, the directory is initialised
+// as a git repository if it is not already within one.
+func NewGit(config GitConfig, logger log.Modular) (*Git, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, ErrGitNotFound
+	}
+	fileConf := NewFileConfig()
+	fileConf.Path = config.Path
+	fileConf.SymlinkPolicy = config.SymlinkPolicy
+	files, err := NewFileFromConfig(fileConf)
+	if err != nil {
+		return nil, err
+	}
+	g := &Git{
+		config:     config,
+		files:      files.(*File),
+		log:        logger.NewModule(":store:git"),
+		pending:    map[string]map[string]struct{}{},
+		closeChan:  make(chan struct{}),
+		closedChan: make(chan struct{}),
+	}
+	if _, err = g.git(nil, "", "rev-parse", "--is-inside-work-tree"); err != nil {
+		if _, err = g.git(nil, "", "init"); err != nil {
+			return nil, err
+		}
+	}
+	gitDir, err := g.git(nil, "", "rev-parse", "--absolute-git-dir")
+	if err != nil {
+		return nil, err
+	}
+	g.indexPath = filepath.Join(gitDir, "leaps-index")
+
+	go g.loop()
+	return g, nil
+}
+
+//------------------------------------------------------------------------------
+
+// Create - Create a new document in the working tree.
+func (g *Git) Create(doc Document) error {
+	return g.UpdateAuthored(doc, nil)
+}
+
+// Update - Update a document in the working tree.
+func (g *Git) Update(doc Document) error {
+	return g.UpdateAuthored(doc, nil)
+}
+
+// UpdateAuthored - Update a document in the working tree and record its
+// authors for the next commit.
+func (g *Git) UpdateAuthored(doc Document, authors []string) error {
+	if err := g.files.UpdateAuthored(doc, authors); err != nil {
+		return err
+	}
+	g.markPending(doc.ID, authors)
+	return nil
+}
+
+// Read - Read a document from the working tree.
+func (g *Git) Read(id string) (Document, error) {
+	return g.files.Read(id)
+}
+
+// LastWritten - Returns the state of the file of a document after the most
+// recent write of the store.
+func (g *Git) LastWritten(id string) (time.Time, int64, bool) {
+	return g.files.LastWritten(id)
+}
+
+// Delete - Remove a document from the working tree, the removal is recorded in
+// the next commit.
+func (g *Git) Delete(id string) error {
+	if err := g.files.Delete(id); err != nil {
+		return err
+	}
+	g.markPending(id, nil)
+	return nil
+}
+
+// Rename - Move a document within the working tree, the move is recorded in
+// the next commit.
+func (g *Git) Rename(oldID, newID string) error {
+	if err := g.files.Rename(oldID, newID); err != nil {
+		return err
+	}
+	g.markPending(oldID, nil)
+	g.markPending(newID, nil)
+	return nil
+}
+
+// List - List the documents of the working tree beginning with a prefix.
+func (g *Git) List(prefix string) ([]string, error) {
+	return g.files.List(prefix)
+}
+
+// Close - Stops committing periodically and commits any outstanding changes.
+func (g *Git) Close() error {
+	close(g.closeChan)
+	<-g.closedChan
+	return g.Commit()
+}
+
+//------------------------------------------------------------------------------
+
+// markPending - Flags a document as changed since the last commit.
+func (g *Git) markPending(id string, authors []string) {
+	g.pendingMut.Lock()
+	defer g.pendingMut.Unlock()
+
+	docAuthors, exists := g.pending[id]
+	if !exists {
+		docAuthors = map[string]struct{}{}
+		g.pending[id] = docAuthors
+	}
+	for _, author := range authors {
+		docAuthors[author] = struct{}{}
+	}
+}
+
+// Commit - Commits all documents changed since the previous commit to the
+// configured branch. Does nothing if there are no changes.
+func (g *Git) Commit() error {
+	g.commitMut.Lock()
+	defer g.commitMut.Unlock()
+
+	g.pendingMut.Lock()
+	pending := g.pending
+	g.pending = map[string]map[string]struct{}{}
+	g.pendingMut.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+	if err := g.commit(pending); err != nil {
+		// Restore the pending changes so that they are included in the next
+		// attempt.
+		g.pendingMut.Lock()
+		for id, authors := range pending {
+			docAuthors, exists := g.pending[id]
+			if !exists {
+				g.pending[id] = authors
+				continue
+			}
+			for author := range authors {
+				docAuthors[author] = struct{}{}
+			}
+		}
+		g.pendingMut.Unlock()
+		return err
+	}
+	return nil
+}
+
+// commit - Writes a commit containing the current working tree content of a
+// set of documents onto the branch.
+func (g *Git) commit(pending map[string]map[string]struct{}) error {
+	ref := "refs/heads/" + g.config.Branch
+	env := []string{"GIT_INDEX_FILE=" + g.indexPath}
+
+	// The expected old value of the branch given to update-ref, which is empty
+	// when the branch is new so that the update fails if it was created since.
+	var oldValue string
+
+	parent, err := g.git(nil, "", "rev-parse", "--verify", "-q", ref+"^{commit}")
+	if err == nil {
+		oldValue = parent
+	} else {
+		// If the branch does not yet exist then it is started from HEAD, which
+		// may not exist either in a fresh repository.
+		parent, _ = g.git(nil, "", "rev-parse", "--verify", "-q", "HEAD^{commit}")
+	}
+	if len(parent) > 0 {
+		_, err = g.git(env, "", "read-tree", parent)
+	} else {
+		_, err = g.git(env, "", "read-tree", "--empty")
+	}
+	if err != nil {
+		return err
+	}
+
+	ids := make([]string, 0, len(pending))
+	authorSet := map[string]struct{}{}
+	for id, authors := range pending {
+		ids = append(ids, id)
+		for author := range authors {
+			if author = sanitiseAuthor(author); len(author) > 0 {
+				authorSet[author] = struct{}{}
+			}
+		}
+	}
+	sort.Strings(ids)
+
+	args := append([]string{"update-index", "--add", "--remove", "--"}, ids...)
+	if _, err = g.git(env, "", args...); err != nil {
+		return err
+	}
+	tree, err := g.git(env, "", "write-tree")
+	if err != nil {
+		return err
+	}
+	if len(parent) > 0 {
+		if parentTree, _ := g.git(nil, "", "rev-parse", parent+"^{tree}"); parentTree == tree {
+			return nil
+		}
+	}
+
+	authors := make([]string, 0, len(authorSet))
+	for author := range authorSet {
+		authors = append(authors, author)
+	}
+	sort.Strings(authors)
+
+	commitEnv := []string{
+		"GIT_COMMITTER_NAME=" + g.config.CommitterName,
+		"GIT_COMMITTER_EMAIL=" + g.config.CommitterEmail,
+		"GIT_AUTHOR_NAME=" + g.config.CommitterName,
+		"GIT_AUTHOR_EMAIL=" + g.config.CommitterEmail,
+	}
+	if len(authors) > 0 {
+		commitEnv[2] = "GIT_AUTHOR_NAME=" + authors[0]
+		commitEnv[3] = "GIT_AUTHOR_EMAIL=" + g.authorEmail(authors[0])
+	}
+
+	commitArgs := []string{"commit-tree", tree, "-F", "-"}
+	if len(parent) > 0 {
+		commitArgs = append(commitArgs, "-p", parent)
+	}
+	commit, err := g.git(commitEnv, g.commitMessage(ids, authors), commitArgs...)
+	if err != nil {
+		return err
+	}
+	_, err = g.git(nil, "", "update-ref", ref, commit, oldValue)
+	return err
+}
+
+// sanitiseAuthor - Removes characters from a username that would break the
+// author identity or trailers of a commit, such as newlines and angle
+// brackets.
+func sanitiseAuthor(author string) string {
+	author = strings.Map(func(r rune) rune {
+		switch r {
+		case '\n', '\r', '<', '>':
+			return -1
+		}
+		return r
+	}, author)
+	return strings.TrimSpace(author)
+}
+
+// commitMessage - Creates a commit message listing the changed documents, with
+// co-author trailers for each author after the first.
+func (g *Git) commitMessage(ids, authors []string) string {
+	var buf bytes.Buffer
+	if len(ids) == 1 {
+		fmt.Fprintf(&buf, "Update %v\n", ids[0])
+	} else {
+		fmt.Fprintf(&buf, "Update %v documents\n\n", len(ids))
+		for _, id := range ids {
+			fmt.Fprintf(&buf, "- %v\n", id)
+		}
+	}
+	if len(authors) > 1 {
+		buf.WriteString("\n")
+		for _, author := range authors[1:] {
+			fmt.Fprintf(&buf, "Co-authored-by: %v <%v>\n", author, g.authorEmail(author))
+		}
+	}
+	return buf.String()
+}
+
+// authorEmail - Returns the email address used for an author.
+func (g *Git) authorEmail(author string) string {
+	return strings.Replace(author, " ", ".", -1) + "@" + g.config.AuthorDomain
+}
+
+// git - Runs a git command within the store directory and returns its trimmed
+// output.
+func (g *Git) git(env []string, stdin string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = g.config.Path
+	cmd.Env = append(os.Environ(), env...)
+	if len(stdin) > 0 {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %v: %v: %v", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// loop - Commits outstanding changes periodically until the store is closed.
+func (g *Git) loop() {
+	defer close(g.closedChan)
+
+	commitPeriod := time.Duration(g.config.CommitPeriodMS) * time.Millisecond
+	if commitPeriod <= 0 {
+		<-g.closeChan
+		return
+	}
+	ticker := time.NewTicker(commitPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := g.Commit(); err != nil {
+				g.log.Errorf("Failed to commit changes: %v\n", err)
+			}
+		case <-g.closeChan:
+			return
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,178 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Jeffail/leaps/lib/util/service/log"
+)
+
+//------------------------------------------------------------------------------
+
+func gitOutput(t *testing.T, dir string, args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("git %v: %v", args, err)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func TestGitCommits(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir, err := ioutil.TempDir("", "leaps_git_store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := NewGitConfig()
+	conf.Path = dir
+	conf.CommitPeriodMS = 0
+
+	g, err := NewGit(conf, log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = g.Create(Document{ID: "foo/a.txt", Content: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = g.UpdateAuthored(Document{ID: "foo/a.txt", Content: "hello world"}, []string{"alice", "bob"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = g.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if exp, act := "hello world", gitOutput(t, dir, "show", "leaps:foo/a.txt"); exp != act {
+		t.Errorf("Wrong committed content: %v != %v", exp, act)
+	}
+	if exp, act := "alice", gitOutput(t, dir, "log", "-1", "--format=%an", "leaps"); exp != act {
+		t.Errorf("Wrong commit author: %v != %v", exp, act)
+	}
+	if msg := gitOutput(t, dir, "log", "-1", "--format=%B", "leaps"); !strings.Contains(msg, "Co-authored-by: bob <bob@localhost>") {
+		t.Errorf("Missing co-author trailer: %v", msg)
+	}
+
+	// The index and checked out branch of the user should be untouched.
+	if status := gitOutput(t, dir, "status", "--porcelain"); !strings.Contains(status, "?? foo/") {
+		t.Errorf("Unexpected working tree status: %v", status)
+	}
+
+	// Committing without changes should not create a commit.
+	if err = g.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := "1", gitOutput(t, dir, "rev-list", "--count", "leaps"); exp != act {
+		t.Errorf("Wrong count of commits: %v != %v", exp, act)
+	}
+
+	if err = g.Rename("foo/a.txt", "b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err = g.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if exp, act := "b.txt", gitOutput(t, dir, "ls-tree", "-r", "--name-only", "leaps"); exp != act {
+		t.Errorf("Wrong committed files: %v != %v", exp, act)
+	}
+	if exp, act := "2", gitOutput(t, dir, "rev-list", "--count", "leaps"); exp != act {
+		t.Errorf("Wrong count of commits: %v != %v", exp, act)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "b.txt")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGitCommitsFromExistingHead(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir, err := ioutil.TempDir("", "leaps_git_store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	gitOutput(t, dir, "init")
+	if err = ioutil.WriteFile(filepath.Join(dir, "readme.md"), []byte("readme"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitOutput(t, dir, "add", "readme.md")
+	gitOutput(t, dir, "-c", "user.name=test", "-c", "user.email=test@localhost", "commit", "-m", "initial")
+	head := gitOutput(t, dir, "rev-parse", "HEAD")
+
+	conf := NewGitConfig()
+	conf.Path = dir
+	conf.CommitPeriodMS = 0
+
+	g, err := NewGit(conf, log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+
+	if err = g.UpdateAuthored(
+		Document{ID: "a.txt", Content: "hello"}, []string{"alice <evil@example.com>", "bob\nSigned-off-by: eve"},
+	); err != nil {
+		t.Fatal(err)
+	}
+	if err = g.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if exp, act := head, gitOutput(t, dir, "rev-parse", "leaps^"); exp != act {
+		t.Errorf("Wrong parent of first commit: %v != %v", exp, act)
+	}
+	if exp, act := "a.txt\nreadme.md", gitOutput(t, dir, "ls-tree", "-r", "--name-only", "leaps"); exp != act {
+		t.Errorf("Wrong committed files: %v != %v", exp, act)
+	}
+	if exp, act := head, gitOutput(t, dir, "rev-parse", "HEAD"); exp != act {
+		t.Errorf("HEAD was moved: %v != %v", exp, act)
+	}
+	if exp, act := "alice evil@example.com", gitOutput(t, dir, "log", "-1", "--format=%an", "leaps"); exp != act {
+		t.Errorf("Wrong commit author: %v != %v", exp, act)
+	}
+	msg := gitOutput(t, dir, "log", "-1", "--format=%B", "leaps")
+	if strings.Contains(msg, "\nSigned-off-by") {
+		t.Errorf("Author name injected a trailer: %v", msg)
+	}
+	if !strings.Contains(msg, "Co-authored-by: bobSigned-off-by: eve <") {
+		t.Errorf("Missing co-author trailer: %v", msg)
+	}
+}
+
+//------------------------------------------------------------------------------
@@ -22,6 +22,22 @@ THE SOFTWARE.
 
 package store
 
+import (
+	"errors"
+	"time"
+)
+
+//--------------------------------------------------------------------------------------------------
+
+// Errors shared by store types.
+var (
+	ErrDocumentExists   = errors.New("a document already exists with that ID")
+	ErrReadOnlyStore    = errors.New("store does not allow modifications")
+	ErrRevisionMismatch = errors.New("document has been modified since the revision was read")
+	ErrRevisionNotExist = errors.New("document revision does not exist")
+	ErrNotVersioned     = errors.New("store is not configured to retain revisions")
+)
+
 //--------------------------------------------------------------------------------------------------
 
 /*
@@ -35,8 +51,112 @@ type Type interface {
 	// Read - Read a document.
 	Read(ID string) (Document, error)
 
-	// Update - Update an existing document.
+	// Update - Update an existing document, if the document has a revision then the update must
+	// fail with ErrRevisionMismatch when the stored document is of a different revision.
 	Update(Document) error
 }
 
 //--------------------------------------------------------------------------------------------------
+
+/*
+AuthoredUpdater - Implemented by store types able to record the authors of an update, such as
+stores that keep a history of changes. This is an optional capability and should be checked for
+with a type assertion.
+*/
+type AuthoredUpdater interface {
+	// UpdateAuthored - Update an existing document with the names of the users that contributed to
+	// the changes since the previous update.
+	UpdateAuthored(doc Document, authors []string) error
+}
+
+/*
+Deleter - Implemented by store types able to remove documents. This is an optional capability and
+should be checked for with a type assertion.
+*/
+type Deleter interface {
+	// Delete - Remove an existing document.
+	Delete(ID string) error
+}
+
+/*
+Renamer - Implemented by store types able to move a document to a new ID. This is an optional
+capability and should be checked for with a type assertion.
+*/
+type Renamer interface {
+	// Rename - Move an existing document to a new ID, the new ID must not already be in use.
+	Rename(oldID, newID string) error
+}
+
+/*
+Lister - Implemented by store types able to enumerate the documents they contain. This is an
+optional capability and should be checked for with a type assertion.
+*/
+type Lister interface {
+	// List - Return the IDs of all documents beginning with a prefix in lexicographical order.
+	List(prefix string) ([]string, error)
+}
+
+/*
+FileWriter - Implemented by store types that keep each document in a file, in order to report the
+state in which their most recent write left the file of a document. This allows changes made to the
+files by other programs to be told apart from writes of the store. This is an optional capability
+and should be checked for with a type assertion.
+*/
+type FileWriter interface {
+	// LastWritten - Return the modification time and size of the file of a document after the
+	// most recent write of the store, or false if the store has not written it.
+	LastWritten(ID string) (modTime time.Time, size int64, ok bool)
+}
+
+//--------------------------------------------------------------------------------------------------
+
+/*
+Versioned - Implemented by store types able to retain a revision of a document each time it is
+written. This is an optional capability and should be checked for with a type assertion, stores
+that implement it may still return ErrNotVersioned when they are not configured to retain
+revisions.
+*/
+type Versioned interface {
+	// ListRevisions - Return the retained revisions of a document, newest first.
+	ListRevisions(ID string) ([]RevisionInfo, error)
+
+	// ReadRevision - Read the content and metadata of a document as of a retained revision.
+	ReadRevision(ID, revision string) (Document, error)
+
+	// PruneRevisions - Remove the retained revisions of a document that fall outside a policy.
+	PruneRevisions(ID string, policy PrunePolicy) error
+}
+
+// RevisionInfo - Describes a retained revision of a document.
+type RevisionInfo struct {
+	ID        string    `json:"id" yaml:"id"`
+	Timestamp time.Time `json:"timestamp" yaml:"timestamp"`
+	Authors   []string  `json:"authors,omitempty" yaml:"authors,omitempty"`
+}
+
+/*
+PrunePolicy - Determines which retained revisions of a document are removed when pruning. Revisions
+beyond the newest MaxRevisions, and revisions older than MaxAge, are removed. A zero value for either
+field disables that limit, and the newest revision is always kept.
+*/
+type PrunePolicy struct {
+	MaxRevisions int           `json:"max_revisions" yaml:"max_revisions"`
+	MaxAge       time.Duration `json:"max_age" yaml:"max_age"`
+}
+
+// Expired - Returns the revisions from a list, ordered newest first, that fall outside the policy.
+func (p PrunePolicy) Expired(revisions []RevisionInfo, now time.Time) []RevisionInfo {
+	expired := []RevisionInfo{}
+	for i, rev := range revisions {
+		if i == 0 {
+			continue
+		}
+		if (p.MaxRevisions > 0 && i >= p.MaxRevisions) ||
+			(p.MaxAge > 0 && now.Sub(rev.Timestamp) > p.MaxAge) {
+			expired = append(expired, rev)
+		}
+	}
+	return expired
+}
+
+//--------------------------------------------------------------------------------------------------
@@ -0,0 +1,704 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, sub to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jeffail/leaps/lib/util/service/log"
+)
+
+//------------------------------------------------------------------------------
+
+func init() {
+	constructors["log"] = typeSpec{
+		constructor: func(conf Config, logger log.Modular) (Type, error) {
+			return NewLog(conf.Log, logger)
+		},
+		description: `An embedded store that appends every change to a log within a directory, and
+periodically writes snapshots of all documents.`,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// Errors for the Log store type.
+var (
+	ErrLogCorrupt = errors.New("log store contains a corrupt record")
+	ErrLogClosed  = errors.New("log store is closed")
+)
+
+//------------------------------------------------------------------------------
+
+// LogConfig - Holds configuration options for an embedded log store.
+type LogConfig struct {
+	Path             string `json:"path" yaml:"path"`
+	MaxSegmentBytes  int64  `json:"max_segment_bytes" yaml:"max_segment_bytes"`
+	SnapshotPeriodMS int64  `json:"snapshot_period_ms" yaml:"snapshot_period_ms"`
+	SyncWrites       bool   `json:"sync_writes" yaml:"sync_writes"`
+}
+
+// NewLogConfig - Returns a default configuration for a log store.
+func NewLogConfig() LogConfig {
+	return LogConfig{
+		Path:             "./leaps_data",
+		MaxSegmentBytes:  64 * 1024 * 1024,
+		SnapshotPeriodMS: 300000,
+		SyncWrites:       true,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// Log record operations.
+const (
+	logOpPut    byte = 1
+	logOpDelete byte = 2
+	logOpRename byte = 3
+
+	// logOpPutMetadata records the content of a document along with its
+	// metadata.
+	logOpPutMetadata byte = 4
+)
+
+const (
+	logSegmentExt  = ".log"
+	logSnapshotExt = ".snapshot"
+	logTempExt     = ".tmp"
+	logHeaderSize  = 8
+)
+
+var logCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+// logRecord - A single operation within a segment or snapshot.
+type logRecord struct {
+	op      byte
+	id      string
+	content string
+}
+
+// encode - Serialises the record with a header containing the length and
+// checksum of the payload.
+func (r logRecord) encode() []byte {
+	payload := make([]byte, 0, 1+binary.MaxVarintLen64+len(r.id)+len(r.content))
+	payload = append(payload, r.op)
+
+	var lenBuf [binary.MaxVarintLen64]byte
+	payload = append(payload, lenBuf[:binary.PutUvarint(lenBuf[:], uint64(len(r.id)))]...)
+	payload = append(payload, r.id...)
+	payload = append(payload, r.content...)
+
+	record := make([]byte, logHeaderSize, logHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(record[4:8], crc32.Checksum(payload, logCRCTable))
+	return append(record, payload...)
+}
+
+// readLogRecord - Reads the next record from an input with a number of bytes
+// remaining, returns io.EOF at a clean end of the input and ErrLogCorrupt if the
+// record is incomplete or fails its checksum.
+func readLogRecord(r io.Reader, remaining int64) (logRecord, int, error) {
+	var header [logHeaderSize]byte
+	if n, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.EOF {
+			return logRecord{}, 0, io.EOF
+		}
+		return logRecord{}, n, ErrLogCorrupt
+	}
+	payloadLen := int64(binary.LittleEndian.Uint32(header[0:4]))
+	if payloadLen > remaining-logHeaderSize {
+		return logRecord{}, 0, ErrLogCorrupt
+	}
+	payload := make([]byte, payloadLen)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return logRecord{}, 0, ErrLogCorrupt
+	}
+	if crc32.Checksum(payload, logCRCTable) != binary.LittleEndian.Uint32(header[4:8]) {
+		return logRecord{}, 0, ErrLogCorrupt
+	}
+	if len(payload) < 2 {
+		return logRecord{}, 0, ErrLogCorrupt
+	}
+	idLen, n := binary.Uvarint(payload[1:])
+	if n <= 0 || uint64(len(payload)-1-n) < idLen {
+		return logRecord{}, 0, ErrLogCorrupt
+	}
+	idStart := 1 + n
+	idEnd := idStart + int(idLen)
+	return logRecord{
+		op:      payload[0],
+		id:      string(payload[idStart:idEnd]),
+		content: string(payload[idEnd:]),
+	}, logHeaderSize + len(payload), nil
+}
+
+// putLogRecord - Returns a record that stores the content and metadata of a
+// document. The metadata of a logOpPutMetadata record is JSON encoded and
+// prefixed to the content along with its length.
+func putLogRecord(doc Document) (logRecord, error) {
+	if len(doc.Metadata) == 0 {
+		return logRecord{op: logOpPut, id: doc.ID, content: doc.Content}, nil
+	}
+	metaBytes, err := json.Marshal(doc.Metadata)
+	if err != nil {
+		return logRecord{}, err
+	}
+	var lenBuf [binary.MaxVarintLen64]byte
+	content := make([]byte, 0, binary.MaxVarintLen64+len(metaBytes)+len(doc.Content))
+	content = append(content, lenBuf[:binary.PutUvarint(lenBuf[:], uint64(len(metaBytes)))]...)
+	content = append(content, metaBytes...)
+	content = append(content, doc.Content...)
+	return logRecord{op: logOpPutMetadata, id: doc.ID, content: string(content)}, nil
+}
+
+// document - Returns the document stored by a put record.
+func (r logRecord) document() (Document, error) {
+	if r.op == logOpPut {
+		return Document{ID: r.id, Content: r.content}, nil
+	}
+	metaLen, n := binary.Uvarint([]byte(r.content))
+	if n <= 0 || uint64(len(r.content)-n) < metaLen {
+		return Document{}, ErrLogCorrupt
+	}
+	doc := Document{ID: r.id, Content: r.content[n+int(metaLen):]}
+	if err := json.Unmarshal([]byte(r.content[n:n+int(metaLen)]), &doc.Metadata); err != nil {
+		return Document{}, ErrLogCorrupt
+	}
+	return doc, nil
+}
+
+//------------------------------------------------------------------------------
+
+/*
+Log - An embedded document store that requires no external services. Every
+change is appended to a segment log within a local directory, and the state of
+all documents is periodically written to a snapshot, after which the segments
+covered by the snapshot are removed.
+
+All documents are also kept in memory. When SyncWrites is enabled each change is
+flushed to disk before it is acknowledged. On start up the latest snapshot is
+loaded and the remaining segments are replayed, if the final segment ends with
+an incomplete or corrupt record, as caused by a crash mid write, the log is
+truncated to the last intact record.
+*/
+type Log struct {
+	config LogConfig
+	log    log.Modular
+
+	mut         sync.RWMutex
+	documents   map[string]Document
+	segment     *os.File
+	segmentSeq  uint64
+	segmentSize int64
+	dirty       bool
+	closed      bool
+
+	snapshotMut sync.Mutex
+
+	closeChan  chan struct{}
+	closedChan chan struct{}
+}
+
+// NewLog - Opens or creates a log store in a directory, recovering the
+// documents from any existing snapshot and segments.
+func NewLog(config LogConfig, logger log.Modular) (*Log, error) {
+	if len(config.Path) == 0 {
+		return nil, ErrInvalidDirectory
+	}
+	if err := os.MkdirAll(config.Path, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("cannot create log store directory: %v", err)
+	}
+	l := &Log{
+		config:     config,
+		log:        logger.NewModule(":store:log"),
+		documents:  map[string]Document{},
+		closeChan:  make(chan struct{}),
+		closedChan: make(chan struct{}),
+	}
+	if err := l.recover(); err != nil {
+		return nil, err
+	}
+	go l.loop()
+	return l, nil
+}
+
+//------------------------------------------------------------------------------
+
+// Create - Create a new document.
+func (l *Log) Create(doc Document) error {
+	return l.Update(doc)
+}
+
+// Update - Update a document.
+func (l *Log) Update(doc Document) error {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	if len(doc.Revision) > 0 {
+		if stored, exists := l.documents[doc.ID]; !exists || contentRevision(stored.Content) != doc.Revision {
+			return ErrRevisionMismatch
+		}
+	}
+	record, err := putLogRecord(doc)
+	if err != nil {
+		return err
+	}
+	if err = l.append(record); err != nil {
+		return err
+	}
+	l.documents[doc.ID] = Document{ID: doc.ID, Content: doc.Content, Metadata: copyMetadata(doc.Metadata)}
+	return nil
+}
+
+// Read - Read a document.
+func (l *Log) Read(id string) (Document, error) {
+	l.mut.RLock()
+	defer l.mut.RUnlock()
+
+	doc, exists := l.documents[id]
+	if !exists {
+		return Document{}, ErrDocumentNotExist
+	}
+	doc.Metadata = copyMetadata(doc.Metadata)
+	doc.Revision = contentRevision(doc.Content)
+	return doc, nil
+}
+
+// Delete - Remove a document.
+func (l *Log) Delete(id string) error {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	if _, exists := l.documents[id]; !exists {
+		return ErrDocumentNotExist
+	}
+	if err := l.append(logRecord{op: logOpDelete, id: id}); err != nil {
+		return err
+	}
+	delete(l.documents, id)
+	return nil
+}
+
+// Rename - Move a document to a new ID.
+func (l *Log) Rename(oldID, newID string) error {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	doc, exists := l.documents[oldID]
+	if !exists {
+		return ErrDocumentNotExist
+	}
+	if _, exists = l.documents[newID]; exists {
+		return ErrDocumentExists
+	}
+	if err := l.append(logRecord{op: logOpRename, id: oldID, content: newID}); err != nil {
+		return err
+	}
+	delete(l.documents, oldID)
+	doc.ID = newID
+	l.documents[newID] = doc
+	return nil
+}
+
+// List - List the IDs of documents beginning with a prefix.
+func (l *Log) List(prefix string) ([]string, error) {
+	l.mut.RLock()
+	defer l.mut.RUnlock()
+
+	ids := []string{}
+	for id := range l.documents {
+		if strings.HasPrefix(id, prefix) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// Close - Writes a final snapshot and closes the log.
+func (l *Log) Close() error {
+	close(l.closeChan)
+	<-l.closedChan
+
+	err := l.Snapshot()
+
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	l.closed = true
+	if cErr := l.segment.Close(); err == nil {
+		err = cErr
+	}
+	return err
+}
+
+//------------------------------------------------------------------------------
+
+// Snapshot - Writes the state of all documents to a snapshot and removes the
+// segments that it covers. Does nothing if there have been no changes since the
+// previous snapshot.
+func (l *Log) Snapshot() error {
+	l.snapshotMut.Lock()
+	defer l.snapshotMut.Unlock()
+
+	l.mut.Lock()
+	if l.closed {
+		l.mut.Unlock()
+		return ErrLogClosed
+	}
+	if !l.dirty {
+		l.mut.Unlock()
+		return nil
+	}
+	// Subsequent changes are written to a fresh segment, and the snapshot
+	// covers all segments before it.
+	if err := l.rotate(); err != nil {
+		l.mut.Unlock()
+		return err
+	}
+	seq := l.segmentSeq
+	documents := make([]Document, 0, len(l.documents))
+	for _, doc := range l.documents {
+		documents = append(documents, doc)
+	}
+	l.dirty = false
+	l.mut.Unlock()
+
+	if err := l.writeSnapshot(seq, documents); err != nil {
+		l.mut.Lock()
+		l.dirty = true
+		l.mut.Unlock()
+		return err
+	}
+	return l.compact(seq)
+}
+
+// writeSnapshot - Atomically writes a snapshot containing a set of documents.
+func (l *Log) writeSnapshot(seq uint64, documents []Document) error {
+	finalPath := l.filePath(seq, logSnapshotExt)
+	tmpPath := finalPath + logTempExt
+
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, doc := range documents {
+		var record logRecord
+		if record, err = putLogRecord(doc); err != nil {
+			break
+		}
+		if _, err = w.Write(record.encode()); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, finalPath)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return syncDir(l.config.Path)
+}
+
+// compact - Removes all segments and snapshots older than a snapshot.
+func (l *Log) compact(seq uint64) error {
+	segments, snapshots, err := l.listFiles()
+	if err != nil {
+		return err
+	}
+	for _, s := range segments {
+		if s < seq {
+			if err = os.Remove(l.filePath(s, logSegmentExt)); err != nil {
+				return err
+			}
+		}
+	}
+	for _, s := range snapshots {
+		if s < seq {
+			if err = os.Remove(l.filePath(s, logSnapshotExt)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//------------------------------------------------------------------------------
+
+// append - Writes a record to the current segment, rotating the segment once it
+// exceeds the maximum size. Must be called whilst holding the write lock.
+func (l *Log) append(record logRecord) error {
+	if l.closed {
+		return ErrLogClosed
+	}
+	data := record.encode()
+	if _, err := l.segment.Write(data); err != nil {
+		// Remove any partially written record so that later records are not
+		// lost behind it during recovery.
+		l.discard()
+		return err
+	}
+	if l.config.SyncWrites {
+		if err := l.segment.Sync(); err != nil {
+			// The write is reported as failed and so the record must not be
+			// replayed during recovery.
+			l.discard()
+			return err
+		}
+	}
+	l.segmentSize += int64(len(data))
+	l.dirty = true
+
+	if l.config.MaxSegmentBytes > 0 && l.segmentSize >= l.config.MaxSegmentBytes {
+		return l.rotate()
+	}
+	return nil
+}
+
+// discard - Truncates the current segment back to the end of its last complete
+// record. Must be called whilst holding the write lock.
+func (l *Log) discard() {
+	l.segment.Truncate(l.segmentSize)
+	l.segment.Seek(l.segmentSize, io.SeekStart)
+}
+
+// rotate - Closes the current segment and opens the next one. Must be called
+// whilst holding the write lock.
+func (l *Log) rotate() error {
+	if err := l.segment.Sync(); err != nil {
+		return err
+	}
+	if err := l.segment.Close(); err != nil {
+		return err
+	}
+	return l.openSegment(l.segmentSeq + 1)
+}
+
+// openSegment - Opens a segment for appending.
+func (l *Log) openSegment(seq uint64) error {
+	f, err := os.OpenFile(l.filePath(seq, logSegmentExt), os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err = syncDir(l.config.Path); err != nil {
+		f.Close()
+		return err
+	}
+	l.segment, l.segmentSeq, l.segmentSize = f, seq, size
+	return nil
+}
+
+// recover - Loads the latest snapshot and replays all subsequent segments.
+func (l *Log) recover() error {
+	segments, snapshots, err := l.listFiles()
+	if err != nil {
+		return err
+	}
+
+	var snapshotSeq uint64
+	if len(snapshots) > 0 {
+		snapshotSeq = snapshots[len(snapshots)-1]
+		if _, err = l.replay(l.filePath(snapshotSeq, logSnapshotExt), false); err != nil {
+			return fmt.Errorf("failed to load snapshot: %v", err)
+		}
+	}
+
+	seq := snapshotSeq
+	for i, s := range segments {
+		if s < snapshotSeq {
+			// Left over from an interrupted compaction.
+			continue
+		}
+		n, err := l.replay(l.filePath(s, logSegmentExt), i == len(segments)-1)
+		if err != nil {
+			return fmt.Errorf("failed to replay segment %v: %v", s, err)
+		}
+		if n > 0 {
+			l.dirty = true
+		}
+		seq = s
+	}
+	if err = l.compact(snapshotSeq); err != nil {
+		return err
+	}
+	return l.openSegment(seq)
+}
+
+// replay - Applies all records of a file to the documents. If truncateTail is
+// set then an incomplete or corrupt record at the end of the file is removed
+// rather than being treated as an error. Returns the number of records applied.
+func (l *Log) replay(path string, truncateTail bool) (int, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	r := bufio.NewReader(f)
+	var offset int64
+	for applied := 0; ; applied++ {
+		record, n, err := readLogRecord(r, info.Size()-offset)
+		if err == io.EOF {
+			return applied, nil
+		}
+		if err != nil {
+			if !truncateTail {
+				return applied, err
+			}
+			l.log.Warnf("Truncating corrupt tail of %v at offset %v\n", path, offset)
+			if err = f.Truncate(offset); err != nil {
+				return applied, err
+			}
+			return applied, f.Sync()
+		}
+		offset += int64(n)
+
+		switch record.op {
+		case logOpPut, logOpPutMetadata:
+			doc, err := record.document()
+			if err != nil {
+				return applied, err
+			}
+			l.documents[record.id] = doc
+		case logOpDelete:
+			delete(l.documents, record.id)
+		case logOpRename:
+			doc := l.documents[record.id]
+			doc.ID = record.content
+			l.documents[record.content] = doc
+			delete(l.documents, record.id)
+		default:
+			return applied, ErrLogCorrupt
+		}
+	}
+}
+
+// listFiles - Returns the sequence numbers of all segments and snapshots in
+// ascending order, removing any temporary files left by an interrupted
+// snapshot.
+func (l *Log) listFiles() (segments, snapshots []uint64, err error) {
+	infos, err := ioutil.ReadDir(l.config.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, info := range infos {
+		name := info.Name()
+		ext := filepath.Ext(name)
+		if ext == logTempExt {
+			os.Remove(filepath.Join(l.config.Path, name))
+			continue
+		}
+		var seq uint64
+		if _, scanErr := fmt.Sscanf(strings.TrimSuffix(name, ext), "%d", &seq); scanErr != nil {
+			continue
+		}
+		switch ext {
+		case logSegmentExt:
+			segments = append(segments, seq)
+		case logSnapshotExt:
+			snapshots = append(snapshots, seq)
+		}
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i] < snapshots[j] })
+	return segments, snapshots, nil
+}
+
+// filePath - Returns the path of a segment or snapshot file.
+func (l *Log) filePath(seq uint64, ext string) string {
+	return filepath.Join(l.config.Path, fmt.Sprintf("%020d%v", seq, ext))
+}
+
+// syncDir - Flushes a directory so that created, renamed and removed files are
+// durable.
+func syncDir(path string) error {
+	d, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err = d.Sync(); err != nil && !os.IsPermission(err) {
+		return err
+	}
+	return nil
+}
+
+// loop - Writes snapshots periodically until the store is closed.
+func (l *Log) loop() {
+	defer close(l.closedChan)
+
+	snapshotPeriod := time.Duration(l.config.SnapshotPeriodMS) * time.Millisecond
+	if snapshotPeriod <= 0 {
+		<-l.closeChan
+		return
+	}
+	ticker := time.NewTicker(snapshotPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := l.Snapshot(); err != nil {
+				l.log.Errorf("Failed to write snapshot: %v\n", err)
+			}
+		case <-l.closeChan:
+			return
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
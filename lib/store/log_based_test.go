@@ -0,0 +1,245 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Jeffail/leaps/lib/util/service/log"
+)
+
+//------------------------------------------------------------------------------
+
+func openTestLog(t *testing.T, dir string) *Log {
+	conf := NewLogConfig()
+	conf.Path = dir
+	conf.SnapshotPeriodMS = 0
+
+	l, err := NewLog(conf, log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func checkLogDocs(t *testing.T, l *Log, exp map[string]string) {
+	ids, err := l.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	act := map[string]string{}
+	for _, id := range ids {
+		doc, err := l.Read(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		act[id] = doc.Content
+	}
+	if !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong documents: %v != %v", exp, act)
+	}
+}
+
+func TestLogRecovery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_log_store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	l := openTestLog(t, dir)
+	for _, doc := range []Document{
+		{ID: "a", Content: "first"},
+		{ID: "b", Content: "second"},
+		{ID: "c", Content: "third"},
+		{ID: "a", Content: "first updated"},
+	} {
+		if err = l.Update(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = l.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Rename("c", "d"); err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Rename("a", "d"); err != ErrDocumentExists {
+		t.Errorf("Wrong error from rename to existing document: %v", err)
+	}
+
+	exp := map[string]string{"a": "first updated", "d": "third"}
+	checkLogDocs(t, l, exp)
+
+	// Reopen without a snapshot by abandoning the store.
+	l.segment.Close()
+	l = openTestLog(t, dir)
+	checkLogDocs(t, l, exp)
+
+	// Close writes a snapshot and compacts the segments.
+	if err = l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	segments, snapshots, err := l.listFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 1 || len(segments) != 1 {
+		t.Errorf("Wrong files after compaction: %v segments, %v snapshots", segments, snapshots)
+	}
+
+	l = openTestLog(t, dir)
+	checkLogDocs(t, l, exp)
+	if err = l.Update(Document{ID: "e", Content: "fifth"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	exp["e"] = "fifth"
+	l = openTestLog(t, dir)
+	checkLogDocs(t, l, exp)
+	l.Close()
+}
+
+func TestLogTruncatedRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_log_store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	l := openTestLog(t, dir)
+	if err = l.Update(Document{ID: "a", Content: "intact"}); err != nil {
+		t.Fatal(err)
+	}
+	intactSize := l.segmentSize
+	if err = l.Update(Document{ID: "b", Content: "torn record"}); err != nil {
+		t.Fatal(err)
+	}
+	fullSize := l.segmentSize
+	segmentPath := l.filePath(l.segmentSeq, logSegmentExt)
+	l.segment.Close()
+
+	original, err := ioutil.ReadFile(segmentPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Truncate at every offset within the final record, including its header.
+	for size := intactSize + 1; size < fullSize; size++ {
+		if err = ioutil.WriteFile(segmentPath, original[:size], 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		l = openTestLog(t, dir)
+		checkLogDocs(t, l, map[string]string{"a": "intact"})
+		if l.segmentSize != intactSize {
+			t.Errorf("Segment was not truncated: %v != %v", l.segmentSize, intactSize)
+		}
+
+		// New records are appended after the intact records.
+		if err = l.Update(Document{ID: "c", Content: "after"}); err != nil {
+			t.Fatal(err)
+		}
+		l.segment.Close()
+
+		l = openTestLog(t, dir)
+		checkLogDocs(t, l, map[string]string{"a": "intact", "c": "after"})
+		l.segment.Close()
+	}
+
+	// A corrupted checksum is also treated as a torn record.
+	corrupt := append([]byte{}, original...)
+	corrupt[len(corrupt)-1] ^= 0xFF
+	if err = ioutil.WriteFile(segmentPath, corrupt, 0644); err != nil {
+		t.Fatal(err)
+	}
+	l = openTestLog(t, dir)
+	checkLogDocs(t, l, map[string]string{"a": "intact"})
+	l.Close()
+
+	// Corruption within a snapshot is an error.
+	snapshots, err := filepath.Glob(filepath.Join(dir, "*"+logSnapshotExt))
+	if err != nil || len(snapshots) != 1 {
+		t.Fatalf("Expected a snapshot: %v %v", snapshots, err)
+	}
+	if err = ioutil.WriteFile(snapshots[0], []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	conf := NewLogConfig()
+	conf.Path = dir
+	if _, err = NewLog(conf, log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"})); err == nil {
+		t.Error("Expected error from corrupt snapshot")
+	}
+}
+
+func TestLogMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_log_store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	metadata := map[string]string{"title": "A"}
+
+	l := openTestLog(t, dir)
+	if err = l.Update(Document{ID: "a", Content: "first", Metadata: metadata}); err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Update(Document{ID: "b", Content: "second", Metadata: metadata}); err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Update(Document{ID: "b", Content: "second updated"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Rename("a", "c"); err != nil {
+		t.Fatal(err)
+	}
+	l.segment.Close()
+
+	// Recover from both the snapshot and the segment written after it.
+	l = openTestLog(t, dir)
+	defer l.Close()
+
+	if doc, err := l.Read("c"); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(metadata, doc.Metadata) {
+		t.Errorf("Wrong metadata: %v != %v", metadata, doc.Metadata)
+	}
+	if doc, err := l.Read("b"); err != nil {
+		t.Error(err)
+	} else if doc.Metadata != nil {
+		t.Errorf("Expected metadata to be cleared: %v", doc.Metadata)
+	}
+}
+
+//------------------------------------------------------------------------------
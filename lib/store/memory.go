@@ -24,11 +24,26 @@ package store
 
 import (
 	"errors"
+	"sort"
+	"strings"
 	"sync"
+
+	"github.com/Jeffail/leaps/lib/util/service/log"
 )
 
 //--------------------------------------------------------------------------------------------------
 
+func init() {
+	constructors["memory"] = typeSpec{
+		constructor: func(conf Config, logger log.Modular) (Type, error) {
+			return NewMemory(), nil
+		},
+		description: `Keeps documents in memory, documents are lost when the service stops.`,
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
+
 // Errors for the Memory type.
 var (
 	ErrDocumentNotExist = errors.New("attempted to fetch memory doc that has not been initialized")
@@ -57,6 +72,11 @@ func (s *Memory) Update(doc Document) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
+	if len(doc.Revision) > 0 && s.documents[doc.ID].Revision != doc.Revision {
+		return ErrRevisionMismatch
+	}
+	doc.Revision = contentRevision(doc.Content)
+	doc.Metadata = copyMetadata(doc.Metadata)
 	s.documents[doc.ID] = doc
 	return nil
 }
@@ -70,7 +90,53 @@ func (s *Memory) Read(id string) (Document, error) {
 	if !ok {
 		return doc, ErrDocumentNotExist
 	}
+	doc.Metadata = copyMetadata(doc.Metadata)
 	return doc, nil
 }
 
+// Delete - Remove a document from memory.
+func (s *Memory) Delete(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.documents[id]; !ok {
+		return ErrDocumentNotExist
+	}
+	delete(s.documents, id)
+	return nil
+}
+
+// Rename - Move a document to a new ID in memory.
+func (s *Memory) Rename(oldID, newID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	doc, ok := s.documents[oldID]
+	if !ok {
+		return ErrDocumentNotExist
+	}
+	if _, exists := s.documents[newID]; exists {
+		return ErrDocumentExists
+	}
+	delete(s.documents, oldID)
+	doc.ID = newID
+	s.documents[newID] = doc
+	return nil
+}
+
+// List - List the IDs of documents in memory that begin with a prefix.
+func (s *Memory) List(prefix string) ([]string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	ids := []string{}
+	for id := range s.documents {
+		if strings.HasPrefix(id, prefix) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
 //--------------------------------------------------------------------------------------------------
@@ -0,0 +1,102 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"reflect"
+	"testing"
+)
+
+//--------------------------------------------------------------------------------------------------
+
+func TestMemoryDeleteRenameList(t *testing.T) {
+	s := NewMemory()
+
+	for _, id := range []string{"foo/a", "foo/b", "bar/c"} {
+		if err := s.Create(Document{ID: id, Content: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lister := s.(Lister)
+	if ids, err := lister.List("foo/"); err != nil {
+		t.Error(err)
+	} else if exp := []string{"foo/a", "foo/b"}; !reflect.DeepEqual(exp, ids) {
+		t.Errorf("Wrong listed IDs: %v != %v", exp, ids)
+	}
+
+	renamer := s.(Renamer)
+	if err := renamer.Rename("foo/a", "bar/c"); err != ErrDocumentExists {
+		t.Errorf("Expected ErrDocumentExists, received: %v", err)
+	}
+	if err := renamer.Rename("nope", "bar/d"); err != ErrDocumentNotExist {
+		t.Errorf("Expected ErrDocumentNotExist, received: %v", err)
+	}
+	if err := renamer.Rename("foo/a", "bar/a"); err != nil {
+		t.Error(err)
+	}
+	if doc, err := s.Read("bar/a"); err != nil {
+		t.Error(err)
+	} else if doc.ID != "bar/a" || doc.Content != "foo/a" {
+		t.Errorf("Wrong renamed document: %v", doc)
+	}
+	if _, err := s.Read("foo/a"); err != ErrDocumentNotExist {
+		t.Errorf("Expected ErrDocumentNotExist, received: %v", err)
+	}
+
+	deleter := s.(Deleter)
+	if err := deleter.Delete("bar/c"); err != nil {
+		t.Error(err)
+	}
+	if err := deleter.Delete("bar/c"); err != ErrDocumentNotExist {
+		t.Errorf("Expected ErrDocumentNotExist, received: %v", err)
+	}
+	if ids, err := lister.List(""); err != nil {
+		t.Error(err)
+	} else if exp := []string{"bar/a", "foo/b"}; !reflect.DeepEqual(exp, ids) {
+		t.Errorf("Wrong listed IDs: %v != %v", exp, ids)
+	}
+}
+
+func TestMemoryRevisions(t *testing.T) {
+	s := NewMemory()
+	if err := s.Create(Document{ID: "a", Content: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := s.Read("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s.Update(Document{ID: "a", Content: "hello world"}); err != nil {
+		t.Fatal(err)
+	}
+
+	doc.Content = "hello there"
+	if err = s.Update(doc); err != ErrRevisionMismatch {
+		t.Errorf("Wrong error from stale update: %v", err)
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
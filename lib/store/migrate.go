@@ -0,0 +1,308 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, sub to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+
+// Errors for store migrations.
+var (
+	ErrSourceNotListable = errors.New("source store is not able to list its documents")
+)
+
+//------------------------------------------------------------------------------
+
+// MigrateConfig - Holds options for copying documents between stores.
+// ResumePath is an optional file where completed documents are recorded, so
+// that an interrupted migration can be resumed by running it again with the
+// same file. When Verify is set each document is read back from the target and
+// compared against its checksum. Existing documents in the target that differ
+// from the source are only replaced when Overwrite is set.
+type MigrateConfig struct {
+	Prefix     string `json:"prefix" yaml:"prefix"`
+	DryRun     bool   `json:"dry_run" yaml:"dry_run"`
+	ResumePath string `json:"resume_path" yaml:"resume_path"`
+	Verify     bool   `json:"verify" yaml:"verify"`
+	Overwrite  bool   `json:"overwrite" yaml:"overwrite"`
+}
+
+// NewMigrateConfig - Returns a default configuration for migrations.
+func NewMigrateConfig() MigrateConfig {
+	return MigrateConfig{
+		Prefix:     "",
+		DryRun:     false,
+		ResumePath: "",
+		Verify:     true,
+		Overwrite:  false,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// MigrateFailure - Describes a document that could not be migrated.
+type MigrateFailure struct {
+	ID    string `json:"id"`
+	Error string `json:"error"`
+}
+
+// MigrateReport - A summary of a migration. Copied documents were written to
+// the target, Unchanged documents already existed in the target with the same
+// content, and Resumed documents were completed by a previous run.
+type MigrateReport struct {
+	DryRun    bool             `json:"dry_run"`
+	Total     int              `json:"total"`
+	Copied    []string         `json:"copied"`
+	Unchanged []string         `json:"unchanged"`
+	Resumed   []string         `json:"resumed"`
+	Failed    []MigrateFailure `json:"failed"`
+	Duration  string           `json:"duration"`
+}
+
+// String - Returns a human readable summary of the report.
+func (r MigrateReport) String() string {
+	buf := bytes.Buffer{}
+	verb := "Copied"
+	if r.DryRun {
+		verb = "Would copy"
+	}
+	fmt.Fprintf(&buf, "Documents found: %v\n", r.Total)
+	fmt.Fprintf(&buf, "%v: %v\n", verb, len(r.Copied))
+	fmt.Fprintf(&buf, "Unchanged: %v\n", len(r.Unchanged))
+	fmt.Fprintf(&buf, "Resumed: %v\n", len(r.Resumed))
+	fmt.Fprintf(&buf, "Failed: %v\n", len(r.Failed))
+	for _, f := range r.Failed {
+		fmt.Fprintf(&buf, "  %v: %v\n", f.ID, f.Error)
+	}
+	fmt.Fprintf(&buf, "Duration: %v\n", r.Duration)
+	return buf.String()
+}
+
+//------------------------------------------------------------------------------
+
+// migrateRecord - An entry of a resume file.
+type migrateRecord struct {
+	ID       string `json:"id"`
+	Checksum string `json:"checksum"`
+}
+
+// documentChecksum - Returns a checksum of the content and metadata of a
+// document, where nil and empty metadata are equivalent. The checksum of a
+// document without metadata is that of its content alone.
+func documentChecksum(doc Document) string {
+	if len(doc.Metadata) == 0 {
+		sum := sha256.Sum256([]byte(doc.Content))
+		return hex.EncodeToString(sum[:])
+	}
+	// Metadata is encoded with sorted keys, and the content is prefixed with
+	// its length so that it cannot be confused with the metadata.
+	metaBytes, _ := json.Marshal(doc.Metadata)
+	h := sha256.New()
+	fmt.Fprintf(h, "%v:", len(doc.Content))
+	h.Write([]byte(doc.Content))
+	h.Write(metaBytes)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// readResumeFile - Reads the IDs and checksums of documents completed by a
+// previous migration, a trailing partial line is ignored.
+func readResumeFile(path string) (map[string]string, error) {
+	completed := map[string]string{}
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return completed, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var record migrateRecord
+		if err = json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+		completed[record.ID] = record.Checksum
+	}
+	return completed, scanner.Err()
+}
+
+/*
+Migrate - Copies every document from a source store, which must implement
+Lister, to a target store and returns a report of the migration. The content
+and metadata of each document is copied, and the revisions of the source are not
+carried over.
+
+Failures to migrate individual documents are recorded in the report rather than
+stopping the migration, including documents that cannot be read from the target
+for reasons other than not existing. An error is only returned when the
+migration is unable to continue.
+*/
+func Migrate(source, target Type, config MigrateConfig) (MigrateReport, error) {
+	started := time.Now()
+	report := MigrateReport{
+		DryRun:    config.DryRun,
+		Copied:    []string{},
+		Unchanged: []string{},
+		Resumed:   []string{},
+		Failed:    []MigrateFailure{},
+	}
+
+	lister, ok := source.(Lister)
+	if !ok {
+		return report, ErrSourceNotListable
+	}
+	ids, err := lister.List(config.Prefix)
+	if err != nil {
+		return report, fmt.Errorf("failed to list source documents: %v", err)
+	}
+	report.Total = len(ids)
+
+	completed := map[string]string{}
+	var resumeFile *os.File
+	if len(config.ResumePath) > 0 {
+		if completed, err = readResumeFile(config.ResumePath); err != nil {
+			return report, fmt.Errorf("failed to read resume file: %v", err)
+		}
+		if !config.DryRun {
+			if resumeFile, err = os.OpenFile(
+				config.ResumePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644,
+			); err != nil {
+				return report, fmt.Errorf("failed to open resume file: %v", err)
+			}
+			defer resumeFile.Close()
+		}
+	}
+
+	for _, id := range ids {
+		doc, err := source.Read(id)
+		if err != nil {
+			report.Failed = append(report.Failed, MigrateFailure{ID: id, Error: err.Error()})
+			continue
+		}
+		doc.Revision = ""
+		checksum := documentChecksum(doc)
+
+		// Documents completed by a previous run are skipped unless they have
+		// since changed in the source.
+		if prev, exists := completed[id]; exists && prev == checksum {
+			report.Resumed = append(report.Resumed, id)
+			continue
+		}
+
+		existing, readErr := target.Read(id)
+		if readErr != nil && readErr != ErrDocumentNotExist {
+			report.Failed = append(report.Failed, MigrateFailure{
+				ID: id, Error: fmt.Sprintf("failed to read target document: %v", readErr),
+			})
+			continue
+		}
+		exists := readErr == nil
+		if exists && documentChecksum(existing) == checksum {
+			report.Unchanged = append(report.Unchanged, id)
+		} else if exists && !config.Overwrite {
+			report.Failed = append(report.Failed, MigrateFailure{
+				ID: id, Error: "a different document already exists in the target",
+			})
+			continue
+		} else {
+			if config.DryRun {
+				report.Copied = append(report.Copied, id)
+				continue
+			}
+			if err = migrateDocument(target, doc, exists, checksum, config.Verify); err != nil {
+				report.Failed = append(report.Failed, MigrateFailure{ID: id, Error: err.Error()})
+				continue
+			}
+			report.Copied = append(report.Copied, id)
+		}
+
+		if resumeFile != nil {
+			recordBytes, _ := json.Marshal(migrateRecord{ID: id, Checksum: checksum})
+			if _, err = resumeFile.Write(append(recordBytes, '\n')); err == nil {
+				err = resumeFile.Sync()
+			}
+			if err != nil {
+				return report, fmt.Errorf("failed to write resume file: %v", err)
+			}
+		}
+	}
+
+	report.Duration = time.Since(started).String()
+	return report, nil
+}
+
+// verifiedStore - Returns the store that a written document is read back from
+// in order to verify it. A cache is flushed and its underlying store returned,
+// so that the stored document is verified rather than the cached copy.
+func verifiedStore(target Type) (Type, error) {
+	if c, ok := target.(*Cache); ok {
+		if err := c.Flush(); err != nil {
+			return nil, err
+		}
+		return c.store, nil
+	}
+	return target, nil
+}
+
+// migrateDocument - Writes a document to the target and optionally verifies
+// that it reads back with the expected checksum.
+func migrateDocument(target Type, doc Document, exists bool, checksum string, verify bool) error {
+	var err error
+	if exists {
+		err = target.Update(doc)
+	} else {
+		err = target.Create(doc)
+	}
+	if err != nil {
+		return err
+	}
+	if !verify {
+		return nil
+	}
+	if target, err = verifiedStore(target); err != nil {
+		return fmt.Errorf("failed to flush document: %v", err)
+	}
+	stored, err := target.Read(doc.ID)
+	if err != nil {
+		return fmt.Errorf("failed to verify document: %v", err)
+	}
+	if storedSum := documentChecksum(stored); storedSum != checksum {
+		return fmt.Errorf("checksum mismatch after copy: %v != %v", checksum, storedSum)
+	}
+	return nil
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,241 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, sub to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Jeffail/leaps/lib/util/service/log"
+)
+
+//------------------------------------------------------------------------------
+
+// failingStore - Fails to create documents with a particular ID.
+type failingStore struct {
+	Type
+	failID string
+}
+
+func (f failingStore) Create(doc Document) error {
+	if doc.ID == f.failID {
+		return ErrReadOnlyStore
+	}
+	return f.Type.Create(doc)
+}
+
+// lossyStore - Fails to read documents with a particular ID and discards all
+// updates.
+type lossyStore struct {
+	Type
+	failID string
+}
+
+func (l lossyStore) Read(id string) (Document, error) {
+	if id == l.failID {
+		return Document{}, errors.New("connection refused")
+	}
+	return l.Type.Read(id)
+}
+
+func (l lossyStore) Update(doc Document) error {
+	return nil
+}
+
+func TestMigrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := NewMemory()
+	for _, doc := range []Document{
+		{ID: "a.txt", Content: "a", Metadata: map[string]string{"title": "A"}},
+		{ID: "b.txt", Content: "b"},
+		{ID: "c.txt", Content: "c"},
+		{ID: "d.txt", Content: "d"},
+	} {
+		if err = source.Create(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	target := NewMemory()
+	if err = target.Create(Document{ID: "c.txt", Content: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = target.Create(Document{ID: "d.txt", Content: "different"}); err != nil {
+		t.Fatal(err)
+	}
+
+	conf := NewMigrateConfig()
+	conf.ResumePath = filepath.Join(dir, "resume.jsonl")
+	conf.DryRun = true
+
+	report, err := Migrate(source, target, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := []string{"a.txt", "b.txt"}, report.Copied; !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong dry run copies: %v != %v", exp, act)
+	}
+	if _, err = target.Read("a.txt"); err != ErrDocumentNotExist {
+		t.Errorf("Expected dry run to not write documents: %v", err)
+	}
+	if _, err = os.Stat(conf.ResumePath); !os.IsNotExist(err) {
+		t.Errorf("Expected dry run to not write resume file: %v", err)
+	}
+
+	// Interrupt the migration by failing to write a document.
+	conf.DryRun = false
+	report, err = Migrate(source, failingStore{Type: target, failID: "b.txt"}, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := []string{"a.txt"}, report.Copied; !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong copies: %v != %v", exp, act)
+	}
+	if exp, act := []string{"c.txt"}, report.Unchanged; !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong unchanged: %v != %v", exp, act)
+	}
+	if len(report.Failed) != 2 || report.Failed[0].ID != "b.txt" || report.Failed[1].ID != "d.txt" {
+		t.Errorf("Wrong failures: %v", report.Failed)
+	}
+
+	conf.Overwrite = true
+	report, err = Migrate(source, target, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := []string{"a.txt", "c.txt"}, report.Resumed; !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong resumed: %v != %v", exp, act)
+	}
+	if exp, act := []string{"b.txt", "d.txt"}, report.Copied; !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong copies: %v != %v", exp, act)
+	}
+	if len(report.Failed) != 0 {
+		t.Errorf("Unexpected failures: %v", report.Failed)
+	}
+
+	for _, id := range []string{"a.txt", "b.txt", "c.txt", "d.txt"} {
+		exp, _ := source.Read(id)
+		act, err := target.Read(id)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if exp.Content != act.Content || !reflect.DeepEqual(exp.Metadata, act.Metadata) {
+			t.Errorf("Wrong migrated document: %v != %v", exp, act)
+		}
+	}
+
+	if _, err = Migrate(failingStore{Type: source}, target, conf); err != ErrSourceNotListable {
+		t.Errorf("Expected ErrSourceNotListable, received: %v", err)
+	}
+}
+
+func TestMigrateTargetErrors(t *testing.T) {
+	source := NewMemory()
+	for _, doc := range []Document{
+		{ID: "a.txt", Content: "a"},
+		{ID: "b.txt", Content: "b"},
+	} {
+		if err := source.Create(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	underlying := NewMemory()
+	if err := underlying.Create(Document{ID: "a.txt", Content: "old"}); err != nil {
+		t.Fatal(err)
+	}
+	target := NewCache(
+		NewCacheConfig(), lossyStore{Type: underlying, failID: "b.txt"},
+		log.NewLogger(os.Stdout, log.LoggerConfig{LogLevel: "NONE"}),
+	)
+	defer target.Close()
+
+	conf := NewMigrateConfig()
+	conf.Overwrite = true
+
+	// The lost update is only detected when the underlying store of the cache
+	// is verified, and the unreadable document is not treated as absent.
+	report, err := Migrate(source, target, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Copied) != 0 {
+		t.Errorf("Unexpected copies: %v", report.Copied)
+	}
+	if len(report.Failed) != 2 || report.Failed[0].ID != "a.txt" || report.Failed[1].ID != "b.txt" {
+		t.Errorf("Wrong failures: %v", report.Failed)
+	}
+	if _, err = underlying.Read("b.txt"); err != ErrDocumentNotExist {
+		t.Errorf("Expected unreadable document to not be written: %v", err)
+	}
+}
+
+func TestMigrateResumeMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source, target := NewMemory(), NewMemory()
+	if err = source.Create(Document{ID: "a.txt", Content: "a", Metadata: map[string]string{"title": "A"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	conf := NewMigrateConfig()
+	conf.ResumePath = filepath.Join(dir, "resume.jsonl")
+	conf.Overwrite = true
+	if _, err = Migrate(source, target, conf); err != nil {
+		t.Fatal(err)
+	}
+
+	// A change to only the metadata of a document is migrated on resume.
+	metadata := map[string]string{"title": "B"}
+	if err = source.Update(Document{ID: "a.txt", Content: "a", Metadata: metadata}); err != nil {
+		t.Fatal(err)
+	}
+	report, err := Migrate(source, target, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := []string{"a.txt"}, report.Copied; !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong copies: %v != %v", exp, act)
+	}
+	if doc, err := target.Read("a.txt"); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(metadata, doc.Metadata) {
+		t.Errorf("Wrong migrated metadata: %v != %v", metadata, doc.Metadata)
+	}
+}
+
+//------------------------------------------------------------------------------
@@ -24,43 +24,85 @@ package store
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Jeffail/leaps/lib/util/service/log"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
 
 	// Blank because SQL driver
-	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/lib/pq"
 )
 
 //--------------------------------------------------------------------------------------------------
 
-// TableConfig - Fields for specifying the table labels of the SQL database target.
+func init() {
+	constructors["mysql"] = typeSpec{
+		constructor: func(conf Config, logger log.Modular) (Type, error) {
+			return NewMySQL(conf.SQL)
+		},
+		description: `Stores documents in a MySQL database table, with a DSN of the format:
+[username[:password]@][protocol[(address)]]/dbname[?param1=value1&...&paramN=valueN]`,
+	}
+	constructors["postgres"] = typeSpec{
+		constructor: func(conf Config, logger log.Modular) (Type, error) {
+			return NewPostgreSQL(conf.SQL)
+		},
+		description: `Stores documents in a PostgreSQL database table, with a DSN of the format:
+postgresql://[user[:password]@][netloc][:port][/dbname][?param1=value1&...]`,
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
+
+/*
+TableConfig - Fields for specifying the table labels of the SQL database target. VersionCol is
+optional, and when set must label an integer column that is incremented with each update in order
+to detect concurrent modifications of documents. MetadataCol is also optional, and when set must
+label a nullable text column where the metadata of documents is stored as JSON. When HistoryTable is
+set a revision of each document is retained in a table of that name with each write, the table is
+created if it does not already exist.
+*/
 type TableConfig struct {
-	Name       string `json:"table" yaml:"table"`
-	IDCol      string `json:"id_column" yaml:"id_column"`
-	ContentCol string `json:"content_column" yaml:"content_column"`
+	Name         string `json:"table" yaml:"table"`
+	IDCol        string `json:"id_column" yaml:"id_column"`
+	ContentCol   string `json:"content_column" yaml:"content_column"`
+	VersionCol   string `json:"version_column" yaml:"version_column"`
+	MetadataCol  string `json:"metadata_column" yaml:"metadata_column"`
+	HistoryTable string `json:"history_table" yaml:"history_table"`
 }
 
 // NewTableConfig - Default table configuration.
 func NewTableConfig() TableConfig {
 	return TableConfig{
-		Name:       "leaps_documents",
-		IDCol:      "ID",
-		ContentCol: "CONTENT",
+		Name:         "leaps_documents",
+		IDCol:        "ID",
+		ContentCol:   "CONTENT",
+		VersionCol:   "",
+		MetadataCol:  "",
+		HistoryTable: "",
 	}
 }
 
-// SQLConfig - The configuration fields for an SQL document store solution.
+// SQLConfig - The configuration fields for an SQL document store solution. When a history table is
+// configured the revisions of a document that fall outside RevisionPolicy are pruned with each write.
 type SQLConfig struct {
-	DSN         string      `json:"dsn" yaml:"dsn"`
-	TableConfig TableConfig `json:"db_table" yaml:"db_table"`
+	DSN            string      `json:"dsn" yaml:"dsn"`
+	TableConfig    TableConfig `json:"db_table" yaml:"db_table"`
+	RevisionPolicy PrunePolicy `json:"revision_policy" yaml:"revision_policy"`
 }
 
 // NewSQLConfig - A default SQL configuration.
 func NewSQLConfig() SQLConfig {
 	return SQLConfig{
-		DSN:         "",
-		TableConfig: NewTableConfig(),
+		DSN:            "",
+		TableConfig:    NewTableConfig(),
+		RevisionPolicy: PrunePolicy{},
 	}
 }
 
@@ -74,6 +116,15 @@ type SQL struct {
 	createStmt *sql.Stmt
 	updateStmt *sql.Stmt
 	readStmt   *sql.Stmt
+	revStmt    *sql.Stmt
+	deleteStmt *sql.Stmt
+	renameStmt *sql.Stmt
+	listStmt   *sql.Stmt
+
+	metaReadStmt   *sql.Stmt
+	metaUpdateStmt *sql.Stmt
+
+	history *sqlHistory
 }
 
 /*
@@ -81,6 +132,8 @@ NewMySQL - Returns an SQL store type for connecting to MySQL databases.
 
 DSN Should be of the format:
 [username[:password]@][protocol[(address)]]/dbname[?param1=value1&...&paramN=valueN]
+
+The clientFoundRows parameter is always enabled so that updates report the rows they match.
 */
 func NewMySQL(config SQLConfig) (Type, error) {
 	return newSQL(mysql, config)
@@ -110,62 +163,191 @@ var (
 	ErrUnrecognizedSQLType = errors.New("SQL Type not recognized")
 )
 
+/*
+mysqlDSN - Returns a MySQL DSN with the clientFoundRows parameter set. By default MySQL reports the
+number of rows changed by an update rather than the number matched, and so an update that writes
+the content a document already has would appear to have not found the document.
+*/
+func mysqlDSN(dsn string) (string, error) {
+	conf, err := mysqldriver.ParseDSN(dsn)
+	if err != nil {
+		return "", err
+	}
+	conf.ClientFoundRows = true
+	return conf.FormatDSN(), nil
+}
+
 func newSQL(dbType int, config SQLConfig) (Type, error) {
-	var (
-		db                            *sql.DB
-		createStr, updateStr, readStr string
-		create, update, read          *sql.Stmt
-		err                           error
-	)
 	if len(config.DSN) == 0 {
 		return nil, ErrMissingDSN
 	}
 
+	var (
+		db  *sql.DB
+		err error
+	)
+	switch dbType {
+	case postgres:
+		db, err = sql.Open("postgres", config.DSN)
+	case mysql:
+		var dsn string
+		if dsn, err = mysqlDSN(config.DSN); err == nil {
+			db, err = sql.Open("mysql", dsn)
+		}
+	default:
+		return nil, ErrUnrecognizedSQLType
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newSQLFromDB(dbType, db, config)
+}
+
+// newSQLFromDB - Prepares the statements of an SQL store type for an open database.
+func newSQLFromDB(dbType int, db *sql.DB, config SQLConfig) (*SQL, error) {
+	var (
+		createStr, updateStr, readStr, revStr        string
+		deleteStr, renameStr, listStr                string
+		metaReadStr, metaUpdateStr                   string
+		create, update, read, rev, del, rename, list *sql.Stmt
+		metaRead, metaUpdate                         *sql.Stmt
+		err                                          error
+	)
+
 	/* Now we set up prepared statements. This ensures at initialization that we can successfully
 	 * connect to the database.
 	 */
 
 	switch dbType {
 	case postgres:
-		db, err = sql.Open("postgres", config.DSN)
 		createStr = "INSERT INTO %v (%v, %v) VALUES ($1, $2)"
 		updateStr = "UPDATE %v SET %v = $1 WHERE %v = $2"
 		readStr = "SELECT %v FROM %v WHERE %v = $1"
+		deleteStr = "DELETE FROM %v WHERE %v = $1"
+		renameStr = "UPDATE %v SET %v = $1 WHERE %v = $2"
+		listStr = "SELECT %v FROM %v WHERE %v LIKE $1 ESCAPE '!' ORDER BY %v"
+		metaReadStr = "SELECT %v FROM %v WHERE %v = $1"
+		metaUpdateStr = "UPDATE %v SET %v = $1 WHERE %v = $2"
+		if len(config.TableConfig.VersionCol) > 0 {
+			createStr = "INSERT INTO %v (%v, %v, %v) VALUES ($1, $2, 1)"
+			updateStr = "UPDATE %v SET %v = $1, %v = %v + 1 WHERE %v = $2"
+			readStr = "SELECT %v, %v FROM %v WHERE %v = $1"
+			revStr = "UPDATE %v SET %v = $1, %v = %v + 1 WHERE %v = $2 AND %v = $3"
+		}
 	case mysql:
-		db, err = sql.Open("mysql", config.DSN)
 		createStr = "INSERT INTO %v (%v, %v) VALUES (?, ?)"
 		updateStr = "UPDATE %v SET %v = ? WHERE %v = ?"
 		readStr = "SELECT %v FROM %v WHERE %v = ?"
+		deleteStr = "DELETE FROM %v WHERE %v = ?"
+		renameStr = "UPDATE %v SET %v = ? WHERE %v = ?"
+		listStr = "SELECT %v FROM %v WHERE %v LIKE ? ESCAPE '!' ORDER BY %v"
+		metaReadStr = "SELECT %v FROM %v WHERE %v = ?"
+		metaUpdateStr = "UPDATE %v SET %v = ? WHERE %v = ?"
+		if len(config.TableConfig.VersionCol) > 0 {
+			createStr = "INSERT INTO %v (%v, %v, %v) VALUES (?, ?, 1)"
+			updateStr = "UPDATE %v SET %v = ?, %v = %v + 1 WHERE %v = ?"
+			readStr = "SELECT %v, %v FROM %v WHERE %v = ?"
+			revStr = "UPDATE %v SET %v = ?, %v = %v + 1 WHERE %v = ? AND %v = ?"
+		}
 	default:
 		return nil, ErrUnrecognizedSQLType
 	}
-	if err != nil {
-		return nil, err
-	}
 
-	create, err = db.Prepare(fmt.Sprintf(createStr,
+	if len(config.TableConfig.VersionCol) > 0 {
+		tc := config.TableConfig
+		create, err = db.Prepare(fmt.Sprintf(createStr, tc.Name, tc.IDCol, tc.ContentCol, tc.VersionCol))
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare create statement: %v", err)
+		}
+		update, err = db.Prepare(fmt.Sprintf(updateStr,
+			tc.Name, tc.ContentCol, tc.VersionCol, tc.VersionCol, tc.IDCol,
+		))
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare update statement: %v", err)
+		}
+		read, err = db.Prepare(fmt.Sprintf(readStr, tc.ContentCol, tc.VersionCol, tc.Name, tc.IDCol))
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare get statement: %v", err)
+		}
+		rev, err = db.Prepare(fmt.Sprintf(revStr,
+			tc.Name, tc.ContentCol, tc.VersionCol, tc.VersionCol, tc.IDCol, tc.VersionCol,
+		))
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare revision update statement: %v", err)
+		}
+	} else {
+		create, err = db.Prepare(fmt.Sprintf(createStr,
+			config.TableConfig.Name,
+			config.TableConfig.IDCol,
+			config.TableConfig.ContentCol,
+		))
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare create statement: %v", err)
+		}
+		update, err = db.Prepare(fmt.Sprintf(updateStr,
+			config.TableConfig.Name,
+			config.TableConfig.ContentCol,
+			config.TableConfig.IDCol,
+		))
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare update statement: %v", err)
+		}
+		read, err = db.Prepare(fmt.Sprintf(readStr,
+			config.TableConfig.ContentCol,
+			config.TableConfig.Name,
+			config.TableConfig.IDCol,
+		))
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare get statement: %v", err)
+		}
+	}
+	del, err = db.Prepare(fmt.Sprintf(deleteStr,
 		config.TableConfig.Name,
 		config.TableConfig.IDCol,
-		config.TableConfig.ContentCol,
 	))
 	if err != nil {
-		return nil, fmt.Errorf("failed to prepare create statement: %v", err)
+		return nil, fmt.Errorf("failed to prepare delete statement: %v", err)
 	}
-	update, err = db.Prepare(fmt.Sprintf(updateStr,
+	rename, err = db.Prepare(fmt.Sprintf(renameStr,
 		config.TableConfig.Name,
-		config.TableConfig.ContentCol,
+		config.TableConfig.IDCol,
 		config.TableConfig.IDCol,
 	))
 	if err != nil {
-		return nil, fmt.Errorf("failed to prepare update statement: %v", err)
+		return nil, fmt.Errorf("failed to prepare rename statement: %v", err)
 	}
-	read, err = db.Prepare(fmt.Sprintf(readStr,
-		config.TableConfig.ContentCol,
+	list, err = db.Prepare(fmt.Sprintf(listStr,
+		config.TableConfig.IDCol,
 		config.TableConfig.Name,
 		config.TableConfig.IDCol,
+		config.TableConfig.IDCol,
 	))
 	if err != nil {
-		return nil, fmt.Errorf("failed to prepare get statement: %v", err)
+		return nil, fmt.Errorf("failed to prepare list statement: %v", err)
+	}
+	if len(config.TableConfig.MetadataCol) > 0 {
+		metaRead, err = db.Prepare(fmt.Sprintf(metaReadStr,
+			config.TableConfig.MetadataCol,
+			config.TableConfig.Name,
+			config.TableConfig.IDCol,
+		))
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare metadata get statement: %v", err)
+		}
+		metaUpdate, err = db.Prepare(fmt.Sprintf(metaUpdateStr,
+			config.TableConfig.Name,
+			config.TableConfig.MetadataCol,
+			config.TableConfig.IDCol,
+		))
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare metadata update statement: %v", err)
+		}
+	}
+	var history *sqlHistory
+	if len(config.TableConfig.HistoryTable) > 0 {
+		if history, err = newSQLHistory(dbType, db, config.TableConfig.HistoryTable); err != nil {
+			return nil, err
+		}
 	}
 
 	return &SQL{
@@ -174,21 +356,111 @@ func newSQL(dbType int, config SQLConfig) (Type, error) {
 		createStmt: create,
 		updateStmt: update,
 		readStmt:   read,
+		revStmt:    rev,
+		deleteStmt: del,
+		renameStmt: rename,
+		listStmt:   list,
+
+		metaReadStmt:   metaRead,
+		metaUpdateStmt: metaUpdate,
+
+		history: history,
 	}, nil
 }
 
 //--------------------------------------------------------------------------------------------------
 
+// exec - Executes a statement that writes the content of a document. When a metadata column or
+// history table is configured the metadata and revision of the document are written within the same
+// transaction, unless the statement did not match a row.
+func (m *SQL) exec(doc Document, authors []string, stmt *sql.Stmt, args ...interface{}) (sql.Result, error) {
+	if m.metaUpdateStmt == nil && m.history == nil {
+		return stmt.Exec(args...)
+	}
+	var metadata sql.NullString
+	if len(doc.Metadata) > 0 {
+		metaBytes, err := json.Marshal(doc.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		metadata = sql.NullString{String: string(metaBytes), Valid: true}
+	}
+	if authors == nil {
+		authors = []string{}
+	}
+	authorsBytes, err := json.Marshal(authors)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	res, err := tx.Stmt(stmt).Exec(args...)
+	matched := true
+	if err == nil {
+		if n, rErr := res.RowsAffected(); rErr == nil && n == 0 {
+			matched = false
+		}
+	}
+	if err == nil && !matched && stmt == m.revStmt {
+		return res, tx.Rollback()
+	}
+	if err == nil && m.metaUpdateStmt != nil {
+		_, err = tx.Stmt(m.metaUpdateStmt).Exec(metadata, doc.ID)
+	}
+	if err == nil && matched && m.history != nil {
+		_, err = tx.Stmt(m.history.insertStmt).Exec(
+			doc.ID, time.Now().UnixNano(), string(authorsBytes), doc.Content, metadata,
+		)
+	}
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	if m.history != nil && m.config.RevisionPolicy != (PrunePolicy{}) {
+		if err = m.PruneRevisions(doc.ID, m.config.RevisionPolicy); err != nil {
+			return nil, fmt.Errorf("failed to prune document revisions: %v", err)
+		}
+	}
+	return res, nil
+}
+
 // Create - Create a new document in a database table.
 func (m *SQL) Create(doc Document) error {
-	_, err := m.createStmt.Exec(doc.ID, doc.Content)
+	_, err := m.exec(doc, nil, m.createStmt, doc.ID, doc.Content)
 	return err
 }
 
-// Update - Update document in a database table.
+// Update - Update document in a database table. When a version column is configured and the
+// document has a revision the update only succeeds if the stored version matches.
 func (m *SQL) Update(doc Document) error {
-	_, err := m.updateStmt.Exec(doc.Content, doc.ID)
-	return err
+	return m.UpdateAuthored(doc, nil)
+}
+
+// UpdateAuthored - Update document in a database table, the authors are recorded with the revision
+// of the document when a history table is configured.
+func (m *SQL) UpdateAuthored(doc Document, authors []string) error {
+	if m.revStmt == nil || len(doc.Revision) == 0 {
+		_, err := m.exec(doc, authors, m.updateStmt, doc.Content, doc.ID)
+		return err
+	}
+	version, err := strconv.ParseInt(doc.Revision, 10, 64)
+	if err != nil {
+		return ErrRevisionMismatch
+	}
+	res, err := m.exec(doc, authors, m.revStmt, doc.Content, doc.ID, version)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrRevisionMismatch
+	}
+	return nil
 }
 
 // Read - Read document from a database table.
@@ -196,7 +468,14 @@ func (m *SQL) Read(id string) (Document, error) {
 	var document Document
 	document.ID = id
 
-	err := m.readStmt.QueryRow(id).Scan(&document.Content)
+	var err error
+	if m.revStmt != nil {
+		var version int64
+		err = m.readStmt.QueryRow(id).Scan(&document.Content, &version)
+		document.Revision = strconv.FormatInt(version, 10)
+	} else {
+		err = m.readStmt.QueryRow(id).Scan(&document.Content)
+	}
 
 	switch {
 	case err == sql.ErrNoRows:
@@ -204,7 +483,94 @@ func (m *SQL) Read(id string) (Document, error) {
 	case err != nil:
 		return Document{}, err
 	}
+
+	if m.metaReadStmt != nil {
+		var metadata sql.NullString
+		if err = m.metaReadStmt.QueryRow(id).Scan(&metadata); err != nil {
+			return Document{}, err
+		}
+		if metadata.Valid && len(metadata.String) > 0 {
+			if err = json.Unmarshal([]byte(metadata.String), &document.Metadata); err != nil {
+				return Document{}, fmt.Errorf("failed to parse document metadata: %v", err)
+			}
+		}
+	}
 	return document, nil
 }
 
+// Delete - Remove a document from a database table.
+func (m *SQL) Delete(id string) error {
+	res, err := m.deleteStmt.Exec(id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrDocumentNotExist
+	}
+	return nil
+}
+
+// Rename - Change the ID of a document in a database table.
+func (m *SQL) Rename(oldID, newID string) error {
+	if _, err := m.Read(newID); err == nil {
+		return ErrDocumentExists
+	} else if err != ErrDocumentNotExist {
+		return err
+	}
+	if m.history == nil {
+		res, err := m.renameStmt.Exec(newID, oldID)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return ErrDocumentNotExist
+		}
+		return nil
+	}
+
+	// The revisions of the document are moved along with it.
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	res, err := tx.Stmt(m.renameStmt).Exec(newID, oldID)
+	if err == nil {
+		if n, rErr := res.RowsAffected(); rErr == nil && n == 0 {
+			err = ErrDocumentNotExist
+		}
+	}
+	if err == nil {
+		_, err = tx.Stmt(m.history.renameStmt).Exec(newID, oldID)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// likeEscaper - Escapes the wildcard characters of a LIKE pattern using the
+// escape character '!'.
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// List - List the IDs of documents in a database table that begin with a
+// prefix.
+func (m *SQL) List(prefix string) ([]string, error) {
+	rows, err := m.listStmt.Query(likeEscaper.Replace(prefix) + "%")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
 //--------------------------------------------------------------------------------------------------
@@ -0,0 +1,419 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+//--------------------------------------------------------------------------------------------------
+
+// mockExpectation - A statement expected by a mockDB along with the response to give. Statements
+// match when they contain the query, and args are only checked when not nil.
+type mockExpectation struct {
+	query    string
+	args     []driver.Value
+	affected int64
+	columns  []string
+	rows     [][]driver.Value
+	err      error
+}
+
+// mockDB - A database driver that expects a sequence of executed statements and queries in the
+// manner of go-sqlmock. Statements may be prepared in any order, and transactions are counted.
+type mockDB struct {
+	sync.Mutex
+	t         *testing.T
+	expected  []mockExpectation
+	commits   int
+	rollbacks int
+}
+
+func newMockDB(t *testing.T) (*mockDB, *sql.DB) {
+	m := &mockDB{t: t}
+	return m, sql.OpenDB(m)
+}
+
+func (m *mockDB) expect(e ...mockExpectation) {
+	m.Lock()
+	m.expected = append(m.expected, e...)
+	m.Unlock()
+}
+
+func (m *mockDB) done() {
+	m.t.Helper()
+	m.Lock()
+	defer m.Unlock()
+	for _, e := range m.expected {
+		m.t.Errorf("Expected statement was not executed: %v", e.query)
+	}
+	m.expected = nil
+}
+
+func (m *mockDB) next(query string, args []driver.Value) (mockExpectation, error) {
+	m.Lock()
+	defer m.Unlock()
+	if len(m.expected) == 0 {
+		m.t.Errorf("Unexpected statement: %v %v", query, args)
+		return mockExpectation{}, fmt.Errorf("unexpected statement: %v", query)
+	}
+	e := m.expected[0]
+	m.expected = m.expected[1:]
+	if !strings.Contains(query, e.query) {
+		m.t.Errorf("Wrong statement: %v != %v", e.query, query)
+		return mockExpectation{}, fmt.Errorf("wrong statement: %v", query)
+	}
+	if e.args != nil && !reflect.DeepEqual(e.args, args) {
+		m.t.Errorf("Wrong arguments of %v: %#v != %#v", e.query, e.args, args)
+	}
+	return e, e.err
+}
+
+func (m *mockDB) Connect(context.Context) (driver.Conn, error) { return mockConn{m}, nil }
+func (m *mockDB) Driver() driver.Driver                        { return nil }
+
+type mockConn struct{ db *mockDB }
+
+func (c mockConn) Prepare(query string) (driver.Stmt, error) { return mockStmt{c.db, query}, nil }
+func (c mockConn) Close() error                              { return nil }
+func (c mockConn) Begin() (driver.Tx, error)                 { return mockTx{c.db}, nil }
+
+type mockTx struct{ db *mockDB }
+
+func (t mockTx) Commit() error {
+	t.db.Lock()
+	t.db.commits++
+	t.db.Unlock()
+	return nil
+}
+
+func (t mockTx) Rollback() error {
+	t.db.Lock()
+	t.db.rollbacks++
+	t.db.Unlock()
+	return nil
+}
+
+type mockStmt struct {
+	db    *mockDB
+	query string
+}
+
+func (s mockStmt) Close() error  { return nil }
+func (s mockStmt) NumInput() int { return -1 }
+
+func (s mockStmt) Exec(args []driver.Value) (driver.Result, error) {
+	e, err := s.db.next(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(e.affected), nil
+}
+
+func (s mockStmt) Query(args []driver.Value) (driver.Rows, error) {
+	e, err := s.db.next(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &mockRows{columns: e.columns, rows: e.rows}, nil
+}
+
+type mockRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *mockRows) Columns() []string { return r.columns }
+func (r *mockRows) Close() error      { return nil }
+
+func (r *mockRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+//--------------------------------------------------------------------------------------------------
+
+func TestSQLHistorySchema(t *testing.T) {
+	for _, test := range []struct {
+		dbType  int
+		schemas []string
+	}{
+		{postgres, []string{"CREATE TABLE IF NOT EXISTS history (", "CREATE INDEX IF NOT EXISTS history_DOCUMENT_ID ON history"}},
+		{mysql, []string{"CREATE TABLE IF NOT EXISTS history ("}},
+	} {
+		mock, db := newMockDB(t)
+		for _, schema := range test.schemas {
+			mock.expect(mockExpectation{query: schema})
+		}
+		conf := NewSQLConfig()
+		conf.TableConfig.HistoryTable = "history"
+		if _, err := newSQLFromDB(test.dbType, db, conf); err != nil {
+			t.Fatal(err)
+		}
+		mock.done()
+	}
+
+	mock, db := newMockDB(t)
+	mock.expect(mockExpectation{query: "CREATE TABLE", err: fmt.Errorf("denied")})
+	conf := NewSQLConfig()
+	conf.TableConfig.HistoryTable = "history"
+	if _, err := newSQLFromDB(mysql, db, conf); err == nil || !strings.Contains(err.Error(), "denied") {
+		t.Errorf("Expected error from schema creation, received: %v", err)
+	}
+	mock.done()
+}
+
+func TestSQLVersionColumn(t *testing.T) {
+	mock, db := newMockDB(t)
+	conf := NewSQLConfig()
+	conf.TableConfig.VersionCol = "VERSION"
+	s, err := newSQLFromDB(postgres, db, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.expect(mockExpectation{
+		query: "INSERT INTO leaps_documents (ID, CONTENT, VERSION) VALUES ($1, $2, 1)",
+		args:  []driver.Value{"foo", "hello"}, affected: 1,
+	}, mockExpectation{
+		query: "SELECT CONTENT, VERSION FROM leaps_documents WHERE ID = $1",
+		args:  []driver.Value{"foo"}, columns: []string{"CONTENT", "VERSION"},
+		rows: [][]driver.Value{{"hello", int64(3)}},
+	}, mockExpectation{
+		query: "UPDATE leaps_documents SET CONTENT = $1, VERSION = VERSION + 1 WHERE ID = $2 AND VERSION = $3",
+		args:  []driver.Value{"world", "foo", int64(3)}, affected: 1,
+	}, mockExpectation{
+		query: "WHERE ID = $2 AND VERSION = $3",
+		args:  []driver.Value{"stale", "foo", int64(3)}, affected: 0,
+	}, mockExpectation{
+		query: "UPDATE leaps_documents SET CONTENT = $1, VERSION = VERSION + 1 WHERE ID = $2",
+		args:  []driver.Value{"forced", "foo"}, affected: 1,
+	})
+
+	if err = s.Create(Document{ID: "foo", Content: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := s.Read("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := (Document{ID: "foo", Content: "hello", Revision: "3"}); !reflect.DeepEqual(exp, doc) {
+		t.Errorf("Wrong document: %v != %v", exp, doc)
+	}
+	if err = s.Update(Document{ID: "foo", Content: "world", Revision: "3"}); err != nil {
+		t.Error(err)
+	}
+	if err = s.Update(Document{ID: "foo", Content: "stale", Revision: "3"}); err != ErrRevisionMismatch {
+		t.Errorf("Expected ErrRevisionMismatch, received: %v", err)
+	}
+	if err = s.Update(Document{ID: "foo", Content: "nope", Revision: "not a version"}); err != ErrRevisionMismatch {
+		t.Errorf("Expected ErrRevisionMismatch, received: %v", err)
+	}
+	if err = s.Update(Document{ID: "foo", Content: "forced"}); err != nil {
+		t.Error(err)
+	}
+	mock.done()
+}
+
+func TestSQLMetadataColumn(t *testing.T) {
+	mock, db := newMockDB(t)
+	conf := NewSQLConfig()
+	conf.TableConfig.MetadataCol = "METADATA"
+	s, err := newSQLFromDB(mysql, db, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.expect(mockExpectation{
+		query: "INSERT INTO leaps_documents (ID, CONTENT) VALUES (?, ?)",
+		args:  []driver.Value{"foo", "hello"}, affected: 1,
+	}, mockExpectation{
+		query: "UPDATE leaps_documents SET METADATA = ? WHERE ID = ?",
+		args:  []driver.Value{`{"lang":"go"}`, "foo"}, affected: 1,
+	}, mockExpectation{
+		query: "UPDATE leaps_documents SET CONTENT = ? WHERE ID = ?",
+		args:  []driver.Value{"world", "foo"}, affected: 1,
+	}, mockExpectation{
+		query: "UPDATE leaps_documents SET METADATA = ? WHERE ID = ?",
+		args:  []driver.Value{nil, "foo"}, affected: 1,
+	}, mockExpectation{
+		query: "SELECT CONTENT FROM leaps_documents WHERE ID = ?",
+		args:  []driver.Value{"foo"}, columns: []string{"CONTENT"},
+		rows: [][]driver.Value{{"world"}},
+	}, mockExpectation{
+		query: "SELECT METADATA FROM leaps_documents WHERE ID = ?",
+		args:  []driver.Value{"foo"}, columns: []string{"METADATA"},
+		rows: [][]driver.Value{{`{"lang":"go"}`}},
+	}, mockExpectation{
+		query: "SELECT CONTENT FROM leaps_documents WHERE ID = ?",
+		args:  []driver.Value{"bar"}, columns: []string{"CONTENT"},
+		rows: [][]driver.Value{{"plain"}},
+	}, mockExpectation{
+		query: "SELECT METADATA FROM leaps_documents WHERE ID = ?",
+		args:  []driver.Value{"bar"}, columns: []string{"METADATA"},
+		rows: [][]driver.Value{{nil}},
+	})
+
+	if err = s.Create(Document{ID: "foo", Content: "hello", Metadata: map[string]string{"lang": "go"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Update(Document{ID: "foo", Content: "world"}); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := s.Read("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := (Document{ID: "foo", Content: "world", Metadata: map[string]string{"lang": "go"}}); !reflect.DeepEqual(exp, doc) {
+		t.Errorf("Wrong document: %v != %v", exp, doc)
+	}
+	if doc, err = s.Read("bar"); err != nil {
+		t.Fatal(err)
+	} else if exp := (Document{ID: "bar", Content: "plain"}); !reflect.DeepEqual(exp, doc) {
+		t.Errorf("Wrong document: %v != %v", exp, doc)
+	}
+	mock.done()
+
+	if mock.commits != 2 || mock.rollbacks != 0 {
+		t.Errorf("Wrong transactions: %v commits, %v rollbacks", mock.commits, mock.rollbacks)
+	}
+}
+
+func TestSQLHistoryTable(t *testing.T) {
+	mock, db := newMockDB(t)
+	mock.expect(mockExpectation{query: "CREATE TABLE IF NOT EXISTS history"})
+	conf := NewSQLConfig()
+	conf.TableConfig.HistoryTable = "history"
+	s, err := newSQLFromDB(mysql, db, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.done()
+
+	mock.expect(mockExpectation{
+		query: "UPDATE leaps_documents SET CONTENT = ? WHERE ID = ?",
+		args:  []driver.Value{"hello", "foo"}, affected: 1,
+	}, mockExpectation{
+		query: "INSERT INTO history (DOCUMENT_ID, CREATED_AT, AUTHORS, CONTENT, METADATA)",
+	}, mockExpectation{
+		query: "UPDATE leaps_documents SET CONTENT = ? WHERE ID = ?",
+		args:  []driver.Value{"hello", "missing"}, affected: 0,
+	}, mockExpectation{
+		query: "SELECT REVISION, CREATED_AT, AUTHORS FROM history WHERE DOCUMENT_ID = ? ORDER BY REVISION DESC",
+		args:  []driver.Value{"foo"}, columns: []string{"REVISION", "CREATED_AT", "AUTHORS"},
+		rows: [][]driver.Value{
+			{int64(2), int64(2000), `["alice","bob"]`},
+			{int64(1), int64(1000), `[]`},
+		},
+	}, mockExpectation{
+		query: "SELECT CONTENT, METADATA FROM history WHERE DOCUMENT_ID = ? AND REVISION = ?",
+		args:  []driver.Value{"foo", int64(1)}, columns: []string{"CONTENT", "METADATA"},
+		rows: [][]driver.Value{{"old", `{"lang":"go"}`}},
+	}, mockExpectation{
+		query: "SELECT CONTENT, METADATA FROM history WHERE DOCUMENT_ID = ? AND REVISION = ?",
+		args:  []driver.Value{"foo", int64(9)}, columns: []string{"CONTENT", "METADATA"},
+	}, mockExpectation{
+		query: "SELECT CONTENT FROM leaps_documents WHERE ID = ?",
+		args:  []driver.Value{"bar"}, columns: []string{"CONTENT"},
+	}, mockExpectation{
+		query: "UPDATE leaps_documents SET ID = ? WHERE ID = ?",
+		args:  []driver.Value{"bar", "foo"}, affected: 1,
+	}, mockExpectation{
+		query: "UPDATE history SET DOCUMENT_ID = ? WHERE DOCUMENT_ID = ?",
+		args:  []driver.Value{"bar", "foo"}, affected: 2,
+	})
+
+	if err = s.UpdateAuthored(Document{ID: "foo", Content: "hello"}, []string{"alice", "bob"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Update(Document{ID: "missing", Content: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+
+	revisions, err := s.ListRevisions("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 2 || revisions[0].ID != "2" || revisions[1].ID != "1" {
+		t.Fatalf("Wrong revisions: %v", revisions)
+	}
+	if exp := []string{"alice", "bob"}; !reflect.DeepEqual(exp, revisions[0].Authors) {
+		t.Errorf("Wrong revision authors: %v != %v", exp, revisions[0].Authors)
+	}
+	if revisions[0].Timestamp.UnixNano() != 2000 {
+		t.Errorf("Wrong revision timestamp: %v", revisions[0].Timestamp)
+	}
+
+	doc, err := s.ReadRevision("foo", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := (Document{ID: "foo", Content: "old", Metadata: map[string]string{"lang": "go"}}); !reflect.DeepEqual(exp, doc) {
+		t.Errorf("Wrong revision: %v != %v", exp, doc)
+	}
+	if _, err = s.ReadRevision("foo", "9"); err != ErrRevisionNotExist {
+		t.Errorf("Expected ErrRevisionNotExist, received: %v", err)
+	}
+	if _, err = s.ReadRevision("foo", "nope"); err != ErrRevisionNotExist {
+		t.Errorf("Expected ErrRevisionNotExist, received: %v", err)
+	}
+
+	if err = s.Rename("foo", "bar"); err != nil {
+		t.Fatal(err)
+	}
+	mock.done()
+
+	if mock.commits != 3 || mock.rollbacks != 0 {
+		t.Errorf("Wrong transactions: %v commits, %v rollbacks", mock.commits, mock.rollbacks)
+	}
+}
+
+func TestMySQLDSN(t *testing.T) {
+	dsn, err := mysqlDSN("user:pass@tcp(localhost:3306)/leaps?charset=utf8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(dsn, "clientFoundRows=true") {
+		t.Errorf("Expected clientFoundRows to be set: %v", dsn)
+	}
+	if !strings.HasPrefix(dsn, "user:pass@tcp(localhost:3306)/leaps?") {
+		t.Errorf("Wrong DSN: %v", dsn)
+	}
+	if _, err = mysqlDSN("not a dsn"); err == nil {
+		t.Error("Expected error from invalid DSN")
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
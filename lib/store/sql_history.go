@@ -0,0 +1,201 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, sub to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+//--------------------------------------------------------------------------------------------------
+
+// sqlHistory - Prepared statements for a table that retains the revisions of documents. The table
+// is created automatically with the following columns:
+//
+// REVISION    - An auto incrementing revision ID.
+// DOCUMENT_ID - The ID of the document.
+// CREATED_AT  - The time of the revision as a unix timestamp in nanoseconds.
+// AUTHORS     - A JSON array of the users that contributed to the revision.
+// CONTENT     - The content of the document.
+// METADATA    - The metadata of the document as a JSON object, or NULL.
+type sqlHistory struct {
+	insertStmt *sql.Stmt
+	listStmt   *sql.Stmt
+	readStmt   *sql.Stmt
+	deleteStmt *sql.Stmt
+	renameStmt *sql.Stmt
+}
+
+func newSQLHistory(dbType int, db *sql.DB, table string) (*sqlHistory, error) {
+	var (
+		schemaStrs                                        []string
+		insertStr, listStr, readStr, deleteStr, renameStr string
+		err                                               error
+	)
+
+	switch dbType {
+	case postgres:
+		schemaStrs = []string{
+			fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %v (
+	REVISION BIGSERIAL PRIMARY KEY,
+	DOCUMENT_ID TEXT NOT NULL,
+	CREATED_AT BIGINT NOT NULL,
+	AUTHORS TEXT NOT NULL,
+	CONTENT TEXT NOT NULL,
+	METADATA TEXT
+)`, table),
+			fmt.Sprintf("CREATE INDEX IF NOT EXISTS %v_DOCUMENT_ID ON %v (DOCUMENT_ID)", table, table),
+		}
+		insertStr = "INSERT INTO %v (DOCUMENT_ID, CREATED_AT, AUTHORS, CONTENT, METADATA) VALUES ($1, $2, $3, $4, $5)"
+		listStr = "SELECT REVISION, CREATED_AT, AUTHORS FROM %v WHERE DOCUMENT_ID = $1 ORDER BY REVISION DESC"
+		readStr = "SELECT CONTENT, METADATA FROM %v WHERE DOCUMENT_ID = $1 AND REVISION = $2"
+		deleteStr = "DELETE FROM %v WHERE DOCUMENT_ID = $1 AND REVISION = $2"
+		renameStr = "UPDATE %v SET DOCUMENT_ID = $1 WHERE DOCUMENT_ID = $2"
+	case mysql:
+		schemaStrs = []string{
+			fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %v (
+	REVISION BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+	DOCUMENT_ID VARCHAR(255) NOT NULL,
+	CREATED_AT BIGINT NOT NULL,
+	AUTHORS TEXT NOT NULL,
+	CONTENT LONGTEXT NOT NULL,
+	METADATA TEXT,
+	INDEX (DOCUMENT_ID)
+)`, table),
+		}
+		insertStr = "INSERT INTO %v (DOCUMENT_ID, CREATED_AT, AUTHORS, CONTENT, METADATA) VALUES (?, ?, ?, ?, ?)"
+		listStr = "SELECT REVISION, CREATED_AT, AUTHORS FROM %v WHERE DOCUMENT_ID = ? ORDER BY REVISION DESC"
+		readStr = "SELECT CONTENT, METADATA FROM %v WHERE DOCUMENT_ID = ? AND REVISION = ?"
+		deleteStr = "DELETE FROM %v WHERE DOCUMENT_ID = ? AND REVISION = ?"
+		renameStr = "UPDATE %v SET DOCUMENT_ID = ? WHERE DOCUMENT_ID = ?"
+	default:
+		return nil, ErrUnrecognizedSQLType
+	}
+
+	for _, schemaStr := range schemaStrs {
+		if _, err = db.Exec(schemaStr); err != nil {
+			return nil, fmt.Errorf("failed to create history table: %v", err)
+		}
+	}
+
+	h := &sqlHistory{}
+	for _, s := range []struct {
+		stmt  **sql.Stmt
+		query string
+		name  string
+	}{
+		{&h.insertStmt, insertStr, "insert"},
+		{&h.listStmt, listStr, "list"},
+		{&h.readStmt, readStr, "read"},
+		{&h.deleteStmt, deleteStr, "delete"},
+		{&h.renameStmt, renameStr, "rename"},
+	} {
+		if *s.stmt, err = db.Prepare(fmt.Sprintf(s.query, table)); err != nil {
+			return nil, fmt.Errorf("failed to prepare history %v statement: %v", s.name, err)
+		}
+	}
+	return h, nil
+}
+
+//--------------------------------------------------------------------------------------------------
+
+// ListRevisions - Return the retained revisions of a document from the history table, newest first.
+func (m *SQL) ListRevisions(id string) ([]RevisionInfo, error) {
+	if m.history == nil {
+		return nil, ErrNotVersioned
+	}
+	rows, err := m.history.listStmt.Query(id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []RevisionInfo{}
+	for rows.Next() {
+		var (
+			revision   int64
+			createdAt  int64
+			authorsStr string
+		)
+		if err = rows.Scan(&revision, &createdAt, &authorsStr); err != nil {
+			return nil, err
+		}
+		info := RevisionInfo{
+			ID:        strconv.FormatInt(revision, 10),
+			Timestamp: time.Unix(0, createdAt),
+		}
+		if err = json.Unmarshal([]byte(authorsStr), &info.Authors); err != nil {
+			return nil, fmt.Errorf("failed to parse revision authors: %v", err)
+		}
+		revisions = append(revisions, info)
+	}
+	return revisions, rows.Err()
+}
+
+// ReadRevision - Read the content and metadata of a document as of a revision in the history table.
+func (m *SQL) ReadRevision(id, revision string) (Document, error) {
+	if m.history == nil {
+		return Document{}, ErrNotVersioned
+	}
+	revNum, err := strconv.ParseInt(revision, 10, 64)
+	if err != nil {
+		return Document{}, ErrRevisionNotExist
+	}
+
+	doc := Document{ID: id}
+	var metadata sql.NullString
+	err = m.history.readStmt.QueryRow(id, revNum).Scan(&doc.Content, &metadata)
+	switch {
+	case err == sql.ErrNoRows:
+		return Document{}, ErrRevisionNotExist
+	case err != nil:
+		return Document{}, err
+	}
+	if metadata.Valid && len(metadata.String) > 0 {
+		if err = json.Unmarshal([]byte(metadata.String), &doc.Metadata); err != nil {
+			return Document{}, fmt.Errorf("failed to parse document metadata: %v", err)
+		}
+	}
+	return doc, nil
+}
+
+// PruneRevisions - Remove the revisions of a document from the history table that fall outside a
+// policy.
+func (m *SQL) PruneRevisions(id string, policy PrunePolicy) error {
+	revisions, err := m.ListRevisions(id)
+	if err != nil {
+		return err
+	}
+	for _, rev := range policy.Expired(revisions, time.Now()) {
+		revNum, _ := strconv.ParseInt(rev.ID, 10, 64)
+		if _, err = m.history.deleteStmt.Exec(id, revNum); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//--------------------------------------------------------------------------------------------------
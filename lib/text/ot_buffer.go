@@ -87,6 +87,21 @@ func NewOTBuffer(content string, config OTBufferConfig) *OTBuffer {
 // relation to earlier transforms it was unaware of, this fixed version gets
 // sent back for distributing across other clients.
 func (m *OTBuffer) PushTransform(ot OTransform) (OTransform, int, error) {
+	if uint64(len(ot.Insert)) > m.config.MaxTransformLength {
+		return OTransform{}, 0, ErrTransformTooLong
+	}
+	return m.pushTransform(ot)
+}
+
+// PushMergeTransform - Inserts a transform onto the unapplied stack without
+// checking its length against MaxTransformLength. This is intended for merging
+// changes that were made to the document outside of the buffer, which are not
+// bound by the limits placed on clients.
+func (m *OTBuffer) PushMergeTransform(ot OTransform) (OTransform, int, error) {
+	return m.pushTransform(ot)
+}
+
+func (m *OTBuffer) pushTransform(ot OTransform) (OTransform, int, error) {
 	// Perform basic checks on size and bounds.
 	// NOTE: It is not appropriate to compare this transform to the document
 	// length at this stage since the transform might need version adjustment
@@ -97,9 +112,6 @@ func (m *OTBuffer) PushTransform(ot OTransform) (OTransform, int, error) {
 	if ot.Delete < 0 {
 		return OTransform{}, 0, ErrTransformNegDelete
 	}
-	if uint64(len(ot.Insert)) > m.config.MaxTransformLength {
-		return OTransform{}, 0, ErrTransformTooLong
-	}
 
 	lenApplied, lenUnapplied := len(m.Applied), len(m.Unapplied)
 
@@ -140,6 +152,15 @@ func (m *OTBuffer) PushTransform(ot OTransform) (OTransform, int, error) {
 	return ot, m.Version, nil
 }
 
+// Copy - Returns a copy of the buffer that can be modified without affecting
+// the original.
+func (m *OTBuffer) Copy() *OTBuffer {
+	c := *m
+	c.Applied = append([]OTransform{}, m.Applied...)
+	c.Unapplied = append([]OTransform{}, m.Unapplied...)
+	return &c
+}
+
 // IsDirty - Check if there is any unapplied transforms.
 func (m *OTBuffer) IsDirty() bool {
 	return len(m.Unapplied) > 0
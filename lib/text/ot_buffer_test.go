@@ -286,3 +286,60 @@ func TestLimits(t *testing.T) {
 		t.Errorf("Wrong error: %v != %v", err, ErrTransformSkipped)
 	}
 }
+
+func TestMergeTransformLimits(t *testing.T) {
+	config := NewOTBufferConfig()
+	config.MaxDocumentSize = 40
+	config.MaxTransformLength = 5
+
+	model := NewOTBuffer("hello world", config)
+
+	if _, _, err := model.PushTransform(OTransform{
+		Version: 2,
+		Insert:  "too long",
+	}); err != ErrTransformTooLong {
+		t.Errorf("Wrong error: %v != %v", err, ErrTransformTooLong)
+	}
+	if _, _, err := model.PushMergeTransform(OTransform{
+		Version: 2,
+		Insert:  "not too long ",
+	}); err != nil {
+		t.Error(err)
+	}
+	if _, _, err := model.PushMergeTransform(OTransform{
+		Version: 3,
+		Insert:  "but this exceeds the document size",
+	}); err != ErrTransformTooLong {
+		t.Errorf("Wrong error: %v != %v", err, ErrTransformTooLong)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	model := NewOTBuffer("hello world", NewOTBufferConfig())
+	if _, _, err := model.PushTransform(OTransform{Version: 2, Position: 11, Insert: "!"}); err != nil {
+		t.Fatal(err)
+	}
+
+	copied := model.Copy()
+	if _, _, err := copied.PushTransform(OTransform{Version: 3, Position: 0, Insert: "oh "}); err != nil {
+		t.Fatal(err)
+	}
+	content := "hello world"
+	if _, err := copied.FlushTransforms(&content, 60); err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := "oh hello world!", content; exp != act {
+		t.Errorf("Wrong copied content: %v != %v", exp, act)
+	}
+
+	if exp, act := 2, model.GetVersion(); exp != act {
+		t.Errorf("Wrong original version: %v != %v", exp, act)
+	}
+	content = "hello world"
+	if _, err := model.FlushTransforms(&content, 60); err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := "hello world!", content; exp != act {
+		t.Errorf("Wrong original content: %v != %v", exp, act)
+	}
+}
@@ -198,4 +198,35 @@ func MergeTransforms(first, second *OTransform) bool {
 	return false
 }
 
+/*
+DiffTransform - Creates a transform that converts one version of content into
+another by replacing the section between their common prefix and suffix. This
+is used to represent changes made to a document outside of leaps. Positions are
+counted in codepoints. Returns false if the contents are identical.
+
+NOTE: The version of the returned transform is not set.
+*/
+func DiffTransform(from, to string) (OTransform, bool) {
+	if from == to {
+		return OTransform{}, false
+	}
+	fromRunes, toRunes := []rune(from), []rune(to)
+
+	prefix := 0
+	for prefix < len(fromRunes) && prefix < len(toRunes) && fromRunes[prefix] == toRunes[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(fromRunes)-prefix && suffix < len(toRunes)-prefix &&
+		fromRunes[len(fromRunes)-1-suffix] == toRunes[len(toRunes)-1-suffix] {
+		suffix++
+	}
+
+	return OTransform{
+		Position: prefix,
+		Delete:   len(fromRunes) - prefix - suffix,
+		Insert:   string(toRunes[prefix : len(toRunes)-suffix]),
+	}, true
+}
+
 //------------------------------------------------------------------------------
@@ -194,4 +194,41 @@ func TestPrematureTransforms(t *testing.T) {
 	}
 }
 
+func TestDiffTransform(t *testing.T) {
+	type diffTest struct {
+		from, to string
+		result   OTransform
+	}
+
+	tests := []diffTest{
+		{from: "hello world", to: "hello big world", result: OTransform{Position: 6, Insert: "big "}},
+		{from: "hello world", to: "hello", result: OTransform{Position: 5, Delete: 6}},
+		{from: "hello world", to: "jello world", result: OTransform{Position: 0, Delete: 1, Insert: "j"}},
+		{from: "", to: "new", result: OTransform{Position: 0, Insert: "new"}},
+		{from: "aaaa", to: "aa", result: OTransform{Position: 2, Delete: 2}},
+		{from: "日本語", to: "日本人語", result: OTransform{Position: 2, Insert: "人"}},
+	}
+
+	for _, test := range tests {
+		result, changed := DiffTransform(test.from, test.to)
+		if !changed {
+			t.Errorf("Expected change from %q to %q", test.from, test.to)
+			continue
+		}
+		if !reflect.DeepEqual(test.result, result) {
+			t.Errorf("Wrong diff from %q to %q: %v != %v", test.from, test.to, test.result, result)
+		}
+		content := []rune(test.from)
+		if err := ApplyTransform(&content, &result); err != nil {
+			t.Error(err)
+		} else if string(content) != test.to {
+			t.Errorf("Diff did not produce target: %q != %q", string(content), test.to)
+		}
+	}
+
+	if _, changed := DiffTransform("same", "same"); changed {
+		t.Error("Expected no change between identical content")
+	}
+}
+
 //--------------------------------------------------------------------------------------------------